@@ -49,6 +49,7 @@ var (
 	flagRecursors   []string
 	flagOwnDomain   string
 	flagMappingFile string
+	flagAddrsFile   string
 )
 
 var discCmd = &cobra.Command{
@@ -70,6 +71,25 @@ var discCmd = &cobra.Command{
 			}
 		}
 
+		// Network addresses override plain domain records
+		if _, err := os.Stat(flagAddrsFile); err == nil {
+			addrs, err := loadMapping(flagAddrsFile)
+
+			if err != nil {
+				discLog.Errorf("Error loading address mapping: %s", err)
+
+				os.Exit(1)
+			}
+
+			if mapping == nil {
+				mapping = map[string]string{}
+			}
+
+			for dom, addr := range addrs {
+				mapping[dom] = addr
+			}
+		}
+
 		httpListener, err := net.Listen("tcp", flagHttpAddr)
 
 		if err != nil {
@@ -200,6 +220,9 @@ func init() {
 	discCmd.Flags().StringVarP(&flagMappingFile,
 		"map", "m", "", "File containing mapping between hosts and IP addresses encoded as a JSON object")
 
+	discCmd.Flags().StringVarP(&flagAddrsFile,
+		"addresses", "a", "/addresses.json", "File containing mapping between hosts and comma separated addresses in CIDR notation, loaded if exists")
+
 	discCmd.Flags().StringSliceVarP(&flagRecursors,
 		"recursors", "r", []string{"1.1.1.1:53"}, "DNS recursors")
 }
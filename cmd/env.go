@@ -0,0 +1,533 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/syhpoon/xenvman/pkg/client"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/envfile"
+	_ "github.com/syhpoon/xenvman/pkg/local"
+)
+
+var (
+	flagServer         string
+	flagUpFile         string
+	flagUpOutput       string
+	flagUpLocal        bool
+	flagKeepaliveEvery time.Duration
+	flagLogsTail       int
+	flagLogsTimestamps bool
+)
+
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Create an environment from a definition file",
+	Long: `Create an environment from a YAML or JSON definition file
+and print its outputs in dotenv format. ${VAR} and ${VAR:-default}
+references in string values are substituted from the shell environment.
+
+With --local no running xenvman API server is required: the environment
+is created in this process using local container engine and
+configuration, it is terminated when the command is interrupted,
+including while it is still being created. Interrupt again to exit
+without waiting for the termination.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		envDef, err := envfile.Load(flagUpFile, os.LookupEnv)
+
+		if err != nil {
+			fail(err)
+		}
+
+		cl := newClient()
+
+		closed := make(chan struct{})
+
+		if flagUpLocal {
+			// Installed before the env is created, so that it is
+			// terminated when interrupted during a long build or pull
+			sigchan := make(chan os.Signal, 2)
+			signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+			if cl, err = client.NewLocal(client.LocalParams{
+				ConfigFile: flagConfig,
+			}); err != nil {
+				fail(err)
+			}
+
+			go closeOnInterrupt(cl, sigchan, closed)
+		}
+
+		env, err := cl.NewEnv(envDef)
+
+		if err == nil && flagUpOutput != "" {
+			err = envfile.WriteOutputs(flagUpOutput, env.OutputEnv)
+		}
+
+		if err == nil {
+			err = envfile.WriteDotenv(os.Stdout, envfile.Vars(env.OutputEnv))
+		}
+
+		if err == nil && flagUpLocal {
+			fmt.Fprintf(os.Stderr,
+				"Env %s is running locally, interrupt to terminate it\n", env.Id)
+
+			// Explicitly set keep alive still applies
+			keepalive, _ := time.ParseDuration(env.OutputEnv.Keepalive)
+
+			err = keepaliveUntil(env, keepalive/2, closed)
+		}
+
+		// Waits for the termination if already interrupted
+		_ = cl.Close()
+
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+var downCmd = &cobra.Command{
+	Use:   "down <env-id>...",
+	Short: "Terminate environments",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+
+		for _, id := range args {
+			env, err := newClient().GetEnvInfo(id)
+
+			if err == nil {
+				err = env.Terminate()
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error terminating %s: %s\n", id, err)
+				failed = true
+			}
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List active environments",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		envs, err := newClient().ListEnvs()
+
+		if err != nil {
+			fail(err)
+		}
+
+		sort.Slice(envs, func(i, j int) bool {
+			return envs[i].Created < envs[j].Created
+		})
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "ID\tNAME\tCREATED\tKEEPALIVE")
+
+		for _, env := range envs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				env.Id, env.Name, env.Created, env.OutputEnv.Keepalive)
+		}
+
+		_ = w.Flush()
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status <env-id>",
+	Short: "Show environment containers and ports",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		env, err := newClient().GetEnvInfo(args[0])
+
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Id:               %s\n", env.Id)
+		fmt.Printf("Name:             %s\n", env.Name)
+		fmt.Printf("Description:      %s\n", env.Description)
+		fmt.Printf("Created:          %s\n", env.Created)
+		fmt.Printf("Keepalive:        %s\n", env.OutputEnv.Keepalive)
+		fmt.Printf("External address: %s\n", env.ExternalAddress)
+		fmt.Println()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "TEMPLATE\tCONTAINER\tHOSTNAME\tPORTS")
+		printTpls(w, "", env.Templates)
+
+		_ = w.Flush()
+	},
+}
+
+var eventsCmd = &cobra.Command{
+	Use:   "events <env-id>",
+	Short: "Show environment events",
+	Long: `Show environment events, such as image build and pull progress.
+Use logs command for container output.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		env, err := newClient().GetEnvInfo(args[0])
+
+		if err != nil {
+			fail(err)
+		}
+
+		events, err := env.Events()
+
+		if err != nil {
+			fail(err)
+		}
+
+		for _, ev := range events {
+			fmt.Println(formatEvent(ev))
+		}
+	},
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <env-id> [container]...",
+	Short: "Show environment container logs",
+	Long: `Show combined stdout and stderr of environment containers.
+Containers are selected by name, hostname or id, all the containers
+are shown if none given. With several containers each line is prefixed
+with container hostname.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		env, err := newClient().GetEnvInfo(args[0])
+
+		if err != nil {
+			fail(err)
+		}
+
+		conts := selectContainers(env.Templates, args[1:])
+
+		if len(conts) == 0 {
+			fail(fmt.Errorf("No matching containers in env %s", env.Id))
+		}
+
+		params := client.LogsParams{
+			Tail:       flagLogsTail,
+			Timestamps: flagLogsTimestamps,
+		}
+
+		for _, cont := range conts {
+			prefix := ""
+
+			if len(conts) > 1 {
+				prefix = cont.Hostname + " | "
+			}
+
+			if err := printLogs(env, cont.Id, prefix, params); err != nil {
+				fail(err)
+			}
+		}
+	},
+}
+
+var keepaliveCmd = &cobra.Command{
+	Use:   "keepalive <env-id>",
+	Short: "Send environment keepalive",
+	Long: `Send a keepalive to an environment. With --every keepalives
+are sent periodically until interrupted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		env, err := newClient().GetEnvInfo(args[0])
+
+		if err != nil {
+			fail(err)
+		}
+
+		if err := env.Keepalive(); err != nil {
+			fail(err)
+		}
+
+		if flagKeepaliveEvery <= 0 {
+			return
+		}
+
+		sigchan := make(chan os.Signal, 1)
+		signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+		stop := make(chan struct{})
+
+		go func() {
+			<-sigchan
+			close(stop)
+		}()
+
+		if err := keepaliveUntil(env, flagKeepaliveEvery, stop); err != nil {
+			fail(err)
+		}
+	},
+}
+
+func newClient() *client.Client {
+	return client.New(client.Params{ServerAddress: flagServer})
+}
+
+// Send keepalives every interval, if it is positive, until stopped
+func keepaliveUntil(env *client.Env, every time.Duration,
+	stop <-chan struct{}) error {
+
+	var tick <-chan time.Time
+
+	if every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			if err := env.Keepalive(); err != nil {
+				return err
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// Close local client upon the first interrupt, terminating its envs,
+// and exit right away upon the second one
+func closeOnInterrupt(cl *client.Client, sigchan <-chan os.Signal,
+	closed chan<- struct{}) {
+
+	<-sigchan
+
+	fmt.Fprintln(os.Stderr, "Interrupted, terminating local envs, "+
+		"interrupt again to exit immediately")
+
+	go func() {
+		<-sigchan
+		os.Exit(1)
+	}()
+
+	_ = cl.Close()
+
+	close(closed)
+}
+
+// Containers matching any of the names, hostnames or ids,
+// all the containers if none given
+func selectContainers(tpls map[string][]*def.TplData,
+	names []string) []*def.ContainerData {
+
+	var res []*def.ContainerData
+
+	tplNames := make([]string, 0, len(tpls))
+
+	for name := range tpls {
+		tplNames = append(tplNames, name)
+	}
+
+	sort.Strings(tplNames)
+
+	for _, name := range tplNames {
+		for _, data := range tpls[name] {
+			conts := make([]string, 0, len(data.Containers))
+
+			for cname := range data.Containers {
+				conts = append(conts, cname)
+			}
+
+			sort.Strings(conts)
+
+			for _, cname := range conts {
+				cont := data.Containers[cname]
+				match := len(names) == 0
+
+				for _, n := range names {
+					if n == cname || n == cont.Hostname || n == cont.Id {
+						match = true
+					}
+				}
+
+				if match {
+					res = append(res, cont)
+				}
+			}
+
+			res = append(res, selectContainers(data.Templates, names)...)
+		}
+	}
+
+	return res
+}
+
+// Print container logs prefixing each line
+func printLogs(env *client.Env, cid, prefix string,
+	params client.LogsParams) error {
+
+	logs, err := env.Logs(cid, params)
+
+	if err != nil {
+		return err
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer logs.Close()
+
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		fmt.Printf("%s%s\n", prefix, scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	os.Exit(1)
+}
+
+func printTpls(w *tabwriter.Writer, prefix string,
+	tpls map[string][]*def.TplData) {
+
+	names := make([]string, 0, len(tpls))
+
+	for name := range tpls {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for idx, data := range tpls[name] {
+			path := fmt.Sprintf("%s%s|%d", prefix, name, idx)
+
+			conts := make([]string, 0, len(data.Containers))
+
+			for cname := range data.Containers {
+				conts = append(conts, cname)
+			}
+
+			sort.Strings(conts)
+
+			for _, cname := range conts {
+				cont := data.Containers[cname]
+
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					path, cname, cont.Hostname, formatPorts(cont))
+			}
+
+			printTpls(w, path+"/", data.Templates)
+		}
+	}
+}
+
+func formatPorts(cont *def.ContainerData) string {
+	keys := make([]string, 0, len(cont.PortBindings))
+
+	for key := range cont.PortBindings {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	ports := make([]string, len(keys))
+
+	for i, key := range keys {
+		b := cont.PortBindings[key]
+		host := b.HostIP
+
+		if host == "" {
+			host = "*"
+		}
+
+		ports[i] = fmt.Sprintf("%s:%d->%s", host, b.HostPort, key)
+	}
+
+	return strings.Join(ports, ", ")
+}
+
+func formatEvent(ev *def.EnvEvent) string {
+	msg := ev.Message
+
+	switch {
+	case ev.TotalSteps > 0:
+		msg = fmt.Sprintf("step %d/%d: %s", ev.Step, ev.TotalSteps, msg)
+	case ev.Layer != "" && ev.Total > 0:
+		msg = fmt.Sprintf("%s: %d/%d", ev.Layer, ev.Current, ev.Total)
+	case ev.Layer != "":
+		msg = fmt.Sprintf("%s: %s", ev.Layer, msg)
+	}
+
+	return strings.TrimRight(fmt.Sprintf("%s %s %s %s",
+		ev.Time, ev.Type, ev.Image, msg), " ")
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{upCmd, downCmd, lsCmd,
+		statusCmd, eventsCmd, logsCmd, keepaliveCmd} {
+
+		cmd.Flags().StringVarP(&flagServer, "server", "s", "",
+			"xenvman API server address, XENV_API_SERVER by default")
+	}
+
+	upCmd.Flags().StringVarP(&flagUpFile, "file", "f", "",
+		"Env definition file (YAML or JSON), - for stdin")
+	_ = upCmd.MarkFlagRequired("file")
+
+	upCmd.Flags().StringVarP(&flagUpOutput, "output", "o", "",
+		"Write outputs to a file, JSON if it has .json extension, dotenv otherwise")
+
+	upCmd.Flags().BoolVarP(&flagUpLocal, "local", "l", false,
+		"Run the environment in this process, without xenvman API server")
+
+	logsCmd.Flags().IntVarP(&flagLogsTail, "tail", "n", 0,
+		"Number of last lines to show, all the lines by default")
+
+	logsCmd.Flags().BoolVarP(&flagLogsTimestamps, "timestamps", "t", false,
+		"Prefix each line with its timestamp")
+
+	keepaliveCmd.Flags().DurationVar(&flagKeepaliveEvery, "every", 0,
+		"Keep sending keepalives with the given interval")
+}
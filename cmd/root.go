@@ -78,6 +78,14 @@ func initLogger() {
 
 func init() {
 	RootCmd.AddCommand(discCmd)
+	RootCmd.AddCommand(downCmd)
+	RootCmd.AddCommand(eventsCmd)
+	RootCmd.AddCommand(keepaliveCmd)
+	RootCmd.AddCommand(logsCmd)
+	RootCmd.AddCommand(lsCmd)
 	RootCmd.AddCommand(runCmd)
+	RootCmd.AddCommand(statusCmd)
+	RootCmd.AddCommand(tplCmd)
+	RootCmd.AddCommand(upCmd)
 	RootCmd.AddCommand(versionCmd)
 }
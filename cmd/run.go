@@ -26,20 +26,15 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"os"
-	"strconv"
 	"sync"
 	"syscall"
 
 	"os/signal"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/syhpoon/xenvman/pkg/config"
-	"github.com/syhpoon/xenvman/pkg/conteng"
-	"github.com/syhpoon/xenvman/pkg/lib"
 	"github.com/syhpoon/xenvman/pkg/logger"
 	"github.com/syhpoon/xenvman/pkg/server"
 )
@@ -56,72 +51,26 @@ var runCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx, cancel := context.WithCancel(context.Background())
 
-		// Start API server
-		params := server.DefaultParams(ctx)
-
 		cengCtx, cengCancel := context.WithCancel(context.Background())
 
-		if contEng, err := buildContEng(); err != nil {
-			runLog.Errorf("Error building container engine: %s", err)
-		} else {
-			params.ContEng = contEng
-		}
-
-		listener, err := net.Listen("tcp", config.GetString("listen"))
+		// Start API server
+		params, err := server.ParamsFromConfig(ctx, cengCtx, config.Global())
 
 		if err != nil {
-			runLog.Errorf("Unable to start listener: %s", err)
+			runLog.Errorf("%s", err)
 
 			os.Exit(1)
 		}
 
-		params.Listener = listener
-		params.ExportAddress = config.GetString("export_address")
-		params.BaseTplDir = config.GetString("tpl.base_dir")
-		params.BaseWsDir = config.GetString("tpl.ws_dir")
-		params.BaseMountDir = config.GetString("tpl.mount_dir")
-		params.TLSCertFile = config.GetString("tls.cert")
-		params.TLSKeyFile = config.GetString("tls.key")
-		params.DefaultKeepalive = config.GetDuration("keepalive")
-		params.RecursionLimit = config.GetInt("tpl.recursion_limit")
-		params.CengCtx = cengCtx
-
-		runLog.Infof("Base directory: %s", params.BaseTplDir)
-
-		if err := makeDirs([]string{
-			params.BaseTplDir,
-			params.BaseWsDir,
-			params.BaseMountDir}); err != nil {
-			os.Exit(1)
-		}
-
-		// Ports
-		prange, err := parsePorts()
+		listener, err := net.Listen("tcp", config.GetString("listen"))
 
 		if err != nil {
-			runLog.Errorf("Error parsing ports: %s", err)
+			runLog.Errorf("Unable to start listener: %s", err)
 
 			os.Exit(1)
-		} else {
-			params.PortRange = prange
 		}
 
-		// Auth backend
-		authb, err := parseAuthBackend()
-
-		if err != nil {
-			runLog.Errorf("Error parsing auth backend: %+v", err)
-
-			os.Exit(1)
-		} else {
-			if authb == nil {
-				runLog.Infof("Not using any auth backend")
-			} else {
-				runLog.Infof("Using auth backend: %s", authb.String())
-			}
-
-			params.AuthBackend = authb
-		}
+		params.Listener = listener
 
 		srv := server.New(params)
 
@@ -136,21 +85,6 @@ var runCmd = &cobra.Command{
 	},
 }
 
-func buildContEng() (conteng.ContainerEngine, error) {
-	ceng := config.GetString("container_engine")
-
-	switch ceng {
-	case "docker":
-		runLog.Infof("Using Docker container engine")
-
-		params := conteng.DockerEngineParams{}
-
-		return conteng.NewDockerEngine(params)
-	default:
-		return nil, fmt.Errorf("Unknown container engine type: %s", ceng)
-	}
-}
-
 func wait(ctx context.Context, cancel, cengCancel func(),
 	wg *sync.WaitGroup, errch <-chan error) {
 	c := make(chan os.Signal, 1)
@@ -190,67 +124,6 @@ LOOP:
 	cengCancel()
 }
 
-func parseAuthBackend() (server.AuthBackend, error) {
-	b := config.GetString("api_auth")
-
-	switch b {
-	case "":
-		return nil, nil
-	case "basic":
-		return parseAuthBackendBasic()
-	default:
-		return nil, errors.Errorf("Unknown auth backend type: %s", b)
-	}
-}
-
-func parseAuthBackendBasic() (server.AuthBackend, error) {
-	creds := config.GetStringMapString("auth_basic")
-
-	if len(creds) == 0 {
-		return nil, nil
-	}
-
-	return &server.AuthBackendBasic{Credentials: creds}, nil
-}
-
-func parsePorts() (*lib.PortRange, error) {
-	ports := config.GetStrings("ports_range")
-
-	if len(ports) != 2 {
-		return nil, fmt.Errorf("Expected two ports for a range, got %d", len(ports))
-	}
-
-	pMin, err := strconv.ParseUint(ports[0], 0, 16)
-
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing port %s: %s", ports[0], err)
-	}
-
-	pMax, err := strconv.ParseUint(ports[1], 0, 16)
-
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing port %s: %s", ports[1], err)
-	}
-
-	if pMax < pMin {
-		return nil, fmt.Errorf("Port %d should be greater than %d", pMax, pMin)
-	}
-
-	return lib.NewPortRange(uint16(pMin), uint16(pMax)), nil
-}
-
-func makeDirs(dirs []string) error {
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			runLog.Errorf("Error making dir %s: %s", dir, err)
-
-			return err
-		}
-	}
-
-	return nil
-}
-
 func init() {
 	runCmd.Flags().StringP("listen", "l", ":9876", "Listen address")
 	runCmd.Flags().StringP("base", "b", "", "Templates base directory")
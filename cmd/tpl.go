@@ -0,0 +1,184 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/syhpoon/xenvman/pkg/config"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/logger"
+	"github.com/syhpoon/xenvman/pkg/tpl"
+	"github.com/syhpoon/xenvman/pkg/tpltest"
+)
+
+var (
+	flagTplUpdate  bool
+	flagTplVerbose bool
+)
+
+var tplCmd = &cobra.Command{
+	Use:   "tpl",
+	Short: "Template tools",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		_ = config.BindPFlag("tpl.base_dir", cmd.Flag("base"))
+
+		// Only warnings and errors unless asked otherwise
+		if !flagTplVerbose {
+			logger.GetRootLogger().SetLogLevel(logger.LogLevel("WARNING"))
+		}
+	},
+}
+
+var tplTestCmd = &cobra.Command{
+	Use:   "test [template...]",
+	Short: "Run template golden tests",
+	Long: `Execute templates without a container engine and compare
+resulting plans against golden files stored in <name>.tpl.test/*.json.
+If no templates are given, all the templates having tests are run.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		baseDir := config.GetString("tpl.base_dir")
+		tpls := args
+
+		if len(tpls) == 0 {
+			var err error
+
+			if tpls, err = tpltest.FindTested(baseDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		failed := 0
+		total := 0
+
+		for _, name := range tpls {
+			results, err := tpltest.RunCases(baseDir, name, flagTplUpdate)
+
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n", name, err)
+				failed++
+
+				continue
+			}
+
+			for _, res := range results {
+				total++
+
+				switch {
+				case res.Err != nil:
+					failed++
+					fmt.Printf("FAIL %s/%s: %s\n", res.Tpl, res.Name, res.Err)
+				case res.Diff != "":
+					failed++
+					fmt.Printf("FAIL %s/%s: plan mismatch at %s\n",
+						res.Tpl, res.Name, res.Diff)
+				case res.Updated:
+					fmt.Printf("UPDATED %s/%s\n", res.Tpl, res.Name)
+				default:
+					fmt.Printf("PASS %s/%s\n", res.Tpl, res.Name)
+				}
+			}
+		}
+
+		fmt.Printf("%d/%d passed\n", total-failed, total)
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var tplLintCmd = &cobra.Command{
+	Use:   "lint [dir]",
+	Short: "Statically check templates",
+	Long: `Check templates for syntax errors, missing execute function,
+invalid info() structure, invalid parameter types, missing data files
+and import cycles. If dir is not given, base template directory is used.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := config.GetString("tpl.base_dir")
+
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		res, err := tpl.Lint(dir, config.GetDuration("tpl.timeout"))
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		names := make([]string, 0, len(res))
+
+		for name := range res {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		errs := 0
+
+		for _, name := range names {
+			for _, issue := range res[name] {
+				if issue.Severity == def.TplLintError {
+					errs++
+				}
+
+				pos := ""
+
+				if issue.Line > 0 {
+					pos = fmt.Sprintf(":%d:%d", issue.Line, issue.Column)
+				}
+
+				fmt.Printf("%s.tpl.js%s: %s: %s\n", name, pos,
+					issue.Severity, issue.Message)
+			}
+		}
+
+		fmt.Printf("%d templates checked, %d errors\n", len(names), errs)
+
+		if errs > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	tplCmd.PersistentFlags().StringP("base", "b", "",
+		"Templates base directory")
+	tplCmd.PersistentFlags().BoolVarP(&flagTplVerbose, "verbose", "v", false,
+		"Verbose logging")
+
+	tplTestCmd.Flags().BoolVarP(&flagTplUpdate, "update", "u", false,
+		"Update golden files with actual plans")
+
+	tplCmd.AddCommand(tplTestCmd)
+	tplCmd.AddCommand(tplLintCmd)
+}
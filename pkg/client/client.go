@@ -26,8 +26,12 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -44,12 +48,21 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 type Params struct {
 	ServerAddress  string
 	RequestTimeout time.Duration
+	// Basic auth credentials, sent if Username is set
+	Username string
+	Password string
+	// TLS configuration for https server address
+	TLSConfig *tls.Config
+
+	// In-memory connections to a local server
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
 // Client structure represents a logical session with xenvman API server
 type Client struct {
 	httpClient http.Client
 	params     Params
+	local      LocalServer
 }
 
 // Create a new xenvman client
@@ -57,7 +70,8 @@ type Client struct {
 // XENV_API_SERVER environment variable will be used
 func New(params Params) *Client {
 	hcl := http.Client{
-		Timeout: params.RequestTimeout,
+		Timeout:   params.RequestTimeout,
+		Transport: newTransport(params),
 	}
 
 	if params.ServerAddress == "" {
@@ -121,6 +135,7 @@ func (cl *Client) NewEnv(envDef *def.InputEnv) (*Env, error) {
 	e.OutputEnv = &outEnv
 	e.httpClient = cl.httpClient
 	e.serverAddress = cl.params.ServerAddress
+	e.params = cl.params
 
 	return e, nil
 }
@@ -148,6 +163,7 @@ func (cl *Client) ListEnvs() ([]*Env, error) {
 			OutputEnv:     env,
 			httpClient:    cl.httpClient,
 			serverAddress: cl.params.ServerAddress,
+			params:        cl.params,
 		}
 	}
 
@@ -173,6 +189,139 @@ func (cl *Client) ListTemplates() (map[string]*def.TplInfo, error) {
 	return r, nil
 }
 
+// Validate available templates
+func (cl *Client) ValidateTemplates() (map[string][]*def.TplLintIssue, error) {
+	url := fmt.Sprintf("%s/api/v1/tpl/validate", cl.params.ServerAddress)
+
+	resp, err := cl.httpClient.Get(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	r := map[string][]*def.TplLintIssue{}
+
+	if err := fetch(resp, &r); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return r, nil
+}
+
+// Refresh template sources
+func (cl *Client) RefreshTemplates() ([]*def.TplSource, error) {
+	url := fmt.Sprintf("%s/api/v1/tpl/refresh", cl.params.ServerAddress)
+
+	resp, err := cl.httpClient.Post(url, "application/json", nil)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	var r []*def.TplSource
+
+	if err := fetch(resp, &r); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return r, nil
+}
+
+// Upload a template as a tar archive containing <name>.tpl.js and optionally
+// <name>.tpl.data directory, where <name> is the last template name component
+func (cl *Client) UploadTemplate(name string,
+	archive io.Reader) (*def.TplInfo, error) {
+
+	url := fmt.Sprintf("%s/api/v1/tpl/%s", cl.params.ServerAddress, name)
+
+	req, err := http.NewRequest(http.MethodPut, url, archive)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating HTTP request to %s", url)
+	}
+
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	resp, err := cl.httpClient.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	info := &def.TplInfo{}
+
+	if err := fetch(resp, info); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return info, nil
+}
+
+// Get template info
+func (cl *Client) GetTemplate(name string) (*def.TplInfo, error) {
+	url := fmt.Sprintf("%s/api/v1/tpl/%s/info", cl.params.ServerAddress, name)
+
+	resp, err := cl.httpClient.Get(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	info := &def.TplInfo{}
+
+	if err := fetch(resp, info); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return info, nil
+}
+
+// Download a template as a gzipped tar archive
+func (cl *Client) DownloadTemplate(name string) ([]byte, error) {
+	url := fmt.Sprintf("%s/api/v1/tpl/%s", cl.params.ServerAddress, name)
+
+	resp, err := cl.httpClient.Get(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading response body")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected HTTP response %d: %s",
+			resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// Delete a template
+func (cl *Client) DeleteTemplate(name string) error {
+	url := fmt.Sprintf("%s/api/v1/tpl/%s", cl.params.ServerAddress, name)
+
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error creating HTTP request to %s", url)
+	}
+
+	resp, err := cl.httpClient.Do(req)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	return errors.WithStack(fetch(resp, nil))
+}
+
 // Get environment info
 func (cl *Client) GetEnvInfo(id string) (*Env, error) {
 	url := fmt.Sprintf("%s/api/v1/env/%s", cl.params.ServerAddress, id)
@@ -193,6 +342,7 @@ func (cl *Client) GetEnvInfo(id string) (*Env, error) {
 		OutputEnv:     e,
 		httpClient:    cl.httpClient,
 		serverAddress: cl.params.ServerAddress,
+		params:        cl.params,
 	}, nil
 }
 
@@ -27,6 +27,7 @@ package client
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -158,6 +159,115 @@ func TestListTemplates(t *testing.T) {
 	require.Equal(t, itpls, otpls)
 }
 
+func TestValidateTemplates(t *testing.T) {
+	ires := map[string][]*def.TplLintIssue{
+		"tpl1": {},
+		"tpl2": {
+			{
+				Severity: def.TplLintError,
+				Line:     2,
+				Column:   11,
+				Message:  "Syntax error: Unexpected token ;",
+			},
+		},
+	}
+
+	srv := testSrv(ires, t)
+	defer srv.Close()
+
+	cl := New(Params{
+		ServerAddress: srv.URL,
+	})
+
+	ores, err := cl.ValidateTemplates()
+	require.Nil(t, err)
+
+	require.Equal(t, ires, ores)
+}
+
+func TestRefreshTemplates(t *testing.T) {
+	ires := []*def.TplSource{
+		{Namespace: "", Type: "local"},
+		{Namespace: "payments", Type: "git", Revision: "abcdef"},
+		{Namespace: "search", Type: "http", Error: "Checksum mismatch"},
+	}
+
+	srv := testSrv(ires, t)
+	defer srv.Close()
+
+	cl := New(Params{
+		ServerAddress: srv.URL,
+	})
+
+	ores, err := cl.RefreshTemplates()
+	require.Nil(t, err)
+
+	require.Equal(t, ires, ores)
+}
+
+func TestUploadTemplate(t *testing.T) {
+	iinfo := &def.TplInfo{
+		Description: "uploaded",
+		Version:     "1.0.0",
+		DataDir:     []string{"Dockerfile"},
+	}
+
+	srv := testSrv(iinfo, t)
+	defer srv.Close()
+
+	cl := New(Params{
+		ServerAddress: srv.URL,
+	})
+
+	oinfo, err := cl.UploadTemplate("svc/app", strings.NewReader("tar"))
+	require.Nil(t, err)
+
+	require.Equal(t, iinfo, oinfo)
+}
+
+func TestGetTemplate(t *testing.T) {
+	iinfo := &def.TplInfo{
+		Description: "app",
+		DataDir:     []string{},
+	}
+
+	srv := testSrv(iinfo, t)
+	defer srv.Close()
+
+	cl := New(Params{
+		ServerAddress: srv.URL,
+	})
+
+	oinfo, err := cl.GetTemplate("svc/app")
+	require.Nil(t, err)
+
+	require.Equal(t, iinfo, oinfo)
+}
+
+func TestDownloadTemplate(t *testing.T) {
+	srv := testSrv("archive", t)
+	defer srv.Close()
+
+	cl := New(Params{
+		ServerAddress: srv.URL,
+	})
+
+	archive, err := cl.DownloadTemplate("svc/app")
+	require.Nil(t, err)
+	require.Equal(t, "archive", string(archive))
+}
+
+func TestDeleteTemplate(t *testing.T) {
+	srv := testSrv("Template deleted", t)
+	defer srv.Close()
+
+	cl := New(Params{
+		ServerAddress: srv.URL,
+	})
+
+	require.Nil(t, cl.DeleteTemplate("svc/app"))
+}
+
 func TestEnvTerminate(t *testing.T) {
 	srv := testSrv("Env deleted", t)
 	defer srv.Close()
@@ -240,3 +350,23 @@ func testSrv(out interface{}, t *testing.T) *httptest.Server {
 			require.Nil(t, err)
 		}))
 }
+
+func TestEnvCACert(t *testing.T) {
+	pem := "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----\n"
+	srv := testSrv(pem, t)
+	defer srv.Close()
+
+	env := &Env{
+		OutputEnv: &def.OutputEnv{
+			Id: "id",
+		},
+		serverAddress: srv.URL,
+		httpClient: http.Client{
+			Timeout: 3 * time.Second,
+		},
+	}
+
+	cert, err := env.CACert()
+	require.Nil(t, err)
+	require.Equal(t, pem, string(cert))
+}
@@ -27,6 +27,7 @@ package client
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 
@@ -39,6 +40,7 @@ type Env struct {
 
 	httpClient    http.Client
 	serverAddress string
+	params        Params
 }
 
 // Terminate/Delete environment
@@ -125,6 +127,85 @@ func (env *Env) Keepalive() error {
 	return nil
 }
 
+// Fetch PEM-encoded env CA certificate
+func (env *Env) CACert() ([]byte, error) {
+	url := fmt.Sprintf("%s/api/v1/env/%s/ca", env.serverAddress, env.Id)
+
+	resp, err := env.httpClient.Get(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading response body")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected HTTP response %d: %s",
+			resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// Fetch environment events
+func (env *Env) Events() ([]*def.EnvEvent, error) {
+	url := fmt.Sprintf("%s/api/v1/env/%s/events", env.serverAddress, env.Id)
+
+	resp, err := env.httpClient.Get(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	var events []*def.EnvEvent
+
+	if err := fetch(resp, &events); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return events, nil
+}
+
+type LogsParams struct {
+	// Number of last lines to return, all the lines if zero
+	Tail int
+	// Prefix each line with its timestamp
+	Timestamps bool
+}
+
+// Fetch combined stdout and stderr of an env container.
+// Returned reader must be closed
+func (env *Env) Logs(containerId string,
+	params LogsParams) (io.ReadCloser, error) {
+
+	url := fmt.Sprintf("%s/api/v1/env/%s/logs/%s?tail=%d&timestamps=%t",
+		env.serverAddress, env.Id, containerId, params.Tail, params.Timestamps)
+
+	resp, err := env.httpClient.Get(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		//noinspection GoUnhandledErrorResult
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return nil, errors.Errorf("Unexpected HTTP response %d: %s",
+			resp.StatusCode, string(body))
+	}
+
+	return resp.Body, nil
+}
+
 func (env *Env) String() string {
 	b, _ := json.MarshalIndent(env, "", "   ")
 
@@ -8,6 +8,7 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.3.0
 	github.com/syhpoon/xenvman/pkg/def v0.0.0-20190213043411-dc8291eeec9e
+	golang.org/x/net v0.0.0-20181106065722-10aee1819953
 )
 
 replace github.com/syhpoon/xenvman/pkg/def => ../def
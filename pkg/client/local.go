@@ -0,0 +1,115 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type LocalParams struct {
+	// xenvman configuration file, if empty default values
+	// and XENVMAN_* environment variables are used
+	ConfigFile     string
+	RequestTimeout time.Duration
+}
+
+// In-process xenvman server
+type LocalServer interface {
+	// Open an in-memory connection to the server
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+	// Terminate all the environments and stop the server
+	Close() error
+}
+
+// Local server is not reachable over network,
+// the address is only used to build request URLs
+const localAddress = "http://xenvman.local"
+
+// Function starting an in-process xenvman server
+type LocalStarter func(params LocalParams) (LocalServer, error)
+
+var (
+	localStarter LocalStarter
+	localLock    sync.Mutex
+)
+
+// Register local server implementation,
+// called by github.com/syhpoon/xenvman/pkg/local package
+func RegisterLocal(starter LocalStarter) {
+	localLock.Lock()
+	defer localLock.Unlock()
+
+	localStarter = starter
+}
+
+// Create a client running xenvman server in the calling process
+// using local container engine, no running xenvman API server is required.
+// Local mode must be enabled by importing
+// github.com/syhpoon/xenvman/pkg/local package.
+// Client must be closed in order to terminate created environments,
+// including when the process is interrupted by a signal
+func NewLocal(params LocalParams) (*Client, error) {
+	localLock.Lock()
+	starter := localStarter
+	localLock.Unlock()
+
+	if starter == nil {
+		return nil, errors.New("Local mode is not available, " +
+			"import github.com/syhpoon/xenvman/pkg/local to enable it")
+	}
+
+	srv, err := starter(params)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error starting local server")
+	}
+
+	cl := New(Params{
+		ServerAddress:  localAddress,
+		RequestTimeout: params.RequestTimeout,
+		dial:           srv.Dial,
+	})
+
+	cl.httpClient.Transport = &http.Transport{DialContext: srv.Dial}
+	cl.local = srv
+
+	return cl, nil
+}
+
+// Close the client. In local mode all the environments
+// are terminated and the server is stopped
+func (cl *Client) Close() error {
+	if cl.local == nil {
+		return nil
+	}
+
+	return cl.local.Close()
+}
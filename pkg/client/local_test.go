@@ -0,0 +1,104 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testLocalServer struct {
+	srv    *httptest.Server
+	dials  int
+	closed bool
+}
+
+func (s *testLocalServer) Dial(ctx context.Context,
+	network, addr string) (net.Conn, error) {
+
+	s.dials++
+
+	return (&net.Dialer{}).DialContext(ctx, network, s.srv.Listener.Addr().String())
+}
+
+func (s *testLocalServer) Close() error {
+	s.closed = true
+	s.srv.Close()
+
+	return nil
+}
+
+func TestNewLocal(t *testing.T) {
+	defer RegisterLocal(nil)
+
+	_, err := NewLocal(LocalParams{})
+	require.NotNil(t, err)
+
+	RegisterLocal(func(params LocalParams) (LocalServer, error) {
+		return nil, errors.New("no engine")
+	})
+
+	_, err = NewLocal(LocalParams{})
+	require.NotNil(t, err)
+
+	var config, host string
+
+	local := &testLocalServer{
+		srv: httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, req *http.Request) {
+				host = req.Host
+
+				_, _ = w.Write([]byte(`{"data": []}`))
+			})),
+	}
+
+	RegisterLocal(func(params LocalParams) (LocalServer, error) {
+		config = params.ConfigFile
+
+		return local, nil
+	})
+
+	cl, err := NewLocal(LocalParams{ConfigFile: "xenvman.toml"})
+	require.Nil(t, err)
+	require.Equal(t, "xenvman.toml", config)
+
+	envs, err := cl.ListEnvs()
+	require.Nil(t, err)
+	require.Empty(t, envs)
+	require.Equal(t, 1, local.dials)
+	require.Equal(t, "xenvman.local", host)
+
+	require.Nil(t, cl.Close())
+	require.True(t, local.closed)
+
+	// Remote clients have nothing to close
+	require.Nil(t, New(Params{}).Close())
+}
@@ -0,0 +1,78 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package client
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// Transport using client TLS configuration and credentials
+func newTransport(params Params) http.RoundTripper {
+	var base http.RoundTripper = http.DefaultTransport
+
+	if params.TLSConfig != nil {
+		base = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: params.TLSConfig,
+		}
+	}
+
+	if params.Username == "" {
+		return base
+	}
+
+	return &authTransport{
+		base:   base,
+		header: basicAuth(params.Username, params.Password),
+	}
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString(
+		[]byte(username+":"+password))
+}
+
+// Adds Authorization header to every request
+type authTransport struct {
+	base   http.RoundTripper
+	header string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests must not be modified by transports
+	r := new(http.Request)
+	*r = *req
+
+	r.Header = make(http.Header, len(req.Header)+1)
+
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+
+	r.Header.Set("Authorization", t.header)
+
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,181 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+// Local listener forwarding accepted connections to an env container
+// port through xenvman API server
+type Tunnel struct {
+	listener net.Listener
+	config   *websocket.Config
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	err      error
+	sync.Mutex
+}
+
+// Open a tunnel to a container port, zero port means the lowest
+// exposed tcp one. Requires proxy to be enabled on the server.
+func (env *Env) Tunnel(container string, port uint16) (*Tunnel, error) {
+	url := fmt.Sprintf("%s/api/v1/env/%s/tunnel/%s/%d",
+		env.serverAddress, env.Id, container, port)
+
+	// Make sure the target exists before listening
+	resp, err := env.httpClient.Head(url)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected HTTP response %d for %s",
+			resp.StatusCode, url)
+	}
+
+	config, err := websocket.NewConfig(
+		"ws"+strings.TrimPrefix(url, "http"), env.serverAddress)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating websocket config")
+	}
+
+	config.TlsConfig = env.params.TLSConfig
+
+	if env.params.Username != "" {
+		config.Header.Set("Authorization",
+			basicAuth(env.params.Username, env.params.Password))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating tunnel listener")
+	}
+
+	t := &Tunnel{
+		listener: l,
+		config:   config,
+		dial:     env.params.dial,
+	}
+
+	go t.run()
+
+	return t, nil
+}
+
+// Local address to connect to
+func (t *Tunnel) Addr() string {
+	return t.listener.Addr().String()
+}
+
+func (t *Tunnel) Close() error {
+	return t.listener.Close()
+}
+
+// Last error connecting to xenvman server, local connections
+// are closed if tunnel cannot be established
+func (t *Tunnel) Err() error {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.err
+}
+
+func (t *Tunnel) run() {
+	for {
+		conn, err := t.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go t.forward(conn)
+	}
+}
+
+func (t *Tunnel) dialWebsocket(config *websocket.Config) (*websocket.Conn, error) {
+	if t.dial == nil {
+		return websocket.DialConfig(config)
+	}
+
+	conn, err := t.dial(context.Background(), "tcp", config.Location.Host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+
+	if err != nil {
+		_ = conn.Close()
+	}
+
+	return ws, err
+}
+
+func (t *Tunnel) forward(conn net.Conn) {
+	defer conn.Close()
+
+	config := *t.config
+
+	ws, err := t.dialWebsocket(&config)
+
+	if err != nil {
+		t.Lock()
+		t.err = errors.Wrapf(err, "Error connecting to %s", config.Location)
+		t.Unlock()
+
+		return
+	}
+
+	defer ws.Close()
+
+	ws.PayloadType = websocket.BinaryFrame
+
+	done := make(chan struct{}, 2)
+
+	cp := func(dst io.Writer, src io.Reader) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go cp(ws, conn)
+	go cp(conn, ws)
+
+	<-done
+}
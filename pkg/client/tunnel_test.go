@@ -0,0 +1,174 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"golang.org/x/net/websocket"
+)
+
+func TestTunnel(t *testing.T) {
+	echo := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			_, _ = io.Copy(ws, ws)
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/api/v1/env/id/tunnel/web/80" {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			if req.Method == http.MethodHead {
+				return
+			}
+
+			echo.ServeHTTP(w, req)
+		}))
+	defer srv.Close()
+
+	env := &Env{
+		OutputEnv:     &def.OutputEnv{Id: "id"},
+		serverAddress: srv.URL,
+	}
+
+	_, err := env.Tunnel("db", 80)
+	require.NotNil(t, err)
+
+	tun, err := env.Tunnel("web", 80)
+	require.Nil(t, err)
+	defer tun.Close()
+
+	conn, err := net.Dial("tcp", tun.Addr())
+	require.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.Nil(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestTunnelAuthTLS(t *testing.T) {
+	echo := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			_, _ = io.Copy(ws, ws)
+		},
+	}
+
+	var fail int32
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if user, pass, _ := req.BasicAuth(); user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			if req.Method == http.MethodHead {
+				return
+			}
+
+			if atomic.LoadInt32(&fail) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			echo.ServeHTTP(w, req)
+		}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	params := Params{
+		ServerAddress: srv.URL,
+		Username:      "user",
+		TLSConfig:     &tls.Config{RootCAs: pool},
+	}
+
+	envs := func(params Params) *Env {
+		cl := New(params)
+
+		return &Env{
+			OutputEnv:     &def.OutputEnv{Id: "id"},
+			httpClient:    cl.httpClient,
+			serverAddress: srv.URL,
+			params:        params,
+		}
+	}
+
+	// Invalid credentials
+	_, err := envs(params).Tunnel("web", 80)
+	require.NotNil(t, err)
+
+	params.Password = "pass"
+
+	tun, err := envs(params).Tunnel("web", 80)
+	require.Nil(t, err)
+	defer tun.Close()
+
+	conn, err := net.Dial("tcp", tun.Addr())
+	require.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.Nil(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.Nil(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.Nil(t, tun.Err())
+
+	// Failed websocket handshake closes local connection
+	atomic.StoreInt32(&fail, 1)
+
+	conn2, err := net.Dial("tcp", tun.Addr())
+	require.Nil(t, err)
+	defer conn2.Close()
+
+	_, err = conn2.Read(buf)
+	require.NotNil(t, err)
+	require.NotNil(t, tun.Err())
+}
@@ -39,15 +39,44 @@ import (
 	"github.com/spf13/viper"
 )
 
+// Configuration instance
+type Config struct {
+	v *viper.Viper
+}
+
+// Process-wide configuration used by xenvman commands
+var global = &Config{v: viper.GetViper()}
+
 // Init configuration from a given file.
 func InitConfig(path string) error {
+	return global.load(path)
+}
+
+// Load a configuration from a given file,
+// independent from the process-wide one.
+func Load(path string) (*Config, error) {
+	cfg := &Config{v: viper.New()}
+
+	if err := cfg.load(path); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Process-wide configuration
+func Global() *Config {
+	return global
+}
+
+func (cfg *Config) load(path string) error {
 	var reader io.Reader
 
 	// First read default values
 	reader = bytes.NewBuffer(defaultConfig)
-	viper.SetConfigType("toml")
+	cfg.v.SetConfigType("toml")
 
-	err := viper.ReadConfig(reader)
+	err := cfg.v.ReadConfig(reader)
 
 	if err != nil {
 		log.Fatal(err)
@@ -64,48 +93,52 @@ func InitConfig(path string) error {
 
 		reader = file
 
-		viper.SetConfigType(filepath.Ext(path)[1:])
+		cfg.v.SetConfigType(filepath.Ext(path)[1:])
 
-		err = viper.MergeConfig(reader)
+		err = cfg.v.MergeConfig(reader)
 
 		if err != nil {
 			return err
 		}
 	}
 
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("XENVMAN")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	cfg.v.AutomaticEnv()
+	cfg.v.SetEnvPrefix("XENVMAN")
+	cfg.v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	return nil
 }
 
-func GetString(key string) string {
-	return viper.GetString(key)
+func (cfg *Config) GetString(key string) string {
+	return cfg.v.GetString(key)
 }
 
-func GetStrings(key string) []string {
-	return viper.GetStringSlice(key)
+func (cfg *Config) GetStrings(key string) []string {
+	return cfg.v.GetStringSlice(key)
 }
 
-func GetDuration(key string) time.Duration {
-	return viper.GetDuration(key)
+func (cfg *Config) GetDuration(key string) time.Duration {
+	return cfg.v.GetDuration(key)
 }
 
-func GetInt(key string) int {
-	return viper.GetInt(key)
+func (cfg *Config) GetBool(key string) bool {
+	return cfg.v.GetBool(key)
 }
 
-func GetUint64(key string) uint64 {
-	return uint64(viper.GetInt64(key))
+func (cfg *Config) GetInt(key string) int {
+	return cfg.v.GetInt(key)
 }
 
-func GetUint(key string) uint {
-	if !viper.IsSet(key) {
+func (cfg *Config) GetUint64(key string) uint64 {
+	return uint64(cfg.v.GetInt64(key))
+}
+
+func (cfg *Config) GetUint(key string) uint {
+	if !cfg.v.IsSet(key) {
 		return uint(0)
 	}
 
-	tmp := viper.GetString(key)
+	tmp := cfg.v.GetString(key)
 
 	ui64, err := strconv.ParseUint(tmp, 10, 32)
 
@@ -116,18 +149,78 @@ func GetUint(key string) uint {
 	return uint(ui64)
 }
 
+func (cfg *Config) GetStringMapString(key string) map[string]string {
+	return cfg.v.GetStringMapString(key)
+}
+
+func (cfg *Config) GetStringMapStringSlice(key string) map[string][]string {
+	return cfg.v.GetStringMapStringSlice(key)
+}
+
+func (cfg *Config) Get(key string) interface{} {
+	return cfg.v.Get(key)
+}
+
+func (cfg *Config) UnmarshalKey(key string, rawVal interface{}) error {
+	return cfg.v.UnmarshalKey(key, rawVal)
+}
+
+func (cfg *Config) IsSet(key string) bool {
+	return cfg.v.IsSet(key)
+}
+
+func (cfg *Config) BindPFlag(key string, flag *pflag.Flag) error {
+	return cfg.v.BindPFlag(key, flag)
+}
+
+func GetString(key string) string {
+	return global.GetString(key)
+}
+
+func GetStrings(key string) []string {
+	return global.GetStrings(key)
+}
+
+func GetDuration(key string) time.Duration {
+	return global.GetDuration(key)
+}
+
+func GetBool(key string) bool {
+	return global.GetBool(key)
+}
+
+func GetInt(key string) int {
+	return global.GetInt(key)
+}
+
+func GetUint64(key string) uint64 {
+	return global.GetUint64(key)
+}
+
+func GetUint(key string) uint {
+	return global.GetUint(key)
+}
+
 func GetStringMapString(key string) map[string]string {
-	return viper.GetStringMapString(key)
+	return global.GetStringMapString(key)
+}
+
+func GetStringMapStringSlice(key string) map[string][]string {
+	return global.GetStringMapStringSlice(key)
 }
 
 func Get(key string) interface{} {
-	return viper.Get(key)
+	return global.Get(key)
+}
+
+func UnmarshalKey(key string, rawVal interface{}) error {
+	return global.UnmarshalKey(key, rawVal)
 }
 
 func IsSet(key string) bool {
-	return viper.IsSet(key)
+	return global.IsSet(key)
 }
 
 func BindPFlag(key string, flag *pflag.Flag) error {
-	return viper.BindPFlag(key, flag)
+	return global.BindPFlag(key, flag)
 }
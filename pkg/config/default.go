@@ -28,6 +28,7 @@ var defaultConfig = []byte(`
 listen = ":9876"
 export_address = "localhost"
 ports_range = [20000, 30000]
+ports_host_ip = ""
 container_engine = "docker"
 keepalive = "2m"
 
@@ -36,6 +37,40 @@ base_dir = "."
 ws_dir = "/tmp/xenvman/ws"
 mount_dir = "/tmp/xenvman/mount"
 recursion_limit = 1000
+sources_dir = "/tmp/xenvman/sources"
+refresh_interval = "10m"
+inline = false
+timeout = "1m"
+max_images = 0
+max_containers = 0
+max_write_bytes = 0
+
+[build_cache]
+enabled = true
+max_images = 20
+max_size = 0
+
+[images]
+pull_policy = "always"
+offline = false
+docker_config = ""
+
+[[network.subnet_pools]]
+base = "10.0.0.0/8"
+size = 24
+
+[[network.subnet_pools]]
+base = "fd78:656e::/48"
+size = 64
+
+[proxy]
+enabled = false
+domain = "localhost"
+
+[secrets]
+provider = ""
+env_prefix = "XENVMAN_SECRET_"
+dir = ""
 
 [log]
 config = "<root>=trace"
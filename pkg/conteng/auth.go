@@ -0,0 +1,344 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	hclient "github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/pkg/errors"
+)
+
+// Registry host name used for Docker Hub
+const dockerHub = "docker.io"
+
+// Docker Hub server address as expected by docker daemon and
+// credential helpers
+const dockerHubAddress = "https://index.docker.io/v1/"
+
+// Username credential helpers return for identity tokens
+const identityTokenUsername = "<token>"
+
+// Credentials for a container registry
+type RegistryCredentials struct {
+	// Registry host, docker.io for Docker Hub
+	Registry string `mapstructure:"registry"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// Source of registry credentials
+type CredentialsSource interface {
+	// Return credentials for a registry host, nil if there are none
+	Get(registry string) (*RegistryCredentials, error)
+	// Return registry hosts credentials are available for
+	Registries() ([]string, error)
+}
+
+// Explicitly provided credentials
+type StaticCredentials []*RegistryCredentials
+
+func (sc StaticCredentials) Get(registry string) (*RegistryCredentials, error) {
+	registry = normalizeRegistry(registry)
+
+	for _, c := range sc {
+		if normalizeRegistry(c.Registry) == registry {
+			return c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (sc StaticCredentials) Registries() ([]string, error) {
+	var res []string
+
+	for _, c := range sc {
+		res = append(res, normalizeRegistry(c.Registry))
+	}
+
+	return res, nil
+}
+
+// Credentials stored in docker cli config file,
+// including the ones kept by credential helpers (credsStore and credHelpers)
+type DockerConfigCredentials struct {
+	// Path to config.json, $DOCKER_CONFIG/config.json or
+	// $HOME/.docker/config.json if empty
+	Path string
+}
+
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+func (dc *DockerConfigCredentials) Get(registry string) (*RegistryCredentials, error) {
+	conf, err := dc.load()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	registry = normalizeRegistry(registry)
+
+	// Credential helper configured for this very registry
+	for host, helper := range conf.CredHelpers {
+		if normalizeRegistry(host) == registry {
+			return helperCredentials(helper, host, registry)
+		}
+	}
+
+	if conf.CredsStore != "" {
+		creds, err := helperCredentials(conf.CredsStore,
+			serverAddress(registry), registry)
+
+		if creds != nil || err != nil {
+			return creds, err
+		}
+	}
+
+	for host, auth := range conf.Auths {
+		if normalizeRegistry(host) != registry {
+			continue
+		}
+
+		creds := &RegistryCredentials{
+			Registry: registry,
+			Username: auth.Username,
+			Password: auth.Password,
+		}
+
+		if auth.IdentityToken != "" {
+			creds.Username = identityTokenUsername
+			creds.Password = auth.IdentityToken
+		} else if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error decoding auth entry for %s", host)
+			}
+
+			split := strings.SplitN(string(decoded), ":", 2)
+
+			if len(split) < 2 {
+				return nil, errors.Errorf("Invalid auth entry format for %s", host)
+			}
+
+			creds.Username, creds.Password = split[0], split[1]
+		}
+
+		return creds, nil
+	}
+
+	return nil, nil
+}
+
+func (dc *DockerConfigCredentials) Registries() ([]string, error) {
+	conf, err := dc.load()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var res []string
+
+	for host := range conf.Auths {
+		res = append(res, normalizeRegistry(host))
+	}
+
+	for host := range conf.CredHelpers {
+		res = append(res, normalizeRegistry(host))
+	}
+
+	if conf.CredsStore != "" {
+		hosts, err := hclient.List(credentialsHelper(conf.CredsStore))
+
+		if err != nil {
+			dockerLog.Warningf("Error listing credentials in %s store: %s",
+				conf.CredsStore, err)
+		}
+
+		for host := range hosts {
+			res = append(res, normalizeRegistry(host))
+		}
+	}
+
+	return res, nil
+}
+
+// Missing config file is the same as an empty one
+func (dc *DockerConfigCredentials) load() (*dockerConfigFile, error) {
+	path := dc.Path
+
+	if path == "" {
+		dir := os.Getenv("DOCKER_CONFIG")
+
+		if dir == "" {
+			dir = filepath.Join(os.Getenv("HOME"), ".docker")
+		}
+
+		path = filepath.Join(dir, "config.json")
+	}
+
+	conf := &dockerConfigFile{}
+	b, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return conf, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Error reading docker config %s", path)
+	}
+
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing docker config %s", path)
+	}
+
+	return conf, nil
+}
+
+func credentialsHelper(name string) hclient.ProgramFunc {
+	return hclient.NewShellProgramFunc(fmt.Sprintf("docker-credential-%s", name))
+}
+
+func helperCredentials(helper, host, registry string) (*RegistryCredentials, error) {
+	dockerLog.Debugf("Using '%s' credential helper for %s", helper, host)
+
+	creds, err := hclient.Get(credentialsHelper(helper), host)
+
+	if credentials.IsErrCredentialsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Error running docker-credential-%s", helper)
+	}
+
+	return &RegistryCredentials{
+		Registry: registry,
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
+
+// Several sources, earlier ones take precedence
+type CredentialsChain []CredentialsSource
+
+func (cc CredentialsChain) Get(registry string) (*RegistryCredentials, error) {
+	for _, src := range cc {
+		if src == nil {
+			continue
+		}
+
+		creds, err := src.Get(registry)
+
+		if creds != nil || err != nil {
+			return creds, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (cc CredentialsChain) Registries() ([]string, error) {
+	seen := map[string]bool{}
+	var res []string
+
+	for _, src := range cc {
+		if src == nil {
+			continue
+		}
+
+		regs, err := src.Registries()
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, reg := range regs {
+			if !seen[reg] {
+				seen[reg] = true
+				res = append(res, reg)
+			}
+		}
+	}
+
+	sort.Strings(res)
+
+	return res, nil
+}
+
+// Registry host an image is pulled from
+func imageRegistry(imgName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imgName)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Error parsing image name %s", imgName)
+	}
+
+	return reference.Domain(named), nil
+}
+
+// Strip scheme and path from a registry address,
+// all Docker Hub aliases are mapped to docker.io
+func normalizeRegistry(addr string) string {
+	addr = strings.TrimPrefix(addr, "https://")
+	addr = strings.TrimPrefix(addr, "http://")
+
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr = addr[:i]
+	}
+
+	addr = strings.ToLower(addr)
+
+	switch addr {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHub
+	default:
+		return addr
+	}
+}
+
+// Registry address as expected by docker daemon
+func serverAddress(registry string) string {
+	if registry == dockerHub {
+		return dockerHubAddress
+	}
+
+	return registry
+}
@@ -0,0 +1,126 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+func TestNormalizeRegistry(t *testing.T) {
+	require.Equal(t, "docker.io", normalizeRegistry("https://index.docker.io/v1/"))
+	require.Equal(t, "docker.io", normalizeRegistry("registry-1.docker.io"))
+	require.Equal(t, "registry.local:5000",
+		normalizeRegistry("http://registry.local:5000/v2/"))
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	require.Nil(t, os.MkdirAll(tmpDir, 0755))
+
+	// Fake credential helper
+	helper := filepath.Join(tmpDir, "docker-credential-fake")
+	script := `#!/bin/sh
+read url
+echo "{\"ServerURL\": \"$url\", \"Username\": \"helper\", \"Secret\": \"s3cret\"}"
+`
+	require.Nil(t, ioutil.WriteFile(helper, []byte(script), 0755))
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	require.Nil(t, os.Setenv("PATH", tmpDir+":"+path))
+
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	conf := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "` + auth + `"},
+    "token.local": {"identitytoken": "tok"}
+  },
+  "credHelpers": {"helper.local": "fake"}
+}`
+	file := filepath.Join(tmpDir, "config.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte(conf), 0644))
+
+	dc := &DockerConfigCredentials{Path: file}
+
+	creds, err := dc.Get("docker.io")
+	require.Nil(t, err)
+	require.Equal(t, "user", creds.Username)
+	require.Equal(t, "pass", creds.Password)
+
+	creds, err = dc.Get("token.local")
+	require.Nil(t, err)
+	require.Equal(t, "tok", authConfig(creds).IdentityToken)
+
+	creds, err = dc.Get("helper.local")
+	require.Nil(t, err)
+	require.Equal(t, "helper", creds.Username)
+	require.Equal(t, "s3cret", creds.Password)
+
+	creds, err = dc.Get("unknown.local")
+	require.Nil(t, err)
+	require.Nil(t, creds)
+
+	regs, err := CredentialsChain{dc}.Registries()
+	require.Nil(t, err)
+	require.Equal(t, []string{"docker.io", "helper.local", "token.local"}, regs)
+
+	// Missing config file
+	creds, err = (&DockerConfigCredentials{
+		Path: filepath.Join(tmpDir, "missing.json")}).Get("docker.io")
+	require.Nil(t, err)
+	require.Nil(t, creds)
+}
+
+func TestCredentialsChain(t *testing.T) {
+	chain := CredentialsChain{
+		nil,
+		StaticCredentials{{Registry: "registry.local", Username: "env"}},
+		StaticCredentials{
+			{Registry: "registry.local", Username: "server"},
+			{Registry: "index.docker.io", Username: "hub"},
+		},
+	}
+
+	creds, err := chain.Get("registry.local")
+	require.Nil(t, err)
+	require.Equal(t, "env", creds.Username)
+
+	creds, err = chain.Get("docker.io")
+	require.Nil(t, err)
+	require.Equal(t, "hub", creds.Username)
+
+	regs, err := chain.Registries()
+	require.Nil(t, err)
+	require.Equal(t, []string{"docker.io", "registry.local"}, regs)
+}
@@ -27,10 +27,36 @@ package conteng
 import (
 	"context"
 	"io"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 type NetworkId = string
 
+type CreateNetworkParams struct {
+	// Create a dual-stack network with an additional IPv6 subnet
+	IPv6 bool
+}
+
+type Network struct {
+	Id NetworkId
+	// IPv4 subnet
+	Subnet string
+	// IPv6 subnet, empty unless the network is a dual-stack one
+	Subnet6 string
+	// Addresses already taken by the gateway and attached containers,
+	// only set by InspectNetwork
+	Taken []string
+}
+
+// Container address on a network
+type NetworkEndpoint struct {
+	NetworkId NetworkId
+	IP        string
+	IP6       string // Only set for dual-stack networks
+}
+
 type ContainerFileMount struct {
 	HostFile      string
 	ContainerFile string
@@ -40,28 +66,118 @@ type ContainerFileMount struct {
 type RunContainerParams struct {
 	NetworkId   NetworkId
 	IP          string
+	IP6         string // Only set for dual-stack networks
 	DiscoverDNS string
-	Hosts       map[string]string // hostname -> IP
-	Ports       map[uint16]uint16 // container port -> host port
+	Hosts       map[string]string  // hostname -> IP
+	Hosts6      map[string]string  // hostname -> IPv6
+	Networks    []*NetworkEndpoint // Additional networks to attach to
+	Ports       []*Port            // Host ports must be set
 	Environ     map[string]string
 	Cmd         []string
 	Entrypoint  []string
 	FileMounts  []*ContainerFileMount
 }
 
+type BuildImageParams struct {
+	// Do not use engine build cache
+	NoCache bool
+	// Always attempt to pull a newer version of the base image
+	Pull bool
+	// Build-time variables
+	BuildArgs map[string]string
+	// Build stage to stop at
+	Target string
+	// Dockerfile path relative to build context root
+	Dockerfile string
+	// Image labels
+	Labels map[string]string
+	// Target platform, e.g. linux/amd64
+	Platform string
+	// Optional build progress callback
+	Progress ProgressFunc
+	// Optional registry credentials for pulling base images,
+	// take precedence over engine wide credentials
+	Credentials CredentialsSource
+}
+
+type ContainerLogsParams struct {
+	// Number of last lines to return, all the lines if zero
+	Tail int
+	// Prefix each line with its timestamp
+	Timestamps bool
+}
+
+type FetchImageParams struct {
+	// Optional pull progress callback
+	Progress ProgressFunc
+	// Optional registry credentials,
+	// take precedence over engine wide credentials
+	Credentials CredentialsSource
+}
+
+// Container could not be started because some of its host ports
+// are already taken
+type PortConflictError struct {
+	Err error
+}
+
+func (e *PortConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func IsPortConflict(err error) bool {
+	_, ok := errors.Cause(err).(*PortConflictError)
+
+	return ok
+}
+
+// Engine error messages meaning a host port is taken
+var portConflictMessages = []string{
+	"port is already allocated",
+	"address already in use",
+}
+
+func isPortConflictMessage(msg string) bool {
+	for _, m := range portConflictMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type ContainerEngine interface {
-	CreateNetwork(ctx context.Context, name string) (NetworkId, string, error)
-	BuildImage(ctx context.Context, imgName string, buildContext io.Reader) error
-	GetImagePorts(ctx context.Context, imgName string) ([]uint16, error)
+	CreateNetwork(ctx context.Context, name string,
+		params CreateNetworkParams) (*Network, error)
+	BuildImage(ctx context.Context, imgName string, buildContext io.Reader,
+		params BuildImageParams) error
+	GetImagePorts(ctx context.Context, imgName string) ([]*Port, error)
+	GetImageSize(ctx context.Context, imgName string) (int64, error)
+	// Whether an image is present locally
+	ImageExists(ctx context.Context, imgName string) (bool, error)
+	// Add a new tag to an existing image
+	TagImage(ctx context.Context, imgName, tag string) error
 	RemoveImage(ctx context.Context, imgName string) error
+	// Returns PortConflictError if host ports are taken,
+	// in which case the container is not left behind
 	RunContainer(ctx context.Context, name, tag string,
 		params RunContainerParams) (string, error)
 	StopContainer(ctx context.Context, id string) error
 	RestartContainer(ctx context.Context, id string) error
+	// Combined stdout and stderr of a container
+	ContainerLogs(ctx context.Context, id string,
+		params ContainerLogsParams) (io.ReadCloser, error)
 	// Stop and remove
 	RemoveContainer(ctx context.Context, id string) error
 	RemoveNetwork(ctx context.Context, id string) error
-	FetchImage(ctx context.Context, imgName string) error
+	// Get a pre-existing network by name or id
+	InspectNetwork(ctx context.Context, name string) (*Network, error)
+	// Attach a running container to a network
+	ConnectNetwork(ctx context.Context, contId string,
+		endpoint NetworkEndpoint) error
+	FetchImage(ctx context.Context, imgName string,
+		params FetchImageParams) error
 
 	Terminate()
 }
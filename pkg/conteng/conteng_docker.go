@@ -25,27 +25,21 @@
 package conteng
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
-	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"sync"
 
-	"github.com/docker/distribution/reference"
-	hclient "github.com/docker/docker-credential-helpers/client"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/registry"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/pkg/errors"
 	"github.com/syhpoon/xenvman/pkg/lib"
@@ -55,14 +49,24 @@ import (
 var dockerLog = logger.GetLogger("xenvman.pkg.conteng.conteng_docker")
 
 type DockerEngineParams struct {
+	// Registry credentials used for pulling images
+	Credentials CredentialsSource
+	// Pools env network subnets are allocated from,
+	// lib.DefaultSubnetPools if empty
+	SubnetPools []lib.SubnetPool
 }
 
+// Number of attempts to create a network if the allocated subnet
+// turns out to be taken by someone else
+const createNetworkAttempts = 3
+
 type DockerEngine struct {
-	cl         *client.Client
-	params     DockerEngineParams
-	subNetOct1 int
-	subNetOct2 int
-	subNetMu   sync.Mutex
+	cl      *client.Client
+	params  DockerEngineParams
+	subnets *lib.SubnetAllocator
+	// Network id -> allocated subnets
+	networks   map[NetworkId][]string
+	networksMu sync.Mutex
 }
 
 func NewDockerEngine(params DockerEngineParams) (*DockerEngine, error) {
@@ -78,44 +82,131 @@ func NewDockerEngine(params DockerEngineParams) (*DockerEngine, error) {
 
 	dockerLog.Debugf("Docker engine client created")
 
+	pools := params.SubnetPools
+
+	if len(pools) == 0 {
+		pools = lib.DefaultSubnetPools
+	}
+
+	subnets, err := lib.NewSubnetAllocator(pools)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	return &DockerEngine{
-		cl:         cli,
-		params:     params,
-		subNetOct1: 0,
-		subNetOct2: 0,
+		cl:       cli,
+		params:   params,
+		subnets:  subnets,
+		networks: map[NetworkId][]string{},
 	}, nil
 }
 
 func (de *DockerEngine) CreateNetwork(ctx context.Context,
-	name string) (NetworkId, string, error) {
-	sub, err := de.getSubNet()
+	name string, params CreateNetworkParams) (*Network, error) {
+
+	used, err := de.usedSubnets(ctx)
 
 	if err != nil {
-		return "", "", err
+		return nil, errors.WithStack(err)
 	}
 
-	netParams := types.NetworkCreate{
-		CheckDuplicate: true,
-		Driver:         "bridge",
-		IPAM: &network.IPAM{
-			Config: []network.IPAMConfig{
-				{
-					Subnet:  sub,
-					IPRange: sub,
-				},
+	for attempt := 1; ; attempt++ {
+		subnets, err := de.allocateSubnets(used, params.IPv6)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		var ipamConfig []network.IPAMConfig
+		var subs []string
+
+		for _, subnet := range subnets {
+			sub := subnet.String()
+
+			subs = append(subs, sub)
+			ipamConfig = append(ipamConfig, network.IPAMConfig{
+				Subnet:  sub,
+				IPRange: sub,
+			})
+		}
+
+		netParams := types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         "bridge",
+			EnableIPv6:     params.IPv6,
+			IPAM: &network.IPAM{
+				Config: ipamConfig,
 			},
-		},
+		}
+
+		r, err := de.cl.NetworkCreate(ctx, name, netParams)
+
+		if err != nil {
+			de.releaseSubnets(subs)
+
+			// Most likely taken by a network created concurrently
+			// by someone else, try another one
+			if attempt < createNetworkAttempts {
+				dockerLog.Debugf("Error creating network with subnets %v, "+
+					"retrying: %s", subs, err)
+
+				used = append(used, subnets...)
+
+				continue
+			}
+
+			return nil, errors.Wrapf(err, "Error creating docker network: %v", subs)
+		}
+
+		de.networksMu.Lock()
+		de.networks[r.ID] = subs
+		de.networksMu.Unlock()
+
+		dockerLog.Debugf("Network created: %s - %s :: %v", name, r.ID, subs)
+
+		n := &Network{
+			Id:     r.ID,
+			Subnet: subs[0],
+		}
+
+		if params.IPv6 {
+			n.Subnet6 = subs[1]
+		}
+
+		return n, nil
+	}
+}
+
+// Allocate an IPv4 and, optionally, an IPv6 subnet
+func (de *DockerEngine) allocateSubnets(used []*net.IPNet,
+	ipv6 bool) ([]*net.IPNet, error) {
+
+	subnet, err := de.subnets.Allocate(used, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ipv6 {
+		return []*net.IPNet{subnet}, nil
 	}
 
-	r, err := de.cl.NetworkCreate(ctx, name, netParams)
+	subnet6, err := de.subnets.Allocate(used, true)
 
 	if err != nil {
-		return "", "", errors.Wrapf(err, "Error creating docker network: %s", sub)
+		de.subnets.Release(subnet.String())
+
+		return nil, err
 	}
 
-	dockerLog.Debugf("Network created: %s - %s :: %s", name, r.ID, sub)
+	return []*net.IPNet{subnet, subnet6}, nil
+}
 
-	return r.ID, sub, nil
+func (de *DockerEngine) releaseSubnets(subs []string) {
+	for _, sub := range subs {
+		de.subnets.Release(sub)
+	}
 }
 
 // Run Docker container
@@ -129,11 +220,24 @@ func (de *DockerEngine) RunContainer(ctx context.Context, name, tag string,
 		hosts = append(hosts, fmt.Sprintf("%s:%s", host, ip))
 	}
 
+	for host, ip := range params.Hosts6 {
+		hosts = append(hosts, fmt.Sprintf("%s:%s", host, ip))
+	}
+
 	// Ports
 	var rawPorts []string
 
-	for contPort, hostPort := range params.Ports {
-		rawPorts = append(rawPorts, fmt.Sprintf("%d:%d", hostPort, contPort))
+	for _, port := range params.Ports {
+		// Explicitly bind on both stacks for dual-stack networks,
+		// so that the ports are reachable over IPv6 regardless
+		// of the daemon defaults
+		if port.HostIP == "" && params.IP6 != "" {
+			rawPorts = append(rawPorts,
+				fmt.Sprintf("0.0.0.0:%d:%s", port.HostPort, port.Key()),
+				fmt.Sprintf("[::]:%d:%s", port.HostPort, port.Key()))
+		} else {
+			rawPorts = append(rawPorts, port.String())
+		}
 	}
 
 	ports, bindings, err := nat.ParsePortSpecs(rawPorts)
@@ -183,6 +287,7 @@ func (de *DockerEngine) RunContainer(ctx context.Context, name, tag string,
 			params.NetworkId: {
 				IPAMConfig: &network.EndpointIPAMConfig{
 					IPv4Address: params.IP,
+					IPv6Address: params.IP6,
 				},
 			},
 		},
@@ -203,9 +308,28 @@ func (de *DockerEngine) RunContainer(ctx context.Context, name, tag string,
 		return "", errors.Wrapf(err, "Error creating container %s", tag)
 	}
 
+	// Only a single network can be set on creation
+	for _, endpoint := range params.Networks {
+		if err = de.ConnectNetwork(ctx, r.ID, *endpoint); err != nil {
+			if rerr := de.RemoveContainer(ctx, r.ID); rerr != nil {
+				dockerLog.Warningf("Error removing container %s: %s", r.ID, rerr)
+			}
+
+			return "", errors.WithStack(err)
+		}
+	}
+
 	err = de.cl.ContainerStart(ctx, r.ID, types.ContainerStartOptions{})
 
 	if err != nil {
+		if rerr := de.RemoveContainer(ctx, r.ID); rerr != nil {
+			dockerLog.Warningf("Error removing container %s: %s", r.ID, rerr)
+		}
+
+		if isPortConflictMessage(err.Error()) {
+			err = &PortConflictError{Err: err}
+		}
+
 		return "", errors.Wrapf(err, "Error starting container: %s", tag)
 	}
 
@@ -230,39 +354,188 @@ func (de *DockerEngine) RestartContainer(ctx context.Context, id string) error {
 	return de.cl.ContainerStart(ctx, id, types.ContainerStartOptions{})
 }
 
+func (de *DockerEngine) ContainerLogs(ctx context.Context, id string,
+	params ContainerLogsParams) (io.ReadCloser, error) {
+
+	tail := "all"
+
+	if params.Tail > 0 {
+		tail = strconv.Itoa(params.Tail)
+	}
+
+	rc, err := de.cl.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: params.Timestamps,
+		Tail:       tail,
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error fetching container logs: %s", id)
+	}
+
+	// Containers are run without a TTY,
+	// so stdout and stderr are multiplexed in a single stream
+	pr, pw := io.Pipe()
+
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, rc)
+		_ = pw.CloseWithError(err)
+	}()
+
+	return &logsReader{PipeReader: pr, logs: rc}, nil
+}
+
+// Demultiplexed container logs
+type logsReader struct {
+	*io.PipeReader
+	logs io.Closer
+}
+
+func (r *logsReader) Close() error {
+	_ = r.logs.Close()
+
+	return r.PipeReader.Close()
+}
+
 func (de *DockerEngine) RemoveNetwork(ctx context.Context, id string) error {
-	return de.cl.NetworkRemove(ctx, id)
+	if err := de.cl.NetworkRemove(ctx, id); err != nil {
+		return err
+	}
+
+	de.networksMu.Lock()
+	subs := de.networks[id]
+	delete(de.networks, id)
+	de.networksMu.Unlock()
+
+	de.releaseSubnets(subs)
+
+	return nil
+}
+
+func (de *DockerEngine) InspectNetwork(ctx context.Context,
+	name string) (*Network, error) {
+
+	r, err := de.cl.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error inspecting network %s", name)
+	}
+
+	n := &Network{Id: r.ID}
+
+	for _, cfg := range r.IPAM.Config {
+		ip, _, err := net.ParseCIDR(cfg.Subnet)
+
+		if err != nil {
+			continue
+		}
+
+		if ip.To4() != nil {
+			n.Subnet = cfg.Subnet
+		} else {
+			n.Subnet6 = cfg.Subnet
+		}
+
+		if cfg.Gateway != "" {
+			n.Taken = append(n.Taken, cfg.Gateway)
+		}
+	}
+
+	if n.Subnet == "" {
+		return nil, errors.Errorf("Network %s has no IPv4 subnet", name)
+	}
+
+	for _, cont := range r.Containers {
+		for _, addr := range []string{cont.IPv4Address, cont.IPv6Address} {
+			if ip, _, err := net.ParseCIDR(addr); err == nil {
+				n.Taken = append(n.Taken, ip.String())
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (de *DockerEngine) ConnectNetwork(ctx context.Context, contId string,
+	endpoint NetworkEndpoint) error {
+
+	err := de.cl.NetworkConnect(ctx, endpoint.NetworkId, contId,
+		&network.EndpointSettings{
+			IPAMConfig: &network.EndpointIPAMConfig{
+				IPv4Address: endpoint.IP,
+				IPv6Address: endpoint.IP6,
+			},
+		})
+
+	if err != nil {
+		return errors.Wrapf(err, "Error connecting container %s to network %s",
+			contId, endpoint.NetworkId)
+	}
+
+	dockerLog.Debugf("Container %s connected to network %s",
+		contId, endpoint.NetworkId)
+
+	return nil
 }
 
 func (de *DockerEngine) BuildImage(ctx context.Context, imgName string,
-	buildContext io.Reader) error {
+	buildContext io.Reader, params BuildImageParams) error {
+
+	buildArgs := map[string]*string{}
+
+	for k := range params.BuildArgs {
+		v := params.BuildArgs[k]
+		buildArgs[k] = &v
+	}
+
+	auths, err := de.buildAuthConfigs(params.Credentials)
+
+	if err != nil {
+		dockerLog.Warningf("Building %s without registry credentials: %s",
+			imgName, err)
+	}
 
 	opts := types.ImageBuildOptions{
-		NetworkMode:    "bridge",
-		Tags:           []string{imgName},
-		Remove:         true,
-		ForceRemove:    true,
-		SuppressOutput: true,
-		NoCache:        true,
-		PullParent:     true,
+		AuthConfigs: auths,
+		NetworkMode: "bridge",
+		Tags:        []string{imgName},
+		Remove:      true,
+		ForceRemove: true,
+		NoCache:     params.NoCache,
+		PullParent:  params.Pull,
+		BuildArgs:   buildArgs,
+		Target:      params.Target,
+		Dockerfile:  params.Dockerfile,
+		Labels:      params.Labels,
+		Platform:    params.Platform,
 	}
 
 	r, err := de.cl.ImageBuild(ctx, buildContext, opts)
 
-	if r.Body != nil {
-		defer r.Body.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		// Check server response
-		if rerr := de.isErrorResponse(r.Body); rerr != nil {
-			return errors.Errorf("Error from Docker server: %s", rerr)
-		}
+	defer r.Body.Close()
+
+	tail, serverErr, err := readJSONStream(r.Body, imgName, params.Progress)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error reading build output for %s", imgName)
 	}
 
-	if err == nil {
-		dockerLog.Debugf("Image built: %s", imgName)
+	if serverErr != "" {
+		return &BuildError{
+			Image: imgName,
+			Err:   fmt.Sprintf("Error from Docker server: %s", serverErr),
+			Log:   tail,
+		}
 	}
 
-	return err
+	dockerLog.Debugf("Image built: %s", imgName)
+
+	return nil
 }
 
 func (de *DockerEngine) RemoveImage(ctx context.Context, imgName string) error {
@@ -280,245 +553,217 @@ func (de *DockerEngine) RemoveImage(ctx context.Context, imgName string) error {
 	return err
 }
 
-func (de *DockerEngine) FetchImage(ctx context.Context, imgName string) error {
-	out, err := de.cl.ImagePull(ctx, imgName, types.ImagePullOptions{})
-	var auth string
+func (de *DockerEngine) FetchImage(ctx context.Context, imgName string,
+	params FetchImageParams) error {
+	auth, err := de.registryAuth(params.Credentials, imgName)
 
+	// Public images can still be pulled
 	if err != nil {
-		// Retry with auth
-		auth, err = de.getAuthForImage(imgName)
+		dockerLog.Warningf("Pulling %s anonymously: %s", imgName, err)
+	}
 
-		if err != nil {
-			return err
-		}
+	out, err := de.cl.ImagePull(ctx, imgName, types.ImagePullOptions{
+		RegistryAuth: auth,
+	})
 
-		out, err = de.cl.ImagePull(ctx, imgName, types.ImagePullOptions{
-			RegistryAuth: auth,
-		})
+	if err != nil {
+		return errors.Wrapf(err, "Error pulling image %s", imgName)
 	}
 
-	if err == nil {
-		dockerLog.Debugf("Image fetched: %s", imgName)
+	//noinspection GoUnhandledErrorResult
+	defer out.Close()
+
+	_, serverErr, err := readJSONStream(out, imgName, params.Progress)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error reading pull output for %s", imgName)
 	}
 
-	if out != nil {
-		_, _ = io.Copy(ioutil.Discard, out)
+	if serverErr != "" {
+		return errors.Errorf("Error from Docker server: %s", serverErr)
 	}
 
-	return err
+	dockerLog.Debugf("Image fetched: %s", imgName)
+
+	return nil
 }
 
 func (de *DockerEngine) GetImagePorts(ctx context.Context,
-	tag string) ([]uint16, error) {
+	tag string) ([]*Port, error) {
 	r, _, err := de.cl.ImageInspectWithRaw(ctx, tag)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error inspecting image %s", tag)
 	}
 
-	var ports []uint16
+	var ports []*Port
 
 	for p := range r.Config.ExposedPorts {
-		ports = append(ports, uint16(p.Int()))
+		ports = append(ports, &Port{
+			Port:     uint16(p.Int()),
+			Protocol: p.Proto(),
+		})
 	}
 
 	return ports, nil
 }
 
-func (de *DockerEngine) Terminate() {
-	de.cl.Close()
-}
-
-func (de *DockerEngine) isErrorResponse(r io.Reader) error {
-	data, err := ioutil.ReadAll(r)
+func (de *DockerEngine) GetImageSize(ctx context.Context,
+	tag string) (int64, error) {
+	r, _, err := de.cl.ImageInspectWithRaw(ctx, tag)
 
 	if err != nil {
-		return err
+		return 0, errors.Wrapf(err, "Error inspecting image %s", tag)
 	}
 
-	split := bytes.Split(data, []byte("\n"))
+	return r.Size, nil
+}
+
+func (de *DockerEngine) ImageExists(ctx context.Context,
+	imgName string) (bool, error) {
+	_, _, err := de.cl.ImageInspectWithRaw(ctx, imgName)
 
-	type errResp struct {
-		Error string
+	if client.IsErrNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "Error inspecting image %s", imgName)
 	}
 
-	for i := range split {
-		e := errResp{}
+	return true, nil
+}
 
-		if err := json.Unmarshal(split[i], &e); err == nil && e.Error != "" {
-			return errors.New(e.Error)
-		}
+func (de *DockerEngine) TagImage(ctx context.Context, imgName, tag string) error {
+	err := de.cl.ImageTag(ctx, imgName, tag)
+
+	if err == nil {
+		dockerLog.Debugf("Image tagged: %s -> %s", imgName, tag)
 	}
 
-	return nil
+	return err
 }
 
-// TODO: This should probably be made more robust at some point
-func (de *DockerEngine) getSubNet() (string, error) {
-	de.subNetMu.Lock()
-	defer de.subNetMu.Unlock()
+func (de *DockerEngine) Terminate() {
+	de.cl.Close()
+}
 
+// Subnets of host interfaces and existing docker networks
+func (de *DockerEngine) usedSubnets(ctx context.Context) ([]*net.IPNet, error) {
 	addrs, err := net.InterfaceAddrs()
 
 	if err != nil {
-		return "", errors.Wrap(err, "Error getting network addresses")
+		return nil, errors.Wrap(err, "Error getting network addresses")
 	}
 
-	var nets []*net.IPNet
+	var cidrs []string
 
 	for _, addr := range addrs {
-		dockerLog.Debugf("Inspecting interface %s", addr.String())
-
-		_, n, err := net.ParseCIDR(addr.String())
-
-		if err != nil {
-			dockerLog.Warningf("Error parsing address: %s", addr.String())
-
-			continue
-		}
-
-		nets = append(nets, n)
+		cidrs = append(cidrs, addr.String())
 	}
 
-	netaddr := func() string {
-		tpl := "10.%d.%d.0/24"
+	nets, err := de.cl.NetworkList(ctx, types.NetworkListOptions{})
 
-		return fmt.Sprintf(tpl, de.subNetOct1, de.subNetOct2)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing docker networks")
 	}
 
-	_, pnet, _ := net.ParseCIDR(netaddr())
-
-	for {
-		// Find non-overlapping network
-		overlap := false
-
-		for _, n := range nets {
-			if lib.NetsOverlap(pnet, n) {
-				overlap = true
-				break
+	for _, n := range nets {
+		for _, cfg := range n.IPAM.Config {
+			if cfg.Subnet != "" {
+				cidrs = append(cidrs, cfg.Subnet)
 			}
 		}
+	}
 
-		if overlap {
-			de.subNetOct2 += 1
+	var used []*net.IPNet
 
-			if de.subNetOct2 > 255 {
-				de.subNetOct1 += 1
-				de.subNetOct2 = 0
-			}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
 
-			_, pnet, _ = net.ParseCIDR(netaddr())
-		} else {
-			break
+		if err != nil {
+			dockerLog.Warningf("Error parsing address: %s", cidr)
+
+			continue
 		}
+
+		used = append(used, n)
 	}
 
-	return netaddr(), nil
+	return used, nil
 }
 
-// TODO: Pretty naive implementation and will likely not work in all the cases
-func (de *DockerEngine) getAuthForImage(imageName string) (string, error) {
-	type ConfigFile struct {
-		AuthConfigs map[string]types.AuthConfig `json:"auths"`
-		CredHelpers map[string]string           `json:"credHelpers"`
-	}
+// Request credentials take precedence over engine wide ones
+func (de *DockerEngine) credentials(creds CredentialsSource) CredentialsSource {
+	return CredentialsChain{creds, de.params.Credentials}
+}
 
-	ref, err := reference.ParseNormalizedNamed(imageName)
+// Encoded auth header for pulling an image, empty if no credentials
+// are known for its registry
+func (de *DockerEngine) registryAuth(creds CredentialsSource,
+	imgName string) (string, error) {
 
-	if err != nil {
-		return "", errors.Wrapf(err, "Error parsing image name %s", imageName)
-	}
+	registry, err := imageRegistry(imgName)
 
-	repoInfo, err := registry.ParseRepositoryInfo(ref)
 	if err != nil {
-		return "", errors.Wrapf(err, "Error parsing repository %s", imageName)
+		return "", errors.WithStack(err)
 	}
 
-	file := filepath.Join(os.Getenv("HOME"), ".docker", "config.json")
+	c, err := de.credentials(creds).Get(registry)
 
-	b, err := ioutil.ReadFile(file)
-
-	if err != nil {
-		return "", errors.Wrapf(err, "Error reading docker config %s", file)
+	if err != nil || c == nil {
+		return "", errors.Wrapf(err, "Error getting credentials for %s", registry)
 	}
 
-	conf := &ConfigFile{}
-
-	err = json.Unmarshal(b, conf)
+	b, err := json.Marshal(authConfig(c))
 
 	if err != nil {
-		return "", errors.Wrapf(err, "Error parsing docker config %s", file)
+		return "", errors.WithStack(err)
 	}
 
-	srv := repoInfo.Index.Name
-	ac := &types.AuthConfig{}
-
-	var variants []string
-
-	if srv == "docker.io" || srv == "index.docker.io" {
-		variants = []string{"https://docker.io", "https://index.docker.io/v1/"}
-	} else {
-		variants = []string{srv}
-	}
-
-	var finalErr error
-
-	for _, host := range variants {
-		if credHelper, ok := conf.CredHelpers[host]; ok {
-			dockerLog.Infof("Using '%s' credential helper for %s", credHelper, host)
-
-			prog := fmt.Sprintf("docker-credential-%s", credHelper)
-			p := hclient.NewShellProgramFunc(prog)
-
-			creds, err := hclient.Get(p, host)
-
-			if err != nil {
-				finalErr = errors.Wrapf(err, "Error running %s", prog)
-
-				continue
-			}
-
-			ac.Username = creds.Username
-			ac.Password = creds.Secret
-		} else if authConf, ok := conf.AuthConfigs[host]; ok {
-			dockerLog.Infof("Using auth section for %s", host)
+	return base64.URLEncoding.EncodeToString(b), nil
+}
 
-			if authConf.Username != "" {
-				ac.Username = authConf.Username
-			}
+// Credentials for all the known registries, used by daemon to pull
+// base images during builds
+func (de *DockerEngine) buildAuthConfigs(
+	creds CredentialsSource) (map[string]types.AuthConfig, error) {
 
-			if authConf.Password != "" {
-				ac.Password = authConf.Password
-			}
+	creds = de.credentials(creds)
+	registries, err := creds.Registries()
 
-			if ac.Username == "" {
-				auth, err := base64.StdEncoding.DecodeString(authConf.Auth)
-
-				if err != nil {
-					finalErr = errors.Wrap(err, "Error decoding auth entry")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-					continue
-				}
+	res := map[string]types.AuthConfig{}
 
-				split := strings.SplitN(string(auth), ":", 2)
+	for _, registry := range registries {
+		c, err := creds.Get(registry)
 
-				if len(split) < 2 {
-					finalErr = errors.Errorf("Invalid auth entry format: %s", auth)
-					continue
-				}
+		if err != nil {
+			dockerLog.Warningf("Error getting credentials for %s: %s", registry, err)
 
-				ac.Username = split[0]
-				ac.Password = split[1]
-			}
-		} else {
 			continue
 		}
 
-		ac.ServerAddress = host
-		b, _ = json.Marshal(ac)
+		if c != nil {
+			res[serverAddress(registry)] = authConfig(c)
+		}
+	}
+
+	return res, nil
+}
 
-		return base64.StdEncoding.EncodeToString(b), nil
+func authConfig(c *RegistryCredentials) types.AuthConfig {
+	ac := types.AuthConfig{
+		ServerAddress: serverAddress(normalizeRegistry(c.Registry)),
+	}
+
+	if c.Username == identityTokenUsername {
+		ac.IdentityToken = c.Password
+	} else {
+		ac.Username = c.Username
+		ac.Password = c.Password
 	}
 
-	return "", finalErr
+	return ac
 }
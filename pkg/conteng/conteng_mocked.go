@@ -25,9 +25,12 @@
 package conteng
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -35,24 +38,53 @@ type MockedEngine struct {
 	mock.Mock
 }
 
-func (me *MockedEngine) CreateNetwork(ctx context.Context, name string) (NetworkId, string, error) {
-	args := me.Called(ctx, name)
+func (me *MockedEngine) CreateNetwork(ctx context.Context, name string,
+	params CreateNetworkParams) (*Network, error) {
+	args := me.Called(ctx, name, params)
 
-	return args.String(0), args.String(1), args.Error(2)
+	return args.Get(0).(*Network), args.Error(1)
 }
 
+// Build context is read in full before the call is recorded, so that
+// recorded arguments don't refer to a stream which is still being written
 func (me *MockedEngine) BuildImage(ctx context.Context, imgName string,
-	buildContext io.Reader) error {
-	args := me.Called(ctx, imgName, buildContext)
+	buildContext io.Reader, params BuildImageParams) error {
+	data, err := ioutil.ReadAll(buildContext)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	args := me.Called(ctx, imgName, bytes.NewReader(data), params)
+
+	return args.Error(0)
+}
+
+func (me *MockedEngine) GetImageSize(ctx context.Context,
+	imgName string) (int64, error) {
+	args := me.Called(ctx, imgName)
+
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (me *MockedEngine) ImageExists(ctx context.Context,
+	imgName string) (bool, error) {
+	args := me.Called(ctx, imgName)
+
+	return args.Bool(0), args.Error(1)
+}
+
+func (me *MockedEngine) TagImage(ctx context.Context, imgName, tag string) error {
+	args := me.Called(ctx, imgName, tag)
 
 	return args.Error(0)
 }
 
 func (me *MockedEngine) GetImagePorts(ctx context.Context,
-	imgName string) ([]uint16, error) {
+	imgName string) ([]*Port, error) {
 	args := me.Called(ctx, imgName)
 
-	return args.Get(0).([]uint16), args.Error(1)
+	return args.Get(0).([]*Port), args.Error(1)
 }
 
 func (me *MockedEngine) RemoveImage(ctx context.Context, imgName string) error {
@@ -86,14 +118,37 @@ func (me *MockedEngine) RestartContainer(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
+func (me *MockedEngine) ContainerLogs(ctx context.Context, id string,
+	params ContainerLogsParams) (io.ReadCloser, error) {
+	args := me.Called(ctx, id, params)
+	rc, _ := args.Get(0).(io.ReadCloser)
+
+	return rc, args.Error(1)
+}
+
 func (me *MockedEngine) RemoveNetwork(ctx context.Context, id string) error {
 	args := me.Called(ctx, id)
 
 	return args.Error(0)
 }
 
-func (me *MockedEngine) FetchImage(ctx context.Context, imgName string) error {
-	args := me.Called(ctx, imgName)
+func (me *MockedEngine) InspectNetwork(ctx context.Context,
+	name string) (*Network, error) {
+	args := me.Called(ctx, name)
+
+	return args.Get(0).(*Network), args.Error(1)
+}
+
+func (me *MockedEngine) ConnectNetwork(ctx context.Context, contId string,
+	endpoint NetworkEndpoint) error {
+	args := me.Called(ctx, contId, endpoint)
+
+	return args.Error(0)
+}
+
+func (me *MockedEngine) FetchImage(ctx context.Context, imgName string,
+	params FetchImageParams) error {
+	args := me.Called(ctx, imgName, params)
 
 	return args.Error(0)
 }
@@ -0,0 +1,163 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// Container port exposed on the host
+type Port struct {
+	// Container port
+	Port     uint16
+	Protocol string
+	// Host interface to bind on, all the interfaces if empty
+	HostIP string
+	// Host port, zero means any
+	HostPort uint16
+}
+
+// Parse a port spec in the format: [[host_ip:][host_port]:]port[/protocol]
+// IPv6 host addresses must be enclosed in square brackets.
+// Protocol defaults to tcp.
+func ParsePort(spec string) (*Port, error) {
+	p := &Port{Protocol: ProtoTCP}
+	rest := spec
+
+	if idx := strings.LastIndex(rest, "/"); idx != -1 {
+		p.Protocol = strings.ToLower(rest[idx+1:])
+		rest = rest[:idx]
+	}
+
+	if p.Protocol != ProtoTCP && p.Protocol != ProtoUDP {
+		return nil, errors.Errorf("Invalid protocol in port %s: %s",
+			spec, p.Protocol)
+	}
+
+	// Host IP, possibly an IPv6 one with colons inside
+	if strings.HasPrefix(rest, "[") {
+		idx := strings.Index(rest, "]:")
+
+		if idx == -1 {
+			return nil, errors.Errorf("Invalid port: %s", spec)
+		}
+
+		p.HostIP = rest[1:idx]
+		rest = rest[idx+2:]
+
+		if !strings.Contains(rest, ":") {
+			return nil, errors.Errorf("Host port is required in port: %s", spec)
+		}
+	} else if strings.Count(rest, ":") == 2 {
+		idx := strings.Index(rest, ":")
+		p.HostIP = rest[:idx]
+		rest = rest[idx+1:]
+	}
+
+	if p.HostIP != "" && net.ParseIP(p.HostIP) == nil {
+		return nil, errors.Errorf("Invalid host IP in port %s: %s",
+			spec, p.HostIP)
+	}
+
+	parts := strings.Split(rest, ":")
+
+	if len(parts) > 2 {
+		return nil, errors.Errorf("Invalid port: %s", spec)
+	}
+
+	if len(parts) == 2 && parts[0] != "" {
+		port, err := parsePortNumber(parts[0])
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid host port in %s", spec)
+		}
+
+		p.HostPort = port
+	}
+
+	port, err := parsePortNumber(parts[len(parts)-1])
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid port %s", spec)
+	}
+
+	p.Port = port
+
+	return p, nil
+}
+
+func parsePortNumber(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if port == 0 {
+		return 0, errors.New("Port must be positive")
+	}
+
+	return uint16(port), nil
+}
+
+// Container port and protocol, e.g. 53/udp
+func (p *Port) Key() string {
+	return fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+}
+
+// Port spec accepted by ParsePort
+func (p *Port) String() string {
+	if p.HostIP == "" && p.HostPort == 0 {
+		return p.Key()
+	}
+
+	hostPort := ""
+
+	if p.HostPort != 0 {
+		hostPort = fmt.Sprintf("%d", p.HostPort)
+	}
+
+	if p.HostIP == "" {
+		return fmt.Sprintf("%s:%s", hostPort, p.Key())
+	}
+
+	host := p.HostIP
+
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return fmt.Sprintf("%s:%s:%s", host, hostPort, p.Key())
+}
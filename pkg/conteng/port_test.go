@@ -0,0 +1,76 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePort(t *testing.T) {
+	cases := []struct {
+		spec string
+		port Port
+		str  string
+	}{
+		{"80", Port{Port: 80, Protocol: ProtoTCP}, "80/tcp"},
+		{"53/udp", Port{Port: 53, Protocol: ProtoUDP}, "53/udp"},
+		{"8080:80/TCP", Port{Port: 80, Protocol: ProtoTCP, HostPort: 8080},
+			"8080:80/tcp"},
+		{"127.0.0.1::8125/udp",
+			Port{Port: 8125, Protocol: ProtoUDP, HostIP: "127.0.0.1"},
+			"127.0.0.1::8125/udp"},
+		{"127.0.0.1:5353:53/udp",
+			Port{Port: 53, Protocol: ProtoUDP, HostIP: "127.0.0.1", HostPort: 5353},
+			"127.0.0.1:5353:53/udp"},
+		{"[::1]:8443:443",
+			Port{Port: 443, Protocol: ProtoTCP, HostIP: "::1", HostPort: 8443},
+			"[::1]:8443:443/tcp"},
+		{"[::1]::443",
+			Port{Port: 443, Protocol: ProtoTCP, HostIP: "::1"},
+			"[::1]::443/tcp"},
+	}
+
+	for _, c := range cases {
+		p, err := ParsePort(c.spec)
+
+		require.Nil(t, err, c.spec)
+		require.Equal(t, c.port, *p, c.spec)
+		require.Equal(t, c.str, p.String(), c.spec)
+
+		p2, err := ParsePort(p.String())
+		require.Nil(t, err, c.spec)
+		require.Equal(t, p, p2, c.spec)
+	}
+
+	for _, spec := range []string{
+		"", "0", "70000", "80/sctp", "a:80", "localhost:8080:80",
+		"[::1]:443", "1:2:3:4", "8080:",
+	} {
+		_, err := ParsePort(spec)
+		require.NotNil(t, err, spec)
+	}
+}
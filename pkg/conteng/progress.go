@@ -0,0 +1,181 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Number of the last build output lines kept for error reporting
+const buildLogTail = 20
+
+// Minimum interval between progress reports for a layer
+// unless its status changes
+const layerProgressInterval = time.Second
+
+var buildStepRe = regexp.MustCompile(`^Step (\d+)/(\d+) :`)
+
+// Progress of an image build or pull
+type ImageProgress struct {
+	Image string
+	// Set for build steps only
+	Step       int
+	TotalSteps int
+	// Layer being pulled
+	Layer   string
+	Status  string
+	Current int64
+	Total   int64
+	// Build output line
+	Log string
+}
+
+type ProgressFunc func(*ImageProgress)
+
+// Image build failure along with the last lines of build output
+type BuildError struct {
+	Image string
+	Err   string
+	Log   []string
+}
+
+func (e *BuildError) Error() string {
+	if len(e.Log) == 0 {
+		return e.Err
+	}
+
+	return fmt.Sprintf("%s\nLast build output lines:\n%s", e.Err,
+		strings.Join(e.Log, "\n"))
+}
+
+type jsonMessage struct {
+	Stream   string `json:"stream"`
+	Status   string `json:"status"`
+	Id       string `json:"id"`
+	Progress *struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+type layerReport struct {
+	status string
+	time   time.Time
+}
+
+// Read a build or pull JSON message stream reporting progress.
+// Returns the last output lines and an error message sent by the server.
+func readJSONStream(r io.Reader, image string,
+	progress ProgressFunc) ([]string, string, error) {
+
+	var tail []string
+	var serverErr string
+
+	layers := map[string]*layerReport{}
+	dec := json.NewDecoder(r)
+
+	report := func(p *ImageProgress) {
+		if progress != nil {
+			p.Image = image
+			progress(p)
+		}
+	}
+
+	addLine := func(line string) {
+		tail = append(tail, line)
+
+		if len(tail) > buildLogTail {
+			tail = tail[1:]
+		}
+	}
+
+	for {
+		var msg jsonMessage
+
+		if err := dec.Decode(&msg); err == io.EOF {
+			return tail, serverErr, nil
+		} else if err != nil {
+			return tail, serverErr, errors.WithStack(err)
+		}
+
+		switch {
+		case msg.Error != "":
+			serverErr = msg.Error
+
+		case msg.Stream != "":
+			for _, line := range strings.Split(msg.Stream, "\n") {
+				line = strings.TrimRight(line, "\r")
+
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+
+				addLine(line)
+
+				p := &ImageProgress{Log: line}
+
+				if m := buildStepRe.FindStringSubmatch(line); m != nil {
+					p.Step, _ = strconv.Atoi(m[1])
+					p.TotalSteps, _ = strconv.Atoi(m[2])
+				}
+
+				report(p)
+			}
+
+		case msg.Status != "" && msg.Id != "":
+			p := &ImageProgress{Layer: msg.Id, Status: msg.Status}
+
+			if msg.Progress != nil {
+				p.Current = msg.Progress.Current
+				p.Total = msg.Progress.Total
+			}
+
+			last := layers[msg.Id]
+			now := time.Now()
+
+			if last != nil && last.status == msg.Status &&
+				now.Sub(last.time) < layerProgressInterval {
+				continue
+			}
+
+			layers[msg.Id] = &layerReport{status: msg.Status, time: now}
+
+			report(p)
+
+		case msg.Status != "":
+			addLine(msg.Status)
+			report(&ImageProgress{Status: msg.Status})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadJSONStream(t *testing.T) {
+	stream := `{"stream":"Step 1/2 : FROM alpine\n"}
+{"status":"Pulling from library/alpine","id":"latest"}
+{"status":"Downloading","progressDetail":{"current":1,"total":10},"id":"abc"}
+{"status":"Downloading","progressDetail":{"current":2,"total":10},"id":"abc"}
+{"status":"Download complete","id":"abc"}
+{"stream":" ---> 123\n"}
+{"stream":"Step 2/2 : RUN false\n"}
+{"stream":"failed\n"}
+{"errorDetail":{"message":"boom"},"error":"boom"}
+`
+
+	var progress []*ImageProgress
+
+	tail, serverErr, err := readJSONStream(strings.NewReader(stream), "img",
+		func(p *ImageProgress) {
+			progress = append(progress, p)
+		})
+
+	require.Nil(t, err)
+	require.Equal(t, "boom", serverErr)
+	require.Equal(t, []string{
+		"Step 1/2 : FROM alpine",
+		" ---> 123",
+		"Step 2/2 : RUN false",
+		"failed",
+	}, tail)
+
+	var steps []int
+	var layers []string
+
+	for _, p := range progress {
+		require.Equal(t, "img", p.Image)
+
+		if p.Step > 0 {
+			require.Equal(t, 2, p.TotalSteps)
+			steps = append(steps, p.Step)
+		}
+
+		if p.Layer == "abc" {
+			layers = append(layers, p.Status)
+		}
+	}
+
+	require.Equal(t, []int{1, 2}, steps)
+
+	// Repeated progress for the same layer and status is throttled
+	require.Equal(t, []string{"Downloading", "Download complete"}, layers)
+
+	// Only the tail of the output is kept
+	var b strings.Builder
+
+	for i := 0; i < buildLogTail+5; i++ {
+		b.WriteString(`{"stream":"line\n"}`)
+	}
+
+	tail, serverErr, err = readJSONStream(strings.NewReader(b.String()),
+		"img", nil)
+	require.Nil(t, err)
+	require.Empty(t, serverErr)
+	require.Len(t, tail, buildLogTail)
+
+	be := &BuildError{Image: "img", Err: "boom", Log: []string{"a", "b"}}
+	require.Equal(t, "boom\nLast build output lines:\na\nb", be.Error())
+}
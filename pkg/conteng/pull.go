@@ -0,0 +1,93 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// When an image should be pulled from a registry
+type PullPolicy string
+
+const (
+	// Pull every time, even if the image is present locally
+	PullAlways PullPolicy = "always"
+	// Pull only if the image is not present locally
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// Never pull, the image must be present locally
+	PullNever PullPolicy = "never"
+)
+
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	switch p := PullPolicy(s); p {
+	case PullAlways, PullIfNotPresent, PullNever:
+		return p, nil
+	default:
+		return "", errors.Errorf(
+			"Invalid pull policy %q, expected one of: %s, %s, %s",
+			s, PullAlways, PullIfNotPresent, PullNever)
+	}
+}
+
+// Registry mirror rule: images which normalized names start
+// with Prefix (e.g. docker.io/) are pulled from Mirror instead,
+// i.e. Prefix is replaced with Mirror
+type RegistryMirror struct {
+	Prefix string `mapstructure:"prefix"`
+	Mirror string `mapstructure:"mirror"`
+}
+
+type RegistryMirrors []RegistryMirror
+
+// Return the name an image should be pulled by.
+// The longest matching prefix wins, images not matching any rule
+// are returned unchanged.
+func (rm RegistryMirrors) Rewrite(imgName string) string {
+	name := imgName
+
+	if named, err := reference.ParseNormalizedNamed(imgName); err == nil {
+		name = reference.TagNameOnly(named).String()
+	}
+
+	var best *RegistryMirror
+
+	for i := range rm {
+		m := &rm[i]
+
+		if strings.HasPrefix(name, m.Prefix) &&
+			(best == nil || len(m.Prefix) > len(best.Prefix)) {
+			best = m
+		}
+	}
+
+	if best == nil {
+		return imgName
+	}
+
+	return best.Mirror + strings.TrimPrefix(name, best.Prefix)
+}
@@ -0,0 +1,56 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package conteng
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePullPolicy(t *testing.T) {
+	p, err := ParsePullPolicy("if-not-present")
+	require.Nil(t, err)
+	require.Equal(t, PullIfNotPresent, p)
+
+	_, err = ParsePullPolicy("sometimes")
+	require.NotNil(t, err)
+}
+
+func TestRegistryMirrorsRewrite(t *testing.T) {
+	rm := RegistryMirrors{
+		{Prefix: "docker.io/", Mirror: "mirror.local/hub/"},
+		{Prefix: "docker.io/library/", Mirror: "mirror.local/official/"},
+		{Prefix: "quay.io/coreos/etcd", Mirror: "mirror.local/etcd"},
+	}
+
+	require.Equal(t, "mirror.local/official/postgres:11",
+		rm.Rewrite("postgres:11"))
+	require.Equal(t, "mirror.local/hub/syhpoon/xenvman:latest",
+		rm.Rewrite("syhpoon/xenvman"))
+	require.Equal(t, "mirror.local/etcd:v3.3",
+		rm.Rewrite("quay.io/coreos/etcd:v3.3"))
+	require.Equal(t, "gcr.io/project/app:1", rm.Rewrite("gcr.io/project/app:1"))
+}
@@ -0,0 +1,51 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package def
+
+// Env event types
+const (
+	EnvEventImageBuild     = "image_build"
+	EnvEventImageBuildStep = "image_build_step"
+	EnvEventImageBuilt     = "image_built"
+	EnvEventImagePull      = "image_pull"
+	EnvEventImagePullLayer = "image_pull_layer"
+	EnvEventImagePulled    = "image_pulled"
+	EnvEventImageFailed    = "image_failed"
+)
+
+// Something that happened to an environment, e.g. image build progress
+type EnvEvent struct {
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	Image   string `json:"image,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Build step
+	Step       int `json:"step,omitempty"`
+	TotalSteps int `json:"total_steps,omitempty" mapstructure:"total_steps"`
+	// Pulled layer progress
+	Layer   string `json:"layer,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+}
@@ -26,11 +26,47 @@ package def
 
 import (
 	"fmt"
+	"net"
 )
 
+// External host address resolving to the container engine host
+const HostGateway = "host-gateway"
+
 type EnvOptions struct {
 	KeepAlive        Duration `json:"keep_alive,omitempty"`
 	DisableDiscovery bool     `json:"disable_discovery,omitempty"`
+	// Create a dual-stack network, assigning IPv6 addresses
+	// to containers in addition to IPv4 ones
+	IPv6 bool `json:"ipv6,omitempty"`
+	// Hostname -> IP address, registered in the env discovery DNS.
+	// HostGateway address resolves to the container engine host
+	ExternalHosts map[string]string `json:"external_hosts,omitempty"`
+	// Pre-existing networks or networks of other envs to attach to
+	ExternalNetworks []*ExternalNetwork `json:"external_networks,omitempty"`
+	// Allow other envs to attach to this env networks
+	Shared bool `json:"shared,omitempty"`
+}
+
+// Network not managed by the env, either a pre-existing
+// container engine network or a network of another env.
+// Exactly one of Network and Env must be set
+type ExternalNetwork struct {
+	// Name containers are attached to the network by
+	Name string `json:"name"`
+	// Container engine network name or id
+	Network string `json:"network,omitempty"`
+	// Id of a shared env to link with
+	Env string `json:"env,omitempty"`
+	// Network of the linked env, default one if empty
+	EnvNetwork string `json:"env_network,omitempty"`
+}
+
+// Credentials for pulling images from a private registry
+type RegistryCredentials struct {
+	// Registry host, docker.io for Docker Hub
+	Registry string `json:"registry"`
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type InputEnv struct {
@@ -43,6 +79,10 @@ type InputEnv struct {
 
 	// Additional env options
 	Options *EnvOptions `json:"options"`
+
+	// Private registry credentials used by this env only,
+	// treated as secrets
+	RegistryCredentials []*RegistryCredentials `json:"registry_credentials,omitempty"`
 }
 
 func (ed *InputEnv) Validate() error {
@@ -50,5 +90,53 @@ func (ed *InputEnv) Validate() error {
 		return fmt.Errorf("Env name is empty")
 	}
 
+	for _, c := range ed.RegistryCredentials {
+		if c == nil || c.Registry == "" {
+			return fmt.Errorf("Registry credentials without registry")
+		}
+	}
+
+	if ed.Options != nil {
+		return ed.Options.Validate()
+	}
+
+	return nil
+}
+
+func (opts *EnvOptions) Validate() error {
+	for host, addr := range opts.ExternalHosts {
+		if host == "" {
+			return fmt.Errorf("External host with empty hostname")
+		}
+
+		if addr != HostGateway && net.ParseIP(addr) == nil {
+			return fmt.Errorf("Invalid external host %s address: %s", host, addr)
+		}
+	}
+
+	names := map[string]bool{}
+
+	for _, n := range opts.ExternalNetworks {
+		if n == nil || n.Name == "" {
+			return fmt.Errorf("External network without name")
+		}
+
+		if n.Name == "default" {
+			return fmt.Errorf("External network cannot be named default")
+		}
+
+		if names[n.Name] {
+			return fmt.Errorf("Duplicate external network %s", n.Name)
+		}
+
+		names[n.Name] = true
+
+		if (n.Network == "") == (n.Env == "") {
+			return fmt.Errorf(
+				"External network %s must set exactly one of network and env",
+				n.Name)
+		}
+	}
+
 	return nil
 }
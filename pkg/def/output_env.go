@@ -32,22 +32,54 @@ import (
 	"github.com/pkg/errors"
 )
 
+type PortBinding struct {
+	// tcp or udp
+	Protocol string `json:"protocol"`
+	// Empty if bound on all the interfaces
+	HostIP   string `json:"host_ip,omitempty"`
+	HostPort int    `json:"host_port"`
+}
+
 type ContainerData struct {
 	Id       string `json:"id"`
 	Hostname string `json:"hostname"`
-	// <internal port> -> <external port>
+	// <internal port>[/udp] -> <external port>
 	Ports map[string]int `json:"ports"`
+	// <internal port>/<protocol> -> binding
+	PortBindings map[string]*PortBinding `json:"port_bindings"`
 }
 
 func NewContainerData(id, hostname string) *ContainerData {
 	return &ContainerData{
-		Id:       id,
-		Hostname: hostname,
-		Ports:    map[string]int{},
+		Id:           id,
+		Hostname:     hostname,
+		Ports:        map[string]int{},
+		PortBindings: map[string]*PortBinding{},
+	}
+}
+
+// Register an exposed port, tcp ports are keyed by a plain port number
+// in Ports for compatibility
+func (c *ContainerData) AddPort(port int, proto, hostIP string, hostPort int) {
+	key := fmt.Sprintf("%d", port)
+
+	if proto != "tcp" {
+		key = fmt.Sprintf("%d/%s", port, proto)
+	}
+
+	c.Ports[key] = hostPort
+	c.PortBindings[fmt.Sprintf("%d/%s", port, proto)] = &PortBinding{
+		Protocol: proto,
+		HostIP:   hostIP,
+		HostPort: hostPort,
 	}
 }
 
 type TplData struct {
+	// Resolved template version, if any
+	Version string `json:"version,omitempty"`
+	// Hash of the template file and data dir contents
+	Hash string `json:"hash"`
 	// Container name -> <internal port> -> "<host>:<external-port>"
 	Containers map[string]*ContainerData `json:"containers"`
 	// Imported templates -> tpl name -> [TplData]
@@ -29,4 +29,16 @@ type TplParams = map[string]interface{}
 type Tpl struct {
 	Tpl        string    `json:"tpl"`
 	Parameters TplParams `json:"parameters,omitempty"`
+	// Values for parameters marked as secret in template info()
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// Inline template definition, if set Tpl is ignored
+	// and a unique template name is generated
+	Inline *InlineTpl `json:"inline,omitempty"`
+}
+
+type InlineTpl struct {
+	// Template JS source
+	Source string `json:"source"`
+	// Data dir files: relative path -> base64 encoded contents
+	Data map[string]string `json:"data,omitempty"`
 }
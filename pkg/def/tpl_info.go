@@ -29,10 +29,15 @@ type TplInfoParam struct {
 	Type        string      `json:"type,omitempty" mapstructure:"type"`
 	Mandatory   bool        `json:"mandatory,omitempty" mapstructure:"mandatory"`
 	Default     interface{} `json:"default,omitempty" mapstructure:"default"`
+	// Secret parameters are never logged or exported
+	Secret bool `json:"secret,omitempty" mapstructure:"secret"`
 }
 
 type TplInfo struct {
 	Description string                   `json:"description,omitempty" mapstructure:"description"`
+	Version     string                   `json:"version,omitempty" mapstructure:"version"`
 	Parameters  map[string]*TplInfoParam `json:"parameters,omitempty" mapstructure:"parameters"`
 	DataDir     []string                 `json:"data_dir" mapstructure:"data_dir"`
+	// Set if the template cannot be loaded
+	Error string `json:"error,omitempty" mapstructure:"error"`
 }
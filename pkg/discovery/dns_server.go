@@ -28,6 +28,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
 
@@ -40,7 +41,10 @@ var dnsLog = logger.GetLogger("xenvman.pkg.discovery.dns_server")
 
 type DnsServerParams struct {
 	Addr string
-	// Domain -> IP address
+	// Domain -> comma separated list of IPv4 and/or IPv6 addresses.
+	// Addresses can be specified in CIDR notation, in which case
+	// only the ones sharing a subnet with a client are returned to it,
+	// if there are any
 	DomainMap map[string]string
 	Recursors []string
 	OwnDomain string
@@ -92,9 +96,15 @@ func (srv *DnsServer) handle(w dns.ResponseWriter, r *dns.Msg) {
 
 	dnsLog.Debugf("Got request: %+v", r)
 
+	var client net.IP
+
+	if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		client = addr.IP
+	}
+
 	switch r.Opcode {
 	case dns.OpcodeQuery:
-		srv.processQuery(&msg)
+		srv.processQuery(&msg, client)
 	default:
 		dnsLog.Warningf("Unable to answer request opcode=%v", r.Opcode)
 	}
@@ -102,14 +112,14 @@ func (srv *DnsServer) handle(w dns.ResponseWriter, r *dns.Msg) {
 	_ = w.WriteMsg(&msg)
 }
 
-func (srv *DnsServer) processQuery(msg *dns.Msg) {
+func (srv *DnsServer) processQuery(msg *dns.Msg, client net.IP) {
 	for _, q := range msg.Question {
 		switch q.Qtype {
-		case dns.TypeA:
-			if rr, err := srv.processA(q, msg); err != nil {
+		case dns.TypeA, dns.TypeAAAA:
+			if rrs, err := srv.processAddr(q, client); err != nil {
 				dnsLog.Errorf("Error processing request: %+v", err)
-			} else if rr != nil {
-				msg.Answer = append(msg.Answer, rr)
+			} else {
+				msg.Answer = append(msg.Answer, rrs...)
 			}
 		default:
 			if rr, err := srv.recurse(q); err != nil {
@@ -121,24 +131,129 @@ func (srv *DnsServer) processQuery(msg *dns.Msg) {
 	}
 }
 
-func (srv *DnsServer) processA(q dns.Question, msg *dns.Msg) (dns.RR, error) {
+// Answer A or AAAA question
+func (srv *DnsServer) processAddr(q dns.Question, client net.IP) ([]dns.RR, error) {
 	name := q.Name
 
 	srv.RLock()
-	addr, ok := srv.domainMap[name]
+	addrs, ok := srv.domainMap[name]
 	srv.RUnlock()
 
 	if ok {
-		return dns.NewRR(fmt.Sprintf("%s A %s", name, addr))
+		var rrs []dns.RR
+
+		rtype := dns.TypeToString[q.Qtype]
+
+		for _, ip := range selectAddrs(addrs, q.Qtype == dns.TypeAAAA, client) {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", name, rtype, ip))
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			rrs = append(rrs, rr)
+		}
+
+		return rrs, nil
 	} else if strings.HasSuffix(name, srv.params.OwnDomain) {
 		dnsLog.Warningf("Internal domain %s not found", name)
 
 		return nil, nil
 	} else {
-		return srv.recurse(q)
+		rr, err := srv.recurse(q)
+
+		if err != nil || rr == nil {
+			return nil, err
+		}
+
+		return []dns.RR{rr}, nil
 	}
 }
 
+// Container address parsed from a discovery record
+type recordAddr struct {
+	ip    net.IP
+	ipnet *net.IPNet
+	// Index of the network the address belongs to
+	network int
+}
+
+// Parse a comma separated address list, where each IPv4 address
+// may be followed by the IPv6 address on the same network
+func parseRecord(addrs string) []*recordAddr {
+	var res []*recordAddr
+
+	network := -1
+	hasIP6 := true
+
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		ra := &recordAddr{}
+
+		if strings.Contains(addr, "/") {
+			ip, ipnet, err := net.ParseCIDR(addr)
+
+			if err != nil {
+				dnsLog.Warningf("Invalid address %s: %s", addr, err)
+
+				continue
+			}
+
+			ra.ip, ra.ipnet = ip, ipnet
+		} else if ip := net.ParseIP(addr); ip != nil {
+			ra.ip = ip
+		} else {
+			dnsLog.Warningf("Invalid address %s", addr)
+
+			continue
+		}
+
+		if ra.ip.To4() != nil || hasIP6 {
+			network++
+		}
+
+		hasIP6 = ra.ip.To4() == nil
+		ra.network = network
+
+		res = append(res, ra)
+	}
+
+	return res
+}
+
+// Select addresses of the requested family, preferring the ones
+// on the networks shared with the client
+func selectAddrs(addrs string, ip6 bool, client net.IP) []net.IP {
+	all := parseRecord(addrs)
+	shared := map[int]bool{}
+
+	for _, ra := range all {
+		if client != nil && ra.ipnet != nil && ra.ipnet.Contains(client) {
+			shared[ra.network] = true
+		}
+	}
+
+	var candidates, local []net.IP
+
+	for _, ra := range all {
+		if (ra.ip.To4() == nil) != ip6 {
+			continue
+		}
+
+		candidates = append(candidates, ra.ip)
+
+		if shared[ra.network] {
+			local = append(local, ra.ip)
+		}
+	}
+
+	if len(local) > 0 {
+		return local
+	}
+
+	return candidates
+}
+
 func (srv *DnsServer) recurse(question dns.Question) (dns.RR, error) {
 	m := &dns.Msg{Question: []dns.Question{question}}
 
@@ -0,0 +1,149 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDnsServerAddr(t *testing.T) {
+	srv := NewDnsServer(DnsServerParams{
+		DomainMap: map[string]string{
+			"cont.0.tpl.xenv.":  "10.0.0.2,fd00:1::2",
+			"cont4.0.tpl.xenv.": "10.0.0.3",
+		},
+		OwnDomain: ".xenv.",
+		Ctx:       context.Background(),
+	})
+
+	query := func(name string, qtype uint16) []dns.RR {
+		msg := &dns.Msg{}
+		msg.SetQuestion(name, qtype)
+
+		srv.processQuery(msg, nil)
+
+		return msg.Answer
+	}
+
+	rrs := query("cont.0.tpl.xenv.", dns.TypeA)
+	require.Len(t, rrs, 1)
+	require.Equal(t, "10.0.0.2", rrs[0].(*dns.A).A.String())
+
+	rrs = query("cont.0.tpl.xenv.", dns.TypeAAAA)
+	require.Len(t, rrs, 1)
+	require.Equal(t, "fd00:1::2", rrs[0].(*dns.AAAA).AAAA.String())
+
+	// IPv4 only
+	require.Empty(t, query("cont4.0.tpl.xenv.", dns.TypeAAAA))
+
+	// Unknown internal domain
+	require.Empty(t, query("unknown.0.tpl.xenv.", dns.TypeA))
+}
+
+func TestDnsServerClientSubnet(t *testing.T) {
+	srv := NewDnsServer(DnsServerParams{
+		DomainMap: map[string]string{
+			"cont.0.tpl.xenv.": "10.0.0.2/24,10.0.1.2/24",
+		},
+		OwnDomain: ".xenv.",
+		Ctx:       context.Background(),
+	})
+
+	query := func(client string) []string {
+		msg := &dns.Msg{}
+		msg.SetQuestion("cont.0.tpl.xenv.", dns.TypeA)
+
+		srv.processQuery(msg, net.ParseIP(client))
+
+		var res []string
+
+		for _, rr := range msg.Answer {
+			res = append(res, rr.(*dns.A).A.String())
+		}
+
+		return res
+	}
+
+	require.Equal(t, []string{"10.0.1.2"}, query("10.0.1.5"))
+	require.Equal(t, []string{"10.0.0.2"}, query("10.0.0.5"))
+
+	// No shared subnet
+	require.Equal(t, []string{"10.0.0.2", "10.0.1.2"}, query("10.0.2.5"))
+}
+
+func TestDnsServerClientSubnetDualStack(t *testing.T) {
+	srv := NewDnsServer(DnsServerParams{
+		DomainMap: map[string]string{
+			"cont.0.tpl.xenv.": "10.0.0.2/24,fd78:656e::2/64," +
+				"10.0.1.2/24,fd78:656e:1::2/64",
+			"cont4.0.tpl.xenv.": "10.0.0.3/24,10.0.1.3/24,fd78:656e:1::3/64",
+		},
+		OwnDomain: ".xenv.",
+		Ctx:       context.Background(),
+	})
+
+	query := func(name string, qtype uint16, client string) []string {
+		msg := &dns.Msg{}
+		msg.SetQuestion(name, qtype)
+
+		srv.processQuery(msg, net.ParseIP(client))
+
+		var res []string
+
+		for _, rr := range msg.Answer {
+			switch r := rr.(type) {
+			case *dns.A:
+				res = append(res, r.A.String())
+			case *dns.AAAA:
+				res = append(res, r.AAAA.String())
+			}
+		}
+
+		return res
+	}
+
+	// Containers query over IPv4
+	require.Equal(t, []string{"fd78:656e::2"},
+		query("cont.0.tpl.xenv.", dns.TypeAAAA, "10.0.0.5"))
+	require.Equal(t, []string{"fd78:656e:1::2"},
+		query("cont.0.tpl.xenv.", dns.TypeAAAA, "10.0.1.5"))
+	require.Equal(t, []string{"10.0.1.2"},
+		query("cont.0.tpl.xenv.", dns.TypeA, "fd78:656e:1::5"))
+
+	// No shared subnet
+	require.Equal(t, []string{"fd78:656e::2", "fd78:656e:1::2"},
+		query("cont.0.tpl.xenv.", dns.TypeAAAA, "10.0.2.5"))
+
+	// Shared network has no IPv6 address
+	require.Equal(t, []string{"fd78:656e:1::3"},
+		query("cont4.0.tpl.xenv.", dns.TypeAAAA, "10.0.0.5"))
+	require.Equal(t, []string{"10.0.0.3"},
+		query("cont4.0.tpl.xenv.", dns.TypeA, "10.0.0.5"))
+}
@@ -98,6 +98,11 @@ func (srv *HttpServer) setupHandlers() {
 	srv.router.HandleFunc("/api/v1/domains", srv.updateDomainsHandler).
 		Methods(http.MethodPatch)
 
+	// PATCH /api/v1/addresses - Add new domains with network addresses,
+	// kept apart from the domains endpoint older agents understand
+	srv.router.HandleFunc("/api/v1/addresses", srv.updateDomainsHandler).
+		Methods(http.MethodPatch)
+
 	// DELETE /api/v1/domains - Delete domains
 	srv.router.HandleFunc("/api/v1/domains", srv.deleteDomainsHandler).
 		Methods(http.MethodDelete)
@@ -110,7 +115,7 @@ func (srv *HttpServer) setupHandlers() {
 	srv.router.HandleFunc("/health", srv.healthHandler).Methods(http.MethodGet)
 }
 
-// Body: {<domain>: <ip>}
+// Body: {<domain>: <comma separated addresses>}
 func (srv *HttpServer) updateDomainsHandler(
 	w http.ResponseWriter, req *http.Request) {
 
@@ -0,0 +1,228 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package env
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+	"github.com/syhpoon/xenvman/pkg/logger"
+)
+
+var cacheLog = logger.GetLogger("xenvman.pkg.env.build_cache")
+
+// Repository name used for cached images
+const buildCacheRepo = "xenv-cache"
+
+type BuildCacheParams struct {
+	// Maximum number of cached images, 0 - unlimited
+	MaxImages int
+	// Maximum total size of cached images in bytes, 0 - unlimited
+	MaxSize int64
+}
+
+// Cache of built images shared by all the environments.
+// Images are keyed by build context hash and reference counted,
+// unused images are evicted in LRU order once limits are exceeded.
+type BuildCache struct {
+	ceng    conteng.ContainerEngine
+	params  BuildCacheParams
+	entries map[string]*cacheEntry
+	size    int64
+	sync.Mutex
+}
+
+type cacheEntry struct {
+	hash     string
+	tag      string
+	refs     int
+	size     int64
+	lastUsed time.Time
+	// Closed once the image is built
+	ready chan struct{}
+	err   error
+}
+
+func NewBuildCache(ceng conteng.ContainerEngine,
+	params BuildCacheParams) *BuildCache {
+	return &BuildCache{
+		ceng:    ceng,
+		params:  params,
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// Get an image built from a context with the given hash.
+// If there's no such image yet, build is called to build one with the given tag.
+// Every successfully acquired image must be released with Release.
+func (bc *BuildCache) Acquire(ctx context.Context, hash string,
+	build func(tag string) error) (string, error) {
+
+	bc.Lock()
+
+	if e, ok := bc.entries[hash]; ok {
+		e.refs++
+		e.lastUsed = time.Now()
+		bc.Unlock()
+
+		select {
+		case <-e.ready:
+		case <-ctx.Done():
+			bc.release(e)
+
+			return "", errors.WithStack(ctx.Err())
+		}
+
+		if e.err != nil {
+			return "", e.err
+		}
+
+		cacheLog.Debugf("Image %s found in cache", e.tag)
+
+		return e.tag, nil
+	}
+
+	e := &cacheEntry{
+		hash:     hash,
+		tag:      fmt.Sprintf("%s:%s", buildCacheRepo, hash),
+		refs:     1,
+		lastUsed: time.Now(),
+		ready:    make(chan struct{}),
+	}
+
+	bc.entries[hash] = e
+	bc.Unlock()
+
+	// The image might have been left from a previous run
+	size, err := bc.ceng.GetImageSize(ctx, e.tag)
+
+	if err != nil {
+		if err = build(e.tag); err == nil {
+			cacheLog.Debugf("Image %s built", e.tag)
+
+			if size, err = bc.ceng.GetImageSize(ctx, e.tag); err != nil {
+				cacheLog.Warningf("Error getting size of %s: %s", e.tag, err)
+
+				size, err = 0, nil
+			}
+		}
+	} else {
+		cacheLog.Debugf("Using existing image %s", e.tag)
+	}
+
+	bc.Lock()
+
+	if err != nil {
+		e.err = errors.WithStack(err)
+		delete(bc.entries, hash)
+	} else {
+		e.size = size
+		bc.size += size
+	}
+
+	close(e.ready)
+	bc.Unlock()
+
+	if err != nil {
+		return "", e.err
+	}
+
+	bc.evict()
+
+	return e.tag, nil
+}
+
+// Release an image acquired with Acquire
+func (bc *BuildCache) Release(hash string) {
+	bc.Lock()
+	e, ok := bc.entries[hash]
+	bc.Unlock()
+
+	if ok {
+		bc.release(e)
+	}
+}
+
+func (bc *BuildCache) release(e *cacheEntry) {
+	bc.Lock()
+
+	if bc.entries[e.hash] == e && e.refs > 0 {
+		e.refs--
+		e.lastUsed = time.Now()
+	}
+
+	bc.Unlock()
+
+	bc.evict()
+}
+
+// Remove unused images while the cache exceeds its limits,
+// least recently used first
+func (bc *BuildCache) evict() {
+	bc.Lock()
+
+	var unused []*cacheEntry
+
+	for _, e := range bc.entries {
+		if e.refs == 0 {
+			unused = append(unused, e)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		return unused[i].lastUsed.Before(unused[j].lastUsed)
+	})
+
+	var evicted []*cacheEntry
+
+	for _, e := range unused {
+		overCount := bc.params.MaxImages > 0 &&
+			len(bc.entries) > bc.params.MaxImages
+		overSize := bc.params.MaxSize > 0 && bc.size > bc.params.MaxSize
+
+		if !overCount && !overSize {
+			break
+		}
+
+		delete(bc.entries, e.hash)
+		bc.size -= e.size
+		evicted = append(evicted, e)
+	}
+
+	bc.Unlock()
+
+	for _, e := range evicted {
+		if err := bc.ceng.RemoveImage(context.Background(), e.tag); err != nil {
+			cacheLog.Warningf("Error removing cached image %s: %s", e.tag, err)
+		} else {
+			cacheLog.Debugf("Cached image %s evicted", e.tag)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package env
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+)
+
+// Engine keeping track of existing images
+type cacheTestEngine struct {
+	conteng.MockedEngine
+	images  map[string]bool
+	removed []string
+}
+
+func (e *cacheTestEngine) GetImageSize(ctx context.Context,
+	imgName string) (int64, error) {
+	if !e.images[imgName] {
+		return 0, errors.Errorf("No such image: %s", imgName)
+	}
+
+	return 10, nil
+}
+
+func (e *cacheTestEngine) RemoveImage(ctx context.Context, imgName string) error {
+	delete(e.images, imgName)
+	e.removed = append(e.removed, imgName)
+
+	return nil
+}
+
+func TestBuildCache(t *testing.T) {
+	ctx := context.Background()
+	ceng := &cacheTestEngine{
+		images: map[string]bool{"xenv-cache:existing": true},
+	}
+
+	cache := NewBuildCache(ceng, BuildCacheParams{MaxImages: 2})
+
+	builds := 0
+
+	acquire := func(hash string) (string, error) {
+		return cache.Acquire(ctx, hash, func(tag string) error {
+			builds++
+
+			if hash == "broken" {
+				return errors.New("build failed")
+			}
+
+			ceng.images[tag] = true
+
+			return nil
+		})
+	}
+
+	// Built once, then reused
+	tag, err := acquire("a")
+	require.Nil(t, err)
+	require.Equal(t, "xenv-cache:a", tag)
+
+	tag, err = acquire("a")
+	require.Nil(t, err)
+	require.Equal(t, "xenv-cache:a", tag)
+	require.Equal(t, 1, builds)
+
+	// Existing image is adopted without building
+	tag, err = acquire("existing")
+	require.Nil(t, err)
+	require.Equal(t, "xenv-cache:existing", tag)
+	require.Equal(t, 1, builds)
+
+	// Failed builds are not cached
+	_, err = acquire("broken")
+	require.NotNil(t, err)
+	_, err = acquire("broken")
+	require.NotNil(t, err)
+	require.Equal(t, 3, builds)
+
+	// Images in use are never evicted
+	_, err = acquire("b")
+	require.Nil(t, err)
+	require.Len(t, cache.entries, 3)
+	require.Empty(t, ceng.removed)
+
+	// Least recently used unused image is evicted first
+	cache.Release("existing")
+	cache.Release("a")
+	require.Len(t, cache.entries, 2)
+	require.Equal(t, []string{"xenv-cache:existing"}, ceng.removed)
+
+	// "a" is still acquired once
+	cache.Release("b")
+	cache.Release("a")
+	require.Len(t, cache.entries, 2)
+	require.Equal(t, int64(20), cache.size)
+
+	_, err = acquire("c")
+	require.Nil(t, err)
+	require.Len(t, cache.entries, 2)
+	require.Equal(t, int64(20), cache.size)
+	require.Equal(t, []string{"xenv-cache:existing", "xenv-cache:b"},
+		ceng.removed)
+
+	// Size limit
+	cache = NewBuildCache(ceng, BuildCacheParams{MaxSize: 15})
+
+	for _, hash := range []string{"x", "y"} {
+		_, err = acquire(hash)
+		require.Nil(t, err)
+
+		cache.Release(hash)
+	}
+
+	require.Len(t, cache.entries, 1)
+	require.Equal(t, int64(10), cache.size)
+	require.Equal(t, "xenv-cache:x", ceng.removed[len(ceng.removed)-1])
+}
+
+func TestBuildCacheKey(t *testing.T) {
+	data := []byte("context")
+	params := conteng.BuildImageParams{
+		BuildArgs: map[string]string{"A": "1", "B": "2"},
+		Target:    "test",
+	}
+
+	key := buildCacheKey(data, params)
+
+	// Parameters not affecting the image are ignored
+	params.NoCache = !params.NoCache
+	require.Equal(t, key, buildCacheKey(data, params))
+
+	params.Target = "prod"
+	require.NotEqual(t, key, buildCacheKey(data, params))
+
+	require.NotEqual(t, key, buildCacheKey([]byte("other"), params))
+}
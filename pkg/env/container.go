@@ -27,27 +27,82 @@ package env
 import (
 	"fmt"
 
+	"github.com/syhpoon/xenvman/pkg/conteng"
 	"github.com/syhpoon/xenvman/pkg/tpl"
 )
 
 type container struct {
-	ports map[uint16]uint16
-	ip    string
+	ports []*conteng.Port
+	// Addresses on all the attached networks, the primary one first
+	addrs []*netAddr
 	cont  *tpl.Container
 }
 
 func container2interpolate(cont *tpl.Container,
-	ports map[uint16]uint16, ip string) *container {
+	ports []*conteng.Port, addrs []*netAddr) *container {
 
 	return &container{
 		cont:  cont,
 		ports: ports,
-		ip:    ip,
+		addrs: addrs,
 	}
 }
 
+// IP address on the primary network
 func (cont *container) IP() string {
-	return cont.ip
+	if len(cont.addrs) == 0 {
+		return ""
+	}
+
+	return cont.addrs[0].ip
+}
+
+// IPv6 address on the primary network,
+// empty unless the env network is a dual-stack one
+func (cont *container) IP6() string {
+	if len(cont.addrs) == 0 {
+		return ""
+	}
+
+	return cont.addrs[0].ip6
+}
+
+// IP address on the given network
+func (cont *container) NetworkIP(network string) string {
+	return cont.networkAddr(network).ip
+}
+
+// IPv6 address on the given network
+func (cont *container) NetworkIP6(network string) string {
+	return cont.networkAddr(network).ip6
+}
+
+// Names of the networks the container is attached to
+func (cont *container) Networks() []string {
+	var res []string
+
+	for _, a := range cont.addrs {
+		res = append(res, a.network)
+	}
+
+	return res
+}
+
+// Comma separated list of all the container addresses
+// on all the networks in CIDR notation
+func (cont *container) Addresses() string {
+	return discoveryRecord(cont.addrs)
+}
+
+func (cont *container) networkAddr(network string) *netAddr {
+	a := findAddr(cont.addrs, network)
+
+	if a == nil {
+		panic(fmt.Sprintf("Container %s is not attached to network %s",
+			cont.Name(), network))
+	}
+
+	return a
 }
 
 func (cont *container) Hostname() string {
@@ -62,13 +117,21 @@ func (cont *container) GetLabel(label string) string {
 	return cont.cont.GetLabel(label)
 }
 
-// Return an external port for the given internal one
-func (cont *container) ExposedPort(port int) uint16 {
-	eport, ok := cont.ports[uint16(port)]
+// Return an external port for the given internal one,
+// protocol defaults to tcp
+func (cont *container) ExposedPort(port int, proto ...string) uint16 {
+	protocol := conteng.ProtoTCP
+
+	if len(proto) > 0 {
+		protocol = proto[0]
+	}
+
+	eport := findPort(cont.ports, uint16(port), protocol)
 
-	if !ok {
-		panic(fmt.Sprintf("Port %d is not exposed for %s", port, cont.Name()))
+	if eport == nil {
+		panic(fmt.Sprintf("Port %d/%s is not exposed for %s",
+			port, protocol, cont.Name()))
 	}
 
-	return eport
+	return eport.HostPort
 }
@@ -30,12 +30,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -47,51 +50,92 @@ import (
 	"github.com/syhpoon/xenvman/pkg/lib"
 	"github.com/syhpoon/xenvman/pkg/logger"
 	"github.com/syhpoon/xenvman/pkg/metrics"
+	"github.com/syhpoon/xenvman/pkg/secret"
 	"github.com/syhpoon/xenvman/pkg/tpl"
 )
 
 var envLog = logger.GetLogger("xenvman.pkg.env.env")
 
+var ErrContainerNotFound = errors.New("Container not found")
+
 const discoveryTplName = "discovery"
 
+// Number of attempts to run a container if its host ports are taken
+const runContainerAttempts = 3
+
 // Configured environment
 type Env struct {
 	id         string
 	wsDir      string
 	mountDir   string
 	ports      ports
-	ips        map[string]string // Hostname -> IP
+	networks   map[string]*envNetwork // Network name -> network
+	addrs      map[string][]*netAddr  // Hostname -> addresses
+	links      map[string]*Env        // External network name -> linked env
+	linkedBy   map[string]*Env        // Env ID -> env linking our networks
 	ed         *def.InputEnv
 	ceng       conteng.ContainerEngine
-	netId      string
-	ipn        *lib.Net
 	containers map[string]*tpl.Container // Container ID -> *Container
 	// template name -> [container name -> container id]
 	contIds                 map[string][]map[string]string
 	terminating             bool
+	deferred                bool // Termination waits for linking envs
 	keepAliveChan           chan bool
 	builtImages             map[string]struct{}
+	cachedImages            []string // Build cache context hashes
 	discoveryHostname       string
+	discoveryId             string
 	discoverExternalAddress string
 	params                  Params
 	tpls                    []*tpl.Tpl
 	tplIdx                  map[string]int
 	created                 time.Time
 	keepalive               time.Duration
+	secrets                 *lib.Redactor
+	credentials             conteng.CredentialsSource
+	ca                      *lib.CA
+	events                  []*def.EnvEvent
+	eventsMu                sync.Mutex
 	sync.RWMutex
 }
 
+// Image pull settings
+type PullParams struct {
+	// Used for images which don't set a pull policy, PullAlways if empty
+	Policy conteng.PullPolicy
+	// Registry mirrors images are pulled from
+	Mirrors conteng.RegistryMirrors
+	// Refuse to pull any images, only locally present ones can be used
+	Offline bool
+}
+
 type Params struct {
 	EnvDef           *def.InputEnv
 	ContEng          conteng.ContainerEngine
 	PortRange        *lib.PortRange
 	BaseTplDir       string
+	TplSources       *tpl.Sources
+	TplRegistry      *tpl.Registry
 	BaseWsDir        string
 	BaseMountDir     string
 	ExportAddress    string
 	DefaultKeepAlive def.Duration
 	RecursionLimit   int
-	Ctx              context.Context
+	SecretProvider   secret.Provider
+	// Resource limits applied to each template execution
+	TplLimits tpl.Limits
+	// If set, built images are reused across environments
+	BuildCache *BuildCache
+	// Image pull policy, registry mirrors and offline mode
+	Pull PullParams
+	// Allow templates defined inline in env definition
+	InlineTpl bool
+	// Find a running env by id, used to link envs
+	LookupEnv func(id string) *Env
+	// Host address exposed ports are bound on unless
+	// set by a template, all the interfaces if empty
+	PortsHostIP string
+	Ctx         context.Context
 }
 
 func NewEnv(params Params) (env *Env, err error) {
@@ -108,18 +152,23 @@ func NewEnv(params Params) (env *Env, err error) {
 		keepAliveChan: make(chan bool, 1),
 		params:        params,
 		builtImages:   map[string]struct{}{},
-		ips:           map[string]string{},
+		networks:      map[string]*envNetwork{},
+		addrs:         map[string][]*netAddr{},
+		links:         map[string]*Env{},
+		linkedBy:      map[string]*Env{},
 		containers:    map[string]*tpl.Container{},
 		contIds:       map[string][]map[string]string{},
 		tplIdx:        map[string]int{},
 		created:       time.Now(),
+		secrets:       lib.NewRedactor(),
 	}
 
 	defer func() {
 		metrics.NumberOfEnvironments.WithLabelValues().Add(-1)
 
 		if r := recover(); r != nil {
-			err = errors.Errorf("Error creating env %s: %s", env.id, r)
+			err = env.secrets.RedactError(
+				errors.Errorf("Error creating env %s: %s", env.id, r))
 
 			_ = env.Terminate()
 		}
@@ -138,11 +187,32 @@ func NewEnv(params Params) (env *Env, err error) {
 
 	env.keepalive = keepalive.ToDuration()
 
+	if len(params.EnvDef.RegistryCredentials) > 0 {
+		var creds conteng.StaticCredentials
+
+		for _, c := range params.EnvDef.RegistryCredentials {
+			env.secrets.Add(c.Password)
+			creds = append(creds, &conteng.RegistryCredentials{
+				Registry: c.Registry,
+				Username: c.Username,
+				Password: c.Password,
+			})
+		}
+
+		env.credentials = creds
+	}
+
+	if err := env.attachExternalNetworks(); err != nil {
+		_ = env.Terminate()
+
+		return nil, env.secrets.RedactError(err)
+	}
+
 	if err := env.ApplyTemplates(
 		env.params.EnvDef.Templates, needDiscovery, false); err != nil {
 		_ = env.Terminate()
 
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	envLog.Infof("New env created: %s", id)
@@ -165,16 +235,19 @@ func (env *Env) waitUntilReady(containers []*tpl.Container) error {
 			env.RLock()
 			selfPorts := env.ports[tplName][tplIdx][cont.Name()]
 			ports := env.ports
-			ips := env.ips
+			addrs := env.addrs
+			links := env.links
 			env.RUnlock()
 
 			intrp := &interpolator{
 				externalAddress: env.params.ExportAddress,
 				self: container2interpolate(cont, selfPorts,
-					ips[cont.Hostname()]),
-				ports:      ports,
-				ips:        ips,
-				containers: containers,
+					addrs[cont.Hostname()]),
+				ports:         ports,
+				addrs:         addrs,
+				containers:    containers,
+				links:         links,
+				externalHosts: env.externalHosts(),
 			}
 
 			if err := check.InterpolateParameters(intrp); err != nil {
@@ -233,7 +306,7 @@ func (env *Env) waitUntilReady(containers []*tpl.Container) error {
 // Interpolate container:
 // * mount files
 // * environment variables
-func (env *Env) interpolate(cont *tpl.Container, ports map[uint16]uint16,
+func (env *Env) interpolate(cont *tpl.Container, ports []*conteng.Port,
 	containers []*tpl.Container) error {
 
 	cont.SetCtx(nil)
@@ -241,14 +314,21 @@ func (env *Env) interpolate(cont *tpl.Container, ports map[uint16]uint16,
 	i := &interpolator{
 		externalAddress: env.params.ExportAddress,
 		self: container2interpolate(cont, ports,
-			env.ips[cont.Hostname()]),
-		ports:      env.ports,
-		ips:        env.ips,
-		containers: containers,
+			env.addrs[cont.Hostname()]),
+		ports:         env.ports,
+		addrs:         env.addrs,
+		containers:    containers,
+		links:         env.links,
+		externalHosts: env.externalHosts(),
 	}
 
 	// Environ
 	for k, val := range cont.Environ() {
+		// Secret values are passed as is
+		if cont.IsSecretEnv(k) {
+			continue
+		}
+
 		newVal, err := lib.Interpolate(string(val), i)
 
 		if err != nil {
@@ -302,9 +382,9 @@ func (env *Env) interpolate(cont *tpl.Container, ports map[uint16]uint16,
 
 func (env *Env) buildAndFetch(toBuild map[string]*tpl.BuildImage,
 	toFetch map[string]*tpl.FetchImage, ceng conteng.ContainerEngine,
-	pctx context.Context) (map[string][]uint16, error) {
+	pctx context.Context) (map[string][]*conteng.Port, error) {
 
-	imgPorts := map[string][]uint16{}
+	imgPorts := map[string][]*conteng.Port{}
 
 	lock := sync.Mutex{}
 	ctx, cancel := context.WithCancel(pctx)
@@ -317,15 +397,9 @@ func (env *Env) buildAndFetch(toBuild map[string]*tpl.BuildImage,
 	// Build images
 	for imgName, img := range toBuild {
 		go func(imgName string, img *tpl.BuildImage) {
-			bctx, err := img.BuildContext()
+			hash, err := env.buildImage(ctx, imgName, img, ceng)
 
 			if err != nil {
-				errch <- errors.WithStack(err)
-
-				return
-			}
-
-			if err := ceng.BuildImage(ctx, imgName, bctx); err != nil {
 				errch <- errors.Wrapf(err, "Error building image %s", imgName)
 
 				return
@@ -333,6 +407,11 @@ func (env *Env) buildAndFetch(toBuild map[string]*tpl.BuildImage,
 
 			lock.Lock()
 			env.builtImages[imgName] = struct{}{}
+
+			if hash != "" {
+				env.cachedImages = append(env.cachedImages, hash)
+			}
+
 			lock.Unlock()
 
 			ports, err := ceng.GetImagePorts(ctx, imgName)
@@ -352,14 +431,14 @@ func (env *Env) buildAndFetch(toBuild map[string]*tpl.BuildImage,
 	}
 
 	// Fetch images
-	for imgName := range toFetch {
-		go func(imgName string) {
-			if err := ceng.FetchImage(ctx, imgName); err != nil {
+	for imgName, img := range toFetch {
+		go func(imgName string, img *tpl.FetchImage) {
+			if err := env.fetchImage(ctx, imgName, img, ceng); err != nil {
 				errch <- errors.Wrapf(err, "Error fetching image %s", imgName)
 			}
 
 			rch <- struct{}{}
-		}(imgName)
+		}(imgName, img)
 	}
 
 	done := 0
@@ -377,6 +456,226 @@ func (env *Env) buildAndFetch(toBuild map[string]*tpl.BuildImage,
 	return imgPorts, nil
 }
 
+// Build an image or reuse a cached one built from the same context
+// and parameters.
+// Returns build cache key if the image was acquired from build cache.
+func (env *Env) buildImage(ctx context.Context, imgName string,
+	img *tpl.BuildImage, ceng conteng.ContainerEngine) (string, error) {
+
+	cache := env.params.BuildCache
+
+	if cache == nil || !img.Reusable() {
+		bctx := img.BuildContext()
+
+		//noinspection GoUnhandledErrorResult
+		defer bctx.Close()
+
+		return "", env.runBuild(ctx, imgName, bctx, img.BuildParams(), ceng)
+	}
+
+	// Context is generated twice: first to compute the cache key and
+	// then again only if the image needs to be built,
+	// so that it's never kept in memory in full
+	h := sha256.New()
+
+	if err := img.WriteBuildContext(h); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	hash := buildCacheKey(h.Sum(nil), img.BuildParams())
+
+	tag, err := cache.Acquire(ctx, hash, func(tag string) error {
+		bctx := img.BuildContext()
+
+		//noinspection GoUnhandledErrorResult
+		defer bctx.Close()
+
+		return env.runBuild(ctx, tag, bctx, img.BuildParams(), ceng)
+	})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if err := ceng.TagImage(ctx, tag, imgName); err != nil {
+		cache.Release(hash)
+
+		return "", errors.Wrapf(err, "Error tagging image %s", tag)
+	}
+
+	env.addEvent(&def.EnvEvent{
+		Type:    def.EnvEventImageBuilt,
+		Image:   imgName,
+		Message: fmt.Sprintf("Using cached image %s", tag),
+	})
+
+	return hash, nil
+}
+
+// Hash of a build context digest and build parameters affecting
+// the resulting image
+func buildCacheKey(contextSum []byte, params conteng.BuildImageParams) string {
+	h := sha256.New()
+	_, _ = h.Write(contextSum)
+
+	// Map keys are sorted by json encoder
+	extra, _ := json.Marshal(map[string]interface{}{
+		"build_args": params.BuildArgs,
+		"target":     params.Target,
+		"dockerfile": params.Dockerfile,
+		"labels":     params.Labels,
+		"platform":   params.Platform,
+	})
+
+	_, _ = h.Write(extra)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Build an image reporting progress
+func (env *Env) runBuild(ctx context.Context, imgName string,
+	bctx io.Reader, params conteng.BuildImageParams,
+	ceng conteng.ContainerEngine) error {
+
+	env.addEvent(&def.EnvEvent{Type: def.EnvEventImageBuild, Image: imgName})
+
+	params.Progress = env.imageProgress
+	params.Credentials = env.credentials
+
+	// Base images must not be pulled either
+	if env.params.Pull.Offline {
+		params.Pull = false
+	}
+
+	start := time.Now()
+
+	err := ceng.BuildImage(ctx, imgName, bctx, params)
+
+	metrics.ImageBuildDuration.WithLabelValues().Observe(
+		time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ImageBuilds.WithLabelValues("error").Inc()
+		env.addEvent(&def.EnvEvent{
+			Type:    def.EnvEventImageFailed,
+			Image:   imgName,
+			Message: err.Error(),
+		})
+
+		return err
+	}
+
+	metrics.ImageBuilds.WithLabelValues("ok").Inc()
+	env.addEvent(&def.EnvEvent{Type: def.EnvEventImageBuilt, Image: imgName})
+
+	return nil
+}
+
+// Fetch an image according to its pull policy reporting progress
+func (env *Env) fetchImage(ctx context.Context, imgName string,
+	img *tpl.FetchImage, ceng conteng.ContainerEngine) error {
+
+	local, err := env.useLocalImage(ctx, imgName, img.PullPolicy(), ceng)
+
+	if err != nil {
+		env.addEvent(&def.EnvEvent{
+			Type:    def.EnvEventImageFailed,
+			Image:   imgName,
+			Message: err.Error(),
+		})
+
+		return err
+	}
+
+	if local {
+		env.addEvent(&def.EnvEvent{
+			Type:    def.EnvEventImagePulled,
+			Image:   imgName,
+			Message: "Image is present locally, not pulling",
+		})
+
+		return nil
+	}
+
+	pullName := env.params.Pull.Mirrors.Rewrite(imgName)
+	event := &def.EnvEvent{Type: def.EnvEventImagePull, Image: imgName}
+
+	if pullName != imgName {
+		event.Message = fmt.Sprintf("Pulling from mirror as %s", pullName)
+	}
+
+	env.addEvent(event)
+
+	start := time.Now()
+
+	err = ceng.FetchImage(ctx, pullName, conteng.FetchImageParams{
+		Progress:    env.imageProgress,
+		Credentials: env.credentials,
+	})
+
+	if err == nil && pullName != imgName {
+		if err = ceng.TagImage(ctx, pullName, imgName); err != nil {
+			err = errors.Wrapf(err, "Error tagging image %s", pullName)
+		}
+	}
+
+	metrics.ImagePullDuration.WithLabelValues().Observe(
+		time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ImagePulls.WithLabelValues("error").Inc()
+		env.addEvent(&def.EnvEvent{
+			Type:    def.EnvEventImageFailed,
+			Image:   imgName,
+			Message: err.Error(),
+		})
+
+		return err
+	}
+
+	metrics.ImagePulls.WithLabelValues("ok").Inc()
+	env.addEvent(&def.EnvEvent{Type: def.EnvEventImagePulled, Image: imgName})
+
+	return nil
+}
+
+// Check whether a locally present image should be used instead of pulling.
+// Returns an error if the image is missing and cannot be pulled.
+func (env *Env) useLocalImage(ctx context.Context, imgName string,
+	policy conteng.PullPolicy, ceng conteng.ContainerEngine) (bool, error) {
+
+	offline := env.params.Pull.Offline
+
+	if policy == "" {
+		policy = env.params.Pull.Policy
+	}
+
+	if (policy == conteng.PullAlways || policy == "") && !offline {
+		return false, nil
+	}
+
+	exists, err := ceng.ImageExists(ctx, imgName)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	switch {
+	case exists:
+		return true, nil
+	case offline:
+		return false, errors.Errorf(
+			"Image %s is not present locally and pulling is disabled in offline mode",
+			imgName)
+	case policy == conteng.PullNever:
+		return false, errors.Errorf(
+			"Image %s is not present locally and its pull policy is %s",
+			imgName, policy)
+	default:
+		return false, nil
+	}
+}
+
 type executeResult struct {
 	t     *tpl.Tpl
 	imprt []*def.Tpl
@@ -406,13 +705,26 @@ func (env *Env) executeTemplates(tpls []*def.Tpl,
 		tplnum += 1
 	}
 
+	for _, template := range tpls {
+		if template.Inline != nil && !env.params.InlineTpl {
+			return nil, errors.Errorf("Inline templates are disabled")
+		}
+	}
+
 	rch := make(chan *executeResult, tplnum)
 	errch := make(chan error, tplnum)
 
 	env.Lock()
 	for _, template := range tpls {
-		idx := env.tplIdx[template.Tpl]
-		env.tplIdx[template.Tpl]++
+		if template.Inline != nil {
+			inline := *template
+			inline.Tpl = fmt.Sprintf("inline-%s", lib.NewIdShort())
+			template = &inline
+		}
+
+		name, _ := tpl.SplitTplRef(template.Tpl)
+		idx := env.tplIdx[name]
+		env.tplIdx[name]++
 
 		go env.execTpl(template, idx, rch, errch, false, ctx)
 	}
@@ -489,8 +801,32 @@ func (env *Env) Terminate() error {
 	}
 
 	env.terminating = true
+
+	// Containers of linking envs are attached to our networks,
+	// so resources are released once the last of them terminates
+	if len(env.linkedBy) > 0 {
+		var ids []string
+
+		for id := range env.linkedBy {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+		env.deferred = true
+		env.Unlock()
+
+		envLog.Infof("Termination of env %s deferred until linked envs "+
+			"terminate: %s", env.id, strings.Join(ids, ", "))
+
+		return nil
+	}
+
 	env.Unlock()
 
+	return env.terminate()
+}
+
+func (env *Env) terminate() error {
 	envLog.Infof("Terminating env %s", env.id)
 
 	var err error
@@ -508,6 +844,8 @@ func (env *Env) Terminate() error {
 		return err
 	}
 
+	env.unlinkAll()
+
 	// Clean up workspace and mount dirs
 	envLog.Debugf("[%s] Removing workspace dir %s", env.id, env.wsDir)
 
@@ -530,11 +868,30 @@ func (env *Env) Terminate() error {
 		}
 	}
 
-	// Remove network
-	err = env.ceng.RemoveNetwork(env.params.Ctx, env.netId)
+	// Cached images are only removed once not used by any env
+	for _, hash := range env.cachedImages {
+		env.params.BuildCache.Release(hash)
+	}
+
+	if env.params.PortRange != nil {
+		released := env.params.PortRange.ReleaseAll(env.id)
+		env.updatePortMetrics()
+
+		envLog.Debugf("[%s] Released %d ports", env.id, released)
+	}
+
+	// Remove networks
+	for _, n := range env.networks {
+		if n.external {
+			continue
+		}
 
-	if err == nil {
-		envLog.Debugf("Network %s removed", env.netId)
+		if nerr := env.ceng.RemoveNetwork(env.params.Ctx, n.id); nerr != nil {
+			envLog.Errorf("[%s] Error removing network %s: %s", env.id, n.name, nerr)
+			err = nerr
+		} else {
+			envLog.Debugf("Network %s removed", n.id)
+		}
 	}
 
 	return err
@@ -589,7 +946,7 @@ func (env *Env) Export() *def.OutputEnv {
 		Description:     env.params.EnvDef.Description,
 		WsDir:           env.wsDir,
 		MountDir:        env.mountDir,
-		NetId:           env.netId,
+		NetId:           env.netId(),
 		Created:         env.created.Format(time.RFC3339),
 		Keepalive:       env.keepalive.String(),
 		ExternalAddress: env.params.ExportAddress,
@@ -597,6 +954,15 @@ func (env *Env) Export() *def.OutputEnv {
 	}
 }
 
+// Id of the default network
+func (env *Env) netId() string {
+	if n, ok := env.networks[tpl.DefaultNetwork]; ok {
+		return n.id
+	}
+
+	return ""
+}
+
 func (env *Env) exportTemplates(tpls []*tpl.Tpl) map[string][]*def.TplData {
 	templates := map[string][]*def.TplData{}
 
@@ -605,21 +971,29 @@ func (env *Env) exportTemplates(tpls []*tpl.Tpl) map[string][]*def.TplData {
 		idx := tplobj.GetIdx()
 
 		tpld := &def.TplData{
+			Version:    tplobj.GetVersion(),
+			Hash:       tplobj.GetHash(),
 			Containers: map[string]*def.ContainerData{},
 			Templates:  env.exportTemplates(tplobj.GetImported()),
 		}
 
+		newContainerData := func(cont string) {
+			if _, ok := tpld.Containers[cont]; ok {
+				return
+			}
+
+			cid := env.contIds[name][idx][cont]
+			tpld.Containers[cont] = def.NewContainerData(
+				cid, env.containers[cid].Hostname())
+		}
+
 		if len(env.ports[name]) > idx {
 			for cont, ps := range env.ports[name][idx] {
-				if _, ok := tpld.Containers[cont]; !ok {
-					cid := env.contIds[name][idx][cont]
-
-					tpld.Containers[cont] = def.NewContainerData(
-						cid, env.containers[cid].Hostname())
-				}
+				newContainerData(cont)
 
-				for ip, ep := range ps {
-					tpld.Containers[cont].Ports[fmt.Sprintf("%d", ip)] = int(ep)
+				for _, p := range ps {
+					tpld.Containers[cont].AddPort(int(p.Port), p.Protocol,
+						p.HostIP, int(p.HostPort))
 				}
 			}
 		}
@@ -635,13 +1009,28 @@ func (env *Env) execTpl(tplObj *def.Tpl, idx int,
 	internal bool, ctx context.Context) {
 
 	params := tpl.ExecuteParams{
-		WsDir:     env.wsDir,
-		MountDir:  env.mountDir,
-		TplParams: tplObj.Parameters,
-		Ctx:       ctx,
+		WsDir:          env.wsDir,
+		MountDir:       env.mountDir,
+		TplParams:      tplObj.Parameters,
+		Secrets:        tplObj.Secrets,
+		SecretProvider: env.params.SecretProvider,
+		Redactor:       env.secrets,
+		Limits:         env.params.TplLimits,
+		Ctx:            ctx,
 	}
 
-	if internal {
+	if tplObj.Inline != nil {
+		fs, err := tpl.NewInlineFs(tplObj.Tpl, tplObj.Inline)
+
+		if err != nil {
+			errch <- errors.WithStack(err)
+
+			return
+		}
+
+		params.TplDir = tpl.InlineTplDir
+		params.Fs = fs
+	} else if internal {
 		params.TplDir = "internal-tpl"
 		params.Fs = &tpl.Fs{
 			ReadFile: Asset,
@@ -650,6 +1039,8 @@ func (env *Env) execTpl(tplObj *def.Tpl, idx int,
 		}
 	} else {
 		params.TplDir = env.params.BaseTplDir
+		params.Sources = env.params.TplSources
+		params.Registry = env.params.TplRegistry
 	}
 
 	t, imprt, err := tpl.Execute(env.id, tplObj.Tpl, idx, params)
@@ -718,9 +1109,32 @@ func (env *Env) RestartContainers(strings []string) error {
 	return nil
 }
 
+// Fetch logs of an env container, the caller must close returned reader
+func (env *Env) ContainerLogs(ctx context.Context, cid string,
+	params conteng.ContainerLogsParams) (io.ReadCloser, error) {
+
+	env.RLock()
+	_, ok := env.containers[cid]
+	env.RUnlock()
+
+	if !ok {
+		return nil, ErrContainerNotFound
+	}
+
+	return env.ceng.ContainerLogs(ctx, cid, params)
+}
+
 func (env *Env) ApplyTemplates(tplDefs []*def.Tpl,
 	needDiscovery, updateDiscovery bool) error {
 
+	err := env.applyTemplates(tplDefs, needDiscovery, updateDiscovery)
+
+	return env.secrets.RedactError(err)
+}
+
+func (env *Env) applyTemplates(tplDefs []*def.Tpl,
+	needDiscovery, updateDiscovery bool) error {
+
 	imagesToBuild := map[string]*tpl.BuildImage{}
 	imagesToFetch := map[string]*tpl.FetchImage{}
 
@@ -741,107 +1155,49 @@ func (env *Env) ApplyTemplates(tplDefs []*def.Tpl,
 		return errors.WithStack(err)
 	}
 
-	env.Lock()
-	ipn := env.ipn
-	netId := env.netId
-	var sub string
-
-	if env.ipn == nil {
-		// Create network
-		netId, sub, err = env.params.ContEng.CreateNetwork(
-			env.params.Ctx, env.id)
-
-		if err != nil {
-			env.Unlock()
-
-			return errors.Wrapf(err, "Error creating network")
-		}
-
-		ipn, err = lib.ParseNet(sub)
-
-		if err != nil {
-			env.Unlock()
-
-			return errors.Wrapf(err, "Error parsing subnet")
-		}
+	declared := map[string]bool{}
+	extractNetworks(tpls, declared)
 
-		// Skip gateway
-		_ = ipn.NextIP()
+	created, err := env.ensureNetworks(declared, containers)
 
-		env.netId = netId
-		env.ipn = ipn
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	env.Unlock()
-
-	ips, hosts, err := assignIps(ipn, containers)
-
-	if err != nil {
+	if err := env.connectDiscovery(created); err != nil {
 		return errors.WithStack(err)
 	}
 
-	env.Lock()
-	for k, v := range hosts {
-		env.ips[k] = v
+	if err := env.assignAddrs(containers); err != nil {
+		return errors.WithStack(err)
 	}
-	env.Unlock()
 
 	// Expose all the ports
-	cports := map[string]map[uint16]uint16{}
+	toExpose := map[string][]*conteng.Port{}
+	cports := map[string][]*conteng.Port{}
 
 	env.RLock()
 	discoveryHostname := env.discoveryHostname
 	env.RUnlock()
 
 	for _, cont := range containers {
-		var dport uint16
-
 		if cont.GetLabel("xenv-discovery") == "true" {
 			env.Lock()
 			env.discoveryHostname = cont.Hostname()
 			discoveryHostname = env.discoveryHostname
 			env.Unlock()
-
-			portStr := cont.GetLabel("xenv-discovery-port")
-			port, _ := strconv.ParseUint(portStr, 0, 16)
-			dport = uint16(port)
-
 		}
 
-		imgName := cont.Image()
-		portsToExpose := cont.Ports()
+		toExpose[cont.Hostname()] = cont.Ports()
 
-		if len(portsToExpose) == 0 {
-			portsToExpose = imgPorts[imgName]
+		if len(toExpose[cont.Hostname()]) == 0 {
+			toExpose[cont.Hostname()] = imgPorts[cont.Image()]
 		}
 
-		if _, ok := cports[cont.Hostname()]; !ok {
-			cports[cont.Hostname()] = map[uint16]uint16{}
-		}
-
-		for _, contPort := range portsToExpose {
-			port, err := env.params.PortRange.NextPort()
-
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			envLog.Debugf("Exposing internal port %d as %d for %s",
-				contPort, port, cont.Hostname())
-
-			cports[cont.Hostname()][contPort] = port
-
-			if contPort == dport {
-				env.Lock()
-				env.discoverExternalAddress = fmt.Sprintf(
-					"http://%s:%d/api/v1/domains", env.params.ExportAddress, port)
-				env.Unlock()
-			}
+		if cports[cont.Hostname()], err = env.exposePorts(
+			cont, toExpose[cont.Hostname()]); err != nil {
+			return errors.WithStack(err)
 		}
-
-		env.Lock()
-		env.ports.add(cont, cports[cont.Hostname()])
-		env.Unlock()
 	}
 
 	// Collect all the containers for interpolation
@@ -856,46 +1212,109 @@ func (env *Env) ApplyTemplates(tplDefs []*def.Tpl,
 	allContainers = append(allContainers, containers...)
 
 	// Now create containers
-	for i, cont := range containers {
-		// Interpolate container files
-		if err = env.interpolate(cont, cports[cont.Hostname()],
-			allContainers); err != nil {
+	for _, cont := range containers {
+		env.RLock()
+		addrs := env.addrs[cont.Hostname()]
+		env.RUnlock()
 
-			return errors.WithStack(err)
+		if err := env.issueCerts(cont, addrs); err != nil {
+			return errors.Wrapf(err, "Error issuing certificates for %s",
+				cont.Hostname())
 		}
 
-		cparams := conteng.RunContainerParams{
-			NetworkId:  netId,
-			IP:         ips[i],
-			Ports:      cports[cont.Hostname()],
-			Environ:    cont.Environ(),
-			Cmd:        cont.Cmd(),
-			Entrypoint: cont.Entrypoint(),
-			FileMounts: cont.Mounts(),
+		// Interpolation is done in place, so keep the originals around
+		// in case it needs to be redone with different ports
+		orig, err := snapshotInterpolation(cont)
+
+		if err != nil {
+			return errors.WithStack(err)
 		}
 
-		if needDiscovery || discoveryHostname != "" {
-			cparams.DiscoverDNS = env.ips[discoveryHostname]
+		var cid string
 
-			envLog.Infof("[%s] Using discovery DNS: %s",
-				env.id, cparams.DiscoverDNS)
-		} else {
-			cparams.Hosts = hosts
+		for attempt := 1; ; attempt++ {
+			// Interpolate container files
+			if err = env.interpolate(cont, cports[cont.Hostname()],
+				allContainers); err != nil {
 
-			envLog.Infof("[%s] Using static hosts", env.id)
-		}
+				return errors.WithStack(err)
+			}
 
-		cid, err := env.params.ContEng.RunContainer(env.params.Ctx,
-			cont.Hostname(), cont.Image(), cparams)
+			env.RLock()
+			primary := env.networks[addrs[0].network]
+			cparams := conteng.RunContainerParams{
+				NetworkId:  primary.id,
+				IP:         addrs[0].ip,
+				IP6:        addrs[0].ip6,
+				Ports:      cports[cont.Hostname()],
+				Environ:    cont.Environ(),
+				Cmd:        cont.Cmd(),
+				Entrypoint: cont.Entrypoint(),
+				FileMounts: cont.Mounts(),
+			}
 
-		if err != nil {
-			return errors.Wrapf(err, "Error running container: %s",
-				cont.Hostname())
+			for _, a := range addrs[1:] {
+				cparams.Networks = append(cparams.Networks,
+					&conteng.NetworkEndpoint{
+						NetworkId: env.networks[a.network].id,
+						IP:        a.ip,
+						IP6:       a.ip6,
+					})
+			}
+
+			// Discovery agent address on the primary network
+			discoveryAddr := findAddr(env.addrs[discoveryHostname],
+				addrs[0].network)
+			env.RUnlock()
+
+			if discoveryAddr != nil && (needDiscovery || discoveryHostname != "") {
+				cparams.DiscoverDNS = discoveryAddr.ip
+
+				envLog.Infof("[%s] Using discovery DNS: %s",
+					env.id, cparams.DiscoverDNS)
+			} else {
+				cparams.Hosts, cparams.Hosts6 = env.staticHosts(
+					env.containerNetworks(cont))
+
+				envLog.Infof("[%s] Using static hosts", env.id)
+			}
+
+			cid, err = env.params.ContEng.RunContainer(env.params.Ctx,
+				cont.Hostname(), cont.Image(), cparams)
+
+			if err == nil {
+				break
+			}
+
+			if !conteng.IsPortConflict(err) || attempt >= runContainerAttempts {
+				return errors.Wrapf(err, "Error running container: %s",
+					cont.Hostname())
+			}
+
+			// Some host ports were grabbed by someone else meanwhile
+			metrics.PortConflicts.WithLabelValues().Inc()
+			envLog.Warningf("[%s] Host port conflict running %s, "+
+				"retrying with other ports: %s", env.id, cont.Hostname(), err)
+
+			if err := orig.restore(cont); err != nil {
+				return errors.WithStack(err)
+			}
+
+			env.releasePorts(cports[cont.Hostname()])
+
+			if cports[cont.Hostname()], err = env.exposePorts(
+				cont, toExpose[cont.Hostname()]); err != nil {
+				return errors.WithStack(err)
+			}
 		}
 
 		env.Lock()
 		env.containers[cid] = cont
 
+		if cont.Hostname() == env.discoveryHostname {
+			env.discoveryId = cid
+		}
+
 		// Allow looking up container id by full name
 		tplName, tplIdx := cont.Template()
 
@@ -922,46 +1341,163 @@ func (env *Env) ApplyTemplates(tplDefs []*def.Tpl,
 	env.Unlock()
 
 	if updateDiscovery {
-		body := map[string]interface{}{}
+		domains := map[string]string{}
+		addresses := map[string]string{}
 
 		for _, cont := range containers {
-			body[fmt.Sprintf("%s.", cont.Hostname())] = hosts[cont.Hostname()]
+			env.RLock()
+			addrs := env.addrs[cont.Hostname()]
+			env.RUnlock()
+
+			host := fmt.Sprintf("%s.", cont.Hostname())
+			domains[host] = primaryRecord(addrs)
+			addresses[host] = discoveryRecord(addrs)
 		}
 
-		bodyBytes, _ := json.Marshal(body)
+		// New networks might have been created, refresh gateways
+		for host, record := range env.externalHosts() {
+			domains[fmt.Sprintf("%s.", host)] = plainRecord(record)
+			addresses[fmt.Sprintf("%s.", host)] = record
+		}
 
-		cl := http.Client{
-			Timeout: 5 * time.Second,
+		if code, err := env.patchDiscovery("domains", domains); err != nil {
+			return err
+		} else if code != http.StatusOK {
+			return errors.Errorf(
+				"Expected code 200 from discovery agent but got: %d", code)
 		}
 
-		env.RLock()
+		// Agents predating multiple networks only know plain addresses
+		if code, err := env.patchDiscovery("addresses", addresses); err != nil {
+			return err
+		} else if code != http.StatusOK {
+			envLog.Warningf("[%s]: Discovery agent doesn't support "+
+				"network addresses, code %d", env.id, code)
+		}
 
-		req, _ := http.NewRequest(
-			http.MethodPatch, env.discoverExternalAddress,
-			bytes.NewReader(bodyBytes))
+		envLog.Debugf("[%s]: Discovery agent updated", env.id)
+	}
 
-		env.RUnlock()
+	return nil
+}
+
+// Send discovery agent records update, returns response status code
+func (env *Env) patchDiscovery(
+	resource string, records map[string]string) (int, error) {
+	bodyBytes, _ := json.Marshal(records)
+
+	cl := http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	env.RLock()
+
+	req, _ := http.NewRequest(http.MethodPatch,
+		fmt.Sprintf("%s/api/v1/%s", env.discoverExternalAddress, resource),
+		bytes.NewReader(bodyBytes))
+
+	env.RUnlock()
+
+	resp, err := cl.Do(req)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Error updating discovery agent")
+	}
+
+	_ = resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// Issue requested TLS certificates for a container,
+// env CA is created on first use
+func (env *Env) issueCerts(cont *tpl.Container, addrs []*netAddr) error {
+	reqs := cont.CertRequests()
+
+	if len(reqs) == 0 {
+		return nil
+	}
 
-		resp, err := cl.Do(req)
+	env.Lock()
+	if env.ca == nil {
+		ca, err := lib.NewCA(fmt.Sprintf("xenvman CA %s", env.id))
 
 		if err != nil {
-			return errors.Wrapf(err, "Error updating discovery agent")
-		} else {
-			_ = resp.Body.Close()
+			env.Unlock()
+
+			return errors.WithStack(err)
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			return errors.Errorf(
-				"Expected code 200 from discovery agent but got: %d (%s)",
-				resp.StatusCode, resp.Status)
-		} else {
-			envLog.Debugf("[%s]: Discovery agent updated", env.id)
+		env.ca = ca
+	}
+	ca := env.ca
+	env.Unlock()
+
+	for _, req := range reqs {
+		sans := []string{cont.Hostname()}
+
+		for _, a := range addrs {
+			sans = append(sans, a.ip)
+
+			if a.ip6 != "" {
+				sans = append(sans, a.ip6)
+			}
+		}
+
+		sans = append(sans, req.Sans...)
+
+		if env.params.ExportAddress != "" {
+			sans = append(sans, env.params.ExportAddress)
 		}
+
+		cert, key, err := ca.Issue(sans)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// Private key is readable by the owner only
+		files := []struct {
+			path string
+			data []byte
+			mode os.FileMode
+		}{
+			{req.CertFile, cert, 0644},
+			{req.KeyFile, key, 0600},
+			{req.CAFile, ca.CertPEM(), 0644},
+		}
+
+		for _, f := range files {
+			if err := ioutil.WriteFile(f.path, f.data, f.mode); err != nil {
+				return errors.Wrapf(err, "Error saving file %s", f.path)
+			}
+
+			// Mode of an existing file is not changed by WriteFile
+			if err := os.Chmod(f.path, f.mode); err != nil {
+				return errors.Wrapf(err, "Error setting mode of file %s", f.path)
+			}
+		}
+
+		envLog.Debugf("[%s] Issued TLS certificate for %s: %v",
+			env.id, cont.Hostname(), sans)
 	}
 
 	return nil
 }
 
+// Return PEM-encoded env CA certificate or nil
+// if no certificates have been issued
+func (env *Env) CACert() []byte {
+	env.RLock()
+	defer env.RUnlock()
+
+	if env.ca == nil {
+		return nil
+	}
+
+	return env.ca.CertPEM()
+}
+
 func (env *Env) extractContainers(
 	tpls []*tpl.Tpl, containers *[]*tpl.Container,
 	imagesToBuild map[string]*tpl.BuildImage,
@@ -980,7 +1516,11 @@ func (env *Env) extractContainers(
 
 		// Collect fetch images
 		for _, fimg := range t.GetFetchImages() {
-			imagesToFetch[fimg.Name()] = fimg
+			// Explicitly set pull policy takes precedence if the same
+			// image is fetched by several templates
+			if prev, ok := imagesToFetch[fimg.Name()]; !ok || prev.PullPolicy() == "" {
+				imagesToFetch[fimg.Name()] = fimg
+			}
 
 			// Collect containers
 			for _, c := range fimg.Containers() {
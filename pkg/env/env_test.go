@@ -25,13 +25,17 @@ package env
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
@@ -39,6 +43,8 @@ import (
 	"github.com/syhpoon/xenvman/pkg/conteng"
 	"github.com/syhpoon/xenvman/pkg/def"
 	"github.com/syhpoon/xenvman/pkg/lib"
+	"github.com/syhpoon/xenvman/pkg/secret"
+	"github.com/syhpoon/xenvman/pkg/tpl"
 )
 
 func TestEnvNonesistentTemplate(t *testing.T) {
@@ -116,16 +122,17 @@ func TestEnvOk(t *testing.T) {
 		return strings.Contains(imgName, bimgName)
 	})
 
-	ceng.On("CreateNetwork", mock.Anything, mock.Anything).
-		Return("net-id", "10.0.0.0/24", nil)
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
 	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
 		Return(nil)
 
 	ceng.On("GetImagePorts", mock.Anything,
-		bimgMatcher).Return([]uint16(nil), nil)
-	ceng.On("FetchImage", mock.Anything, fimgName).Return(nil)
-	ceng.On("BuildImage", mock.Anything, bimgMatcher,
+		bimgMatcher).Return([]*conteng.Port(nil), nil)
+	ceng.On("FetchImage", mock.Anything, fimgName,
 		mock.Anything).Return(nil)
+	ceng.On("BuildImage", mock.Anything, bimgMatcher,
+		mock.Anything, mock.Anything).Return(nil)
 
 	ceng.On("RunContainer", mock.Anything,
 		fmt.Sprintf("%s.0.ok.xenv", fcontName), fimgName,
@@ -249,6 +256,7 @@ func TestEnvOk(t *testing.T) {
 	fbp := exported.Templates["ok"][0].Containers[fcontName].Ports[fmt.Sprintf("%d", fport)]
 
 	require.Equal(t, exported.ExternalAddress, "localhost")
+	require.Len(t, exported.Templates["ok"][0].Hash, 64)
 	require.True(t, ebp >= 20000 && ebp <= 30000)
 	require.True(t, fbp >= 20000 && fbp <= 30000)
 
@@ -271,9 +279,10 @@ func TestEnvOk(t *testing.T) {
 	require.Equal(t, bcontRunParams.Environ["DONT-INTERPOLATE-ME"], "WUT")
 
 	// Mock assertion
-	ceng.AssertCalled(t, "FetchImage", mock.Anything, fimgName)
-	ceng.AssertCalled(t, "BuildImage", mock.Anything, bimgMatcher,
+	ceng.AssertCalled(t, "FetchImage", mock.Anything, fimgName,
 		mock.Anything)
+	ceng.AssertCalled(t, "BuildImage", mock.Anything, bimgMatcher,
+		mock.Anything, mock.Anything)
 
 	ceng.AssertCalled(t, "RunContainer", mock.Anything,
 		fmt.Sprintf("%s.0.ok.xenv", fcontName), fimgName,
@@ -289,6 +298,18 @@ func TestEnvOk(t *testing.T) {
 	ceng.AssertCalled(t, "RemoveImage", mock.Anything, bimgMatcher)
 	ceng.AssertCalled(t, "RemoveNetwork", mock.Anything, mock.Anything)
 	ceng.AssertNumberOfCalls(t, "RemoveNetwork", 1)
+
+	// Image events
+	var events []string
+
+	for _, ev := range env.Events() {
+		if ev.Image == fimgName {
+			events = append(events, ev.Type)
+		}
+	}
+
+	require.Equal(t, []string{def.EnvEventImagePull, def.EnvEventImagePulled},
+		events)
 }
 
 func TestStartStopContainers(t *testing.T) {
@@ -302,14 +323,15 @@ func TestStartStopContainers(t *testing.T) {
 		return strings.Contains(img, imgName)
 	})
 
-	ceng.On("CreateNetwork", mock.Anything, mock.Anything).
-		Return("net-id", "10.0.0.0/24", nil)
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
 	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
 		Return(nil)
 
 	ceng.On("GetImagePorts", mock.Anything,
-		imgMatcher).Return([]uint16(nil), nil)
-	ceng.On("FetchImage", mock.Anything, imgName).Return(nil)
+		imgMatcher).Return([]*conteng.Port(nil), nil)
+	ceng.On("FetchImage", mock.Anything, imgName,
+		mock.Anything).Return(nil)
 
 	ceng.On("RunContainer", mock.Anything,
 		fmt.Sprintf("%s.0.simple.xenv", contName), imgName,
@@ -321,6 +343,9 @@ func TestStartStopContainers(t *testing.T) {
 
 	ceng.On("StopContainer", mock.Anything, "cont-0").Return(nil)
 	ceng.On("RestartContainer", mock.Anything, "cont-0").Return(nil)
+	ceng.On("ContainerLogs", mock.Anything, "cont-1",
+		conteng.ContainerLogsParams{Tail: 10}).
+		Return(ioutil.NopCloser(strings.NewReader("log line\n")), nil)
 
 	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
 	ceng.On("RemoveImage", mock.Anything, mock.Anything).Return(nil)
@@ -384,6 +409,15 @@ func TestStartStopContainers(t *testing.T) {
 	err = env.RestartContainers([]string{cid0})
 	require.Nil(t, err)
 
+	logs, err := env.ContainerLogs(ctx, cid1, conteng.ContainerLogsParams{Tail: 10})
+	require.Nil(t, err)
+	data, err := ioutil.ReadAll(logs)
+	require.Nil(t, err)
+	require.Equal(t, "log line\n", string(data))
+
+	_, err = env.ContainerLogs(ctx, "other", conteng.ContainerLogsParams{})
+	require.Equal(t, ErrContainerNotFound, err)
+
 	require.Nil(t, env.Terminate())
 
 	// Mock assertion
@@ -412,14 +446,15 @@ func TestAddTemplates(t *testing.T) {
 		return strings.Contains(img, imgName)
 	})
 
-	ceng.On("CreateNetwork", mock.Anything, mock.Anything).
-		Return("net-id", "10.0.0.0/24", nil)
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
 	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
 		Return(nil)
 
 	ceng.On("GetImagePorts", mock.Anything,
-		imgMatcher).Return([]uint16(nil), nil)
-	ceng.On("FetchImage", mock.Anything, imgName).Return(nil)
+		imgMatcher).Return([]*conteng.Port(nil), nil)
+	ceng.On("FetchImage", mock.Anything, imgName,
+		mock.Anything).Return(nil)
 
 	ceng.On("RunContainer", mock.Anything,
 		fmt.Sprintf("%s.0.simple.xenv", contName), imgName,
@@ -532,14 +567,15 @@ func TestImport(t *testing.T) {
 		return strings.Contains(img, imgName)
 	})
 
-	ceng.On("CreateNetwork", mock.Anything, mock.Anything).
-		Return("net-id", "10.0.0.0/24", nil)
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
 	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
 		Return(nil)
 
 	ceng.On("GetImagePorts", mock.Anything,
-		imgMatcher).Return([]uint16(nil), nil)
-	ceng.On("FetchImage", mock.Anything, imgName).Return(nil)
+		imgMatcher).Return([]*conteng.Port(nil), nil)
+	ceng.On("FetchImage", mock.Anything, imgName,
+		mock.Anything).Return(nil)
 
 	ceng.On("RunContainer", mock.Anything,
 		"import-cont.0.import.xenv", imgName,
@@ -679,3 +715,959 @@ func TestImportLoop(t *testing.T) {
 	require.NotNil(t, err)
 	require.Contains(t, err.Error(), "Recursion limit reached")
 }
+
+func TestSecretRedacted(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	params := Params{
+		EnvDef: &def.InputEnv{
+			Name: "test",
+			Templates: []*def.Tpl{
+				{
+					Tpl:     "secret",
+					Secrets: map[string]string{"password": "s3cr3t"},
+				},
+			},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+			},
+		},
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      lib.NewPortRange(20000, 30000),
+		ExportAddress:  "localhost",
+		RecursionLimit: 10,
+		Ctx:            ctx,
+	}
+
+	_, err = NewEnv(params)
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "s3cr3t")
+	require.Contains(t, err.Error(), "invalid password: "+lib.Redacted)
+
+	// Value from provider
+	params.EnvDef.Templates[0].Secrets = nil
+	params.SecretProvider = &secret.EnvProvider{Prefix: "XENVMAN_TEST_SECRET_"}
+
+	require.Nil(t, os.Setenv("XENVMAN_TEST_SECRET_PASSWORD", "pr0v1d3d"))
+	defer os.Unsetenv("XENVMAN_TEST_SECRET_PASSWORD")
+
+	_, err = NewEnv(params)
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "pr0v1d3d")
+	require.Contains(t, err.Error(), "invalid password: "+lib.Redacted)
+
+	// Non-string values passed as parameters
+	params.SecretProvider = nil
+
+	for _, v := range []interface{}{12345678.0, 31337} {
+		params.EnvDef.Templates[0].Parameters = def.TplParams{"password": v}
+
+		_, err = NewEnv(params)
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "invalid password: "+lib.Redacted, v)
+	}
+}
+
+func TestIssueCert(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	imgName := "image"
+	contName := "cont"
+	hostname := fmt.Sprintf("%s.0.tls.xenv", contName)
+
+	var mounts []*conteng.ContainerFileMount
+
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("FetchImage", mock.Anything, imgName,
+		mock.Anything).Return(nil)
+	ceng.On("RunContainer", mock.Anything, hostname, imgName,
+		mock.Anything).Return("cont-0", nil).Run(func(args mock.Arguments) {
+		mounts = args.Get(3).(conteng.RunContainerParams).FileMounts
+	})
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnv(Params{
+		EnvDef: &def.InputEnv{
+			Name: "test",
+			Templates: []*def.Tpl{
+				{
+					Tpl: "tls",
+					Parameters: map[string]interface{}{
+						"image":     imgName,
+						"container": contName,
+					},
+				},
+			},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+			},
+		},
+		RecursionLimit: 10,
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      lib.NewPortRange(20000, 30000),
+		ExportAddress:  "localhost",
+		Ctx:            ctx,
+	})
+
+	require.Nil(t, err)
+	require.NotNil(t, env.CACert())
+
+	files := map[string][]byte{}
+	modes := map[string]os.FileMode{}
+
+	for _, m := range mounts {
+		data, err := ioutil.ReadFile(m.HostFile)
+		require.Nil(t, err)
+
+		files[m.ContainerFile] = data
+
+		fi, err := os.Stat(m.HostFile)
+		require.Nil(t, err)
+
+		modes[m.ContainerFile] = fi.Mode().Perm()
+	}
+
+	require.Equal(t, os.FileMode(0600), modes["/etc/xenvman/tls/key.pem"])
+	require.Equal(t, os.FileMode(0644), modes["/etc/xenvman/tls/cert.pem"])
+
+	require.Equal(t, env.CACert(), files["/etc/xenvman/tls/ca.pem"])
+
+	_, err = tls.X509KeyPair(files["/etc/xenvman/tls/cert.pem"],
+		files["/etc/xenvman/tls/key.pem"])
+	require.Nil(t, err)
+
+	block, _ := pem.Decode(files["/etc/xenvman/tls/cert.pem"])
+	require.NotNil(t, block)
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.Nil(t, err)
+
+	require.Equal(t, []string{hostname, "extra.local", "localhost"},
+		cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	require.Equal(t, "10.0.0.2", cert.IPAddresses[0].String())
+
+	require.Nil(t, env.Terminate())
+}
+
+func TestInlineTpl(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	simpleImgName := "simple-image"
+
+	inlineImgMatcher := mock.MatchedBy(func(img string) bool {
+		return strings.Contains(img, "inline-image")
+	})
+
+	var mounts []*conteng.ContainerFileMount
+
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("GetImagePorts", mock.Anything,
+		inlineImgMatcher).Return([]*conteng.Port(nil), nil)
+	ceng.On("BuildImage", mock.Anything, inlineImgMatcher,
+		mock.Anything, mock.Anything).Return(nil)
+	ceng.On("FetchImage", mock.Anything, simpleImgName,
+		mock.Anything).Return(nil)
+	ceng.On("RunContainer", mock.Anything, mock.Anything, inlineImgMatcher,
+		mock.Anything).Return("cont-0", nil).Run(func(args mock.Arguments) {
+		mounts = args.Get(3).(conteng.RunContainerParams).FileMounts
+	})
+	ceng.On("RunContainer", mock.Anything, "simple-cont.0.simple.xenv",
+		simpleImgName, mock.Anything).Return("cont-1", nil)
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+	ceng.On("RemoveImage", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	inline := &def.InlineTpl{
+		Source: `
+function execute(tpl, params) {
+  var img = tpl.BuildImage("inline-image");
+  img.CopyDataToWorkspace("Dockerfile");
+
+  var cont = img.NewContainer("inline-cont");
+  cont.MountData("conf/app.conf", "/app.conf", {});
+
+  import_template("simple", {image: "simple-image", container: "simple-cont"});
+}
+`,
+		Data: map[string]string{
+			"Dockerfile": base64.StdEncoding.EncodeToString(
+				[]byte("FROM scratch")),
+			"conf/app.conf": base64.StdEncoding.EncodeToString(
+				[]byte("key = value")),
+		},
+	}
+
+	params := Params{
+		EnvDef: &def.InputEnv{
+			Name:      "test",
+			Templates: []*def.Tpl{{Inline: inline}},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+			},
+		},
+		RecursionLimit: 10,
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      lib.NewPortRange(20000, 30000),
+		ExportAddress:  "localhost",
+		Ctx:            ctx,
+	}
+
+	_, err = NewEnv(params)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Inline templates are disabled")
+
+	params.InlineTpl = true
+
+	env, err := NewEnv(params)
+	require.Nil(t, err)
+	defer env.Terminate()
+
+	require.Len(t, mounts, 1)
+	require.Equal(t, "/app.conf", mounts[0].ContainerFile)
+
+	data, err := ioutil.ReadFile(mounts[0].HostFile)
+	require.Nil(t, err)
+	require.Equal(t, "key = value", string(data))
+
+	exported := env.Export()
+	require.Len(t, exported.Templates, 1)
+
+	for name, tpls := range exported.Templates {
+		require.True(t, strings.HasPrefix(name, "inline-"), name)
+		require.Contains(t, tpls[0].Templates, "simple")
+	}
+}
+
+func TestFetchImagePullPolicy(t *testing.T) {
+	ctx := context.Background()
+	img := &tpl.FetchImage{}
+
+	// Present locally with if-not-present policy
+	ceng := &conteng.MockedEngine{}
+	ceng.On("ImageExists", mock.Anything, "local:1").Return(true, nil)
+
+	env := &Env{params: Params{
+		Pull: PullParams{Policy: conteng.PullIfNotPresent},
+	}}
+
+	require.Nil(t, env.fetchImage(ctx, "local:1", img, ceng))
+	ceng.AssertNotCalled(t, "FetchImage", mock.Anything, mock.Anything, mock.Anything)
+
+	// Missing with never policy
+	ceng = &conteng.MockedEngine{}
+	ceng.On("ImageExists", mock.Anything, "missing:1").Return(false, nil)
+
+	_, err := env.useLocalImage(ctx, "missing:1", conteng.PullNever, ceng)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "pull policy is never")
+
+	// Offline mode
+	env.params.Pull.Offline = true
+
+	_, err = env.useLocalImage(ctx, "missing:1", conteng.PullAlways, ceng)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "offline mode")
+
+	// Pulled from a mirror and tagged with the original name
+	ceng = &conteng.MockedEngine{}
+	ceng.On("FetchImage", mock.Anything, "mirror.local/library/pg:11",
+		mock.Anything).Return(nil)
+	ceng.On("TagImage", mock.Anything, "mirror.local/library/pg:11",
+		"pg:11").Return(nil)
+
+	env = &Env{params: Params{
+		Pull: PullParams{Mirrors: conteng.RegistryMirrors{
+			{Prefix: "docker.io/", Mirror: "mirror.local/"},
+		}},
+	}}
+
+	require.Nil(t, env.fetchImage(ctx, "pg:11", img, ceng))
+	ceng.AssertNotCalled(t, "ImageExists", mock.Anything, mock.Anything)
+	ceng.AssertCalled(t, "TagImage", mock.Anything,
+		"mirror.local/library/pg:11", "pg:11")
+}
+
+func TestRunContainerPortConflict(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	imgName := "image"
+	contName := "cont"
+	hostname := fmt.Sprintf("%s.0.ports.xenv", contName)
+
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("FetchImage", mock.Anything, imgName, mock.Anything).Return(nil)
+
+	ceng.On("RunContainer", mock.Anything, hostname, imgName, mock.Anything).
+		Return("", &conteng.PortConflictError{
+			Err: fmt.Errorf("port is already allocated")}).Once()
+	ceng.On("RunContainer", mock.Anything, hostname, imgName, mock.Anything).
+		Return("cont-0", nil).Once()
+
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+	ceng.On("RemoveImage", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	prange := lib.NewPortRange(20000, 30000)
+
+	env, err := NewEnv(Params{
+		EnvDef: &def.InputEnv{
+			Name: "test",
+			Templates: []*def.Tpl{
+				{
+					Tpl: "ports",
+					Parameters: map[string]interface{}{
+						"image":     imgName,
+						"container": contName,
+					},
+				},
+			},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+			},
+		},
+		RecursionLimit: 10,
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      prange,
+		ExportAddress:  "localhost",
+		Ctx:            ctx,
+	})
+
+	require.Nil(t, err)
+	ceng.AssertNumberOfCalls(t, "RunContainer", 2)
+
+	var params []conteng.RunContainerParams
+
+	for _, call := range ceng.Calls {
+		if call.Method == "RunContainer" {
+			params = append(params,
+				call.Arguments.Get(3).(conteng.RunContainerParams))
+		}
+	}
+
+	// Retried with a different host port, the conflicting one is released
+	port := params[1].Ports[0].HostPort
+
+	require.Equal(t, uint16(80), params[1].Ports[0].Port)
+	require.NotEqual(t, params[0].Ports[0].HostPort, port)
+	require.Equal(t, 1, prange.Leased())
+
+	// Interpolated again with the new port
+	require.Equal(t, fmt.Sprintf("http://localhost:%d", port),
+		params[1].Environ["PUBLIC_URL"])
+
+	exported := env.Export()
+	require.Equal(t, int(port),
+		exported.Templates["ports"][0].Containers[contName].Ports["80"])
+
+	require.Nil(t, env.Terminate())
+	require.Equal(t, 0, prange.Leased())
+}
+
+func TestDualStackNetwork(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	imgName := "image"
+	contName := "cont"
+
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything,
+		conteng.CreateNetworkParams{IPv6: true}).
+		Return(&conteng.Network{
+			Id:      "net-id",
+			Subnet:  "10.0.0.0/24",
+			Subnet6: "fd00:1::/64",
+		}, nil)
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("FetchImage", mock.Anything, imgName, mock.Anything).Return(nil)
+	ceng.On("RunContainer", mock.Anything, mock.Anything, imgName,
+		mock.Anything).Return("cont-0", nil).Once()
+	ceng.On("RunContainer", mock.Anything, mock.Anything, imgName,
+		mock.Anything).Return("cont-1", nil).Once()
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	env, err := NewEnv(Params{
+		EnvDef: &def.InputEnv{
+			Name: "test",
+			Templates: []*def.Tpl{
+				{
+					Tpl: "ipv6",
+					Parameters: map[string]interface{}{
+						"image":     imgName,
+						"container": contName,
+					},
+				},
+			},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+				IPv6:             true,
+			},
+		},
+		RecursionLimit: 10,
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      lib.NewPortRange(20000, 30000),
+		Ctx:            ctx,
+	})
+
+	require.Nil(t, err)
+
+	params := map[string]conteng.RunContainerParams{}
+
+	for _, call := range ceng.Calls {
+		if call.Method == "RunContainer" {
+			params[call.Arguments.String(1)] =
+				call.Arguments.Get(3).(conteng.RunContainerParams)
+		}
+	}
+
+	require.Len(t, params, 2)
+
+	hosts6 := map[string]string{}
+
+	for hostname, p := range params {
+		ip6 := net.ParseIP(p.IP6)
+
+		require.NotNil(t, ip6, hostname)
+		require.Nil(t, ip6.To4())
+		require.NotEqual(t, "fd00:1::1", p.IP6, "gateway assigned")
+
+		require.Equal(t, p.IP, p.Environ["ADDR"])
+		require.Equal(t, p.IP6, p.Environ["ADDR6"])
+
+		hosts6[hostname] = p.IP6
+	}
+
+	for _, p := range params {
+		require.Equal(t, hosts6, p.Hosts6)
+	}
+
+	require.Nil(t, env.Terminate())
+}
+
+func TestNetworks(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	imgName := "image"
+
+	// Networks are created in order: default, then declared ones sorted
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-default", Subnet: "10.0.0.0/24"}, nil).Once()
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-back", Subnet: "10.0.1.0/24"}, nil).Once()
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-front", Subnet: "10.0.2.0/24"}, nil).Once()
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("FetchImage", mock.Anything, imgName, mock.Anything).Return(nil)
+	ceng.On("RunContainer", mock.Anything, mock.Anything, imgName,
+		mock.Anything).Return("cont-id", nil)
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	params := Params{
+		EnvDef: &def.InputEnv{
+			Name: "test",
+			Templates: []*def.Tpl{
+				{
+					Tpl: "networks",
+					Parameters: map[string]interface{}{
+						"image": imgName,
+					},
+				},
+			},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+			},
+		},
+		RecursionLimit: 10,
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      lib.NewPortRange(20000, 30000),
+		Ctx:            ctx,
+	}
+
+	env, err := NewEnv(params)
+	require.Nil(t, err)
+
+	cparams := map[string]conteng.RunContainerParams{}
+
+	for _, call := range ceng.Calls {
+		if call.Method == "RunContainer" {
+			name := strings.SplitN(call.Arguments.String(1), ".", 2)[0]
+			cparams[name] = call.Arguments.Get(3).(conteng.RunContainerParams)
+		}
+	}
+
+	hostname := func(name string) string {
+		return fmt.Sprintf("%s.0.networks.xenv", name)
+	}
+
+	// Primary network first, the rest attached additionally
+	proxy, web, db, worker := cparams["proxy"], cparams["web"],
+		cparams["db"], cparams["worker"]
+
+	require.Equal(t, "net-front", proxy.NetworkId)
+	require.Empty(t, proxy.Networks)
+
+	require.Equal(t, "net-front", web.NetworkId)
+	require.Len(t, web.Networks, 1)
+	require.Equal(t, "net-back", web.Networks[0].NetworkId)
+	require.Equal(t, web.Networks[0].IP, web.Environ["BACK_IP"])
+
+	require.Equal(t, "net-back", db.NetworkId)
+	require.Equal(t, "net-default", worker.NetworkId)
+	require.Equal(t, worker.IP, worker.Environ["WORKER_IP"])
+
+	// Only containers sharing a network are resolvable
+	require.Equal(t, web.IP, proxy.Hosts[hostname("web")])
+	require.NotContains(t, proxy.Hosts, hostname("db"))
+	require.NotContains(t, proxy.Hosts, hostname("worker"))
+
+	require.Equal(t, web.Networks[0].IP, db.Hosts[hostname("web")])
+	require.NotContains(t, db.Hosts, hostname("proxy"))
+
+	require.Len(t, worker.Hosts, 1)
+
+	require.Equal(t, "net-default", env.Export().NetId)
+
+	// All the networks are removed
+	require.Nil(t, env.Terminate())
+	ceng.AssertCalled(t, "RemoveNetwork", mock.Anything, "net-default")
+	ceng.AssertCalled(t, "RemoveNetwork", mock.Anything, "net-back")
+	ceng.AssertCalled(t, "RemoveNetwork", mock.Anything, "net-front")
+
+	// Attaching to an undeclared network
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-default", Subnet: "10.0.0.0/24"}, nil)
+
+	params.EnvDef.Templates[0].Parameters["undeclared"] = "nowhere"
+
+	_, err = NewEnv(params)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "undeclared network nowhere")
+}
+
+func TestExternalNetworks(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	imgName := "image"
+
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-shared", Subnet: "10.0.0.0/24"}, nil).Once()
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-default", Subnet: "10.0.1.0/24"}, nil)
+	ceng.On("InspectNetwork", mock.Anything, "corp").
+		Return(&conteng.Network{
+			Id:     "net-corp",
+			Subnet: "10.5.0.0/24",
+			Taken:  []string{"10.5.0.1", "10.5.0.2"},
+		}, nil)
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("FetchImage", mock.Anything, imgName, mock.Anything).Return(nil)
+	ceng.On("RunContainer", mock.Anything, mock.Anything, imgName,
+		mock.Anything).Return("cont-id", nil)
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	envs := map[string]*Env{}
+
+	newParams := func(tplParams map[string]interface{},
+		opts *def.EnvOptions) Params {
+
+		tplParams["image"] = imgName
+		opts.DisableDiscovery = true
+
+		return Params{
+			EnvDef: &def.InputEnv{
+				Name: "test",
+				Templates: []*def.Tpl{
+					{
+						Tpl:        "external",
+						Parameters: tplParams,
+					},
+				},
+				Options: opts,
+			},
+			RecursionLimit: 10,
+			ContEng:        ceng,
+			BaseTplDir:     filepath.Join(cwd, "./testdata"),
+			BaseWsDir:      filepath.Join(tmpDir, "ws"),
+			BaseMountDir:   filepath.Join(tmpDir, "mount"),
+			PortRange:      lib.NewPortRange(20000, 30000),
+			Ctx:            ctx,
+			LookupEnv: func(id string) *Env {
+				return envs[id]
+			},
+		}
+	}
+
+	lastRun := func() conteng.RunContainerParams {
+		var p conteng.RunContainerParams
+
+		for _, call := range ceng.Calls {
+			if call.Method == "RunContainer" {
+				p = call.Arguments.Get(3).(conteng.RunContainerParams)
+			}
+		}
+
+		return p
+	}
+
+	// Shared env
+	shared, err := NewEnv(newParams(map[string]interface{}{},
+		&def.EnvOptions{Shared: true}))
+	require.Nil(t, err)
+
+	envs[shared.id] = shared
+	sharedApp := lastRun()
+
+	require.Equal(t, "net-shared", sharedApp.NetworkId)
+
+	// Existing network and external hosts
+	env, err := NewEnv(newParams(map[string]interface{}{
+		"network": "corp",
+		"host":    "gw",
+	}, &def.EnvOptions{
+		ExternalHosts: map[string]string{
+			"db.example.com": "192.168.1.10",
+			"gw":             def.HostGateway,
+		},
+		ExternalNetworks: []*def.ExternalNetwork{
+			{Name: "corp", Network: "corp"},
+		},
+	}))
+	require.Nil(t, err)
+
+	app := lastRun()
+
+	require.Equal(t, "net-corp", app.NetworkId)
+	require.Equal(t, "10.5.0.3", app.IP, "taken address assigned")
+	require.Equal(t, app.IP, app.Environ["IP"])
+	require.Equal(t, "192.168.1.10", app.Hosts["db.example.com"])
+	require.Equal(t, def.HostGateway, app.Hosts["gw"])
+	require.Equal(t, "10.0.1.1,10.5.0.1", app.Environ["HOST_ADDR"])
+	require.Equal(t, "10.0.1.1/24,10.5.0.1/24", app.Environ["HOST_CIDR"])
+
+	// External networks are left intact
+	require.Nil(t, env.Terminate())
+	ceng.AssertCalled(t, "RemoveNetwork", mock.Anything, "net-default")
+	ceng.AssertNotCalled(t, "RemoveNetwork", mock.Anything, "net-corp")
+
+	// Linked env
+	linkedParams := newParams(map[string]interface{}{
+		"network": "shared",
+		"linked":  "shared",
+	}, &def.EnvOptions{
+		ExternalNetworks: []*def.ExternalNetwork{
+			{Name: "shared", Env: shared.id},
+		},
+	})
+
+	linked, err := NewEnv(linkedParams)
+	require.Nil(t, err)
+
+	app = lastRun()
+
+	require.Equal(t, "net-shared", app.NetworkId)
+	require.NotEqual(t, sharedApp.IP, app.IP)
+	require.True(t, strings.HasPrefix(app.IP, "10.0.0."), app.IP)
+	require.Equal(t, sharedApp.IP, app.Environ["LINKED_IP"])
+
+	// Addresses on the shared network are assigned by the owning env,
+	// so concurrent assignments never clash
+	const perEnv = 50
+
+	ips := make(chan string, 2*perEnv)
+	wg := sync.WaitGroup{}
+
+	assign := func(e *Env, netName string) {
+		defer wg.Done()
+
+		for i := 0; i < perEnv; i++ {
+			e.Lock()
+			addr, err := e.assignAddr(netName, "cont")
+			e.Unlock()
+
+			if err != nil {
+				ips <- err.Error()
+			} else {
+				ips <- addr.ip
+			}
+		}
+	}
+
+	wg.Add(2)
+	go assign(shared, tpl.DefaultNetwork)
+	go assign(linked, "shared")
+	wg.Wait()
+	close(ips)
+
+	assigned := map[string]bool{app.IP: true, sharedApp.IP: true}
+
+	for ip := range ips {
+		require.True(t, strings.HasPrefix(ip, "10.0.0."), ip)
+		require.False(t, assigned[ip], "duplicate address %s", ip)
+
+		assigned[ip] = true
+	}
+
+	require.Nil(t, linked.Terminate())
+	ceng.AssertNotCalled(t, "RemoveNetwork", mock.Anything, "net-shared")
+
+	// Only shared envs can be linked
+	shared.ed.Options.Shared = false
+
+	_, err = NewEnv(linkedParams)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "is not shared")
+
+	// Termination of a linked env is deferred until linking envs terminate
+	shared.ed.Options.Shared = true
+
+	linked, err = NewEnv(linkedParams)
+	require.Nil(t, err)
+
+	require.Nil(t, shared.Terminate())
+	require.False(t, shared.IsAlive())
+	ceng.AssertNotCalled(t, "RemoveNetwork", mock.Anything, "net-shared")
+
+	_, err = NewEnv(linkedParams)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "not found")
+
+	require.Nil(t, linked.Terminate())
+	require.Empty(t, linked.links)
+	require.Empty(t, shared.linkedBy)
+	ceng.AssertCalled(t, "RemoveNetwork", mock.Anything, "net-shared")
+}
+
+func TestExposePortProtocols(t *testing.T) {
+	ctx := context.Background()
+	ceng := new(conteng.MockedEngine)
+
+	imgName := "image"
+	contName := "cont"
+
+	ceng.On("CreateNetwork", mock.Anything, mock.Anything, mock.Anything).
+		Return(&conteng.Network{Id: "net-id", Subnet: "10.0.0.0/24"}, nil)
+	ceng.On("RemoveNetwork", mock.Anything, mock.AnythingOfType("string")).
+		Return(nil)
+	ceng.On("FetchImage", mock.Anything, imgName, mock.Anything).Return(nil)
+	ceng.On("RunContainer", mock.Anything, mock.Anything, imgName,
+		mock.Anything).Return("cont-0", nil)
+	ceng.On("RemoveContainer", mock.Anything, mock.Anything).Return(nil)
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	prange := lib.NewPortRange(20000, 30000)
+
+	params := Params{
+		EnvDef: &def.InputEnv{
+			Name: "test",
+			Templates: []*def.Tpl{
+				{
+					Tpl: "protocols",
+					Parameters: map[string]interface{}{
+						"image":     imgName,
+						"container": contName,
+						"fixed":     25000,
+						"host_ip":   "127.0.0.2",
+					},
+				},
+			},
+			Options: &def.EnvOptions{
+				DisableDiscovery: true,
+			},
+		},
+		RecursionLimit: 10,
+		ContEng:        ceng,
+		BaseTplDir:     filepath.Join(cwd, "./testdata"),
+		BaseWsDir:      filepath.Join(tmpDir, "ws"),
+		BaseMountDir:   filepath.Join(tmpDir, "mount"),
+		PortRange:      prange,
+		PortsHostIP:    "127.0.0.2",
+		Ctx:            ctx,
+	}
+
+	env, err := NewEnv(params)
+	require.Nil(t, err)
+
+	var cparams conteng.RunContainerParams
+
+	for _, call := range ceng.Calls {
+		if call.Method == "RunContainer" {
+			cparams = call.Arguments.Get(3).(conteng.RunContainerParams)
+		}
+	}
+
+	require.Len(t, cparams.Ports, 3)
+
+	web := findPort(cparams.Ports, 80, conteng.ProtoTCP)
+	dns := findPort(cparams.Ports, 53, conteng.ProtoUDP)
+	fixed := findPort(cparams.Ports, 9000, conteng.ProtoTCP)
+
+	require.Nil(t, findPort(cparams.Ports, 53, conteng.ProtoTCP))
+
+	require.Equal(t, "127.0.0.2", web.HostIP)
+	require.Equal(t, "127.0.0.2", dns.HostIP)
+	require.NotEqual(t, web.HostPort, dns.HostPort)
+	require.Equal(t, "127.0.0.2", fixed.HostIP)
+	require.Equal(t, uint16(25000), fixed.HostPort)
+
+	require.Equal(t, fmt.Sprintf("%d", web.HostPort), cparams.Environ["HTTP_PORT"])
+	require.Equal(t, fmt.Sprintf("%d", dns.HostPort), cparams.Environ["DNS_PORT"])
+	require.Equal(t, "25000", cparams.Environ["FIXED_PORT"])
+
+	// Fixed ports within the range are leased as well
+	require.Equal(t, 3, prange.Leased())
+
+	data := env.Export().Templates["protocols"][0].Containers[contName]
+
+	require.Equal(t, int(web.HostPort), data.Ports["80"])
+	require.Equal(t, int(dns.HostPort), data.Ports["53/udp"])
+	require.Equal(t, &def.PortBinding{
+		Protocol: conteng.ProtoUDP,
+		HostIP:   "127.0.0.2",
+		HostPort: int(dns.HostPort),
+	}, data.PortBindings["53/udp"])
+	require.Equal(t, "127.0.0.2", data.PortBindings["9000/tcp"].HostIP)
+
+	// Proxy addresses, the lowest tcp port by default
+	addr, err := env.ProxyAddress(contName, 0)
+	require.Nil(t, err)
+	require.Equal(t, fmt.Sprintf("127.0.0.2:%d", web.HostPort), addr)
+
+	addr, err = env.ProxyAddress(contName, 9000)
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.2:25000", addr)
+
+	_, err = env.ProxyAddress(contName, 53)
+	require.NotNil(t, err)
+
+	_, err = env.ProxyAddress("unknown", 0)
+	require.NotNil(t, err)
+
+	// Fixed port is already taken by another env
+	_, err = NewEnv(params)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Port 25000 is already leased")
+
+	require.Nil(t, env.Terminate())
+	require.Equal(t, 0, prange.Leased())
+
+	// Templates cannot bind outside of ports_host_ip
+	params.EnvDef.Templates[0].Parameters["host_ip"] = "0.0.0.0"
+
+	_, err = NewEnv(params)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(),
+		"Host address 0.0.0.0 of port 9000/tcp is not allowed")
+	require.Equal(t, 0, prange.Leased())
+
+	// Unless it's not set
+	params.PortsHostIP = ""
+
+	env, err = NewEnv(params)
+	require.Nil(t, err)
+
+	data = env.Export().Templates["protocols"][0].Containers[contName]
+	require.Equal(t, "0.0.0.0", data.PortBindings["9000/tcp"].HostIP)
+
+	require.Nil(t, env.Terminate())
+}
+
+func TestDiscoveryRecords(t *testing.T) {
+	addrs := []*netAddr{
+		{network: "front", ip: "10.0.0.2", ip6: "fd00::2", prefix: 24, prefix6: 64},
+		{network: "back", ip: "10.0.1.2", prefix: 24},
+	}
+
+	// Older discovery agents only parse plain primary addresses
+	require.Equal(t, "10.0.0.2,fd00::2", primaryRecord(addrs))
+	require.Equal(t, "10.0.1.2", primaryRecord(addrs[1:]))
+	require.Equal(t, "", primaryRecord(nil))
+
+	record := discoveryRecord(addrs)
+	require.Equal(t, "10.0.0.2/24,fd00::2/64,10.0.1.2/24", record)
+	require.Equal(t, "10.0.0.2,fd00::2,10.0.1.2", plainRecord(record))
+	require.Equal(t, "192.168.1.10", plainRecord("192.168.1.10"))
+}
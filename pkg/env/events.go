@@ -0,0 +1,86 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package env
+
+import (
+	"time"
+
+	"github.com/syhpoon/xenvman/pkg/conteng"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+// Maximum number of events kept per env, older ones are dropped
+const maxEnvEvents = 1000
+
+func (env *Env) addEvent(ev *def.EnvEvent) {
+	ev.Time = time.Now().Format(time.RFC3339)
+	ev.Message = env.secrets.Redact(ev.Message)
+
+	envLog.Debugf("[%s] Event %s %s: %s", env.id, ev.Type, ev.Image, ev.Message)
+
+	env.eventsMu.Lock()
+	defer env.eventsMu.Unlock()
+
+	env.events = append(env.events, ev)
+
+	if len(env.events) > maxEnvEvents {
+		env.events = env.events[len(env.events)-maxEnvEvents:]
+	}
+}
+
+// Return env events, oldest first
+func (env *Env) Events() []*def.EnvEvent {
+	env.eventsMu.Lock()
+	defer env.eventsMu.Unlock()
+
+	events := make([]*def.EnvEvent, len(env.events))
+	copy(events, env.events)
+
+	return events
+}
+
+// Record image build and pull progress
+func (env *Env) imageProgress(p *conteng.ImageProgress) {
+	switch {
+	case p.Step > 0:
+		env.addEvent(&def.EnvEvent{
+			Type:       def.EnvEventImageBuildStep,
+			Image:      p.Image,
+			Message:    p.Log,
+			Step:       p.Step,
+			TotalSteps: p.TotalSteps,
+		})
+
+	case p.Layer != "":
+		env.addEvent(&def.EnvEvent{
+			Type:    def.EnvEventImagePullLayer,
+			Image:   p.Image,
+			Message: p.Status,
+			Layer:   p.Layer,
+			Current: p.Current,
+			Total:   p.Total,
+		})
+	}
+}
@@ -18,6 +18,8 @@ function execute(tpl, params) {
   var port = 8080;
 
   cont.MountData("domains.json", "/domains.json", {"interpolate": true});
+  // Network addresses in CIDR notation, picked up by newer agents only
+  cont.MountData("addresses.json", "/addresses.json", {"interpolate": true});
   cont.SetPorts(port);
   cont.SetLabel("xenv-discovery", "true");
   cont.SetLabel("xenv-discovery-port", fmt("%d", port));
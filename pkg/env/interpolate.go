@@ -25,6 +25,12 @@
 package env
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/conteng"
 	"github.com/syhpoon/xenvman/pkg/tpl"
 )
 
@@ -33,8 +39,10 @@ type interpolator struct {
 	self            *container
 	containers      []*tpl.Container
 	ports           ports
-	ips             map[string]string
+	addrs           map[string][]*netAddr // Hostname -> addresses
 	extra           map[string]interface{}
+	links           map[string]*Env   // External network name -> linked env
+	externalHosts   map[string]string // Hostname -> addresses
 }
 
 // Return a Container instance for the given template
@@ -58,6 +66,50 @@ func (ip *interpolator) SelfExposedPort(port int) uint16 {
 	return ip.self.ExposedPort(port)
 }
 
+// Return external hosts registered in discovery DNS:
+// hostname -> comma separated list of plain addresses
+func (ip *interpolator) ExternalHosts() map[string]string {
+	hosts := map[string]string{}
+
+	for host, record := range ip.externalHosts {
+		hosts[host] = plainRecord(record)
+	}
+
+	return hosts
+}
+
+// Return external hosts registered in discovery DNS:
+// hostname -> comma separated list of addresses in CIDR notation
+func (ip *interpolator) ExternalHostAddresses() map[string]string {
+	return ip.externalHosts
+}
+
+// Return an interpolator for the env linked by the given external network,
+// only its containers are accessible
+func (ip *interpolator) LinkedEnv(network string) *interpolator {
+	linked, ok := ip.links[network]
+
+	if !ok {
+		panic(fmt.Sprintf("No env linked by network %s", network))
+	}
+
+	linked.RLock()
+	defer linked.RUnlock()
+
+	containers := make([]*tpl.Container, 0, len(linked.containers))
+
+	for _, cont := range linked.containers {
+		containers = append(containers, cont)
+	}
+
+	return &interpolator{
+		externalAddress: ip.externalAddress,
+		containers:      containers,
+		ports:           linked.ports,
+		addrs:           linked.addrs,
+	}
+}
+
 // Return a list of containers which have one of the provided labels set
 // A label is considered set when it has any non-empty label value
 func (ip *interpolator) ContainersWithLabels(labels ...string) []*container {
@@ -71,7 +123,7 @@ func (ip *interpolator) ContainersWithLabels(labels ...string) []*container {
 
 	for _, c := range ip.containers {
 		tplName, tplIdx := c.Template()
-		cPorts := map[uint16]uint16{}
+		var cPorts []*conteng.Port
 
 		if tplPorts, ok := ip.ports[tplName]; ok && len(tplPorts) > tplIdx {
 			if p, ok := tplPorts[tplIdx][c.Name()]; ok {
@@ -82,7 +134,7 @@ func (ip *interpolator) ContainersWithLabels(labels ...string) []*container {
 		for label := range c.Labels() {
 			if ls[label] {
 				res = append(res, container2interpolate(c, cPorts,
-					ip.ips[c.Hostname()]))
+					ip.addrs[c.Hostname()]))
 				break
 			}
 		}
@@ -101,7 +153,7 @@ func (ip *interpolator) ContainerWithLabel(label, value string) *container {
 				if value == "" || value == v {
 					tplName, tplIdx := c.Template()
 
-					cPorts := map[uint16]uint16{}
+					var cPorts []*conteng.Port
 
 					if tplPorts, ok := ip.ports[tplName]; ok && len(tplPorts) > tplIdx {
 						if p, ok := tplPorts[tplIdx][c.Name()]; ok {
@@ -109,7 +161,8 @@ func (ip *interpolator) ContainerWithLabel(label, value string) *container {
 						}
 					}
 
-					return container2interpolate(c, cPorts, ip.ips[c.Hostname()])
+					return container2interpolate(c, cPorts,
+						ip.addrs[c.Hostname()])
 				}
 			}
 		}
@@ -125,15 +178,67 @@ func (ip *interpolator) AllContainers() []*container {
 	for _, c := range ip.containers {
 		tplName, tplIdx := c.Template()
 
-		var cPorts map[uint16]uint16
+		var cPorts []*conteng.Port
 
 		if ports, ok := ip.ports[tplName]; ok && len(ports) > 0 {
 			cPorts = ip.ports[tplName][tplIdx][c.Name()]
 		}
 
 		res = append(res, container2interpolate(c, cPorts,
-			ip.ips[c.Hostname()]))
+			ip.addrs[c.Hostname()]))
 	}
 
 	return res
 }
+
+// Container environ and files to interpolate as they were
+// before interpolation
+type interpolationSnapshot struct {
+	environ map[string]string
+	files   map[string][]byte
+}
+
+func snapshotInterpolation(cont *tpl.Container) (*interpolationSnapshot, error) {
+	snap := &interpolationSnapshot{
+		environ: map[string]string{},
+		files:   map[string][]byte{},
+	}
+
+	for k, v := range cont.Environ() {
+		snap.environ[k] = v
+	}
+
+	files, _ := cont.ToInterpolate()
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading file %s", file)
+		}
+
+		snap.files[file] = data
+	}
+
+	return snap, nil
+}
+
+func (snap *interpolationSnapshot) restore(cont *tpl.Container) error {
+	for k, v := range snap.environ {
+		cont.Environ()[k] = v
+	}
+
+	for file, data := range snap.files {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return errors.Wrapf(err, "Error getting file info %s", file)
+		}
+
+		if err := ioutil.WriteFile(file, data, info.Mode()); err != nil {
+			return errors.Wrapf(err, "Error writing file %s", file)
+		}
+	}
+
+	return nil
+}
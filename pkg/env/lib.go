@@ -28,9 +28,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/syhpoon/xenvman/pkg/lib"
-	"github.com/syhpoon/xenvman/pkg/tpl"
 )
 
 func newEnvId(name string) string {
@@ -38,24 +36,3 @@ func newEnvId(name string) string {
 
 	return fmt.Sprintf("%s-%s-%s", name, t, lib.NewIdShort())
 }
-
-func assignIps(ipn *lib.Net, conts []*tpl.Container) ([]string, map[string]string, error) {
-	ips := make([]string, len(conts))
-	hosts := map[string]string{}
-
-	for i, cont := range conts {
-		ip := ipn.NextIP()
-		hostname := cont.Hostname()
-
-		if ip == nil {
-			return nil, nil, errors.Errorf("Unable to assign IP to container: network %s exhausted", ipn.Sub())
-		}
-
-		ips[i] = ip.String()
-		hosts[hostname] = ip.String()
-
-		envLog.Debugf("Assigned IP %s to %s", ips[i], hostname)
-	}
-
-	return ips, hosts, nil
-}
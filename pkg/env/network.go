@@ -0,0 +1,642 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package env
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+	"github.com/syhpoon/xenvman/pkg/tpl"
+)
+
+type envNetwork struct {
+	name string
+	id   conteng.NetworkId
+	ipn  *lib.Net
+	// Only set for dual-stack networks
+	ipn6 *lib.Net
+	// Subnet prefix lengths
+	prefix  int
+	prefix6 int
+	// Gateway addresses
+	gateway  string
+	gateway6 string
+	// Addresses taken by someone else, external networks only
+	taken map[string]bool
+	// Not managed by the env: either a pre-existing network
+	// or a network of a linked env
+	external bool
+	// Linked env owning the network, addresses are assigned by it
+	owner *Env
+	// Network name in the owning env
+	ownerName string
+}
+
+// Container addresses on a network
+type netAddr struct {
+	network string
+	ip      string
+	ip6     string
+	// Subnet prefix lengths
+	prefix  int
+	prefix6 int
+}
+
+// Addresses in CIDR notation
+func (a *netAddr) cidrs() []string {
+	res := []string{fmt.Sprintf("%s/%d", a.ip, a.prefix)}
+
+	if a.ip6 != "" {
+		res = append(res, fmt.Sprintf("%s/%d", a.ip6, a.prefix6))
+	}
+
+	return res
+}
+
+// Comma separated list of all the container addresses in CIDR notation
+// as used in discovery network address records
+func discoveryRecord(addrs []*netAddr) string {
+	var all []string
+
+	for _, a := range addrs {
+		all = append(all, a.cidrs()...)
+	}
+
+	return strings.Join(all, ",")
+}
+
+// Primary network addresses as used in discovery domain records,
+// which older agents expect to contain plain IPs only
+func primaryRecord(addrs []*netAddr) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+
+	if addrs[0].ip6 != "" {
+		return addrs[0].ip + "," + addrs[0].ip6
+	}
+
+	return addrs[0].ip
+}
+
+// Strip subnet prefixes from a comma separated address list
+func plainRecord(record string) string {
+	all := strings.Split(record, ",")
+
+	for i, addr := range all {
+		if idx := strings.Index(addr, "/"); idx != -1 {
+			all[i] = addr[:idx]
+		}
+	}
+
+	return strings.Join(all, ",")
+}
+
+// Parse env network subnet, the first address is taken by the gateway
+func parseEnvNet(sub string) (*lib.Net, string, int, error) {
+	_, ipnet, err := net.ParseCIDR(sub)
+
+	if err != nil {
+		return nil, "", 0, errors.Wrapf(err, "Error parsing subnet")
+	}
+
+	ipn, err := lib.ParseNet(sub)
+
+	if err != nil {
+		return nil, "", 0, errors.Wrapf(err, "Error parsing subnet")
+	}
+
+	gateway := ipn.NextIP()
+	ones, _ := ipnet.Mask.Size()
+
+	return ipn, gateway.String(), ones, nil
+}
+
+func newEnvNetwork(name string, n *conteng.Network) (*envNetwork, error) {
+	enet := &envNetwork{
+		name:  name,
+		id:    n.Id,
+		taken: map[string]bool{},
+	}
+
+	var err error
+
+	enet.ipn, enet.gateway, enet.prefix, err = parseEnvNet(n.Subnet)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if n.Subnet6 != "" {
+		enet.ipn6, enet.gateway6, enet.prefix6, err = parseEnvNet(n.Subnet6)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	for _, ip := range n.Taken {
+		enet.taken[ip] = true
+	}
+
+	return enet, nil
+}
+
+// Gateway addresses in CIDR notation
+func (n *envNetwork) gatewayCidrs() []string {
+	res := []string{fmt.Sprintf("%s/%d", n.gateway, n.prefix)}
+
+	if n.gateway6 != "" {
+		res = append(res, fmt.Sprintf("%s/%d", n.gateway6, n.prefix6))
+	}
+
+	return res
+}
+
+// Next free address in the network, nil if exhausted
+func (n *envNetwork) nextIP(ipn *lib.Net) net.IP {
+	for {
+		ip := ipn.NextIP()
+
+		if ip == nil || !n.taken[ip.String()] {
+			return ip
+		}
+	}
+}
+
+// Collect networks declared by the templates and their imports
+func extractNetworks(tpls []*tpl.Tpl, names map[string]bool) {
+	for _, t := range tpls {
+		for _, n := range t.GetNetworks() {
+			names[n] = true
+		}
+
+		extractNetworks(t.GetImported(), names)
+	}
+}
+
+// Names of all the env networks, the default one first
+func (env *Env) networkNames() []string {
+	var names []string
+
+	for name := range env.networks {
+		if name != tpl.DefaultNetwork {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return append([]string{tpl.DefaultNetwork}, names...)
+}
+
+// Networks to attach a container to, the primary one first.
+// Discovery agent is attached to all of them to serve every container
+func (env *Env) containerNetworks(cont *tpl.Container) []string {
+	if cont.GetLabel("xenv-discovery") == "true" {
+		return env.networkNames()
+	}
+
+	return cont.Networks()
+}
+
+// Create declared networks which do not exist yet,
+// the default network is always created.
+// Returns names of the newly created networks
+func (env *Env) ensureNetworks(declared map[string]bool,
+	containers []*tpl.Container) ([]string, error) {
+
+	env.Lock()
+	defer env.Unlock()
+
+	for _, cont := range containers {
+		for _, name := range cont.Networks() {
+			if _, ok := env.networks[name]; !ok &&
+				!declared[name] && name != tpl.DefaultNetwork {
+
+				return nil, errors.Errorf(
+					"Container %s is attached to undeclared network %s",
+					cont.Hostname(), name)
+			}
+		}
+	}
+
+	names := []string{tpl.DefaultNetwork}
+
+	for name := range declared {
+		if name != tpl.DefaultNetwork {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names[1:])
+
+	var created []string
+
+	for _, name := range names {
+		if _, ok := env.networks[name]; ok {
+			continue
+		}
+
+		if _, err := env.createNetwork(name); err != nil {
+			return created, errors.WithStack(err)
+		}
+
+		created = append(created, name)
+	}
+
+	return created, nil
+}
+
+func (env *Env) createNetwork(name string) (*envNetwork, error) {
+	// The default network is named after the env itself
+	netName := env.id
+
+	if name != tpl.DefaultNetwork {
+		netName = fmt.Sprintf("%s-%s", env.id, name)
+	}
+
+	params := conteng.CreateNetworkParams{
+		IPv6: env.ed.Options != nil && env.ed.Options.IPv6,
+	}
+
+	n, err := env.params.ContEng.CreateNetwork(env.params.Ctx, netName, params)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating network %s", name)
+	}
+
+	enet, err := newEnvNetwork(name, n)
+
+	// Register right away so that the network is removed on termination
+	// even if the rest fails
+	env.networks[name] = &envNetwork{name: name, id: n.Id}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	env.networks[name] = enet
+
+	envLog.Infof("[%s] Network %s created: %s", env.id, name, n.Id)
+
+	return enet, nil
+}
+
+// Assign an address on the network
+func (env *Env) assignAddr(netName, hostname string) (*netAddr, error) {
+	n := env.networks[netName]
+
+	if n.owner != nil {
+		addr, err := n.owner.assignLinkedAddr(n.ownerName, hostname)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		addr.network = netName
+
+		return addr, nil
+	}
+
+	ip := n.nextIP(n.ipn)
+
+	if ip == nil {
+		return nil, errors.Errorf(
+			"Unable to assign IP to %s: network %s (%s) exhausted",
+			hostname, netName, n.ipn.Sub())
+	}
+
+	addr := &netAddr{
+		network: netName,
+		ip:      ip.String(),
+		prefix:  n.prefix,
+	}
+
+	if n.ipn6 != nil {
+		ip6 := n.nextIP(n.ipn6)
+
+		if ip6 == nil {
+			return nil, errors.Errorf(
+				"Unable to assign IPv6 to %s: network %s (%s) exhausted",
+				hostname, netName, n.ipn6.Sub())
+		}
+
+		addr.ip6 = ip6.String()
+		addr.prefix6 = n.prefix6
+	}
+
+	envLog.Debugf("[%s] Assigned IP %s %s to %s on %s",
+		env.id, addr.ip, addr.ip6, hostname, netName)
+
+	return addr, nil
+}
+
+// Assign an address to a container of a linking env
+func (env *Env) assignLinkedAddr(netName, hostname string) (*netAddr, error) {
+	env.Lock()
+	defer env.Unlock()
+
+	return env.assignAddr(netName, hostname)
+}
+
+// Assign addresses to the containers on all the networks
+// they are attached to
+func (env *Env) assignAddrs(containers []*tpl.Container) error {
+	env.Lock()
+	defer env.Unlock()
+
+	for _, cont := range containers {
+		var addrs []*netAddr
+
+		for _, name := range env.containerNetworks(cont) {
+			addr, err := env.assignAddr(name, cont.Hostname())
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			addrs = append(addrs, addr)
+		}
+
+		env.addrs[cont.Hostname()] = addrs
+	}
+
+	return nil
+}
+
+// Attach already running discovery agent to the newly created networks
+func (env *Env) connectDiscovery(networks []string) error {
+	env.Lock()
+	defer env.Unlock()
+
+	if env.discoveryId == "" {
+		return nil
+	}
+
+	for _, name := range networks {
+		addr, err := env.assignAddr(name, env.discoveryHostname)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = env.params.ContEng.ConnectNetwork(env.params.Ctx, env.discoveryId,
+			conteng.NetworkEndpoint{
+				NetworkId: env.networks[name].id,
+				IP:        addr.ip,
+				IP6:       addr.ip6,
+			})
+
+		if err != nil {
+			return errors.Wrapf(err, "Error connecting discovery agent")
+		}
+
+		env.addrs[env.discoveryHostname] =
+			append(env.addrs[env.discoveryHostname], addr)
+	}
+
+	return nil
+}
+
+// Address of a container on a network, nil if not attached
+func findAddr(addrs []*netAddr, network string) *netAddr {
+	for _, a := range addrs {
+		if a.network == network {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// Static hosts of the containers sharing any of the networks,
+// resolved to the addresses on the first shared network
+func (env *Env) staticHosts(networks []string) (map[string]string,
+	map[string]string) {
+
+	hosts := map[string]string{}
+	hosts6 := map[string]string{}
+
+	env.RLock()
+	defer env.RUnlock()
+
+	for hostname, addrs := range env.addrs {
+		for _, network := range networks {
+			if a := findAddr(addrs, network); a != nil {
+				hosts[hostname] = a.ip
+
+				if a.ip6 != "" {
+					hosts6[hostname] = a.ip6
+				}
+
+				break
+			}
+		}
+	}
+
+	// Docker resolves host-gateway on its own
+	if env.ed.Options != nil {
+		for host, addr := range env.ed.Options.ExternalHosts {
+			hosts[host] = addr
+		}
+	}
+
+	return hosts, hosts6
+}
+
+// Attach the env to pre-existing networks and networks of linked envs
+func (env *Env) attachExternalNetworks() error {
+	if env.ed.Options == nil {
+		return nil
+	}
+
+	for _, ext := range env.ed.Options.ExternalNetworks {
+		var enet *envNetwork
+		var err error
+
+		if ext.Network != "" {
+			enet, err = env.inspectNetwork(ext)
+		} else {
+			enet, err = env.linkEnvNetwork(ext)
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		enet.external = true
+
+		env.Lock()
+		env.networks[ext.Name] = enet
+		env.Unlock()
+
+		envLog.Infof("[%s] Attached to external network %s: %s",
+			env.id, ext.Name, enet.id)
+	}
+
+	return nil
+}
+
+func (env *Env) inspectNetwork(ext *def.ExternalNetwork) (*envNetwork, error) {
+	n, err := env.params.ContEng.InspectNetwork(env.params.Ctx, ext.Network)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return newEnvNetwork(ext.Name, n)
+}
+
+// Share a network of another env, addresses are assigned
+// by the owning env so they never clash
+func (env *Env) linkEnvNetwork(ext *def.ExternalNetwork) (*envNetwork, error) {
+	var target *Env
+
+	if env.params.LookupEnv != nil {
+		target = env.params.LookupEnv(ext.Env)
+	}
+
+	if target == nil {
+		return nil, errors.Errorf("Env %s not found", ext.Env)
+	}
+
+	target.Lock()
+	defer target.Unlock()
+
+	if target.terminating {
+		return nil, errors.Errorf("Env %s not found", ext.Env)
+	}
+
+	if target.ed.Options == nil || !target.ed.Options.Shared {
+		return nil, errors.Errorf("Env %s is not shared", ext.Env)
+	}
+
+	name := ext.EnvNetwork
+
+	if name == "" {
+		name = tpl.DefaultNetwork
+	}
+
+	tn, ok := target.networks[name]
+
+	if !ok || tn.ipn == nil {
+		return nil, errors.Errorf("Env %s has no network %s", ext.Env, name)
+	}
+
+	enet := &envNetwork{
+		name:      ext.Name,
+		id:        tn.id,
+		ipn:       tn.ipn,
+		ipn6:      tn.ipn6,
+		prefix:    tn.prefix,
+		prefix6:   tn.prefix6,
+		gateway:   tn.gateway,
+		gateway6:  tn.gateway6,
+		owner:     target,
+		ownerName: name,
+	}
+
+	env.Lock()
+	env.links[ext.Name] = target
+	env.Unlock()
+
+	target.linkedBy[env.id] = env
+
+	return enet, nil
+}
+
+// Release all the linked envs, must be called once
+// the env containers have been removed
+func (env *Env) unlinkAll() {
+	env.Lock()
+	links := env.links
+	env.links = map[string]*Env{}
+	env.Unlock()
+
+	for _, target := range links {
+		target.unlink(env)
+	}
+}
+
+// Release a link from another env, finishing deferred termination
+// when no linking envs are left
+func (env *Env) unlink(linker *Env) {
+	env.Lock()
+	delete(env.linkedBy, linker.id)
+
+	resume := env.deferred && len(env.linkedBy) == 0
+
+	if resume {
+		env.deferred = false
+	}
+
+	env.Unlock()
+
+	if resume {
+		if err := env.terminate(); err != nil {
+			envLog.Errorf("[%s] Error terminating env: %s", env.id, err)
+		}
+	}
+}
+
+// External hosts to register in discovery DNS: hostname -> addresses,
+// the engine host is resolved to env networks gateways
+func (env *Env) externalHosts() map[string]string {
+	hosts := map[string]string{}
+
+	if env.ed.Options == nil {
+		return hosts
+	}
+
+	env.RLock()
+	defer env.RUnlock()
+
+	for host, addr := range env.ed.Options.ExternalHosts {
+		if addr != def.HostGateway {
+			hosts[host] = addr
+
+			continue
+		}
+
+		var gateways []string
+
+		for _, name := range env.networkNames() {
+			if n, ok := env.networks[name]; ok && n.ipn != nil {
+				gateways = append(gateways, n.gatewayCidrs()...)
+			}
+		}
+
+		hosts[host] = strings.Join(gateways, ",")
+	}
+
+	return hosts
+}
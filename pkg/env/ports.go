@@ -24,19 +24,194 @@
 
 package env
 
-import "github.com/syhpoon/xenvman/pkg/tpl"
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+	"github.com/syhpoon/xenvman/pkg/metrics"
+	"github.com/syhpoon/xenvman/pkg/tpl"
+)
 
 // template name -> [container name -> ports]
-type ports map[string][]map[string]map[uint16]uint16
+type ports map[string][]map[string][]*conteng.Port
 
-func (p ports) add(cont *tpl.Container, ports map[uint16]uint16) {
+func (p ports) add(cont *tpl.Container, ports []*conteng.Port) {
 	tplName, tplIdx := cont.Template()
 
 	for len(p[tplName])-1 < tplIdx {
-		p[tplName] = append(p[tplName], map[string]map[uint16]uint16{})
+		p[tplName] = append(p[tplName], map[string][]*conteng.Port{})
 	}
 
 	cur := p[tplName][tplIdx]
 
 	cur[cont.Name()] = ports
 }
+
+// Find an exposed port by container port and protocol
+func findPort(ports []*conteng.Port, port uint16,
+	proto string) *conteng.Port {
+
+	for _, p := range ports {
+		if p.Port == port && p.Protocol == proto {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Lease host ports for container ports, unless fixed ones are requested.
+// Discovery agent address is updated if its port is among them.
+func (env *Env) exposePorts(cont *tpl.Container,
+	contPorts []*conteng.Port) ([]*conteng.Port, error) {
+
+	var dport uint16
+
+	if cont.GetLabel("xenv-discovery") == "true" {
+		port, _ := strconv.ParseUint(cont.GetLabel("xenv-discovery-port"), 0, 16)
+		dport = uint16(port)
+	}
+
+	var res []*conteng.Port
+
+	defer env.updatePortMetrics()
+
+	for _, contPort := range contPorts {
+		port := *contPort
+		var err error
+
+		if port.HostIP == "" {
+			port.HostIP = env.params.PortsHostIP
+		} else if !allowedHostIP(port.HostIP, env.params.PortsHostIP) {
+			env.releasePorts(res)
+
+			return nil, errors.Errorf(
+				"Host address %s of port %s is not allowed, ports_host_ip is %s",
+				port.HostIP, port.Key(), env.params.PortsHostIP)
+		}
+
+		if port.HostPort == 0 {
+			port.HostPort, err = env.params.PortRange.Lease(env.id)
+		} else {
+			err = env.params.PortRange.LeasePort(port.HostPort, env.id)
+		}
+
+		if err != nil {
+			env.releasePorts(res)
+
+			return nil, errors.WithStack(err)
+		}
+
+		envLog.Debugf("Exposing internal port %s as %d for %s",
+			port.Key(), port.HostPort, cont.Hostname())
+
+		res = append(res, &port)
+
+		if port.Port == dport && port.Protocol == conteng.ProtoTCP {
+			env.Lock()
+			env.discoverExternalAddress = fmt.Sprintf(
+				"http://%s:%d", env.params.ExportAddress,
+				port.HostPort)
+			env.Unlock()
+		}
+	}
+
+	env.Lock()
+	env.ports.add(cont, res)
+	env.Unlock()
+
+	return res, nil
+}
+
+// Templates may only choose a host address when
+// the server doesn't restrict it
+func allowedHostIP(hostIP, allowed string) bool {
+	if allowed == "" {
+		return true
+	}
+
+	ip, aip := net.ParseIP(hostIP), net.ParseIP(allowed)
+
+	if ip == nil || aip == nil {
+		return hostIP == allowed
+	}
+
+	return ip.Equal(aip)
+}
+
+// Return host ports back to the range
+func (env *Env) releasePorts(ports []*conteng.Port) {
+	for _, port := range ports {
+		env.params.PortRange.Release(port.HostPort)
+	}
+
+	env.updatePortMetrics()
+}
+
+func (env *Env) updatePortMetrics() {
+	metrics.PortsLeased.WithLabelValues().Set(
+		float64(env.params.PortRange.Leased()))
+	metrics.PortsTotal.WithLabelValues().Set(
+		float64(env.params.PortRange.Size()))
+}
+
+// Return a host address the given container port is reachable at
+// from the xenvman host. Zero port means the lowest exposed tcp one.
+func (env *Env) ProxyAddress(container string, port uint16) (string, error) {
+	env.RLock()
+	defer env.RUnlock()
+
+	tplNames := make([]string, 0, len(env.ports))
+
+	for name := range env.ports {
+		tplNames = append(tplNames, name)
+	}
+
+	sort.Strings(tplNames)
+
+	for _, name := range tplNames {
+		for _, conts := range env.ports[name] {
+			ports, ok := conts[container]
+
+			if !ok {
+				continue
+			}
+
+			var found *conteng.Port
+
+			for _, p := range ports {
+				if p.Protocol != conteng.ProtoTCP {
+					continue
+				}
+
+				if (port == 0 && (found == nil || p.Port < found.Port)) ||
+					p.Port == port {
+					found = p
+				}
+			}
+
+			if found == nil && port == 0 {
+				return "", errors.Errorf("No tcp ports exposed for %s",
+					container)
+			} else if found == nil {
+				return "", errors.Errorf("Port %d is not exposed for %s",
+					port, container)
+			}
+
+			host := found.HostIP
+
+			if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+				host = "127.0.0.1"
+			}
+
+			return net.JoinHostPort(host,
+				strconv.FormatUint(uint64(found.HostPort), 10)), nil
+		}
+	}
+
+	return "", errors.Errorf("Container %s not found", container)
+}
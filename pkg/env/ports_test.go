@@ -27,9 +27,16 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/conteng"
 	"github.com/syhpoon/xenvman/pkg/tpl"
 )
 
+func tcpPort(port, hostPort uint16) []*conteng.Port {
+	return []*conteng.Port{
+		{Port: port, Protocol: conteng.ProtoTCP, HostPort: hostPort},
+	}
+}
+
 func TestPorts(t *testing.T) {
 	p := make(ports)
 
@@ -39,27 +46,34 @@ func TestPorts(t *testing.T) {
 	cont1tpl02 := tpl.NewContainer("c1", "tpl2", 0)
 	cont1tpl03 := tpl.NewContainer("c1", "tpl3", 0)
 
-	p.add(cont1tpl01, map[uint16]uint16{1: 1})
+	p.add(cont1tpl01, tcpPort(1, 1))
 	require.Len(t, p["tpl1"], 1)
 	require.Len(t, p["tpl1"][0], 1)
 
-	p.add(cont2tpl01, map[uint16]uint16{2: 1})
+	p.add(cont2tpl01, tcpPort(2, 1))
 	require.Len(t, p["tpl1"], 1)
 	require.Len(t, p["tpl1"][0], 2)
 
-	p.add(cont1tpl11, map[uint16]uint16{10: 10})
+	p.add(cont1tpl11, tcpPort(10, 10))
 	require.Len(t, p["tpl1"], 2)
 	require.Len(t, p["tpl1"][1], 1)
 
-	p.add(cont1tpl02, map[uint16]uint16{1: 2})
-	p.add(cont1tpl03, map[uint16]uint16{1: 3})
+	p.add(cont1tpl02, tcpPort(1, 2))
+	p.add(cont1tpl03, tcpPort(1, 3))
 
 	require.Len(t, p["tpl2"], 1)
 	require.Len(t, p["tpl3"], 1)
 
-	require.Equal(t, p["tpl1"][0]["c1"][1], uint16(1))
-	require.Equal(t, p["tpl1"][0]["c2"][2], uint16(1))
-	require.Equal(t, p["tpl1"][1]["c1"][10], uint16(10))
-	require.Equal(t, p["tpl2"][0]["c1"][1], uint16(2))
-	require.Equal(t, p["tpl3"][0]["c1"][1], uint16(3))
+	require.Equal(t, uint16(1),
+		findPort(p["tpl1"][0]["c1"], 1, conteng.ProtoTCP).HostPort)
+	require.Equal(t, uint16(1),
+		findPort(p["tpl1"][0]["c2"], 2, conteng.ProtoTCP).HostPort)
+	require.Equal(t, uint16(10),
+		findPort(p["tpl1"][1]["c1"], 10, conteng.ProtoTCP).HostPort)
+	require.Equal(t, uint16(2),
+		findPort(p["tpl2"][0]["c1"], 1, conteng.ProtoTCP).HostPort)
+	require.Equal(t, uint16(3),
+		findPort(p["tpl3"][0]["c1"], 1, conteng.ProtoTCP).HostPort)
+
+	require.Nil(t, findPort(p["tpl1"][0]["c1"], 1, conteng.ProtoUDP))
 }
@@ -0,0 +1,24 @@
+function execute(tpl, params) {
+  var img = tpl.FetchImage(params.image);
+
+  var app = img.NewContainer("app");
+  app.SetEnv("IP", "{{.Self.IP}}");
+
+  if (params.network) {
+    app.AttachNetwork(params.network);
+  }
+
+  if (params.host) {
+    app.SetEnv("HOST_ADDR", fmt('{{index .ExternalHosts "%s"}}', params.host));
+    app.SetEnv("HOST_CIDR",
+      fmt('{{index .ExternalHostAddresses "%s"}}', params.host));
+  }
+
+  if (params.linked) {
+    app.SetEnv("LINKED_IP", fmt(
+      '{{with .LinkedEnv "%s"}}{{(.ContainerWithLabel "role" "app").IP}}{{end}}',
+      params.linked));
+  }
+
+  app.SetLabel("role", "app");
+}
@@ -0,0 +1,10 @@
+function execute(tpl, params) {
+  var img = tpl.FetchImage(params.image);
+
+  for (var i = 0; i < 2; i++) {
+    var cont = img.NewContainer(fmt("%s%d", params.container, i));
+
+    cont.SetEnv("ADDR", "{{.Self.IP}}");
+    cont.SetEnv("ADDR6", "{{.Self.IP6}}");
+  }
+}
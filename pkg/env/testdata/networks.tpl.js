@@ -0,0 +1,23 @@
+function execute(tpl, params) {
+  tpl.Network("front");
+  tpl.Network("back");
+
+  var img = tpl.FetchImage(params.image);
+
+  var proxy = img.NewContainer("proxy");
+  proxy.AttachNetwork("front");
+
+  var web = img.NewContainer("web");
+  web.AttachNetwork("front");
+  web.AttachNetwork("back");
+  web.SetEnv("BACK_IP", '{{.Self.NetworkIP "back"}}');
+
+  var db = img.NewContainer("db");
+  db.AttachNetwork("back");
+
+  var worker = img.NewContainer("worker");
+  worker.SetEnv("WORKER_IP", "{{.Self.IP}}");
+
+  var cache = img.NewContainer("cache");
+  cache.AttachNetwork(params.undeclared || "back");
+}
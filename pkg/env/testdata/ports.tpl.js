@@ -0,0 +1,7 @@
+function execute(tpl, params) {
+  var img = tpl.FetchImage(params.image);
+  var cont = img.NewContainer(params.container);
+
+  cont.SetPorts(80);
+  cont.SetEnv("PUBLIC_URL", "http://{{.ExternalAddress}}:{{.Self.ExposedPort 80}}");
+}
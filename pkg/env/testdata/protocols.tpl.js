@@ -0,0 +1,9 @@
+function execute(tpl, params) {
+  var img = tpl.FetchImage(params.image);
+  var cont = img.NewContainer(params.container);
+
+  cont.SetPorts(80, "53/udp", fmt("%s:%d:9000", params.host_ip, params.fixed));
+  cont.SetEnv("HTTP_PORT", "{{.Self.ExposedPort 80}}");
+  cont.SetEnv("DNS_PORT", '{{.Self.ExposedPort 53 "udp"}}');
+  cont.SetEnv("FIXED_PORT", "{{.Self.ExposedPort 9000}}");
+}
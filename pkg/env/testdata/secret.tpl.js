@@ -0,0 +1,16 @@
+function info() {
+  return {
+    description: "Secret parameters test",
+    parameters: {
+      password: {
+        description: "Password",
+        type: "string",
+        secret: true
+      }
+    }
+  };
+}
+
+function execute(tpl, params) {
+  throw new Error("invalid password: " + params.password);
+}
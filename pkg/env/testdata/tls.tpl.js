@@ -0,0 +1,6 @@
+function execute(tpl, params) {
+  var img = tpl.FetchImage(params.image);
+  var cont = img.NewContainer(params.container);
+
+  tpl.IssueCert(cont, {"sans": ["extra.local"]});
+}
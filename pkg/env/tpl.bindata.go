@@ -1,5 +1,6 @@
 // Code generated by go-bindata. (@generated) DO NOT EDIT.
 // sources:
+// internal-tpl/discovery.tpl.data/addresses.json
 // internal-tpl/discovery.tpl.data/domains.json
 // internal-tpl/discovery.tpl.js
 package env
@@ -67,7 +68,27 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _internalTplDiscoveryTplDataDomainsJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x44\x8c\x31\x0a\x42\x31\x10\x05\x7b\x4f\xf1\x08\xbf\xfc\xe4\x00\x01\x0b\xb1\xd1\xce\x2b\x04\xb3\x4a\x20\x6e\x20\x49\x21\x2c\xef\xee\x62\x0c\xd8\x0e\x33\x63\x07\x00\x66\x68\x51\x9f\x82\x2d\xa7\xf7\x8e\xed\x5e\x75\x20\x1c\xe1\x4f\xa5\x9c\xab\x8e\x98\x55\x5a\x07\xb9\xe4\xfc\x98\x26\xb9\x9b\x89\xa6\x1f\x07\x9c\xd9\x4c\xfd\xa5\xf6\xa1\xf1\x25\xa4\x77\xe1\x8f\xaf\x37\xd2\xad\x85\x68\xfa\xfe\xf8\x09\x00\x00\xff\xff\xbc\x68\x8f\x02\x80\x00\x00\x00")
+var _internalTplDiscoveryTplDataAddressesJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x65\x8e\x41\x0a\x02\x31\x0c\x45\xf7\x9e\x22\x94\x59\x96\x1e\x60\xc0\xc5\x20\x82\xd7\x28\x36\xea\x40\xcd\x40\xd3\xc5\x40\xc8\xdd\x6d\x3b\xd5\x2e\xdc\x85\xf0\xdf\xfb\x5f\x4e\x00\x20\x02\xc9\xd3\x13\x61\x5a\xc3\x6e\x61\xba\x6f\x94\x61\x3e\x83\x5b\x62\xbc\x94\xdb\xaf\x84\x89\x41\xb5\x87\xd7\x47\x4b\xaa\x5a\x11\xa4\x70\xfc\x01\x8c\x48\x43\xdd\x6d\xe3\x4c\xfe\x8d\xaa\xce\xcc\xe3\xbd\x84\x90\x90\x19\x59\xd5\x74\x53\xa1\x87\xb6\x6f\x78\x15\xba\x8c\xf0\x25\xcd\x6d\xc5\x75\xcf\x98\xc8\xc7\xaa\xfd\x39\xe0\xdb\x6a\xab\xbf\x32\xa3\xad\xa1\x7f\x25\xfa\x01\x6c\x8a\xfa\xa9\xec\x00\x00\x00")
+
+func internalTplDiscoveryTplDataAddressesJsonBytes() ([]byte, error) {
+	return bindataRead(
+		_internalTplDiscoveryTplDataAddressesJson,
+		"internal-tpl/discovery.tpl.data/addresses.json",
+	)
+}
+
+func internalTplDiscoveryTplDataAddressesJson() (*asset, error) {
+	bytes, err := internalTplDiscoveryTplDataAddressesJsonBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "internal-tpl/discovery.tpl.data/addresses.json", size: 236, mode: os.FileMode(420), modTime: time.Unix(1792386703, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _internalTplDiscoveryTplDataDomainsJson = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x65\x8e\x3d\x0a\x02\x31\x10\x85\x7b\x4f\xf1\x58\xb6\x0c\x29\x2d\x16\x2c\x44\x04\xed\xbc\x42\x30\x51\x03\x71\x16\xb2\x29\x16\x86\xb9\xfb\x26\x31\x12\xc1\x6e\xde\xf0\x7e\x3e\xde\x01\x60\x46\x34\xf4\x74\x18\xbd\x5d\x15\xc6\xfb\x4c\x09\xd3\x01\xfa\x18\xc2\x29\xdf\xc6\x93\x8b\x0b\x44\x9a\xd9\x3f\xaa\x53\x44\x31\x3b\xb2\x9f\x3f\x30\x30\xd7\xa8\xbe\xcc\x4b\x22\xf3\x76\x22\x7a\x98\xfa\xfb\x7a\x13\x61\x2e\xe1\x26\xf7\xb5\xe1\x47\xb5\xba\xa1\xed\x64\xd1\x47\x1b\xe1\x2b\x77\x67\x44\x63\x6d\x26\x2a\x8c\xe7\x35\xb9\x48\x26\x94\xd1\x2f\x23\xa0\xca\x6a\xf1\x76\x86\x1a\xf9\x2b\x97\x0d\xc3\xc7\xff\x0d\x02\x01\x00\x00")
 
 func internalTplDiscoveryTplDataDomainsJsonBytes() ([]byte, error) {
 	return bindataRead(
@@ -82,12 +103,12 @@ func internalTplDiscoveryTplDataDomainsJson() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "internal-tpl/discovery.tpl.data/domains.json", size: 128, mode: os.FileMode(420), modTime: time.Unix(1545629188, 0)}
+	info := bindataFileInfo{name: "internal-tpl/discovery.tpl.data/domains.json", size: 258, mode: os.FileMode(420), modTime: time.Unix(1792386703, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
 
-var _internalTplDiscoveryTplJs = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x53\x5f\x6b\x9c\x40\x10\x7f\xf7\x53\x0c\x03\x21\x2b\x9c\x7a\x6d\x29\x04\x83\x0f\x21\x49\x21\xd0\x86\x10\x1f\x4b\x1f\xb6\xee\x5c\xb4\xd5\x5d\xd9\x19\xaf\x17\xc4\xef\x5e\xd6\x9e\x77\x97\xa3\xa5\xfa\xe4\xec\xcc\xef\xcf\xfc\xdc\x2c\x83\x92\xfc\xb6\xa9\x08\x4c\xc3\x95\xdb\x92\x7f\x05\xa1\xae\x6f\xb5\x50\x94\x65\x51\x96\xc1\x93\xf6\xba\x23\x21\xcf\x79\xf8\xf4\x54\x0d\x9e\x9d\x87\x3c\x07\x16\xdf\xd8\x17\x18\xf1\x5d\x3a\xbf\x38\x41\x02\xf7\x3b\x21\x6f\x75\x0b\x77\x8f\x25\x30\xf9\x2d\x79\x10\x07\x03\x53\x14\x6d\x06\x5b\x49\xe3\x2c\xd0\x8e\xaa\x41\x48\x49\xdf\xae\xa0\x0f\x0c\x1c\xc3\x18\x01\xc8\x6b\x4f\xe9\xbd\xe5\xc1\x53\x39\xa3\x2b\x5c\x18\x71\xe9\x4c\x8d\xe5\xe7\x7d\x31\xbe\x8e\x22\x80\xad\xf6\xd0\x74\x2f\x50\x80\xf4\x6d\xfa\x89\xa4\xaa\x1f\x3a\xfd\x42\x0a\xf9\xb5\xee\x9d\xb3\xd9\x8e\xec\xb6\xd3\x36\x0f\xc6\x58\x30\xbe\xde\x4f\x55\xce\x0a\x14\x61\x38\x7d\xa4\x5f\xb7\xce\x8a\x6e\x2c\x79\x85\x87\x7d\xe0\x91\xe2\xe0\xbd\x80\x83\xe5\xf9\xb0\xd9\x80\x9a\x95\x3f\xf0\x1d\x6d\x1a\x4b\x46\xed\xa5\x2e\x23\xf1\x1f\x7b\x70\x8a\x71\xd6\x12\x24\x4d\x0b\x55\xef\x7c\xd0\x75\xb5\xbe\x5a\xcf\x0c\x41\x67\xfa\xc5\x0d\x56\xee\xb4\x68\x85\xc6\x75\xba\xb1\x9c\xfe\x60\x67\x71\x05\x98\x9d\x15\x46\x6c\xac\x90\xef\x5d\x30\x8c\x39\x88\x1f\x68\x9a\x5d\xcf\x48\x25\xc9\x93\xf3\xc2\x2a\xf0\xbc\x29\x7f\xd6\xdf\xa9\x55\x18\xf6\x95\x1c\x77\xb0\x02\x0c\x08\xf8\xff\xd6\x24\x20\xe2\x0a\x36\x9d\x28\xbc\x30\x21\xb4\x40\x11\x1f\x5d\x94\x24\xb7\x9d\x51\xb8\x64\x12\xc0\x4f\x98\xe6\x2d\x1d\x1f\x4c\x92\x4e\xf7\xc5\x99\xbf\xb3\xa6\x99\x2c\x49\x6a\x91\x3e\xd1\xc6\xf8\x22\x3f\x32\xff\x05\xd0\x58\xde\xb7\x7d\xfc\xf0\x2f\xac\x25\x15\x2e\x2e\x18\x57\x87\xd8\xe2\x08\xe0\xc4\xcb\x8d\x31\xcf\xa4\x4d\x63\x89\xf9\xb6\xa6\xea\xa7\xc2\x20\x22\x04\x30\xc3\xe2\xe0\x5b\xcc\x67\xcc\xcb\x70\x90\x67\xd9\x38\xa6\xcb\x15\xb9\x31\xc6\x13\xf3\x34\xe5\xe3\x98\x96\xd4\x6e\xd2\xfb\x5d\xef\x98\x4c\xc8\x06\x2e\xcc\x34\x65\x35\xe9\x56\xea\xcb\x37\x5e\xb0\x72\x86\x18\x73\xf8\xfa\x7e\xbd\xfe\x16\xfe\x9a\xf8\x3a\x9a\x7e\x07\x00\x00\xff\xff\xb0\xdb\x40\xc7\xcc\x03\x00\x00")
+var _internalTplDiscoveryTplJs = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x85\x53\x4b\x6b\xdb\x40\x10\xbe\xfb\x57\x0c\x0b\x26\x32\x54\x92\xdb\x52\x08\x2a\x3e\x04\x3b\x05\x43\x6b\x42\x7c\x0c\x3d\x6c\xb4\x63\x4b\xb5\xb4\x2b\x76\x56\x7e\x60\xf4\xdf\x3b\x2b\x5b\x96\xe3\x26\x54\xd6\x45\xbb\x33\xdf\x63\xbe\x71\x1c\xc3\x12\xed\x36\x4f\x11\x54\x4e\xa9\xd9\xa2\x3d\x80\xc3\xb2\x2a\xa4\xc3\x41\x1c\xf3\x0b\x4f\xd2\xca\x12\x1d\x5a\x4a\xfc\xa7\xc5\xb4\xb6\x64\x2c\x24\x09\x90\xb3\xb9\x5e\xc3\x51\x7c\x8e\xda\x9f\x68\x20\x84\xc7\x3d\xd7\x6a\x59\xc0\x6c\xb1\x04\x62\x74\xb4\xe0\x0c\xd4\x84\x83\xc1\xaa\xd6\xa9\xcb\x8d\x06\xdc\x33\x8c\xc3\xc0\x55\xc5\x27\xa8\x3c\x03\x8d\xe0\x38\x00\x70\x87\x0a\xa3\x47\x4d\xb5\xc5\x65\x8b\x1e\x88\x8e\x51\x74\x95\x91\xd2\xf4\x7c\x3e\x1c\x7d\x1f\x70\xd7\x56\x5a\xc8\xcb\x35\x4c\x80\x01\xa3\x1f\xe8\xd2\x6c\x5e\xca\x35\x06\x82\x0e\x59\x65\x8c\x8e\xf7\xa8\xb7\xa5\xd4\x89\x37\x46\x4e\x70\xdb\xa9\x2b\x35\xda\x71\x1b\x37\x47\x0b\xdc\x4d\xf9\x4b\xe6\x1a\x6d\x20\x2e\xf3\x10\x3d\xc5\xc5\xfb\x04\x2e\x96\xdb\xcb\x7c\x05\x41\xab\x7c\x4e\x33\x5c\x31\x80\x0a\xce\x52\xbb\x96\xd1\xc9\x1e\x5c\x63\xdc\x94\x78\x49\x4d\x47\x55\x19\xeb\x75\xdd\x8f\xef\xc7\x2d\x83\xd7\x19\xfd\x32\xb5\x76\x33\xe9\x24\xcb\x33\x25\x0b\xa5\xe8\x0f\x19\xcd\x83\x11\xf1\xcd\xc1\x51\xe4\x9a\x73\xa8\x8c\x37\x2c\x12\x70\xb6\xc6\xa6\x75\xcd\x19\x2e\xd0\xed\x8c\xdd\x80\x54\xca\x22\x11\x12\xe4\x1a\xa6\xf3\xd9\x33\x68\xe3\xa4\x0f\x88\x47\x9d\xa7\x1b\x54\x50\x57\xf0\x7a\x00\x8d\x3b\x4e\x91\x07\xaa\x1d\x81\xd1\xc5\xe1\x1d\x45\x17\xb0\x5e\xd3\x3f\x47\x1f\xab\x6a\xd1\x96\xe8\x9e\xd8\x37\x05\xde\xfd\x9b\xe3\x9f\xf2\x15\x8b\x40\xf8\x14\xc3\x3e\x19\xe6\xf0\x08\xe2\xff\xa5\xa1\x47\xe4\xfa\x55\xe9\x02\x31\x54\x7e\x95\x3c\xc5\xa8\x9f\x2d\x77\x4e\x4b\x15\x88\x6e\x53\x3c\xf8\x15\x53\x9b\x5d\xff\x88\x30\x2c\x65\x35\xb9\x99\xfa\x4d\x51\x4b\x16\x86\x99\x73\x55\xe8\x47\x31\x49\x7a\xe6\x77\x00\x79\xab\xcf\x65\xdf\xbe\x7e\x84\xd5\xed\x0a\x4d\x86\xc4\x50\x97\xed\xe2\xea\x2b\x2f\x0f\x4a\x3d\xa3\x54\xbc\x87\x44\xd3\x0c\xd3\x4d\x20\xbc\x08\x1f\x40\x0b\x2b\x6a\x5b\xf0\xf4\x3d\xe6\x9d\xbf\x48\xe2\xf8\x78\x8c\xba\x3f\xee\xc3\x29\xb5\xa6\x49\xf8\x70\x89\xc5\x8a\x6f\x2a\x43\xa8\x7c\x36\x30\x54\x4d\x13\x67\x28\x0b\x97\xdd\xbd\xf1\x22\x52\xa3\x90\x18\xf6\xe5\xcb\x78\xfc\xdb\xef\x32\x2b\x6a\xfe\x02\x58\x82\x1f\x0d\x62\x04\x00\x00")
 
 func internalTplDiscoveryTplJsBytes() ([]byte, error) {
 	return bindataRead(
@@ -102,7 +123,7 @@ func internalTplDiscoveryTplJs() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "internal-tpl/discovery.tpl.js", size: 972, mode: os.FileMode(420), modTime: time.Unix(1546406514, 0)}
+	info := bindataFileInfo{name: "internal-tpl/discovery.tpl.js", size: 1122, mode: os.FileMode(420), modTime: time.Unix(1792386714, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -159,8 +180,9 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
-	"internal-tpl/discovery.tpl.data/domains.json": internalTplDiscoveryTplDataDomainsJson,
-	"internal-tpl/discovery.tpl.js":                internalTplDiscoveryTplJs,
+	"internal-tpl/discovery.tpl.data/addresses.json": internalTplDiscoveryTplDataAddressesJson,
+	"internal-tpl/discovery.tpl.data/domains.json":   internalTplDiscoveryTplDataDomainsJson,
+	"internal-tpl/discovery.tpl.js":                  internalTplDiscoveryTplJs,
 }
 
 // AssetDir returns the file names below a certain
@@ -206,7 +228,8 @@ type bintree struct {
 var _bintree = &bintree{nil, map[string]*bintree{
 	"internal-tpl": &bintree{nil, map[string]*bintree{
 		"discovery.tpl.data": &bintree{nil, map[string]*bintree{
-			"domains.json": &bintree{internalTplDiscoveryTplDataDomainsJson, map[string]*bintree{}},
+			"addresses.json": &bintree{internalTplDiscoveryTplDataAddressesJson, map[string]*bintree{}},
+			"domains.json":   &bintree{internalTplDiscoveryTplDataDomainsJson, map[string]*bintree{}},
 		}},
 		"discovery.tpl.js": &bintree{internalTplDiscoveryTplJs, map[string]*bintree{}},
 	}},
@@ -0,0 +1,223 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+// Package envfile implements declarative environment definitions:
+// YAML or JSON files mapping to def.InputEnv
+package envfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"gopkg.in/yaml.v2"
+)
+
+// Variable lookup function, os.LookupEnv signature
+type LookupFunc func(name string) (string, bool)
+
+// Read and parse env definition file, "-" reads from stdin
+func Load(path string, lookup LookupFunc) (*def.InputEnv, error) {
+	var b []byte
+	var err error
+
+	if path == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading env file %s", path)
+	}
+
+	env, err := Parse(b, lookup)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing env file %s", path)
+	}
+
+	return env, nil
+}
+
+// Parse env definition, YAML being a superset of JSON both formats
+// are accepted. Variables in string values are substituted using lookup
+func Parse(data []byte, lookup LookupFunc) (*def.InputEnv, error) {
+	var raw interface{}
+
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if raw == nil {
+		return nil, errors.New("Env definition is empty")
+	}
+
+	conv, err := convert(raw, lookup)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if _, ok := conv.(map[string]interface{}); !ok {
+		return nil, errors.New("Env definition must be a mapping")
+	}
+
+	b, err := json.Marshal(conv)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	env := &def.InputEnv{}
+
+	if err := dec.Decode(env); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := env.Validate(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return env, nil
+}
+
+// Convert YAML generic mappings to JSON-compatible ones
+// expanding variables in string values
+func convert(v interface{}, lookup LookupFunc) (interface{}, error) {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+
+		for k, item := range val {
+			c, err := convert(item, lookup)
+
+			if err != nil {
+				return nil, err
+			}
+
+			m[fmt.Sprintf("%v", k)] = c
+		}
+
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(val))
+
+		for i, item := range val {
+			c, err := convert(item, lookup)
+
+			if err != nil {
+				return nil, err
+			}
+
+			l[i] = c
+		}
+
+		return l, nil
+	case string:
+		return Expand(val, lookup)
+	default:
+		return v, nil
+	}
+}
+
+// Substitute ${VAR} and ${VAR:-default} references,
+// $$ is an escaped $. Referencing an unset variable
+// without a default value is an error
+func Expand(s string, lookup LookupFunc) (string, error) {
+	var buf strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i == len(s)-1 {
+			buf.WriteByte(s[i])
+
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			buf.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+
+			if end == -1 {
+				return "", errors.Errorf("Unterminated variable reference in %q", s)
+			}
+
+			expr := s[i+2 : i+end]
+			name, dflt, hasDflt := expr, "", false
+
+			if idx := strings.Index(expr, ":-"); idx != -1 {
+				name, dflt, hasDflt = expr[:idx], expr[idx+2:], true
+			}
+
+			if !validName(name) {
+				return "", errors.Errorf("Invalid variable name %q in %q", name, s)
+			}
+
+			val, ok := lookup(name)
+
+			switch {
+			case ok && (val != "" || !hasDflt):
+				buf.WriteString(val)
+			case hasDflt:
+				buf.WriteString(dflt)
+			default:
+				return "", errors.Errorf("Variable %s is not set", name)
+			}
+
+			i += end
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return buf.String(), nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,212 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package envfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+func lookup(vars map[string]string) LookupFunc {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+
+		return v, ok
+	}
+}
+
+func TestExpand(t *testing.T) {
+	l := lookup(map[string]string{"A": "a", "EMPTY": ""})
+
+	cases := map[string]string{
+		"plain":           "plain",
+		"${A}":            "a",
+		"x-${A}-y":        "x-a-y",
+		"${B:-b}":         "b",
+		"${EMPTY:-e}":     "e",
+		"${EMPTY}":        "",
+		"$$":              "$",
+		"$${A}":           "${A}",
+		"$A":              "$A",
+		"cost $":          "cost $",
+		"${A}${B:-${}":    "a${",
+		"${A:-}${B:-}end": "aend",
+	}
+
+	for in, out := range cases {
+		res, err := Expand(in, l)
+
+		require.Nil(t, err, in)
+		require.Equal(t, out, res, in)
+	}
+
+	for _, in := range []string{"${B}", "${A", "${}", "${1A}"} {
+		_, err := Expand(in, l)
+
+		require.NotNil(t, err, in)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	data := `
+name: test
+description: ${DESC:-default description}
+templates:
+  - tpl: db/postgres
+    parameters:
+      version: ${PG_VERSION}
+      replicas: 2
+      opts:
+        ssl: true
+options:
+  keep_alive: 2m
+  disable_discovery: true
+`
+	env, err := Parse([]byte(data), lookup(map[string]string{
+		"PG_VERSION": "11"}))
+
+	require.Nil(t, err)
+	require.Equal(t, "test", env.Name)
+	require.Equal(t, "default description", env.Description)
+	require.Len(t, env.Templates, 1)
+	require.Equal(t, "db/postgres", env.Templates[0].Tpl)
+	require.Equal(t, def.TplParams{
+		"version":  "11",
+		"replicas": float64(2),
+		"opts":     map[string]interface{}{"ssl": true},
+	}, env.Templates[0].Parameters)
+	require.Equal(t, 2*time.Minute, env.Options.KeepAlive.ToDuration())
+	require.True(t, env.Options.DisableDiscovery)
+}
+
+func TestParseJSON(t *testing.T) {
+	data := `{"name": "${NAME}", "templates": [{"tpl": "web"}]}`
+
+	env, err := Parse([]byte(data), lookup(map[string]string{"NAME": "j"}))
+
+	require.Nil(t, err)
+	require.Equal(t, "j", env.Name)
+	require.Equal(t, "web", env.Templates[0].Tpl)
+}
+
+func TestParseErrors(t *testing.T) {
+	l := lookup(nil)
+
+	for _, data := range []string{
+		"",
+		"- a\n- b\n",
+		"name: test\ntemplate: []\n",
+		"name: ${NAME}\n",
+		"description: no name\n",
+		"name: [\n",
+	} {
+		_, err := Parse([]byte(data), l)
+
+		require.NotNil(t, err, data)
+	}
+}
+
+func TestVars(t *testing.T) {
+	cont := def.NewContainerData("id1", "db.test")
+	cont.AddPort(5432, "tcp", "", 30001)
+	cont.AddPort(53, "udp", "", 30002)
+
+	env := &def.OutputEnv{
+		Id:              "env1",
+		Name:            "test",
+		ExternalAddress: "localhost",
+		Templates: map[string][]*def.TplData{
+			"db/postgres": {
+				{
+					Containers: map[string]*def.ContainerData{"db": cont},
+					Templates: map[string][]*def.TplData{
+						"mon": {{
+							Containers: map[string]*def.ContainerData{
+								"agent-1": def.NewContainerData("id2", "agent"),
+							},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	require.Equal(t, map[string]string{
+		"XENV_ID":                                   "env1",
+		"XENV_NAME":                                 "test",
+		"XENV_EXTERNAL_ADDRESS":                     "localhost",
+		"XENV_DB_POSTGRES_0_DB_HOSTNAME":            "db.test",
+		"XENV_DB_POSTGRES_0_DB_PORT_5432":           "30001",
+		"XENV_DB_POSTGRES_0_DB_PORT_53_UDP":         "30002",
+		"XENV_DB_POSTGRES_0_MON_0_AGENT_1_HOSTNAME": "agent",
+	}, Vars(env))
+
+	dir, err := ioutil.TempDir("", "envfile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	dotenv := filepath.Join(dir, "env")
+	require.Nil(t, WriteOutputs(dotenv, env))
+
+	b, err := ioutil.ReadFile(dotenv)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(string(b),
+		"XENV_DB_POSTGRES_0_DB_HOSTNAME=db.test\n"))
+	require.Contains(t, string(b), "XENV_ID=env1\n")
+
+	js := filepath.Join(dir, "env.json")
+	require.Nil(t, ioutil.WriteFile(js, nil, 0644))
+	require.Nil(t, WriteOutputs(js, env))
+
+	b, err = ioutil.ReadFile(js)
+	require.Nil(t, err)
+	require.Contains(t, string(b), `"id": "env1"`)
+
+	// Outputs are only readable by the owner, existing files included
+	for _, path := range []string{dotenv, js} {
+		st, err := os.Stat(path)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0600), st.Mode().Perm(), path)
+	}
+}
+
+func TestWriteDotenvQuoting(t *testing.T) {
+	var buf strings.Builder
+
+	require.Nil(t, WriteDotenv(&buf, map[string]string{
+		"A": "plain-value:1/x",
+		"B": "with space",
+		"C": "",
+	}))
+
+	require.Equal(t, "A=plain-value:1/x\nB=\"with space\"\nC=\n", buf.String())
+}
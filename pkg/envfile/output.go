@@ -0,0 +1,165 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package envfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+// Variables prefix
+const Prefix = "XENV_"
+
+// Flatten env outputs into variables: XENV_ID, XENV_NAME,
+// XENV_EXTERNAL_ADDRESS and per container
+// XENV_<TPL>_<IDX>[_<TPL>_<IDX>...]_<CONT>_HOSTNAME,
+// XENV_<TPL>_<IDX>[_<TPL>_<IDX>...]_<CONT>_PORT_<PORT>[_UDP].
+// Names are upper-cased with non-alphanumeric characters replaced by _
+func Vars(env *def.OutputEnv) map[string]string {
+	vars := map[string]string{
+		Prefix + "ID":               env.Id,
+		Prefix + "NAME":             env.Name,
+		Prefix + "EXTERNAL_ADDRESS": env.ExternalAddress,
+	}
+
+	tplVars(vars, Prefix, env.Templates)
+
+	return vars
+}
+
+func tplVars(vars map[string]string, prefix string,
+	tpls map[string][]*def.TplData) {
+
+	for name, list := range tpls {
+		for idx, data := range list {
+			p := fmt.Sprintf("%s%s_%d_", prefix, varName(name), idx)
+
+			for cname, cont := range data.Containers {
+				cp := p + varName(cname) + "_"
+
+				vars[cp+"HOSTNAME"] = cont.Hostname
+
+				for port, hostPort := range cont.Ports {
+					vars[cp+"PORT_"+varName(port)] = strconv.Itoa(hostPort)
+				}
+			}
+
+			tplVars(vars, p, data.Templates)
+		}
+	}
+}
+
+func varName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// Write variables in dotenv format, sorted by name
+func WriteDotenv(w io.Writer, vars map[string]string) error {
+	names := make([]string, 0, len(vars))
+
+	for name := range vars {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", name,
+			quote(vars[name])); err != nil {
+
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+func quote(s string) string {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_-.:/@", r):
+		default:
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
+
+// Write env outputs to a file: the whole output env JSON
+// if path has .json extension, dotenv variables otherwise
+func WriteOutputs(path string, env *def.OutputEnv) error {
+	var b []byte
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		var err error
+
+		if b, err = json.MarshalIndent(env, "", "  "); err != nil {
+			return errors.WithStack(err)
+		}
+
+		b = append(b, '\n')
+	} else {
+		var buf strings.Builder
+
+		if err := WriteDotenv(&buf, Vars(env)); err != nil {
+			return err
+		}
+
+		b = []byte(buf.String())
+	}
+
+	// Outputs may contain credentials
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return errors.Wrapf(err, "Error writing outputs to %s", path)
+	}
+
+	// Mode of an existing file is not changed by WriteFile
+	if err := os.Chmod(path, 0600); err != nil {
+		return errors.Wrapf(err, "Error writing outputs to %s", path)
+	}
+
+	return nil
+}
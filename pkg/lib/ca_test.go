@@ -0,0 +1,71 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCAIssue(t *testing.T) {
+	ca, err := NewCA("test-ca")
+	require.Nil(t, err)
+
+	certPem, keyPem, err := ca.Issue(
+		[]string{"cont.0.tpl.xenv", "10.0.0.2", "localhost"})
+	require.Nil(t, err)
+
+	_, err = tls.X509KeyPair(certPem, keyPem)
+	require.Nil(t, err)
+
+	block, _ := pem.Decode(certPem)
+	require.NotNil(t, block)
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.Nil(t, err)
+
+	require.Equal(t, "cont.0.tpl.xenv", cert.Subject.CommonName)
+	require.Equal(t, []string{"cont.0.tpl.xenv", "localhost"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	require.Equal(t, "10.0.0.2", cert.IPAddresses[0].String())
+
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(ca.CertPEM()))
+
+	for _, name := range []string{"cont.0.tpl.xenv", "10.0.0.2"} {
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName: name,
+			Roots:   pool,
+		})
+		require.Nil(t, err)
+	}
+
+	_, _, err = ca.Issue(nil)
+	require.NotNil(t, err)
+}
@@ -29,11 +29,10 @@ import (
 	"sync"
 	"syscall"
 
-	"encoding/binary"
-
 	"github.com/pkg/errors"
 )
 
+// Network to assign addresses from
 type Net struct {
 	sub    string
 	ip     net.IP
@@ -42,45 +41,69 @@ type Net struct {
 	sync.Mutex
 }
 
-// Only works for IP4 for now
+// Parse an IPv4 or IPv6 network
 func ParseNet(sub string) (*Net, error) {
-	ip, ipnet, err := net.ParseCIDR(sub)
+	_, ipnet, err := net.ParseCIDR(sub)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error parsing net: %s", sub)
 	}
 
-	lastip := make(net.IP, len(ip.To4()))
+	ip := ipnet.IP
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
 
-	binary.BigEndian.PutUint32(lastip,
-		binary.BigEndian.Uint32(ip.To4())|^binary.BigEndian.Uint32(
-			net.IP(ipnet.Mask).To4()))
+	// Last address in the network: all host bits set
+	lastip := make(net.IP, len(ip))
+
+	for i := range ip {
+		lastip[i] = ip[i] | ^ipnet.Mask[i]
+	}
 
 	return &Net{
 		sub:    sub,
-		ip:     ip.To4(),
-		lastIP: lastip.To4(),
+		ip:     append(net.IP(nil), ip...),
+		lastIP: lastip,
 		ipnet:  ipnet,
 	}, nil
 }
 
+// Return the next address in the network, nil if exhausted.
+// The first address is never returned: it's the network address for IPv4
+// and the Subnet-Router anycast one for IPv6 (RFC 4291).
+// The last address is never returned either: it's a broadcast one for IPv4
+// and belongs to the reserved subnet anycast range for IPv6 (RFC 2526).
 func (n *Net) NextIP() net.IP {
 	n.Lock()
 	defer n.Unlock()
 
-	n.ip[3]++
+	// Increment with carry
+	for i := len(n.ip) - 1; i >= 0; i-- {
+		n.ip[i]++
+
+		if n.ip[i] != 0 {
+			break
+		}
+	}
 
 	if n.ip.Equal(n.lastIP) || !n.ipnet.Contains(n.ip) {
 		return nil
 	}
 
-	return n.ip
+	return append(net.IP(nil), n.ip...)
 }
 
 func (n *Net) Sub() string {
 	return n.sub
 }
 
+// Whether the network is an IPv6 one
+func (n *Net) IsIPv6() bool {
+	return len(n.ip) == net.IPv6len
+}
+
 func NetsOverlap(n1, n2 *net.IPNet) bool {
 	return n1.Contains(n2.IP) || n2.Contains(n1.IP)
 }
@@ -46,6 +46,26 @@ func TestIPNext(t *testing.T) {
 	require.Nil(t, ip3)
 }
 
+func TestIPNextIPv6(t *testing.T) {
+	ipn, err := ParseNet("fd00::/126")
+	require.Nil(t, err)
+	require.True(t, ipn.IsIPv6())
+
+	require.Equal(t, net.ParseIP("fd00::1"), ipn.NextIP())
+	require.Equal(t, net.ParseIP("fd00::2"), ipn.NextIP())
+	require.Nil(t, ipn.NextIP())
+
+	// Carry into the upper byte
+	ipn, err = ParseNet("fd00::/119")
+	require.Nil(t, err)
+
+	for i := 0; i < 255; i++ {
+		require.NotNil(t, ipn.NextIP())
+	}
+
+	require.Equal(t, net.ParseIP("fd00::100"), ipn.NextIP())
+}
+
 func TestNetsOverlap(t *testing.T) {
 	_, n1, _ := net.ParseCIDR("10.0.0.0/24")
 	_, n2, _ := net.ParseCIDR("10.0.0.0/30")
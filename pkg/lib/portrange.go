@@ -34,45 +34,52 @@ import (
 
 type Port = uint16
 
+// Range of host ports leased to owners (e.g. environments).
+// Leased ports are not handed out again until released.
 type PortRange struct {
 	min  Port
 	max  Port
 	next Port
+	// Port -> owner
+	leases map[Port]string
 	sync.Mutex
 }
 
 func NewPortRange(min, max Port) *PortRange {
 	return &PortRange{
-		min:  min,
-		next: min,
-		max:  max,
+		min:    min,
+		next:   min,
+		max:    max,
+		leases: map[Port]string{},
 	}
 }
 
-func (pr *PortRange) NextPort() (Port, error) {
+// Lease a free port to owner.
+// A port is considered free if it's not leased and can be bound to,
+// which is still racy, so whoever binds it eventually must be ready
+// for the port to be taken by someone else meanwhile.
+func (pr *PortRange) Lease(owner string) (Port, error) {
 	pr.Lock()
 	defer pr.Unlock()
 
-	var span uint16
-
-	for {
-		if span >= pr.max-pr.min {
-			return 0, errors.Errorf("No free ports found in range %d-%d: %d",
-				pr.min, pr.max, span)
-		}
+	size := pr.size()
 
+	for tried := 0; tried < size; tried++ {
 		// Reset back to min, some ports may have been freed by now
-		if pr.next > pr.max {
+		if pr.next > pr.max || pr.next < pr.min {
 			pr.next = pr.min
 		}
 
-		l, err := net.Listen("tcp", fmt.Sprintf(":%d", pr.next))
-
+		port := pr.next
 		pr.next++
 
-		if err != nil {
-			span++
+		if _, ok := pr.leases[port]; ok {
+			continue
+		}
+
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 
+		if err != nil {
 			if IsErrAddrInUse(err) {
 				continue
 			}
@@ -80,11 +87,77 @@ func (pr *PortRange) NextPort() (Port, error) {
 			return 0, err
 		}
 
-		span = 0
-
-		port := l.Addr().(*net.TCPAddr).Port
 		_ = l.Close()
 
-		return uint16(port), nil
+		pr.leases[port] = owner
+
+		return port, nil
+	}
+
+	return 0, errors.Errorf("No free ports found in range %d-%d",
+		pr.min, pr.max)
+}
+
+// Lease a specific port to owner.
+// Ports outside of the range are not tracked and can always be leased.
+func (pr *PortRange) LeasePort(port Port, owner string) error {
+	pr.Lock()
+	defer pr.Unlock()
+
+	if port < pr.min || port > pr.max {
+		return nil
+	}
+
+	if o, ok := pr.leases[port]; ok && o != owner {
+		return errors.Errorf("Port %d is already leased", port)
+	}
+
+	pr.leases[port] = owner
+
+	return nil
+}
+
+// Return a port back to the range
+func (pr *PortRange) Release(port Port) {
+	pr.Lock()
+	defer pr.Unlock()
+
+	delete(pr.leases, port)
+}
+
+// Release all the ports leased to owner, returns number of released ports
+func (pr *PortRange) ReleaseAll(owner string) int {
+	pr.Lock()
+	defer pr.Unlock()
+
+	released := 0
+
+	for port, o := range pr.leases {
+		if o == owner {
+			delete(pr.leases, port)
+			released++
+		}
 	}
+
+	return released
+}
+
+// Number of currently leased ports
+func (pr *PortRange) Leased() int {
+	pr.Lock()
+	defer pr.Unlock()
+
+	return len(pr.leases)
+}
+
+// Total number of ports in the range
+func (pr *PortRange) Size() int {
+	pr.Lock()
+	defer pr.Unlock()
+
+	return pr.size()
+}
+
+func (pr *PortRange) size() int {
+	return int(pr.max) - int(pr.min) + 1
 }
@@ -0,0 +1,178 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Find a free range of n ports above 40000
+func freeRange(t *testing.T, n int) (Port, Port) {
+	for min := 40000; min < 60000; min += n {
+		var ls []net.Listener
+
+		for p := min; p < min+n; p++ {
+			l, err := net.Listen("tcp", fmt.Sprintf(":%d", p))
+
+			if err != nil {
+				break
+			}
+
+			ls = append(ls, l)
+		}
+
+		for _, l := range ls {
+			_ = l.Close()
+		}
+
+		if len(ls) == n {
+			return Port(min), Port(min + n - 1)
+		}
+	}
+
+	t.Fatalf("No free port range found")
+
+	return 0, 0
+}
+
+func TestPortRangeLease(t *testing.T) {
+	min, max := freeRange(t, 3)
+	pr := NewPortRange(min, max)
+
+	// Bound by someone else
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", min+1))
+	require.Nil(t, err)
+	defer l.Close()
+
+	p1, err := pr.Lease("env1")
+	require.Nil(t, err)
+	require.Equal(t, min, p1)
+
+	p2, err := pr.Lease("env2")
+	require.Nil(t, err)
+	require.Equal(t, max, p2)
+
+	_, err = pr.Lease("env1")
+	require.NotNil(t, err)
+
+	require.Equal(t, 2, pr.Leased())
+	require.Equal(t, 3, pr.Size())
+
+	pr.Release(p2)
+
+	p3, err := pr.Lease("env1")
+	require.Nil(t, err)
+	require.Equal(t, p2, p3)
+
+	require.Equal(t, 2, pr.ReleaseAll("env1"))
+	require.Equal(t, 0, pr.Leased())
+}
+
+func TestPortRangeConcurrent(t *testing.T) {
+	min, max := freeRange(t, 50)
+	pr := NewPortRange(min, max)
+
+	var wg sync.WaitGroup
+
+	type lease struct {
+		port  Port
+		owner string
+		err   error
+	}
+
+	// Results are checked in the test goroutine
+	leases := make(chan lease, 50)
+
+	for i := 0; i < 5; i++ {
+		owner := fmt.Sprintf("env%d", i)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				port, err := pr.Lease(owner)
+				leases <- lease{port: port, owner: owner, err: err}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(leases)
+
+	leased := map[Port]string{}
+
+	for l := range leases {
+		require.Nil(t, l.err)
+
+		_, dup := leased[l.port]
+		require.False(t, dup, "port %d leased twice", l.port)
+
+		leased[l.port] = l.owner
+	}
+
+	require.Len(t, leased, 50)
+	require.Equal(t, 50, pr.Leased())
+
+	_, err := pr.Lease("env0")
+	require.NotNil(t, err)
+
+	require.Equal(t, 10, pr.ReleaseAll("env3"))
+
+	for i := 0; i < 10; i++ {
+		port, err := pr.Lease("env5")
+		require.Nil(t, err)
+		require.Equal(t, "env3", leased[port])
+	}
+}
+
+func TestPortRangeLeasePort(t *testing.T) {
+	pr := NewPortRange(40000, 40002)
+
+	require.Nil(t, pr.LeasePort(40001, "env1"))
+	require.Nil(t, pr.LeasePort(40001, "env1"))
+	require.NotNil(t, pr.LeasePort(40001, "env2"))
+
+	// Not tracked
+	require.Nil(t, pr.LeasePort(50000, "env2"))
+	require.Equal(t, 1, pr.Leased())
+
+	// Specifically leased ports are not handed out
+	for i := 0; i < 2; i++ {
+		p, err := pr.Lease("env2")
+
+		if err == nil {
+			require.NotEqual(t, Port(40001), p)
+		}
+	}
+
+	require.Equal(t, 1, pr.ReleaseAll("env1"))
+}
@@ -0,0 +1,101 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const Redacted = "******"
+
+// Redactor keeps track of secret values and masks them
+// in arbitrary strings before those are logged or exported
+type Redactor struct {
+	values map[string]struct{}
+	sync.RWMutex
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{
+		values: map[string]struct{}{},
+	}
+}
+
+// Register a new secret value
+func (r *Redactor) Add(value string) {
+	if r == nil || value == "" {
+		return
+	}
+
+	r.Lock()
+	r.values[value] = struct{}{}
+	r.Unlock()
+}
+
+// Replace all the known secret values in a string
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	r.RLock()
+	values := make([]string, 0, len(r.values))
+
+	for v := range r.values {
+		values = append(values, v)
+	}
+	r.RUnlock()
+
+	// Longest values first, so that a secret containing
+	// another secret is masked as a whole
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	for _, v := range values {
+		s = strings.Replace(s, v, Redacted, -1)
+	}
+
+	return s
+}
+
+// Return an error with all the secret values masked
+func (r *Redactor) RedactError(err error) error {
+	if err == nil || r == nil {
+		return err
+	}
+
+	msg := err.Error()
+
+	if red := r.Redact(msg); red != msg {
+		return errors.New(red)
+	}
+
+	return err
+}
@@ -0,0 +1,328 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Semantic version: <major>.<minor>.<patch>[-<prerelease>]
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Pre   string
+}
+
+func ParseVersion(s string) (*Version, error) {
+	v, n, err := parsePartial(s)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if n == 0 {
+		return nil, errors.Errorf("Invalid version: %s", s)
+	}
+
+	return v, nil
+}
+
+// Returns -1, 0 or 1 if v is lower, equal or greater than o
+func (v *Version) Compare(o *Version) int {
+	pairs := [][2]int{
+		{v.Major, o.Major},
+		{v.Minor, o.Minor},
+		{v.Patch, o.Patch},
+	}
+
+	for _, p := range pairs {
+		if p[0] < p[1] {
+			return -1
+		} else if p[0] > p[1] {
+			return 1
+		}
+	}
+
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	// A pre-release version has lower precedence than a normal one
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+
+	return s
+}
+
+// Parse possibly partial version (1, 1.2, 1.2.x etc.).
+// Returns the version and number of specified numeric components
+func parsePartial(s string) (*Version, int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	// Build metadata does not affect precedence
+	if idx := strings.Index(s, "+"); idx >= 0 {
+		s = s[:idx]
+	}
+
+	v := &Version{}
+
+	if idx := strings.Index(s, "-"); idx >= 0 {
+		v.Pre = s[idx+1:]
+		s = s[:idx]
+	}
+
+	parts := strings.Split(s, ".")
+
+	if len(parts) > 3 {
+		return nil, 0, errors.Errorf("Invalid version: %s", s)
+	}
+
+	fields := []*int{&v.Major, &v.Minor, &v.Patch}
+	n := 0
+
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			if i != len(parts)-1 {
+				return nil, 0, errors.Errorf(
+					"Wildcard must be the last version component: %s", s)
+			}
+
+			break
+		}
+
+		num, err := strconv.ParseUint(p, 10, 31)
+
+		if err != nil {
+			return nil, 0, errors.Errorf("Invalid version component %q in %s", p, s)
+		}
+
+		*fields[i] = int(num)
+		n++
+	}
+
+	if v.Pre != "" && n != 3 {
+		return nil, 0, errors.Errorf(
+			"Pre-release requires full version: %s", s)
+	}
+
+	return v, n, nil
+}
+
+type comparator struct {
+	op string
+	v  *Version
+}
+
+func (c *comparator) check(v *Version) bool {
+	cmp := v.Compare(c.v)
+
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Version constraint, e.g. "2.1", "^2.1.0", ">=1.0 <3", "~1.2 || 2.x".
+// A partial version matches all the versions with the same prefix
+type Constraint struct {
+	src string
+	// Alternatives, each of which is a list of comparators to satisfy
+	sets [][]*comparator
+}
+
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{src: s}
+
+	for _, alt := range strings.Split(s, "||") {
+		var set []*comparator
+		var op string
+
+		for _, tok := range strings.Fields(alt) {
+			// Operator separated from the version by a space
+			if isConstraintOp(tok) {
+				op = tok
+
+				continue
+			}
+
+			comps, err := parseComparator(op + tok)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid constraint: %s", s)
+			}
+
+			op = ""
+			set = append(set, comps...)
+		}
+
+		if op != "" {
+			return nil, errors.Errorf("Invalid constraint: %s: dangling %s",
+				s, op)
+		}
+
+		c.sets = append(c.sets, set)
+	}
+
+	return c, nil
+}
+
+// Check if a version satisfies the constraint
+func (c *Constraint) Check(v *Version) bool {
+	for _, set := range c.sets {
+		ok := true
+
+		for _, comp := range set {
+			if !comp.check(v) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Constraint) String() string {
+	return c.src
+}
+
+func isConstraintOp(s string) bool {
+	switch s {
+	case ">", ">=", "<", "<=", "=", "^", "~":
+		return true
+	}
+
+	return false
+}
+
+// Expand a single constraint term into a list of plain comparators
+func parseComparator(s string) ([]*comparator, error) {
+	op := ""
+
+	for _, o := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, o) {
+			op = o
+			s = s[len(o):]
+			break
+		}
+	}
+
+	v, n, err := parsePartial(s)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Upper bound for a partial version: 1 -> 2.0.0, 1.2 -> 1.3.0
+	next := func(n int) *Version {
+		switch n {
+		case 1:
+			return &Version{Major: v.Major + 1}
+		case 2:
+			return &Version{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+		}
+	}
+
+	rng := func(upper *Version) []*comparator {
+		return []*comparator{{op: ">=", v: v}, {op: "<", v: upper}}
+	}
+
+	if n == 0 {
+		// Wildcard
+		if op == "" || op == "=" || op == ">=" || op == "<=" {
+			return nil, nil
+		}
+
+		return nil, errors.Errorf("Invalid use of wildcard with %s", op)
+	}
+
+	switch op {
+	case "", "=":
+		if n == 3 {
+			return []*comparator{{op: "=", v: v}}, nil
+		}
+
+		return rng(next(n)), nil
+	case "^":
+		// Allow changes that do not modify the left-most non-zero component
+		switch {
+		case v.Major > 0 || n == 1:
+			return rng(next(1)), nil
+		case v.Minor > 0 || n == 2:
+			return rng(next(2)), nil
+		default:
+			return rng(next(3)), nil
+		}
+	case "~":
+		if n == 1 {
+			return rng(next(1)), nil
+		}
+
+		return rng(next(2)), nil
+	case ">":
+		if n < 3 {
+			return []*comparator{{op: ">=", v: next(n)}}, nil
+		}
+	case "<=":
+		if n < 3 {
+			return []*comparator{{op: "<", v: next(n)}}, nil
+		}
+	}
+
+	return []*comparator{{op: op, v: v}}, nil
+}
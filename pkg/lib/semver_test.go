@@ -0,0 +1,107 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v1.2.3-rc1+build5")
+	require.Nil(t, err)
+	require.Equal(t, &Version{Major: 1, Minor: 2, Patch: 3, Pre: "rc1"}, v)
+	require.Equal(t, "1.2.3-rc1", v.String())
+
+	v, err = ParseVersion("2.1")
+	require.Nil(t, err)
+	require.Equal(t, "2.1.0", v.String())
+
+	for _, bad := range []string{"", "x", "1.a", "1.2.3.4", "1.2-rc1"} {
+		_, err = ParseVersion(bad)
+		require.NotNil(t, err, bad)
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		res  int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0-rc2", "1.0.0-rc1", 1},
+	}
+
+	for _, c := range cases {
+		a, _ := ParseVersion(c.a)
+		b, _ := ParseVersion(c.b)
+
+		require.Equal(t, c.res, a.Compare(b), "%s <=> %s", c.a, c.b)
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	cases := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"2.1", []string{"2.1.0", "2.1.9"}, []string{"2.0.9", "2.2.0"}},
+		{"2.1.3", []string{"2.1.3"}, []string{"2.1.4"}},
+		{"2.x", []string{"2.0.0", "2.9.1"}, []string{"3.0.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{"^1.2", []string{"1.2.0", "1.9.0"}, []string{"1.1.9", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{">= 1.0 <2", []string{"1.0.0", "1.9.9"}, []string{"0.9.0", "2.0.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.5"}},
+		{"<=1.2", []string{"1.2.5"}, []string{"1.3.0"}},
+		{"1.x || ^3", []string{"1.5.0", "3.1.0"}, []string{"2.0.0"}},
+	}
+
+	for _, c := range cases {
+		cons, err := ParseConstraint(c.constraint)
+		require.Nil(t, err, c.constraint)
+
+		for _, m := range c.match {
+			v, _ := ParseVersion(m)
+			require.True(t, cons.Check(v), "%s ~ %s", c.constraint, m)
+		}
+
+		for _, m := range c.noMatch {
+			v, _ := ParseVersion(m)
+			require.False(t, cons.Check(v), "%s !~ %s", c.constraint, m)
+		}
+	}
+
+	for _, bad := range []string{"^", ">=x.1", ">*", "1.2.y"} {
+		_, err := ParseConstraint(bad)
+		require.NotNil(t, err, bad)
+	}
+}
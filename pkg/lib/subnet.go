@@ -0,0 +1,198 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"math/big"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Pool of subnets of the same size carved out of a base network
+type SubnetPool struct {
+	// Base network, e.g. 10.0.0.0/8
+	Base string `mapstructure:"base"`
+	// Prefix length of allocated subnets, e.g. 24
+	Size int `mapstructure:"size"`
+}
+
+var DefaultSubnetPools = []SubnetPool{
+	{Base: "10.0.0.0/8", Size: 24},
+	{Base: "fd78:656e::/48", Size: 64},
+}
+
+// Upper bound of subnets considered in a single pool,
+// so that huge IPv6 pools do not take forever to exhaust
+const maxPoolSubnets = 1 << 24
+
+type subnetPool struct {
+	base *net.IPNet
+	size int
+	bits int
+	// Index of the next subnet to try
+	next uint64
+	// Total number of subnets in the pool
+	count uint64
+}
+
+// Allocates non-overlapping subnets from a set of IPv4 and IPv6 pools
+type SubnetAllocator struct {
+	pools     []*subnetPool
+	allocated map[string]*net.IPNet
+	sync.Mutex
+}
+
+func NewSubnetAllocator(pools []SubnetPool) (*SubnetAllocator, error) {
+	if len(pools) == 0 {
+		return nil, errors.New("No subnet pools configured")
+	}
+
+	sa := &SubnetAllocator{
+		allocated: map[string]*net.IPNet{},
+	}
+
+	for _, p := range pools {
+		_, base, err := net.ParseCIDR(p.Base)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid subnet pool: %s", p.Base)
+		}
+
+		ones, bits := base.Mask.Size()
+
+		// Leave room for at least a gateway and a couple of hosts
+		maxSize := bits - 2
+
+		if p.Size < ones || p.Size > maxSize {
+			return nil, errors.Errorf(
+				"Invalid subnet size /%d for pool %s, expected /%d-/%d",
+				p.Size, p.Base, ones, maxSize)
+		}
+
+		count := uint64(maxPoolSubnets)
+
+		if p.Size-ones < 24 {
+			count = 1 << uint(p.Size-ones)
+		}
+
+		sa.pools = append(sa.pools, &subnetPool{
+			base:  base,
+			size:  p.Size,
+			bits:  bits,
+			count: count,
+		})
+	}
+
+	return sa, nil
+}
+
+// Allocate a subnet overlapping neither previously allocated ones
+// nor any of the used networks (e.g. host interfaces or networks
+// created by someone else).
+// Only pools of the requested address family are considered.
+// Pools are tried in order, within a pool the search continues
+// from where the previous one stopped, wrapping around to reuse
+// released subnets.
+func (sa *SubnetAllocator) Allocate(used []*net.IPNet,
+	ipv6 bool) (*net.IPNet, error) {
+
+	sa.Lock()
+	defer sa.Unlock()
+
+	for _, p := range sa.pools {
+		if (p.bits == 8*net.IPv6len) != ipv6 {
+			continue
+		}
+
+		for i := uint64(0); i < p.count; i++ {
+			idx := (p.next + i) % p.count
+			sub := p.subnet(idx)
+
+			if sa.overlaps(sub, used) {
+				continue
+			}
+
+			p.next = (idx + 1) % p.count
+			sa.allocated[sub.String()] = sub
+
+			return sub, nil
+		}
+	}
+
+	family := "IPv4"
+
+	if ipv6 {
+		family = "IPv6"
+	}
+
+	return nil, errors.Errorf("No free %s subnets left in any of the pools",
+		family)
+}
+
+// Return a subnet back to its pool
+func (sa *SubnetAllocator) Release(sub string) {
+	sa.Lock()
+	defer sa.Unlock()
+
+	delete(sa.allocated, sub)
+}
+
+func (sa *SubnetAllocator) overlaps(sub *net.IPNet, used []*net.IPNet) bool {
+	for _, n := range sa.allocated {
+		if NetsOverlap(sub, n) {
+			return true
+		}
+	}
+
+	for _, n := range used {
+		if NetsOverlap(sub, n) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *subnetPool) subnet(idx uint64) *net.IPNet {
+	base := p.base.IP
+
+	if p.bits == 8*net.IPv4len {
+		base = base.To4()
+	}
+
+	n := new(big.Int).SetUint64(idx)
+	n.Lsh(n, uint(p.bits-p.size))
+	n.Add(n, new(big.Int).SetBytes(base))
+
+	ip := make(net.IP, len(base))
+	n.FillBytes(ip)
+
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(p.size, p.bits),
+	}
+}
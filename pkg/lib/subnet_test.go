@@ -0,0 +1,139 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubnetAllocator(t *testing.T) {
+	sa, err := NewSubnetAllocator([]SubnetPool{
+		{Base: "10.0.0.0/30", Size: 30},
+		{Base: "172.30.0.0/16", Size: 24},
+	})
+	require.Nil(t, err)
+
+	_, host, _ := net.ParseCIDR("172.30.0.15/24")
+
+	s1, err := sa.Allocate(nil, false)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.0/30", s1.String())
+
+	// First pool exhausted, used network skipped
+	s2, err := sa.Allocate([]*net.IPNet{host}, false)
+	require.Nil(t, err)
+	require.Equal(t, "172.30.1.0/24", s2.String())
+
+	// Released subnet is reused
+	sa.Release(s1.String())
+
+	s3, err := sa.Allocate(nil, false)
+	require.Nil(t, err)
+	require.Equal(t, s1.String(), s3.String())
+
+	_, err = NewSubnetAllocator([]SubnetPool{{Base: "10.0.0.0/16", Size: 8}})
+	require.NotNil(t, err)
+
+	_, err = NewSubnetAllocator([]SubnetPool{{Base: "fd00::/8", Size: 127}})
+	require.NotNil(t, err)
+
+	// No IPv6 pools configured
+	_, err = sa.Allocate(nil, true)
+	require.NotNil(t, err)
+}
+
+func TestSubnetAllocatorIPv6(t *testing.T) {
+	sa, err := NewSubnetAllocator([]SubnetPool{
+		{Base: "10.0.0.0/8", Size: 24},
+		{Base: "fd00:1::/48", Size: 64},
+	})
+	require.Nil(t, err)
+
+	_, host, _ := net.ParseCIDR("fd00:1::1/64")
+
+	s1, err := sa.Allocate([]*net.IPNet{host}, true)
+	require.Nil(t, err)
+	require.Equal(t, "fd00:1:0:1::/64", s1.String())
+
+	s2, err := sa.Allocate(nil, true)
+	require.Nil(t, err)
+	require.Equal(t, "fd00:1:0:2::/64", s2.String())
+
+	s3, err := sa.Allocate(nil, false)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.0/24", s3.String())
+
+	// Huge pools are capped
+	sa, err = NewSubnetAllocator([]SubnetPool{{Base: "fd00::/8", Size: 64}})
+	require.Nil(t, err)
+	require.Equal(t, uint64(maxPoolSubnets), sa.pools[0].count)
+}
+
+func TestSubnetAllocatorExhausted(t *testing.T) {
+	sa, err := NewSubnetAllocator([]SubnetPool{{Base: "10.1.0.0/23", Size: 24}})
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	subs := make(chan *net.IPNet, 2)
+	errs := make(chan error, 2)
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sub, err := sa.Allocate(nil, false)
+
+			if err != nil {
+				errs <- err
+			} else {
+				subs <- sub
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(subs)
+	close(errs)
+
+	// Checked in the test goroutine
+	require.Nil(t, <-errs)
+
+	var all []string
+
+	for sub := range subs {
+		all = append(all, sub.String())
+	}
+
+	require.ElementsMatch(t, []string{"10.1.0.0/24", "10.1.1.0/24"}, all)
+
+	_, err = sa.Allocate(nil, false)
+	require.NotNil(t, err)
+}
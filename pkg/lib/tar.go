@@ -0,0 +1,347 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/builder/dockerignore"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/pkg/errors"
+)
+
+// Extract a (optionally gzipped) tar archive into a directory.
+// First strip path components are removed from every entry name.
+// Entries which would end up outside of dest and anything except
+// regular files and directories are skipped.
+func ExtractTar(r io.Reader, dest string, strip int) error {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+
+		if err != nil {
+			return errors.Wrap(err, "Error reading gzip stream")
+		}
+
+		defer gz.Close()
+
+		r = gz
+	} else {
+		r = br
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	dest = filepath.Clean(dest)
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "Error reading tar archive")
+		}
+
+		name := stripComponents(hdr.Name, strip)
+
+		if name == "" {
+			continue
+		}
+
+		path := filepath.Join(dest, name)
+
+		if path != dest && !strings.HasPrefix(path, dest+string(filepath.Separator)) {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return errors.WithStack(err)
+			}
+
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return errors.WithStack(err)
+			}
+
+			mode := os.FileMode(hdr.Mode).Perm() | 0600
+
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			_, err = io.Copy(f, tr)
+			_ = f.Close()
+
+			if err != nil {
+				return errors.Wrapf(err, "Error extracting %s", hdr.Name)
+			}
+		}
+	}
+}
+
+func stripComponents(name string, strip int) string {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+
+	if len(parts) <= strip {
+		return ""
+	}
+
+	return filepath.FromSlash(strings.Join(parts[strip:], "/"))
+}
+
+// Write a gzipped tar archive with the given files and directories.
+// Entry names are relative to baseDir, missing paths are skipped.
+func WriteTar(w io.Writer, baseDir string, paths []string) error {
+	return writeTar(w, baseDir, paths, tarOptions{gzip: true})
+}
+
+// Same as WriteTar but modification times and ownership are not stored,
+// so that the same content always produces the same archive
+func WriteStableTar(w io.Writer, baseDir string, paths []string) error {
+	return writeTar(w, baseDir, paths,
+		tarOptions{gzip: true, stable: true, noOwner: true})
+}
+
+// Stream a docker build context for dir as an uncompressed tar archive.
+// Paths matched by dir/.dockerignore are left out, except for
+// .dockerignore itself and the dockerfile (relative to dir,
+// "Dockerfile" if empty).
+// Symbolic links, file modes and ownership are preserved, timestamps
+// are dropped, so that the same content always produces the same stream.
+func WriteBuildContext(w io.Writer, dir, dockerfile string) error {
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	excludes, err := readDockerignore(dir)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var pm *fileutils.PatternMatcher
+
+	if len(excludes) > 0 {
+		// Same as docker cli, these two are always sent to the daemon
+		excludes = append(excludes,
+			"!"+filepath.ToSlash(filepath.Clean(dockerfile)),
+			"!.dockerignore")
+
+		if pm, err = fileutils.NewPatternMatcher(excludes); err != nil {
+			return errors.Wrap(err, "Invalid .dockerignore")
+		}
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error reading directory: %s", dir)
+	}
+
+	var paths []string
+
+	for _, info := range infos {
+		paths = append(paths, filepath.Join(dir, info.Name()))
+	}
+
+	return writeTar(w, dir, paths, tarOptions{
+		stable:   true,
+		symlinks: true,
+		excludes: pm,
+	})
+}
+
+func readDockerignore(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer f.Close()
+
+	excludes, err := dockerignore.ReadAll(f)
+
+	return excludes, errors.Wrap(err, "Error reading .dockerignore")
+}
+
+type tarOptions struct {
+	// Compress with gzip
+	gzip bool
+	// Do not store timestamps
+	stable bool
+	// Do not store ownership
+	noOwner bool
+	// Store symbolic links instead of skipping them
+	symlinks bool
+	// Entries matched are not archived
+	excludes *fileutils.PatternMatcher
+}
+
+func writeTar(w io.Writer, baseDir string, paths []string, opts tarOptions) error {
+	var gz *gzip.Writer
+
+	if opts.gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	bw := bufio.NewWriterSize(w, 256*1024)
+	tw := tar.NewWriter(bw)
+
+	add := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		isLink := info.Mode()&os.ModeSymlink != 0
+
+		if !info.IsDir() && !info.Mode().IsRegular() &&
+			!(isLink && opts.symlinks) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(baseDir, path)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if opts.excludes != nil {
+			skip, err := opts.excludes.Matches(rel)
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if skip {
+				// Keep walking an excluded directory only if some of
+				// its content might be re-included by an exception
+				if info.IsDir() && !opts.excludes.Exclusions() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+		}
+
+		var link string
+
+		if isLink {
+			if link, err = os.Readlink(path); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		hdr.Name = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if opts.stable {
+			hdr.ModTime = time.Time{}
+			hdr.AccessTime = time.Time{}
+			hdr.ChangeTime = time.Time{}
+		}
+
+		if opts.noOwner {
+			hdr.Uid, hdr.Gid = 0, 0
+			hdr.Uname, hdr.Gname = "", ""
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		//noinspection GoUnhandledErrorResult
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+
+		return errors.Wrapf(err, "Error archiving %s", path)
+	}
+
+	for _, path := range paths {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := filepath.Walk(path, add); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if gz != nil {
+		return errors.WithStack(gz.Close())
+	}
+
+	return nil
+}
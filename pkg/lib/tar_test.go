@@ -0,0 +1,257 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTar(t *testing.T) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	files := map[string]string{
+		"top/a.tpl.js":          "a",
+		"top/dir/b.tpl.js":      "b",
+		"top/../../evil.tpl.js": "evil",
+	}
+
+	require.Nil(t, tw.WriteHeader(&tar.Header{
+		Name:     "top/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	}))
+
+	for name, data := range files {
+		require.Nil(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(data)),
+		}))
+
+		_, err := tw.Write([]byte(data))
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, tw.WriteHeader(&tar.Header{
+		Name:     "top/link",
+		Typeflag: tar.TypeSymlink,
+		Linkname: "/etc/passwd",
+	}))
+
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+NewId())
+	defer os.RemoveAll(tmpDir)
+
+	dest := filepath.Join(tmpDir, "dest")
+
+	require.Nil(t, ExtractTar(bytes.NewReader(buf.Bytes()), dest, 1))
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "a.tpl.js"))
+	require.Nil(t, err)
+	require.Equal(t, "a", string(data))
+
+	data, err = ioutil.ReadFile(filepath.Join(dest, "dir", "b.tpl.js"))
+	require.Nil(t, err)
+	require.Equal(t, "b", string(data))
+
+	_, err = os.Lstat(filepath.Join(dest, "link"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(filepath.Join(tmpDir, "evil.tpl.js"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestWriteTar(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+NewId())
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "src")
+	require.Nil(t, os.MkdirAll(filepath.Join(src, "a.tpl.data", "sub"), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(src, "a.tpl.js"),
+		[]byte("js"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(src, "a.tpl.data", "sub", "f"),
+		[]byte("data"), 0644))
+
+	var buf bytes.Buffer
+
+	require.Nil(t, WriteTar(&buf, src, []string{
+		filepath.Join(src, "a.tpl.js"),
+		filepath.Join(src, "a.tpl.data"),
+		filepath.Join(src, "missing"),
+	}))
+
+	dest := filepath.Join(tmpDir, "dest")
+	require.Nil(t, ExtractTar(&buf, dest, 0))
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "a.tpl.js"))
+	require.Nil(t, err)
+	require.Equal(t, "js", string(data))
+
+	data, err = ioutil.ReadFile(filepath.Join(dest, "a.tpl.data", "sub", "f"))
+	require.Nil(t, err)
+	require.Equal(t, "data", string(data))
+}
+
+func TestWriteStableTar(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+NewId())
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "Dockerfile")
+
+	require.Nil(t, os.MkdirAll(tmpDir, 0755))
+	require.Nil(t, ioutil.WriteFile(file, []byte("FROM scratch"), 0644))
+
+	write := func() []byte {
+		var buf bytes.Buffer
+
+		require.Nil(t, WriteStableTar(&buf, tmpDir, []string{file}))
+
+		return buf.Bytes()
+	}
+
+	first := write()
+
+	past := time.Now().Add(-time.Hour)
+	require.Nil(t, os.Chtimes(file, past, past))
+
+	require.Equal(t, first, write())
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("FROM alpine"), 0644))
+	require.NotEqual(t, first, write())
+}
+
+func TestWriteBuildContext(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+NewId())
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"Dockerfile":       "FROM scratch",
+		".dockerignore":    "# comment\n*.log\ncache\nDockerfile\n!keep.log",
+		"run.sh":           "#!/bin/sh",
+		"debug.log":        "log",
+		"keep.log":         "log",
+		"cache/data":       "data",
+		"src/main.go":      "package main",
+		"src/vendor/x.log": "log",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	require.Nil(t, os.Chmod(filepath.Join(tmpDir, "run.sh"), 0755))
+	require.Nil(t, os.Symlink("src/main.go", filepath.Join(tmpDir, "main.go")))
+
+	// Ownership can only be changed by root
+	uid, gid := os.Getuid(), os.Getgid()
+
+	if os.Chown(filepath.Join(tmpDir, "src/main.go"), 1234, 1234) == nil {
+		uid, gid = 1234, 1234
+	}
+
+	var buf bytes.Buffer
+
+	require.Nil(t, WriteBuildContext(&buf, tmpDir, ""))
+
+	entries := map[string]*tar.Header{}
+	tr := tar.NewReader(&buf)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		require.Nil(t, err)
+		entries[hdr.Name] = hdr
+	}
+
+	var names []string
+
+	for name := range entries {
+		names = append(names, name)
+	}
+
+	require.ElementsMatch(t, []string{
+		"Dockerfile", ".dockerignore", "run.sh", "keep.log",
+		"main.go", "src/", "src/main.go", "src/vendor/", "src/vendor/x.log",
+	}, names)
+
+	require.Equal(t, int64(0755), entries["run.sh"].Mode&0777)
+	require.Equal(t, byte(tar.TypeSymlink), entries["main.go"].Typeflag)
+	require.Equal(t, "src/main.go", entries["main.go"].Linkname)
+	require.Equal(t, uid, entries["src/main.go"].Uid)
+	require.Equal(t, gid, entries["src/main.go"].Gid)
+	require.Equal(t, int64(0), entries["src/main.go"].ModTime.Unix())
+}
+
+// Build context for a workspace of 2000 small and 10 large files
+func BenchmarkWriteBuildContext(b *testing.B) {
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-bench-"+NewId())
+	defer os.RemoveAll(tmpDir)
+
+	small := bytes.Repeat([]byte("x"), 16*1024)
+	large := bytes.Repeat([]byte("y"), 16*1024*1024)
+
+	for i := 0; i < 2000; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("d%d", i%20), fmt.Sprintf("f%d", i))
+
+		require.Nil(b, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(b, ioutil.WriteFile(path, small, 0644))
+	}
+
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("large%d", i))
+
+		require.Nil(b, ioutil.WriteFile(path, large, 0644))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		require.Nil(b, WriteBuildContext(ioutil.Discard, tmpDir, ""))
+	}
+}
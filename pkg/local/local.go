@@ -0,0 +1,141 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+// Package local runs xenvman server in the calling process,
+// so that environments can be created without a running
+// xenvman API server. Importing the package enables client.NewLocal
+package local
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/client"
+	"github.com/syhpoon/xenvman/pkg/config"
+	"github.com/syhpoon/xenvman/pkg/logger"
+	"github.com/syhpoon/xenvman/pkg/server"
+)
+
+var localLog = logger.GetLogger("xenvman.pkg.local")
+
+type Params struct {
+	// xenvman configuration file, if empty default values
+	// and XENVMAN_* environment variables are used
+	ConfigFile string
+}
+
+// In-process xenvman server. It does not listen on any address,
+// connections are only made in memory using Dial
+type Server struct {
+	listener   *pipeListener
+	cancel     func()
+	cengCancel func()
+	wg         *sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+// Start a server using the same configuration as `xenvman run`.
+// As the server is only reachable from the calling process, API auth,
+// TLS and proxy are not used, tunnels are always enabled.
+// Environments are not expired unless keep alive is set explicitly,
+// they are terminated when the server is closed
+func Start(params Params) (*Server, error) {
+	cfg, err := config.Load(params.ConfigFile)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error in configuration")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cengCtx, cengCancel := context.WithCancel(context.Background())
+
+	sp, err := server.ParamsFromConfig(ctx, cengCtx, cfg)
+
+	if err == nil && sp.ContEng == nil {
+		err = errors.New("Container engine is not available")
+	}
+
+	if err != nil {
+		cancel()
+		cengCancel()
+
+		return nil, errors.WithStack(err)
+	}
+
+	s := &Server{
+		listener:   newPipeListener(),
+		cancel:     cancel,
+		cengCancel: cengCancel,
+		wg:         &sync.WaitGroup{},
+	}
+
+	sp.Listener = s.listener
+	sp.AuthBackend = nil
+	sp.TLSCertFile = ""
+	sp.TLSKeyFile = ""
+	sp.ProxyDomain = ""
+	sp.Tunnel = true
+	sp.DefaultKeepalive = 0
+
+	s.wg.Add(1)
+
+	// Serve error is reported after shutdown as well, nobody waits for it
+	go server.New(sp).Run(s.wg, make(chan error, 1))
+
+	localLog.Infof("Local xenvman server started")
+
+	return s, nil
+}
+
+// Open an in-memory connection to the server
+func (s *Server) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return s.listener.dial(ctx)
+}
+
+// Terminate all the environments and stop the server
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.wg.Wait()
+		s.cengCancel()
+
+		localLog.Infof("Local xenvman server stopped")
+	})
+
+	return nil
+}
+
+func init() {
+	client.RegisterLocal(func(params client.LocalParams) (client.LocalServer, error) {
+		s, err := Start(Params{ConfigFile: params.ConfigFile})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	})
+}
@@ -0,0 +1,94 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package local
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/client"
+	"github.com/syhpoon/xenvman/pkg/config"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xenvman-local")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := filepath.Join(dir, "xenvman.toml")
+
+	require.Nil(t, ioutil.WriteFile(cfg, []byte(fmt.Sprintf(`
+[tpl]
+base_dir = "%[1]s/tpl"
+ws_dir = "%[1]s/ws"
+mount_dir = "%[1]s/mount"
+refresh_interval = "0s"
+`, dir)), 0644))
+
+	cl, err := client.NewLocal(client.LocalParams{ConfigFile: cfg})
+	require.Nil(t, err)
+
+	envs, err := cl.ListEnvs()
+	require.Nil(t, err)
+	require.Empty(t, envs)
+
+	for _, sub := range []string{"tpl", "ws", "mount"} {
+		_, err := os.Stat(filepath.Join(dir, sub))
+		require.Nil(t, err)
+	}
+
+	// Process-wide configuration is left intact
+	require.Equal(t, "", config.GetString("tpl.base_dir"))
+
+	require.Nil(t, cl.Close())
+	require.Nil(t, cl.Close())
+
+	_, err = cl.ListEnvs()
+	require.NotNil(t, err)
+
+	// Server is only reachable in memory
+	s, err := Start(Params{ConfigFile: cfg})
+	require.Nil(t, err)
+
+	conn, err := s.Dial(context.Background(), "tcp", "xenvman.local:80")
+	require.Nil(t, err)
+	require.Equal(t, "pipe", conn.RemoteAddr().Network())
+	_ = conn.Close()
+
+	require.Nil(t, s.Close())
+
+	_, err = s.Dial(context.Background(), "tcp", "xenvman.local:80")
+	require.NotNil(t, err)
+}
+
+func TestLocalInvalidConfig(t *testing.T) {
+	_, err := Start(Params{ConfigFile: "/nonexistent/xenvman.toml"})
+	require.NotNil(t, err)
+}
@@ -32,10 +32,61 @@ var (
 			Name: "xenvman_number_of_environments",
 			Help: "Number of active environments"},
 		[]string{})
+
+	ImageBuilds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "xenvman_image_builds_total",
+			Help: "Number of image builds by result"},
+		[]string{"result"})
+
+	ImageBuildDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "xenvman_image_build_duration_seconds",
+			Help:    "Image build duration",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12)},
+		[]string{})
+
+	ImagePulls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "xenvman_image_pulls_total",
+			Help: "Number of image pulls by result"},
+		[]string{"result"})
+
+	ImagePullDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "xenvman_image_pull_duration_seconds",
+			Help:    "Image pull duration",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12)},
+		[]string{})
+
+	PortsLeased = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "xenvman_ports_leased",
+			Help: "Number of host ports leased to environments"},
+		[]string{})
+
+	PortsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "xenvman_ports_total",
+			Help: "Total number of host ports in the range"},
+		[]string{})
+
+	PortConflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "xenvman_port_conflicts_total",
+			Help: "Number of container starts failed because of taken host ports"},
+		[]string{})
 )
 
 func init() {
 	prometheus.MustRegister(
 		NumberOfEnvironments,
+		ImageBuilds,
+		ImageBuildDuration,
+		ImagePulls,
+		ImagePullDuration,
+		PortsLeased,
+		PortsTotal,
+		PortConflicts,
 	)
 }
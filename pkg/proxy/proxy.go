@@ -0,0 +1,316 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/logger"
+)
+
+var proxyLog = logger.GetLogger("xenvman.pkg.proxy.proxy")
+
+// First byte of a TLS handshake record
+const tlsRecordHandshake = 0x16
+
+// Time allowed for a client to send TLS ClientHello or HTTP request
+// before it is handed over to API server
+const handshakeTimeout = 10 * time.Second
+
+// Resolves env container ports to addresses reachable from xenvman host
+type Resolver interface {
+	// Zero port means the default one
+	ResolvePort(envId, container string, port uint16) (string, error)
+}
+
+// Container port addressed by a proxy hostname
+type Target struct {
+	Env       string
+	Container string
+	Port      uint16
+}
+
+type Params struct {
+	// Env hostnames are subdomains of this one
+	Domain   string
+	Resolver Resolver
+	// Pass TLS connections through routed by SNI. Such connections
+	// are opaque and cannot be authenticated
+	PassTLS bool
+}
+
+// Proxy gives access to env containers through xenvman API listener:
+// HTTP requests are routed by Host header and TLS connections
+// are passed through as is, routed by SNI.
+type Proxy struct {
+	params Params
+}
+
+func New(params Params) *Proxy {
+	return &Proxy{
+		params: params,
+	}
+}
+
+// Check if a host is a proxy hostname, i.e. within proxy domain
+func (p *Proxy) Match(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	return strings.HasSuffix(host, "."+strings.ToLower(p.params.Domain))
+}
+
+// Parse a proxy hostname in the format: [<port>.]<container>.<env-id>.<domain>
+func ParseHost(host, domain string) (*Target, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	suffix := "." + strings.ToLower(domain)
+
+	if !strings.HasSuffix(host, suffix) {
+		return nil, errors.Errorf("Host %s is not within %s domain",
+			host, domain)
+	}
+
+	labels := strings.Split(strings.TrimSuffix(host, suffix), ".")
+	target := &Target{}
+
+	if len(labels) > 2 {
+		if port, err := strconv.ParseUint(labels[0], 10, 16); err == nil {
+			target.Port = uint16(port)
+			labels = labels[1:]
+		}
+	}
+
+	if len(labels) < 2 || hasEmpty(labels) {
+		return nil, errors.Errorf(
+			"Invalid host %s, expected [<port>.]<container>.<env-id>.%s",
+			host, domain)
+	}
+
+	target.Container = labels[0]
+	target.Env = strings.Join(labels[1:], ".")
+
+	return target, nil
+}
+
+func hasEmpty(labels []string) bool {
+	for _, l := range labels {
+		if l == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap API listener: TLS connections for proxy hostnames are passed
+// through if enabled, all the others are returned by Accept
+func (p *Proxy) Listener(l net.Listener) net.Listener {
+	ml := &muxListener{
+		Listener: l,
+		p:        p,
+		conns:    newConnListener(l.Addr()),
+	}
+
+	go ml.run()
+
+	return ml
+}
+
+type muxListener struct {
+	net.Listener
+	p     *Proxy
+	conns *connListener
+}
+
+func (l *muxListener) run() {
+	for {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+
+				continue
+			}
+
+			l.conns.closeWith(errors.WithStack(err))
+
+			return
+		}
+
+		go l.handleConn(conn)
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	return l.conns.Accept()
+}
+
+func (l *muxListener) Close() error {
+	_ = l.conns.Close()
+
+	return l.Listener.Close()
+}
+
+// Tell TLS from plain HTTP by the first byte
+func (l *muxListener) handleConn(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+
+	br := bufio.NewReader(conn)
+	b, err := br.Peek(1)
+
+	if err != nil {
+		_ = conn.Close()
+
+		return
+	}
+
+	if b[0] != tlsRecordHandshake || !l.p.params.PassTLS {
+		// HTTP server sets its own deadlines
+		_ = conn.SetReadDeadline(time.Time{})
+		l.conns.push(&peekedConn{Conn: conn, r: br})
+
+		return
+	}
+
+	serverName, hello, err := readServerName(br)
+
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// Replay consumed ClientHello
+	pconn := &peekedConn{
+		Conn: conn,
+		r:    io.MultiReader(bytes.NewReader(hello), br),
+	}
+
+	if err != nil || !l.p.Match(serverName) {
+		l.conns.push(pconn)
+
+		return
+	}
+
+	if err := l.p.passTLS(pconn, serverName); err != nil {
+		proxyLog.Debugf("Error proxying TLS connection from %s: %s",
+			conn.RemoteAddr(), err)
+	}
+}
+
+func (p *Proxy) passTLS(conn net.Conn, serverName string) error {
+	defer conn.Close()
+
+	target, err := ParseHost(serverName, p.params.Domain)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	addr, err := p.params.Resolver.ResolvePort(target.Env, target.Container,
+		target.Port)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	upstream, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error connecting to %s", addr)
+	}
+
+	Pipe(conn, upstream)
+
+	return nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	target, err := ParseHost(req.Host, p.params.Domain)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	addr, err := p.params.Resolver.ResolvePort(target.Env, target.Container,
+		target.Port)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = addr
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyLog.Debugf("Error proxying request to %s: %s", addr, err)
+
+			http.Error(w, fmt.Sprintf("Error connecting to %s", addr),
+				http.StatusBadGateway)
+		},
+	}
+
+	rp.ServeHTTP(w, req)
+}
+
+// Copy data both ways until either side is done, closes both
+func Pipe(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+
+	cp := func(dst io.Writer, src io.Reader) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+
+	<-done
+
+	_ = a.Close()
+	_ = b.Close()
+
+	<-done
+}
@@ -0,0 +1,250 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testResolver map[string]string
+
+func (r testResolver) ResolvePort(envId, container string,
+	port uint16) (string, error) {
+
+	addr, ok := r[fmt.Sprintf("%s/%s/%d", envId, container, port)]
+
+	if !ok {
+		return "", errors.Errorf("Not found")
+	}
+
+	return addr, nil
+}
+
+func TestParseHost(t *testing.T) {
+	cases := map[string]*Target{
+		"web.env1.localhost":           {Env: "env1", Container: "web"},
+		"Web.Env1.localhost:9876":      {Env: "env1", Container: "web"},
+		"8080.web.env1.localhost":      {Env: "env1", Container: "web", Port: 8080},
+		"web.my.env.xenv.example.com.": {Env: "my.env", Container: "web"},
+		"443.db.test-1-ab.xenv.example.com": {
+			Env: "test-1-ab", Container: "db", Port: 443},
+	}
+
+	for host, target := range cases {
+		domain := "localhost"
+
+		if strings.Contains(host, "example.com") {
+			domain = "xenv.example.com"
+		}
+
+		res, err := ParseHost(host, domain)
+
+		require.Nil(t, err, host)
+		require.Equal(t, target, res, host)
+	}
+
+	for _, host := range []string{
+		"localhost", "env1.localhost", "web.env1.example.com",
+		".env1.localhost", "web..localhost",
+	} {
+		_, err := ParseHost(host, "localhost")
+		require.NotNil(t, err, host)
+	}
+}
+
+// Run an API server with proxy, non-proxy requests are answered with "api"
+func runProxy(t *testing.T, resolver Resolver, passTLS bool) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	p := New(Params{
+		Domain:   "localhost",
+		Resolver: resolver,
+		PassTLS:  passTLS,
+	})
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, req *http.Request) {
+				if p.Match(req.Host) {
+					p.ServeHTTP(w, req)
+				} else {
+					_, _ = fmt.Fprintf(w, "api")
+				}
+			}),
+	}
+
+	go func() {
+		_ = srv.Serve(p.Listener(l))
+	}()
+
+	return l.Addr().String(), func() {
+		_ = srv.Close()
+	}
+}
+
+func TestProxyHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s %s", req.Host, req.URL.Path)
+		}))
+	defer backend.Close()
+
+	addr, stop := runProxy(t, testResolver{
+		"env1/web/0": backend.Listener.Addr().String(),
+	}, true)
+	defer stop()
+
+	get := func(host string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet,
+			fmt.Sprintf("http://%s/path", addr), nil)
+		require.Nil(t, err)
+
+		req.Host = host
+
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("web.env1.localhost")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "web.env1.localhost /path", body)
+
+	code, _ = get("db.env1.localhost")
+	require.Equal(t, http.StatusNotFound, code)
+
+	code, _ = get("env1.localhost")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body = get("example.com")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "api", body)
+}
+
+func TestProxyTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s", req.TLS.ServerName)
+		}))
+	defer backend.Close()
+
+	resolver := testResolver{
+		"env1/web/443": backend.Listener.Addr().String(),
+	}
+
+	tlsClient := func(addr string) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				DialContext: func(ctx context.Context, network,
+					_ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				},
+			},
+		}
+	}
+
+	addr, stop := runProxy(t, resolver, true)
+	defer stop()
+
+	client := tlsClient(addr)
+
+	resp, err := client.Get("https://443.web.env1.localhost/")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	// TLS is terminated by the backend itself
+	require.Equal(t, "443.web.env1.localhost", string(body))
+
+	// Unknown server name
+	_, err = client.Get("https://db.env1.localhost/")
+	require.NotNil(t, err)
+
+	// Plain HTTP still reaches API server
+	resp, err = http.Get("http://" + addr + "/")
+	require.Nil(t, err)
+
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, "api", string(body))
+
+	// Pass through disabled, connection is handed over to API server
+	addr, stop2 := runProxy(t, resolver, false)
+	defer stop2()
+
+	_, err = tlsClient(addr).Get("https://443.web.env1.localhost/")
+	require.NotNil(t, err)
+}
+
+func TestProxyListenerReplay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	p := New(Params{Domain: "localhost", PassTLS: true})
+	ml := p.Listener(l)
+	defer ml.Close()
+
+	// Non-proxy TLS connection is handed over with ClientHello intact
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintf(w, "api %s", req.TLS.ServerName)
+		}))
+	srv.Listener = ml
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.ServerName = "example.com"
+	client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+
+	resp, err := client.Get(srv.URL)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "api example.com", string(body))
+}
@@ -0,0 +1,79 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Provider looks up secret values on xenvman host
+type Provider interface {
+	// Return a secret value and whether it has been found
+	Get(name string) (string, bool, error)
+}
+
+// Read secrets from environment variables: <prefix><NAME>
+type EnvProvider struct {
+	Prefix string
+}
+
+func (p *EnvProvider) Get(name string) (string, bool, error) {
+	key := p.Prefix + strings.ToUpper(
+		strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(name))
+
+	v, ok := os.LookupEnv(key)
+
+	return v, ok, nil
+}
+
+// Read secrets from files: <dir>/<name>
+type FileProvider struct {
+	Dir string
+}
+
+func (p *FileProvider) Get(name string) (string, bool, error) {
+	path := filepath.Clean(filepath.Join(p.Dir, name))
+
+	// Names are relative to the secrets dir and cannot escape it
+	if filepath.IsAbs(name) ||
+		!strings.HasPrefix(path, filepath.Clean(p.Dir)+string(filepath.Separator)) {
+		return "", false, errors.Errorf("Invalid secret name: %s", name)
+	}
+
+	b, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, errors.Wrapf(err, "Error reading secret %s", name)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
@@ -0,0 +1,120 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvProvider(t *testing.T) {
+	require.Nil(t, os.Setenv("XENVMAN_TEST_DB_PASSWORD", "pass"))
+	require.Nil(t, os.Setenv("DB_PASSWORD", "unprefixed"))
+
+	defer os.Unsetenv("XENVMAN_TEST_DB_PASSWORD")
+	defer os.Unsetenv("DB_PASSWORD")
+
+	p := &EnvProvider{Prefix: "XENVMAN_TEST_"}
+
+	cases := []struct {
+		name  string
+		value string
+		found bool
+	}{
+		{"db_password", "pass", true},
+		{"db-password", "pass", true},
+		{"db.password", "pass", true},
+		{"db/password", "pass", true},
+		{"DB_PASSWORD", "pass", true},
+		// Prefix is always prepended
+		{"XENVMAN_TEST_DB_PASSWORD", "", false},
+		{"password", "", false},
+	}
+
+	for _, c := range cases {
+		v, found, err := p.Get(c.name)
+		require.Nil(t, err, c.name)
+		require.Equal(t, c.found, found, c.name)
+		require.Equal(t, c.value, v, c.name)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "xenvman-secret-")
+	require.Nil(t, err)
+	defer os.RemoveAll(baseDir)
+
+	dir := filepath.Join(baseDir, "secrets")
+
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "db"), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "token"),
+		[]byte("secret\n"), 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "db", "password"),
+		[]byte("pass"), 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(baseDir, "outside"),
+		[]byte("outside"), 0600))
+
+	p := &FileProvider{Dir: dir}
+
+	cases := []struct {
+		name    string
+		value   string
+		found   bool
+		invalid bool
+	}{
+		{name: "token", value: "secret", found: true},
+		{name: "db/password", value: "pass", found: true},
+		{name: "db/../token", value: "secret", found: true},
+		{name: "missing"},
+		{name: "../outside", invalid: true},
+		{name: "db/../../outside", invalid: true},
+		{name: "../secrets/token", found: true, value: "secret"},
+		{name: filepath.Join(dir, "token"), invalid: true},
+		{name: "/etc/passwd", invalid: true},
+		{name: "", invalid: true},
+		{name: ".", invalid: true},
+		{name: "..", invalid: true},
+	}
+
+	for _, c := range cases {
+		v, found, err := p.Get(c.name)
+
+		if c.invalid {
+			require.NotNil(t, err, c.name)
+			require.Contains(t, err.Error(), "Invalid secret name", c.name)
+			require.False(t, found, c.name)
+
+			continue
+		}
+
+		require.Nil(t, err, c.name)
+		require.Equal(t, c.found, found, c.name)
+		require.Equal(t, c.value, v, c.name)
+	}
+}
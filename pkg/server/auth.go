@@ -33,8 +33,17 @@ import (
 
 var authLog = logger.GetLogger("xenvman.pkg.server.auth")
 
+// Permission to upload and delete templates
+const PermTplWrite = "tpl_write"
+
+// Permission to attach envs to external networks and link them
+// with other envs
+const PermEnvLink = "env_link"
+
 type AuthBackend interface {
 	Authenticate(req *http.Request) error
+	// Check if an authenticated request is granted a permission
+	Authorize(req *http.Request, perm string) error
 	fmt.Stringer
 }
 
@@ -45,7 +54,7 @@ func apiAuthMiddleware(h http.HandlerFunc, auth AuthBackend) http.HandlerFunc {
 
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 
-			ApiSendMessage(w, http.StatusUnauthorized, err.Error())
+			ApiSendMessage(w, http.StatusUnauthorized, "%s", err)
 
 			return
 		}
@@ -53,3 +62,19 @@ func apiAuthMiddleware(h http.HandlerFunc, auth AuthBackend) http.HandlerFunc {
 		h.ServeHTTP(w, r)
 	}
 }
+
+func apiPermMiddleware(h http.HandlerFunc, auth AuthBackend,
+	perm string) http.HandlerFunc {
+
+	return apiAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if err := auth.Authorize(r, perm); err != nil {
+			authLog.Warningf("Permission %s denied: %+v", perm, err)
+
+			ApiSendMessage(w, http.StatusForbidden, "%s", err)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}, auth)
+}
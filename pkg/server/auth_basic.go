@@ -33,6 +33,8 @@ import (
 type AuthBackendBasic struct {
 	// Map from username to password
 	Credentials map[string]string
+	// Map from permission to usernames granted it
+	Permissions map[string][]string
 }
 
 func (auth *AuthBackendBasic) Authenticate(req *http.Request) error {
@@ -47,6 +49,18 @@ func (auth *AuthBackendBasic) Authenticate(req *http.Request) error {
 	return nil
 }
 
+func (auth *AuthBackendBasic) Authorize(req *http.Request, perm string) error {
+	user, _, _ := req.BasicAuth()
+
+	for _, u := range auth.Permissions[perm] {
+		if u == user {
+			return nil
+		}
+	}
+
+	return errors.Errorf("Permission denied: %s", perm)
+}
+
 func (auth *AuthBackendBasic) String() string {
 	return "basic"
 }
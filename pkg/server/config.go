@@ -0,0 +1,339 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/config"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+	"github.com/syhpoon/xenvman/pkg/env"
+	"github.com/syhpoon/xenvman/pkg/lib"
+	"github.com/syhpoon/xenvman/pkg/secret"
+	"github.com/syhpoon/xenvman/pkg/tpl"
+)
+
+// Build server parameters from a configuration, Listener is left unset.
+// ctx controls template sources refreshing, cengCtx is passed
+// to container engine operations.
+// Failure to build container engine is only logged,
+// templates can still be managed without one.
+func ParamsFromConfig(ctx, cengCtx context.Context,
+	cfg *config.Config) (Params, error) {
+
+	params := DefaultParams(ctx)
+
+	if contEng, err := buildContEng(cfg); err != nil {
+		serverLog.Errorf("Error building container engine: %s", err)
+	} else {
+		params.ContEng = contEng
+
+		if cfg.GetBool("build_cache.enabled") {
+			params.BuildCache = env.NewBuildCache(contEng,
+				env.BuildCacheParams{
+					MaxImages: cfg.GetInt("build_cache.max_images"),
+					MaxSize:   int64(cfg.GetUint64("build_cache.max_size")),
+				})
+		}
+	}
+
+	params.ExportAddress = cfg.GetString("export_address")
+	params.BaseTplDir = cfg.GetString("tpl.base_dir")
+	params.BaseWsDir = cfg.GetString("tpl.ws_dir")
+	params.BaseMountDir = cfg.GetString("tpl.mount_dir")
+	params.TLSCertFile = cfg.GetString("tls.cert")
+	params.TLSKeyFile = cfg.GetString("tls.key")
+	params.DefaultKeepalive = cfg.GetDuration("keepalive")
+	params.RecursionLimit = cfg.GetInt("tpl.recursion_limit")
+	params.InlineTpl = cfg.GetBool("tpl.inline")
+	params.TplLimits = tpl.Limits{
+		Timeout:       cfg.GetDuration("tpl.timeout"),
+		MaxImages:     cfg.GetInt("tpl.max_images"),
+		MaxContainers: cfg.GetInt("tpl.max_containers"),
+		MaxWriteBytes: int64(cfg.GetUint64("tpl.max_write_bytes")),
+	}
+	params.CengCtx = cengCtx
+
+	var err error
+
+	if params.ImagePull, err = parseImagePull(cfg); err != nil {
+		return params, errors.Wrapf(err, "Error parsing image pull settings")
+	}
+
+	serverLog.Infof("Base directory: %s", params.BaseTplDir)
+
+	for _, dir := range []string{
+		params.BaseTplDir,
+		params.BaseWsDir,
+		params.BaseMountDir} {
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return params, errors.Wrapf(err, "Error making dir %s", dir)
+		}
+	}
+
+	// Template sources
+	sources, err := parseTplSources(cfg, params.BaseTplDir)
+
+	if err != nil {
+		return params, errors.Wrapf(err, "Error parsing template sources")
+	}
+
+	for _, st := range sources.Refresh(ctx) {
+		if st.Error == "" {
+			serverLog.Infof("Template source %q (%s) loaded, revision: %s",
+				st.Namespace, st.Type, st.Revision)
+		}
+	}
+
+	if interval := cfg.GetDuration("tpl.refresh_interval"); interval > 0 {
+		go sources.RunRefresher(ctx, interval)
+	}
+
+	params.TplSources = sources
+	params.TplRegistry = tpl.NewRegistry(sources, params.TplLimits.Timeout)
+
+	if err := params.TplRegistry.Watch(ctx, params.BaseTplDir); err != nil {
+		serverLog.Warningf("Error watching base template dir, "+
+			"templates will be rescanned on every request: %s", err)
+	}
+
+	// Ports
+	if params.PortRange, err = parsePorts(cfg); err != nil {
+		return params, errors.Wrapf(err, "Error parsing ports")
+	}
+
+	params.PortsHostIP = cfg.GetString("ports_host_ip")
+
+	if params.PortsHostIP != "" && net.ParseIP(params.PortsHostIP) == nil {
+		return params, errors.Errorf("Invalid ports host IP: %s",
+			params.PortsHostIP)
+	}
+
+	// Auth backend
+	authb, err := parseAuthBackend(cfg)
+
+	if err != nil {
+		return params, errors.Wrapf(err, "Error parsing auth backend")
+	}
+
+	if authb == nil {
+		serverLog.Infof("Not using any auth backend")
+	} else {
+		serverLog.Infof("Using auth backend: %s", authb.String())
+	}
+
+	params.AuthBackend = authb
+
+	// Secrets provider
+	sprov, err := parseSecretProvider(cfg)
+
+	if err != nil {
+		return params, errors.Wrapf(err, "Error parsing secrets provider")
+	} else if sprov != nil {
+		serverLog.Infof("Using secrets provider: %s",
+			cfg.GetString("secrets.provider"))
+
+		params.SecretProvider = sprov
+	}
+
+	params.Tunnel = cfg.GetBool("proxy.enabled")
+
+	if params.Tunnel {
+		params.ProxyDomain = cfg.GetString("proxy.domain")
+	}
+
+	return params, nil
+}
+
+func buildContEng(cfg *config.Config) (conteng.ContainerEngine, error) {
+	ceng := cfg.GetString("container_engine")
+
+	switch ceng {
+	case "docker":
+		serverLog.Infof("Using Docker container engine")
+
+		creds, err := parseRegistryCredentials(cfg)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		var pools []lib.SubnetPool
+
+		if err := cfg.UnmarshalKey("network.subnet_pools", &pools); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		params := conteng.DockerEngineParams{
+			Credentials: creds,
+			SubnetPools: pools,
+		}
+
+		return conteng.NewDockerEngine(params)
+	default:
+		return nil, fmt.Errorf("Unknown container engine type: %s", ceng)
+	}
+}
+
+func parseAuthBackend(cfg *config.Config) (AuthBackend, error) {
+	b := cfg.GetString("api_auth")
+
+	switch b {
+	case "":
+		return nil, nil
+	case "basic":
+		return parseAuthBackendBasic(cfg)
+	default:
+		return nil, errors.Errorf("Unknown auth backend type: %s", b)
+	}
+}
+
+func parseAuthBackendBasic(cfg *config.Config) (AuthBackend, error) {
+	creds := cfg.GetStringMapString("auth_basic")
+
+	if len(creds) == 0 {
+		return nil, nil
+	}
+
+	return &AuthBackendBasic{
+		Credentials: creds,
+		Permissions: cfg.GetStringMapStringSlice("auth_permissions"),
+	}, nil
+}
+
+func parseSecretProvider(cfg *config.Config) (secret.Provider, error) {
+	p := cfg.GetString("secrets.provider")
+
+	switch p {
+	case "":
+		return nil, nil
+	case "env":
+		return &secret.EnvProvider{
+			Prefix: cfg.GetString("secrets.env_prefix"),
+		}, nil
+	case "file":
+		dir := cfg.GetString("secrets.dir")
+
+		if dir == "" {
+			return nil, errors.Errorf("secrets.dir must be set for file provider")
+		}
+
+		return &secret.FileProvider{Dir: dir}, nil
+	default:
+		return nil, errors.Errorf("Unknown secrets provider type: %s", p)
+	}
+}
+
+// Base template dir is always available without a namespace
+func parseTplSources(cfg *config.Config, baseDir string) (*tpl.Sources, error) {
+	var cfgs []tpl.SourceConfig
+
+	if err := cfg.UnmarshalKey("tpl.sources", &cfgs); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	cacheDir := cfg.GetString("tpl.sources_dir")
+	srcs := []tpl.Source{tpl.NewLocalSource("", baseDir)}
+
+	for _, cfg := range cfgs {
+		src, err := tpl.NewSource(cfg, cacheDir)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		srcs = append(srcs, src)
+	}
+
+	return tpl.NewSources(srcs...)
+}
+
+// Explicitly configured credentials take precedence over docker config
+func parseRegistryCredentials(cfg *config.Config) (conteng.CredentialsSource, error) {
+	var static conteng.StaticCredentials
+
+	if err := cfg.UnmarshalKey("images.credentials", &static); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return conteng.CredentialsChain{
+		static,
+		&conteng.DockerConfigCredentials{
+			Path: cfg.GetString("images.docker_config"),
+		},
+	}, nil
+}
+
+func parseImagePull(cfg *config.Config) (env.PullParams, error) {
+	var mirrors conteng.RegistryMirrors
+
+	if err := cfg.UnmarshalKey("images.mirrors", &mirrors); err != nil {
+		return env.PullParams{}, errors.WithStack(err)
+	}
+
+	policy, err := conteng.ParsePullPolicy(cfg.GetString("images.pull_policy"))
+
+	if err != nil {
+		return env.PullParams{}, errors.WithStack(err)
+	}
+
+	return env.PullParams{
+		Policy:  policy,
+		Mirrors: mirrors,
+		Offline: cfg.GetBool("images.offline"),
+	}, nil
+}
+
+func parsePorts(cfg *config.Config) (*lib.PortRange, error) {
+	ports := cfg.GetStrings("ports_range")
+
+	if len(ports) != 2 {
+		return nil, fmt.Errorf("Expected two ports for a range, got %d", len(ports))
+	}
+
+	pMin, err := strconv.ParseUint(ports[0], 0, 16)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing port %s: %s", ports[0], err)
+	}
+
+	pMax, err := strconv.ParseUint(ports[1], 0, 16)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing port %s: %s", ports[1], err)
+	}
+
+	if pMax < pMin {
+		return nil, fmt.Errorf("Port %d should be greater than %d", pMax, pMin)
+	}
+
+	return lib.NewPortRange(uint16(pMin), uint16(pMax)), nil
+}
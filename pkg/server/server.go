@@ -27,8 +27,13 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -45,25 +50,41 @@ import (
 	"github.com/syhpoon/xenvman/pkg/env"
 	"github.com/syhpoon/xenvman/pkg/lib"
 	"github.com/syhpoon/xenvman/pkg/logger"
+	"github.com/syhpoon/xenvman/pkg/proxy"
+	"github.com/syhpoon/xenvman/pkg/secret"
 	"github.com/syhpoon/xenvman/pkg/tpl"
+	"golang.org/x/net/websocket"
 )
 
 var serverLog = logger.GetLogger("xenvman.pkg.server.server")
 
+const maxTplUploadSize = 64 << 20
+
 type Params struct {
-	Listener         net.Listener
-	WriteTimeout     time.Duration
-	ReadTimeout      time.Duration
-	ContEng          conteng.ContainerEngine
-	PortRange        *lib.PortRange
-	BaseTplDir       string
-	BaseWsDir        string
-	BaseMountDir     string
-	ExportAddress    string
-	TLSCertFile      string
-	TLSKeyFile       string
-	AuthBackend      AuthBackend
-	RecursionLimit   int
+	Listener       net.Listener
+	WriteTimeout   time.Duration
+	ReadTimeout    time.Duration
+	ContEng        conteng.ContainerEngine
+	PortRange      *lib.PortRange
+	PortsHostIP    string
+	BaseTplDir     string
+	TplSources     *tpl.Sources
+	TplRegistry    *tpl.Registry
+	BaseWsDir      string
+	BaseMountDir   string
+	ExportAddress  string
+	TLSCertFile    string
+	TLSKeyFile     string
+	AuthBackend    AuthBackend
+	RecursionLimit int
+	SecretProvider secret.Provider
+	InlineTpl      bool
+	Tunnel         bool
+	// Proxy hostnames domain, proxy is disabled if empty
+	ProxyDomain      string
+	TplLimits        tpl.Limits
+	BuildCache       *env.BuildCache
+	ImagePull        env.PullParams
 	Ctx              context.Context
 	CengCtx          context.Context
 	DefaultKeepalive time.Duration
@@ -81,14 +102,28 @@ type Server struct {
 	router *mux.Router
 	server http.Server
 	params Params
+	proxy  *proxy.Proxy
 	envs   map[string]*env.Env
+	// Envs being created, waited for upon shutdown
+	creating sync.WaitGroup
+	closing  bool
 	sync.RWMutex
 }
 
 func New(params Params) *Server {
 	router := mux.NewRouter()
 
-	return &Server{
+	if params.TplSources == nil {
+		params.TplSources, _ = tpl.NewSources(
+			tpl.NewLocalSource("", params.BaseTplDir))
+	}
+
+	if params.TplRegistry == nil {
+		params.TplRegistry = tpl.NewRegistry(params.TplSources,
+			params.TplLimits.Timeout)
+	}
+
+	s := &Server{
 		router: router,
 		server: http.Server{
 			Handler:      router,
@@ -98,6 +133,17 @@ func New(params Params) *Server {
 		params: params,
 		envs:   map[string]*env.Env{},
 	}
+
+	if params.ProxyDomain != "" {
+		s.proxy = proxy.New(proxy.Params{
+			Domain:   params.ProxyDomain,
+			Resolver: s,
+			// Passed through connections cannot be authenticated
+			PassTLS: params.AuthBackend == nil,
+		})
+	}
+
+	return s
 }
 
 func (s *Server) Run(wg *sync.WaitGroup, errch chan<- error) {
@@ -112,6 +158,13 @@ func (s *Server) Run(wg *sync.WaitGroup, errch chan<- error) {
 		<-ctx.Done()
 		_ = s.server.Shutdown(ctx)
 
+		s.Lock()
+		s.closing = true
+		s.Unlock()
+
+		// Envs which are still being created are terminated as well
+		s.creating.Wait()
+
 		s.Lock()
 
 		for _, e := range s.envs {
@@ -133,13 +186,21 @@ func (s *Server) Run(wg *sync.WaitGroup, errch chan<- error) {
 	serverLog.Infof("Starting xenvman server%s at %s",
 		mode, s.params.Listener.Addr().String())
 
+	listener := s.params.Listener
+
+	if s.proxy != nil {
+		serverLog.Infof("Proxying *.%s hostnames", s.params.ProxyDomain)
+
+		listener = s.proxy.Listener(listener)
+	}
+
 	var err error
 
 	if useTls {
-		err = s.server.ServeTLS(s.params.Listener,
+		err = s.server.ServeTLS(listener,
 			s.params.TLSCertFile, s.params.TLSKeyFile)
 	} else {
-		err = s.server.Serve(s.params.Listener)
+		err = s.server.Serve(listener)
 	}
 
 	if err != nil {
@@ -156,6 +217,22 @@ func (s *Server) setupHandlers() {
 		}
 	}
 
+	// Handlers which require extra permission
+	pf := func(h http.HandlerFunc, perm string) http.HandlerFunc {
+		if s.params.AuthBackend != nil {
+			return apiPermMiddleware(h, s.params.AuthBackend, perm)
+		} else {
+			return h
+		}
+	}
+
+	// Requests to proxy hostnames
+	if s.proxy != nil {
+		s.router.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			return s.proxy.Match(req.Host)
+		}).Handler(hf(s.proxyHandler))
+	}
+
 	// GET /api/v1/env - List environments
 	s.router.HandleFunc("/api/v1/env", hf(s.listEnvsHandler)).
 		Methods(http.MethodGet)
@@ -180,10 +257,53 @@ func (s *Server) setupHandlers() {
 	s.router.HandleFunc("/api/v1/env/{id}/keepalive",
 		hf(s.keepaliveEnvHandler)).Methods(http.MethodPost)
 
+	// GET /api/v1/env/{id}/ca - Get environment CA certificate
+	s.router.HandleFunc("/api/v1/env/{id}/ca",
+		hf(s.getEnvCAHandler)).Methods(http.MethodGet)
+
+	// GET /api/v1/env/{id}/events - Get environment events
+	s.router.HandleFunc("/api/v1/env/{id}/events",
+		hf(s.getEnvEventsHandler)).Methods(http.MethodGet)
+
+	// GET /api/v1/env/{id}/logs/{container} - Get container logs
+	s.router.HandleFunc("/api/v1/env/{id}/logs/{container}",
+		hf(s.getEnvLogsHandler)).Methods(http.MethodGet)
+
+	// GET /api/v1/env/{id}/tunnel/{container}/{port} - Tunnel to a container port
+	// HEAD /api/v1/env/{id}/tunnel/{container}/{port} - Check tunnel target
+	if s.params.Tunnel {
+		s.router.HandleFunc("/api/v1/env/{id}/tunnel/{container}/{port:[0-9]+}",
+			hf(s.tunnelEnvHandler)).Methods(http.MethodGet, http.MethodHead)
+	}
+
 	// GET /api/v1/tpl - List templates
 	s.router.HandleFunc("/api/v1/tpl",
 		hf(s.listTplsHandler)).Methods(http.MethodGet)
 
+	// GET /api/v1/tpl/validate - Validate templates
+	s.router.HandleFunc("/api/v1/tpl/validate",
+		hf(s.validateTplsHandler)).Methods(http.MethodGet)
+
+	// POST /api/v1/tpl/refresh - Refresh template sources
+	s.router.HandleFunc("/api/v1/tpl/refresh",
+		hf(s.refreshTplsHandler)).Methods(http.MethodPost)
+
+	// GET /api/v1/tpl/{name}/info - Get template info
+	s.router.HandleFunc("/api/v1/tpl/{name:.+}/info",
+		hf(s.getTplInfoHandler)).Methods(http.MethodGet)
+
+	// GET /api/v1/tpl/{name} - Download a template
+	s.router.HandleFunc("/api/v1/tpl/{name:.+}",
+		hf(s.getTplHandler)).Methods(http.MethodGet)
+
+	// PUT /api/v1/tpl/{name} - Upload a template
+	s.router.HandleFunc("/api/v1/tpl/{name:.+}",
+		pf(s.putTplHandler, PermTplWrite)).Methods(http.MethodPut)
+
+	// DELETE /api/v1/tpl/{name} - Delete a template
+	s.router.HandleFunc("/api/v1/tpl/{name:.+}",
+		pf(s.deleteTplHandler, PermTplWrite)).Methods(http.MethodDelete)
+
 	// Prometheus metrics
 	s.router.Handle("/metrics", promhttp.Handler())
 
@@ -229,16 +349,53 @@ func (s *Server) createEnvHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if edef.Options != nil && len(edef.Options.ExternalNetworks) > 0 &&
+		s.params.AuthBackend != nil {
+
+		if err := s.params.AuthBackend.Authorize(req, PermEnvLink); err != nil {
+			authLog.Warningf("Permission %s denied: %+v", PermEnvLink, err)
+
+			ApiSendMessage(w, http.StatusForbidden, "%s", err)
+
+			return
+		}
+	}
+
+	s.Lock()
+
+	if s.closing {
+		s.Unlock()
+
+		ApiSendMessage(w, http.StatusServiceUnavailable,
+			"Server is shutting down")
+
+		return
+	}
+
+	s.creating.Add(1)
+	s.Unlock()
+
+	defer s.creating.Done()
+
 	e, err := env.NewEnv(env.Params{
 		EnvDef:           &edef,
 		ContEng:          s.params.ContEng,
 		PortRange:        s.params.PortRange,
+		PortsHostIP:      s.params.PortsHostIP,
 		BaseTplDir:       s.params.BaseTplDir,
+		TplSources:       s.params.TplSources,
+		TplRegistry:      s.params.TplRegistry,
 		BaseWsDir:        s.params.BaseWsDir,
 		BaseMountDir:     s.params.BaseMountDir,
 		ExportAddress:    s.params.ExportAddress,
 		DefaultKeepAlive: def.Duration(s.params.DefaultKeepalive),
 		RecursionLimit:   s.params.RecursionLimit,
+		SecretProvider:   s.params.SecretProvider,
+		InlineTpl:        s.params.InlineTpl,
+		TplLimits:        s.params.TplLimits,
+		BuildCache:       s.params.BuildCache,
+		Pull:             s.params.ImagePull,
+		LookupEnv:        s.lookupEnv,
 		Ctx:              s.params.CengCtx,
 	})
 
@@ -257,6 +414,13 @@ func (s *Server) createEnvHandler(w http.ResponseWriter, req *http.Request) {
 	ApiSendData(w, http.StatusOK, e.Export())
 }
 
+func (s *Server) lookupEnv(id string) *env.Env {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.envs[id]
+}
+
 func (s *Server) deleteEnvHandler(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
@@ -445,14 +609,231 @@ func (s *Server) keepaliveEnvHandler(w http.ResponseWriter, req *http.Request) {
 	ApiSendMessage(w, http.StatusOK, "")
 }
 
+func (s *Server) getEnvCAHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	s.RLock()
+	e, ok := s.envs[id]
+	s.RUnlock()
+
+	if !ok {
+		serverLog.Errorf("Env not found: %s", id)
+
+		ApiSendMessage(w, http.StatusNotFound, "Env not found")
+
+		return
+	}
+
+	cert := e.CACert()
+
+	if cert == nil {
+		ApiSendMessage(w, http.StatusNotFound,
+			"No certificates have been issued for env")
+
+		return
+	}
+
+	hdrs := http.Header{}
+	hdrs.Set("Content-Type", "application/x-pem-file")
+
+	_ = SendHttpResponse(w, http.StatusOK, hdrs, cert)
+}
+
+func (s *Server) getEnvEventsHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	s.RLock()
+	e, ok := s.envs[id]
+	s.RUnlock()
+
+	if !ok {
+		serverLog.Errorf("Env not found: %s", id)
+
+		ApiSendMessage(w, http.StatusNotFound, "Env not found")
+
+		return
+	}
+
+	ApiSendData(w, http.StatusOK, e.Events())
+}
+
+// Query: tail - number of last lines, timestamps - prefix lines with time
+func (s *Server) getEnvLogsHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+	cid := vars["container"]
+
+	s.RLock()
+	e, ok := s.envs[id]
+	s.RUnlock()
+
+	if !ok {
+		serverLog.Errorf("Env not found: %s", id)
+
+		ApiSendMessage(w, http.StatusNotFound, "Env not found")
+
+		return
+	}
+
+	params := conteng.ContainerLogsParams{}
+	query := req.URL.Query()
+
+	if tail := query.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+
+		if err != nil || n < 0 {
+			ApiSendMessage(w, http.StatusBadRequest, "Invalid tail: %s", tail)
+
+			return
+		}
+
+		params.Tail = n
+	}
+
+	if ts := query.Get("timestamps"); ts != "" {
+		b, err := strconv.ParseBool(ts)
+
+		if err != nil {
+			ApiSendMessage(w, http.StatusBadRequest,
+				"Invalid timestamps: %s", ts)
+
+			return
+		}
+
+		params.Timestamps = b
+	}
+
+	logs, err := e.ContainerLogs(req.Context(), cid, params)
+
+	if err == env.ErrContainerNotFound {
+		ApiSendMessage(w, http.StatusNotFound, "Container not found")
+
+		return
+	} else if err != nil {
+		serverLog.Errorf("Error fetching logs of %s: %+v", cid, err)
+
+		ApiSendMessage(w, http.StatusInternalServerError,
+			"Error fetching logs: %s", err)
+
+		return
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, logs); err != nil {
+		serverLog.Warningf("Error sending logs of %s: %s", cid, err)
+	}
+}
+
+// Forward a websocket connection to a container port
+func (s *Server) tunnelEnvHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+	port, _ := strconv.ParseUint(vars["port"], 10, 16)
+
+	addr, err := s.ResolvePort(id, vars["container"], uint16(port))
+
+	if err != nil {
+		serverLog.Errorf("Error resolving tunnel target: %s", err)
+
+		ApiSendMessage(w, http.StatusNotFound, "%s", err)
+
+		return
+	}
+
+	if req.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	wss := websocket.Server{
+		// Clients are not browsers, so there's no origin to check
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+
+			// Tunnels are long lived unlike API requests
+			_ = ws.SetDeadline(time.Time{})
+
+			upstream, err := net.Dial("tcp", addr)
+
+			if err != nil {
+				serverLog.Errorf("[%s] Error connecting to %s: %s", id, addr, err)
+
+				_ = ws.Close()
+
+				return
+			}
+
+			serverLog.Debugf("[%s] Tunnel opened to %s", id, addr)
+
+			proxy.Pipe(ws, upstream)
+
+			serverLog.Debugf("[%s] Tunnel to %s closed", id, addr)
+		},
+	}
+
+	wss.ServeHTTP(w, req)
+}
+
+// Forward a request to an env container,
+// xenvman credentials are not passed along
+func (s *Server) proxyHandler(w http.ResponseWriter, req *http.Request) {
+	if s.params.AuthBackend != nil {
+		req.Header.Del("Authorization")
+	}
+
+	s.proxy.ServeHTTP(w, req)
+}
+
+// Resolve an env container port to an address reachable from
+// xenvman host, env ids are matched case-insensitively as proxy
+// hostnames are lowercased
+func (s *Server) ResolvePort(envId, container string,
+	port uint16) (string, error) {
+
+	s.RLock()
+	e, ok := s.envs[envId]
+
+	if !ok {
+		for id, env := range s.envs {
+			if strings.EqualFold(id, envId) {
+				e, ok = env, true
+
+				break
+			}
+		}
+	}
+	s.RUnlock()
+
+	if !ok || !e.IsAlive() {
+		return "", errors.Errorf("Env not found: %s", envId)
+	}
+
+	return e.ProxyAddress(container, port)
+}
+
 func (s *Server) listTplsHandler(w http.ResponseWriter, req *http.Request) {
-	res, err := tpl.LoadTemplatesInfo(s.params.BaseTplDir)
+	ApiSendData(w, http.StatusOK, s.params.TplRegistry.List())
+}
+
+func (s *Server) validateTplsHandler(w http.ResponseWriter, req *http.Request) {
+	res, err := s.params.TplSources.Lint(s.params.TplLimits.Timeout)
 
 	if err != nil {
-		serverLog.Errorf("Error loading templates info: %+v", err)
+		serverLog.Errorf("Error validating templates: %+v", err)
 
 		ApiSendMessage(w, http.StatusInternalServerError,
-			"Error loading templates info: %s", err)
+			"Error validating templates: %s", err)
 
 		return
 	}
@@ -460,6 +841,137 @@ func (s *Server) listTplsHandler(w http.ResponseWriter, req *http.Request) {
 	ApiSendData(w, http.StatusOK, res)
 }
 
+func (s *Server) refreshTplsHandler(w http.ResponseWriter, req *http.Request) {
+	ApiSendData(w, http.StatusOK, s.params.TplSources.Refresh(req.Context()))
+}
+
+func (s *Server) getTplInfoHandler(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	info, err := s.params.TplRegistry.Get(name)
+
+	if err != nil {
+		ApiSendMessage(w, http.StatusNotFound, "Template not found: %s", name)
+
+		return
+	}
+
+	ApiSendData(w, http.StatusOK, info)
+}
+
+func (s *Server) getTplHandler(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	dir, rel, err := s.params.TplSources.Resolve(name)
+
+	if err != nil {
+		ApiSendMessage(w, http.StatusNotFound, "%s", err)
+
+		return
+	}
+
+	var buf bytes.Buffer
+
+	if err := tpl.ArchiveTemplate(dir, rel, &buf); err == tpl.ErrTplNotFound {
+		ApiSendMessage(w, http.StatusNotFound, "Template not found: %s", name)
+
+		return
+	} else if err != nil {
+		serverLog.Errorf("Error archiving template %s: %+v", name, err)
+
+		ApiSendMessage(w, http.StatusInternalServerError,
+			"Error archiving template: %s", err)
+
+		return
+	}
+
+	hdrs := http.Header{}
+	hdrs.Set("Content-Type", "application/gzip")
+
+	_ = SendHttpResponse(w, http.StatusOK, hdrs, buf.Bytes())
+}
+
+// Body: tar archive with template file and data dir
+func (s *Server) putTplHandler(w http.ResponseWriter, req *http.Request) {
+	//noinspection GoUnhandledErrorResult
+	defer req.Body.Close()
+
+	name := mux.Vars(req)["name"]
+
+	if !s.checkTplWritable(w, name) {
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, maxTplUploadSize)
+	info, err := tpl.InstallTemplate(s.params.BaseTplDir, name, body,
+		s.params.TplLimits.Timeout)
+
+	if err != nil {
+		code := http.StatusBadRequest
+
+		switch errors.Cause(err).(type) {
+		case *os.PathError, *os.LinkError:
+			code = http.StatusInternalServerError
+		}
+
+		serverLog.Errorf("Error installing template %s: %+v", name, err)
+
+		ApiSendMessage(w, code, "Error installing template: %s", err)
+
+		return
+	}
+
+	s.params.TplRegistry.Invalidate(s.params.BaseTplDir)
+
+	ApiSendData(w, http.StatusOK, info)
+}
+
+func (s *Server) deleteTplHandler(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	if !s.checkTplWritable(w, name) {
+		return
+	}
+
+	if err := tpl.RemoveTemplate(s.params.BaseTplDir, name); err == tpl.ErrTplNotFound {
+		ApiSendMessage(w, http.StatusNotFound, "Template not found: %s", name)
+
+		return
+	} else if err != nil {
+		serverLog.Errorf("Error removing template %s: %+v", name, err)
+
+		ApiSendMessage(w, http.StatusInternalServerError,
+			"Error removing template: %s", err)
+
+		return
+	}
+
+	s.params.TplRegistry.Invalidate(s.params.BaseTplDir)
+
+	ApiSendMessage(w, http.StatusOK, "")
+}
+
+// Only templates in the base dir can be modified,
+// the ones shadowed by other sources would not be visible anyway
+func (s *Server) checkTplWritable(w http.ResponseWriter, name string) bool {
+	if s.params.BaseTplDir == "" {
+		ApiSendMessage(w, http.StatusBadRequest, "Base template dir is not set")
+
+		return false
+	}
+
+	dir, _, err := s.params.TplSources.Resolve(name)
+
+	if err != nil || filepath.Clean(dir) != filepath.Clean(s.params.BaseTplDir) {
+		ApiSendMessage(w, http.StatusBadRequest,
+			"Template %s belongs to a read-only template source", name)
+
+		return false
+	}
+
+	return true
+}
+
 func (s *Server) webappHandler(w http.ResponseWriter, req *http.Request) {
 	path := mux.Vars(req)["path"]
 	hdrs := http.Header{}
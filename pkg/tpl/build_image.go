@@ -27,18 +27,23 @@ package tpl
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"text/template"
 
-	"github.com/mholt/archiver"
 	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+	"github.com/syhpoon/xenvman/pkg/lib"
 )
 
 type BuildImage struct {
 	*Image
+
+	buildParams conteng.BuildImageParams
+	reuse       bool
 }
 
 // Copy a file/dir from data dir to workspace dir
@@ -50,6 +55,8 @@ func (img *Image) CopyDataToWorkspace(objs ...string) {
 			imgLog.Debugf("Copying everything from %s to %s for %s",
 				img.dataDir, img.wsDir, img.envId)
 
+			img.quota.addTree(img.dataDir, img.fs)
+
 			if err := Copy(img.dataDir, img.wsDir, img.fs); err != nil {
 				panic(errors.Wrapf(err, "Error copying data to workspace"))
 			}
@@ -64,6 +71,8 @@ func (img *Image) CopyDataToWorkspace(objs ...string) {
 
 			imgLog.Debugf("Copying %s to %s for %s", dataPath, wsPath, img.envId)
 
+			img.quota.addTree(dataPath, img.fs)
+
 			if err := Copy(dataPath, wsPath, img.fs); err != nil {
 				panic(errors.Wrapf(err, "Error copying data to workspace"))
 			}
@@ -87,6 +96,8 @@ func (img *BuildImage) AddFileToWorkspace(file string, data interface{}, mode in
 			errors.Errorf("Invalid file data type %T, expected bytes or string", data))
 	}
 
+	img.quota.addBytes(int64(len(bs)))
+
 	_ = os.MkdirAll(filepath.Dir(path), 0755)
 
 	if err := ioutil.WriteFile(path, bs, os.FileMode(mode)); err != nil {
@@ -125,34 +136,103 @@ func (img *BuildImage) InterpolateWorkspaceFile(file string, data interface{}) {
 
 	_ = out.Flush()
 
+	img.quota.addBytes(int64(b.Len()) - info.Size())
+
 	if err := ioutil.WriteFile(path, b.Bytes(), info.Mode()); err != nil {
 		panic(errors.Wrapf(err, "Error saving template %s", path))
 	}
 }
 
-func (img *BuildImage) BuildContext() (io.Reader, error) {
-	var b bytes.Buffer
-	out := bufio.NewWriter(&b)
+// Set image build options
+func (img *BuildImage) SetBuildOptions(opts Opts) {
+	checkCancelled(img.ctx)
 
-	var paths []string
+	img.buildParams.NoCache = opts.GetBool("no_cache", img.buildParams.NoCache)
+	img.buildParams.Pull = opts.GetBool("pull", img.buildParams.Pull)
+	img.reuse = opts.GetBool("reuse", img.reuse)
+}
 
-	infos, err := ioutil.ReadDir(img.wsDir)
+// Set a build-time variable
+func (img *BuildImage) SetBuildArg(name, value string) {
+	checkCancelled(img.ctx)
 
-	if err != nil {
-		return nil, errors.Wrapf(err, "Error reading workspace: %s", img.wsDir)
+	if img.buildParams.BuildArgs == nil {
+		img.buildParams.BuildArgs = map[string]string{}
 	}
 
-	for _, info := range infos {
-		paths = append(paths, filepath.Join(img.wsDir, info.Name()))
-	}
+	img.buildParams.BuildArgs[name] = value
+}
 
-	err = archiver.TarBz2.Write(out, paths)
+// Set a build stage to stop at in a multi-stage Dockerfile
+func (img *BuildImage) SetTarget(target string) {
+	checkCancelled(img.ctx)
+	img.buildParams.Target = target
+}
+
+// Set Dockerfile path relative to workspace dir
+func (img *BuildImage) SetDockerfile(file string) {
+	path := filepath.Clean(filepath.Join(img.wsDir, file))
+	verifyPath(path, img.wsDir)
+
+	checkCancelled(img.ctx)
+
+	rel, err := filepath.Rel(img.wsDir, path)
 
 	if err != nil {
-		return nil, errors.Wrapf(err, "Error creating archive: %s", img.wsDir)
+		panic(errors.WithStack(err))
 	}
 
-	_ = out.Flush()
+	img.buildParams.Dockerfile = filepath.ToSlash(rel)
+}
+
+// Set an image label
+func (img *BuildImage) SetLabel(k string, v interface{}) {
+	checkCancelled(img.ctx)
+
+	if img.buildParams.Labels == nil {
+		img.buildParams.Labels = map[string]string{}
+	}
+
+	switch vv := v.(type) {
+	case string:
+		img.buildParams.Labels[k] = vv
+	default:
+		img.buildParams.Labels[k] = fmt.Sprintf("%v", vv)
+	}
+}
+
+// Set target platform, e.g. linux/amd64
+func (img *BuildImage) SetPlatform(platform string) {
+	checkCancelled(img.ctx)
+	img.buildParams.Platform = platform
+}
+
+func (img *BuildImage) BuildParams() conteng.BuildImageParams {
+	return img.buildParams
+}
+
+// Whether a cached image built from the same context can be used
+func (img *BuildImage) Reusable() bool {
+	return img.reuse
+}
+
+// Build context is a stream of an uncompressed tar archive of the
+// workspace, generated while it is being read.
+// Reader must be closed once no longer needed.
+func (img *BuildImage) BuildContext() io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		_ = pw.CloseWithError(img.WriteBuildContext(pw))
+	}()
+
+	return pr
+}
+
+// Write build context to w,
+// the same workspace content always produces the same output
+func (img *BuildImage) WriteBuildContext(w io.Writer) error {
+	err := lib.WriteBuildContext(w, img.wsDir, img.buildParams.Dockerfile)
 
-	return bytes.NewReader(b.Bytes()), nil
+	return errors.Wrapf(err, "Error creating archive: %s", img.wsDir)
 }
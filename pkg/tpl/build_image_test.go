@@ -0,0 +1,55 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/conteng"
+)
+
+func TestBuildImageParams(t *testing.T) {
+	img := &BuildImage{Image: &Image{wsDir: "/ws/img"}}
+
+	img.SetBuildArg("VERSION", "1.2")
+	img.SetTarget("test")
+	img.SetDockerfile("./docker/../build/Dockerfile.prod")
+	img.SetLabel("team", "payments")
+	img.SetLabel("tier", 1)
+	img.SetPlatform("linux/amd64")
+
+	require.Equal(t, conteng.BuildImageParams{
+		BuildArgs:  map[string]string{"VERSION": "1.2"},
+		Target:     "test",
+		Dockerfile: "build/Dockerfile.prod",
+		Labels:     map[string]string{"team": "payments", "tier": "1"},
+		Platform:   "linux/amd64",
+	}, img.BuildParams())
+
+	require.Panics(t, func() {
+		img.SetDockerfile("../../etc/Dockerfile")
+	})
+}
@@ -0,0 +1,101 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+const defaultCertDir = "/etc/xenvman/tls"
+
+// TLS certificate to be issued for a container by the env CA
+// once container IP is known
+type CertRequest struct {
+	// Extra SANs, container hostname and IP are always added
+	Sans []string
+	// Host files, mounted into a container
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Request a TLS certificate signed by the env CA for a container.
+// Certificate, private key and CA certificate are mounted into
+// the container as cert.pem, key.pem and ca.pem in the opts.dir directory.
+func (tpl *Tpl) IssueCert(cont *Container, opts Opts) {
+	checkCancelled(tpl.ctx)
+
+	if cont == nil {
+		panic(errors.New("IssueCert: container is required"))
+	}
+
+	dir := opts.GetString("dir", defaultCertDir)
+
+	if !filepath.IsAbs(dir) {
+		panic(errors.Errorf("IssueCert: dir must be an absolute path: %s", dir))
+	}
+
+	req := &CertRequest{
+		Sans: opts.GetListOfStrings("sans", nil),
+	}
+
+	id := lib.NewId()
+
+	files := []struct {
+		dst  *string
+		name string
+		mode os.FileMode
+	}{
+		{&req.CertFile, "cert.pem", 0644},
+		{&req.KeyFile, "key.pem", 0600},
+		{&req.CAFile, "ca.pem", 0644},
+	}
+
+	// Files are created empty and filled in after IP assignment
+	for _, f := range files {
+		path := filepath.Clean(
+			filepath.Join(cont.mountDir, fmt.Sprintf("%s-%s", id, f.name)))
+		verifyPath(path, cont.mountDir)
+
+		if err := ioutil.WriteFile(path, nil, f.mode); err != nil {
+			panic(errors.Wrapf(err, "Error creating file %s", path))
+		}
+
+		*f.dst = path
+
+		cont.doMount(path, filepath.Join(dir, f.name), Opts{})
+	}
+
+	cont.certs = append(cont.certs, req)
+
+	tplLog.Debugf("[%s] Requested TLS certificate for %s in %s",
+		tpl.envId, cont.Hostname(), dir)
+}
@@ -50,7 +50,7 @@ type Container struct {
 	image                string
 	cmd                  []string
 	entrypoint           []string
-	ports                []uint16
+	ports                []*conteng.Port
 	dataDir              string
 	mountDir             string
 	mounts               []*conteng.ContainerFileMount
@@ -59,7 +59,12 @@ type Container struct {
 	needInterpolating    map[string]bool
 	extraInterpolateData map[string]map[string]interface{}
 	readinessChecks      []ReadinessCheck
+	secretEnv            map[string]bool
+	certs                []*CertRequest
+	networks             []string
 	fs                   *Fs
+	secrets              *lib.Redactor
+	quota                *quota
 	ctx                  context.Context
 }
 
@@ -72,6 +77,7 @@ func NewContainer(name, tplName string, tplIdx int) *Container {
 		labels:               map[string]string{},
 		needInterpolating:    map[string]bool{},
 		extraInterpolateData: map[string]map[string]interface{}{},
+		secretEnv:            map[string]bool{},
 	}
 }
 
@@ -80,6 +86,18 @@ func (cont *Container) SetEnv(k, v string) {
 	cont.environ[k] = v
 }
 
+// Set an environment variable whose value is never logged or exported
+func (cont *Container) SetSecretEnv(k, v string) {
+	checkCancelled(cont.ctx)
+	cont.secrets.Add(v)
+	cont.environ[k] = v
+	cont.secretEnv[k] = true
+}
+
+func (cont *Container) IsSecretEnv(k string) bool {
+	return cont.secretEnv[k]
+}
+
 func (cont *Container) SetLabel(k string, v interface{}) {
 	checkCancelled(cont.ctx)
 
@@ -105,9 +123,24 @@ func (cont *Container) SetEntrypoint(ep ...string) {
 	cont.entrypoint = ep
 }
 
-func (cont *Container) SetPorts(ports ...uint16) {
+// Ports are either numbers or specs in the format:
+// [[host_ip:][host_port]:]port[/protocol]
+func (cont *Container) SetPorts(ports ...interface{}) {
 	checkCancelled(cont.ctx)
-	cont.ports = ports
+
+	var res []*conteng.Port
+
+	for _, p := range ports {
+		port, err := conteng.ParsePort(fmt.Sprintf("%v", p))
+
+		if err != nil {
+			panic(errors.WithStack(err))
+		}
+
+		res = append(res, port)
+	}
+
+	cont.ports = res
 }
 
 func (cont *Container) Name() string {
@@ -118,7 +151,7 @@ func (cont *Container) Image() string {
 	return cont.image
 }
 
-func (cont *Container) Ports() []uint16 {
+func (cont *Container) Ports() []*conteng.Port {
 	return cont.ports
 }
 
@@ -166,6 +199,7 @@ func (cont *Container) MountString(data, contFile string, mode int, opts Opts) {
 	verifyPath(path, cont.mountDir)
 
 	checkCancelled(cont.ctx)
+	cont.quota.addBytes(int64(len(data)))
 
 	if err := ioutil.WriteFile(path, []byte(data), os.FileMode(mode)); err != nil {
 		panic(errors.Wrapf(err, "Error copying file %s", path))
@@ -195,6 +229,7 @@ func (cont *Container) MountData(dataFile, contFile string, opts Opts) {
 	}
 
 	checkCancelled(cont.ctx)
+	cont.quota.addTree(dataPath, cont.fs)
 
 	if err := Copy(dataPath, mountPath, cont.fs); err != nil {
 		panic(errors.Wrapf(err, "Error copying data to mount dir"))
@@ -204,8 +239,9 @@ func (cont *Container) MountData(dataFile, contFile string, opts Opts) {
 }
 
 func (cont *Container) doMount(hostFile, contFile string, opts Opts) {
-	contLog.Debugf("[%s] Mounting %s to %s [opts=%+v]",
-		cont.envId, hostFile, contFile, opts)
+	contLog.Debugf("[%s] Mounting %s to %s [opts=%s]",
+		cont.envId, hostFile, contFile,
+		cont.secrets.Redact(fmt.Sprintf("%+v", opts)))
 
 	cont.mounts = append(cont.mounts, &conteng.ContainerFileMount{
 		HostFile:      hostFile,
@@ -219,6 +255,11 @@ func (cont *Container) doMount(hostFile, contFile string, opts Opts) {
 	}
 }
 
+// Return TLS certificates requested for the container
+func (cont *Container) CertRequests() []*CertRequest {
+	return cont.certs
+}
+
 func (cont *Container) Template() (string, int) {
 	return cont.tplName, cont.tplIdx
 }
@@ -7,7 +7,6 @@ import (
 	"io"
 	"os"
 
-	"io/ioutil"
 	"path/filepath"
 )
 
@@ -72,7 +71,7 @@ func dcopy(srcdir, destdir string, info os.FileInfo, fs *Fs) error {
 		return err
 	}
 
-	contents, err := ioutil.ReadDir(srcdir)
+	contents, err := fs.readDir(srcdir)
 	if err != nil {
 		return err
 	}
@@ -29,13 +29,15 @@ import (
 	"fmt"
 	"os"
 
-	"io/ioutil"
 	"path/filepath"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/robertkrimen/otto"
 	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
 	"github.com/syhpoon/xenvman/pkg/logger"
+	"github.com/syhpoon/xenvman/pkg/secret"
 )
 
 var executeLog = logger.GetLogger("xenvman.pkg.tpl.execute")
@@ -59,8 +61,18 @@ type ExecuteParams struct {
 	WsDir     string
 	MountDir  string
 	TplParams def.TplParams
-	Fs        *Fs
-	Ctx       context.Context
+	// If set, templates are looked up in sources instead of TplDir
+	Sources *Sources
+	// If set, cached compiled programs are used
+	Registry *Registry
+	// Values for secret parameters supplied by the caller
+	Secrets map[string]string
+	// Fallback for secret parameters not supplied by the caller
+	SecretProvider secret.Provider
+	Redactor       *lib.Redactor
+	Fs             *Fs
+	Limits         Limits
+	Ctx            context.Context
 }
 
 func Execute(envId, tplName string, tplIndex int,
@@ -68,36 +80,61 @@ func Execute(envId, tplName string, tplIndex int,
 
 	defer func() {
 		if r := recover(); r != nil {
-			if r == errCancelled {
+			if e, ok := r.(error); ok && errors.Cause(e) == errCancelled {
 				executeLog.Infof("Execution cancelled for %s", tplName)
 			}
 
-			err = errors.Errorf("Error running template: %v", r)
+			err = params.Redactor.RedactError(
+				errors.Errorf("Error running template: %v", r))
 		}
 	}()
 
 	if params.Fs == nil {
-		params.Fs = &Fs{
-			ReadFile: ioutil.ReadFile,
-			Stat:     os.Stat,
-			Lstat:    os.Lstat,
-		}
+		params.Fs = defaultFs
 	}
 
 	vm := otto.New()
 
+	stop := watchExecution(vm, tplName, params.Limits.Timeout, params.Ctx)
+	defer stop()
+
 	imprt := &importTpls{}
 
 	// Setup library
 	setupLib(vm)
 	_ = vm.Set("import_template", imprt.Add)
 
-	jsFile, dataDir, err := getTplPaths(tplName, params.TplDir)
+	name, constraint := SplitTplRef(tplName)
+	tplDir, relName := params.TplDir, name
+
+	if params.Sources != nil {
+		if tplDir, relName, err = params.Sources.Resolve(name); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	jsFile, dataDir, err := getTplPaths(relName, tplDir)
 
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
 
+	// Only versioned copies of the template may exist
+	if _, err := params.Fs.Stat(jsFile); os.IsNotExist(err) && constraint == "" {
+		constraint = "*"
+	}
+
+	if constraint != "" {
+		if relName, _, err = resolveVersion(relName, constraint, tplDir,
+			&params); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		if jsFile, dataDir, err = getTplPaths(relName, tplDir); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
 	tplLog.Debugf("Executing tpl from %s (%s)", tplName, jsFile)
 
 	bytes, err := params.Fs.ReadFile(jsFile)
@@ -106,33 +143,67 @@ func Execute(envId, tplName string, tplIndex int,
 		return nil, nil, errors.Wrapf(err, "Error reading tpl %s", tplName)
 	}
 
-	_, err = vm.Run(bytes)
+	var program interface{} = bytes
+
+	if params.Registry != nil && params.Fs == defaultFs {
+		if p := params.Registry.Program(jsFile); p != nil {
+			program = p
+		}
+	}
+
+	_, err = vm.Run(program)
 
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "Error executing tpl %s", tplName)
 	}
 
+	info, err := readInfo(vm)
+
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Error reading info for tpl %s",
+			tplName)
+	}
+
+	hash, err := contentHash(jsFile, dataDir, params.Fs)
+
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Error hashing tpl %s", tplName)
+	}
+
+	tplParams, err := resolveSecrets(info, params)
+
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Error resolving secrets for tpl %s",
+			tplName)
+	}
+
 	// /<ws-dir>/<tpl-name>/<tpl-idx>
-	wsDir := filepath.Join(params.WsDir, tplName,
+	wsDir := filepath.Join(params.WsDir, name,
 		fmt.Sprintf("%d", tplIndex))
 
 	mountDir := filepath.Join(params.MountDir, "mounts")
 
 	tpl = &Tpl{
 		envId:    envId,
-		name:     tplName,
+		name:     name,
+		version:  tplVersion(info, jsFile, params.Fs),
+		hash:     hash,
 		idx:      tplIndex,
 		dataDir:  dataDir,
 		wsDir:    wsDir,
 		mountDir: mountDir,
 		fs:       params.Fs,
+		secrets:  params.Redactor,
+		quota:    newQuota(name, params.Limits),
 		ctx:      params.Ctx,
 	}
 
-	_, err = vm.Call(executeFunctionName, nil, tpl, params.TplParams)
+	_, err = vm.Call(executeFunctionName, nil, tpl, tplParams)
 
 	if err != nil {
-		executeLog.Errorf("%s", err.(*otto.Error).String())
+		executeLog.Errorf("%s", params.Redactor.Redact(err.(*otto.Error).String()))
+
+		err = params.Redactor.RedactError(err)
 
 		return nil, nil, errors.Wrapf(err, "Error calling %s function for %s",
 			executeFunctionName, tpl.name)
@@ -140,3 +211,65 @@ func Execute(envId, tplName string, tplIndex int,
 
 	return tpl, imprt.list, nil
 }
+
+// Fill in parameters declared as secret in template info()
+// and register their values for redaction
+func resolveSecrets(info *def.TplInfo, params ExecuteParams) (def.TplParams, error) {
+	if info == nil {
+		// Templates are not required to define info()
+		return params.TplParams, nil
+	}
+
+	tplParams := def.TplParams{}
+
+	for k, v := range params.TplParams {
+		tplParams[k] = v
+	}
+
+	for name, param := range info.Parameters {
+		if param == nil || !param.Secret {
+			continue
+		}
+
+		if v, ok := params.Secrets[name]; ok {
+			tplParams[name] = v
+		} else if params.SecretProvider != nil {
+			v, found, err := params.SecretProvider.Get(name)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			if found {
+				tplParams[name] = v
+			}
+		}
+
+		addSecret(params.Redactor, tplParams[name])
+	}
+
+	return tplParams, nil
+}
+
+// Register a secret value for redaction, non-string values are
+// registered as they are formatted, objects and arrays by their elements
+func addSecret(r *lib.Redactor, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+	case string:
+		r.Add(val)
+	case float64:
+		r.Add(fmt.Sprint(val))
+		r.Add(strconv.FormatFloat(val, 'f', -1, 64))
+	case map[string]interface{}:
+		for _, elem := range val {
+			addSecret(r, elem)
+		}
+	case []interface{}:
+		for _, elem := range val {
+			addSecret(r, elem)
+		}
+	default:
+		r.Add(fmt.Sprint(val))
+	}
+}
@@ -24,6 +24,31 @@
 
 package tpl
 
+import (
+	"github.com/syhpoon/xenvman/pkg/conteng"
+)
+
 type FetchImage struct {
 	*Image
+
+	pullPolicy conteng.PullPolicy
+}
+
+// Set image pull policy: "always", "if-not-present" or "never",
+// server default is used if not set
+func (img *FetchImage) SetPullPolicy(policy string) {
+	checkCancelled(img.ctx)
+
+	p, err := conteng.ParsePullPolicy(policy)
+
+	if err != nil {
+		panic(err)
+	}
+
+	img.pullPolicy = p
+}
+
+// Pull policy set by template, empty if not set
+func (img *FetchImage) PullPolicy() conteng.PullPolicy {
+	return img.pullPolicy
 }
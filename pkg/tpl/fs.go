@@ -24,11 +24,57 @@
 
 package tpl
 
-import "os"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
 
 // A simple filesystem abstraction layer
 type Fs struct {
 	ReadFile func(string) ([]byte, error)
 	Stat     func(name string) (os.FileInfo, error)
 	Lstat    func(name string) (os.FileInfo, error)
+	// Optional, the real filesystem is used if not set
+	ReadDir func(dirname string) ([]os.FileInfo, error)
+}
+
+var defaultFs = &Fs{
+	ReadFile: ioutil.ReadFile,
+	Stat:     os.Stat,
+	Lstat:    os.Lstat,
+	ReadDir:  ioutil.ReadDir,
+}
+
+func (fs *Fs) readDir(dirname string) ([]os.FileInfo, error) {
+	if fs.ReadDir == nil {
+		return ioutil.ReadDir(dirname)
+	}
+
+	return fs.ReadDir(dirname)
+}
+
+// Walk all the regular files in a directory tree in lexical order
+func (fs *Fs) walkFiles(dir string, f func(path string) error) error {
+	entries, err := fs.readDir(dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+
+		if e.IsDir() {
+			err = fs.walkFiles(path, f)
+		} else if e.Mode().IsRegular() {
+			err = f(path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
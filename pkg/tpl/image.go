@@ -44,6 +44,8 @@ type Image struct {
 	mountDir   string
 	containers map[string]*Container
 	fs         *Fs
+	secrets    *lib.Redactor
+	quota      *quota
 	ctx        context.Context
 }
 
@@ -51,6 +53,7 @@ func (img *Image) NewContainer(name string) *Container {
 	mountDir := filepath.Join(img.mountDir, name, lib.NewIdShort())
 
 	checkCancelled(img.ctx)
+	img.quota.addContainer()
 	makeDir(mountDir)
 
 	cont := &Container{
@@ -65,7 +68,10 @@ func (img *Image) NewContainer(name string) *Container {
 		labels:               map[string]string{},
 		needInterpolating:    map[string]bool{},
 		extraInterpolateData: map[string]map[string]interface{}{},
+		secretEnv:            map[string]bool{},
 		fs:                   img.fs,
+		secrets:              img.secrets,
+		quota:                img.quota,
 		ctx:                  img.ctx,
 	}
 
@@ -84,3 +90,8 @@ func (img *Image) Name() string {
 func (img *Image) Containers() map[string]*Container {
 	return img.containers
 }
+
+// Return image workspace directory
+func (img *Image) WsDir() string {
+	return img.wsDir
+}
@@ -29,6 +29,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
@@ -38,89 +39,142 @@ import (
 
 const infoFunctionName = "info"
 
-func LoadTemplatesInfo(baseDir string) (map[string]*def.TplInfo, error) {
-	var tpls []string
-
-	f := func(path string, info os.FileInfo, err error) error {
-		name := info.Name()
-
-		if info.Mode().IsRegular() && strings.HasSuffix(name, "tpl.js") {
-			tpls = append(tpls, path)
-		}
+// Load info for all the templates in a directory.
+// Templates which cannot be loaded, including the ones exceeding
+// execution timeout, are skipped, use Lint to find out why
+func LoadTemplatesInfo(baseDir string,
+	timeout time.Duration) (map[string]*def.TplInfo, error) {
 
-		return nil
-	}
+	tpls, err := findTemplates(baseDir)
 
-	if err := filepath.Walk(baseDir, f); err != nil {
-		return nil, errors.Wrapf(err, "Error scanning tpl directory")
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
 	res := map[string]*def.TplInfo{}
 
 	for _, tpl := range tpls {
-		bytes, err := ioutil.ReadFile(tpl)
+		info, err := loadInfo(tpl, timeout)
 
 		if err != nil {
-			return nil, errors.Wrapf(err, "Error reading tpl %s", tpl)
+			tplLog.Warningf("Skipping tpl %s: %s", tpl, err)
+
+			continue
+		} else if info == nil {
+			continue
 		}
 
-		vm := otto.New()
+		res[tplName(baseDir, tpl)] = info
+	}
+
+	return res, nil
+}
 
-		_, err = vm.Run(bytes)
+// Load info for a single template file, nil if the template has no info()
+func loadInfo(tpl string, timeout time.Duration) (*def.TplInfo, error) {
+	bytes, err := ioutil.ReadFile(tpl)
 
-		if err != nil {
-			return nil, errors.Wrap(err, "Error executing tpl")
-		}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading tpl %s", tpl)
+	}
 
-		if ifunc, err := vm.Get(infoFunctionName); err != nil ||
-			ifunc.IsUndefined() {
-			continue
-		}
+	info, err := runInfo(otto.New(), bytes, tpl, timeout)
 
-		rawInfo, err := vm.Call(infoFunctionName, nil)
+	if err != nil || info == nil {
+		return nil, errors.WithStack(err)
+	}
 
-		if err != nil {
-			return nil, errors.Wrap(err, "Error calling info function")
-		}
+	info.Version = tplVersion(info, tpl, defaultFs)
+
+	// Check if a template has non-empty data dir
+	dataDir := strings.TrimSuffix(tpl, ".js") + ".data/"
+
+	if _, err := os.Stat(dataDir); err == nil {
+		info.DataDir = loadDataDir(dataDir)
+	}
+
+	return info, nil
+}
 
-		rawInfo2, err := rawInfo.Export()
+// Find all the template files in a directory
+func findTemplates(baseDir string) ([]string, error) {
+	var tpls []string
 
+	f := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return nil, errors.Wrap(err, "Error exporting info map")
+			return err
 		}
 
-		infoMap, ok := rawInfo2.(map[string]interface{})
+		name := info.Name()
 
-		if !ok {
-			return nil, errors.Errorf("Expected info map to be object but got: %T", rawInfo2)
+		// Hidden directories hold VCS metadata and pending uploads
+		if info.IsDir() && strings.HasPrefix(name, ".") && path != baseDir {
+			return filepath.SkipDir
 		}
 
-		info := def.TplInfo{
-			DataDir: []string{},
+		if info.Mode().IsRegular() && strings.HasSuffix(name, "tpl.js") {
+			tpls = append(tpls, path)
 		}
 
-		if err := mapstructure.Decode(infoMap, &info); err != nil {
-			return nil, errors.Wrap(err, "Error decoding info map")
-		}
+		return nil
+	}
 
-		f := strings.TrimPrefix(tpl, baseDir)
-		f = strings.TrimSuffix(f, ".tpl.js")
+	if err := filepath.Walk(baseDir, f); err != nil {
+		return nil, errors.Wrapf(err, "Error scanning tpl directory")
+	}
 
-		for strings.HasPrefix(f, "/") {
-			f = strings.TrimPrefix(f, "/")
-		}
+	return tpls, nil
+}
 
-		// Check if a template has non-empty data dir
-		dataDir := strings.TrimSuffix(tpl, ".js") + ".data/"
+// Convert template file path to template name
+func tplName(baseDir, tpl string) string {
+	f := strings.TrimPrefix(tpl, baseDir)
+	f = strings.TrimSuffix(f, ".tpl.js")
 
-		if _, err := os.Stat(dataDir); err == nil {
-			info.DataDir = loadDataDir(dataDir)
-		}
+	for strings.HasPrefix(f, "/") {
+		f = strings.TrimPrefix(f, "/")
+	}
+
+	return f
+}
 
-		res[f] = &info
+// Call info() function defined in a template, if any
+func readInfo(vm *otto.Otto) (*def.TplInfo, error) {
+	if ifunc, err := vm.Get(infoFunctionName); err != nil ||
+		ifunc.IsUndefined() {
+		return nil, nil
 	}
 
-	return res, nil
+	rawInfo, err := vm.Call(infoFunctionName, nil)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error calling info function")
+	}
+
+	rawInfo2, err := rawInfo.Export()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error exporting info map")
+	}
+
+	infoMap, ok := rawInfo2.(map[string]interface{})
+
+	if !ok {
+		return nil, errors.Errorf("Expected info map to be object but got: %T", rawInfo2)
+	}
+
+	info := &def.TplInfo{
+		DataDir: []string{},
+	}
+
+	if err := mapstructure.Decode(infoMap, info); err != nil {
+		return nil, errors.Wrap(err, "Error decoding info map")
+	}
+
+	// Only set by xenvman itself
+	info.Error = ""
+
+	return info, nil
 }
 
 func loadDataDir(dataDir string) []string {
@@ -0,0 +1,175 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+// Virtual directory inline templates are served from
+const InlineTplDir = "/xenvman-inline"
+
+// Build an in-memory filesystem serving an inline template
+// as <InlineTplDir>/<name>.tpl.js with its data dir
+func NewInlineFs(name string, inline *def.InlineTpl) (*Fs, error) {
+	jsFile, dataDir, err := getTplPaths(name, InlineTplDir)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	mfs := &memFs{
+		files: map[string][]byte{
+			jsFile: []byte(inline.Source),
+		},
+		dirs: map[string][]string{},
+	}
+
+	for path, encoded := range inline.Data {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error decoding inline data file %s", path)
+		}
+
+		full := filepath.Join(dataDir, path)
+
+		if filepath.IsAbs(path) || !strings.HasPrefix(full, dataDir+"/") {
+			return nil, errors.Errorf("Invalid inline data file path: %s", path)
+		}
+
+		mfs.files[full] = data
+	}
+
+	for path := range mfs.files {
+		mfs.addParents(path)
+	}
+
+	for _, children := range mfs.dirs {
+		sort.Strings(children)
+	}
+
+	return &Fs{
+		ReadFile: mfs.readFile,
+		Stat:     mfs.stat,
+		Lstat:    mfs.stat,
+		ReadDir:  mfs.readDir,
+	}, nil
+}
+
+type memFs struct {
+	files map[string][]byte
+	// Directory -> names of its entries
+	dirs map[string][]string
+}
+
+func (m *memFs) addParents(path string) {
+	for path != "/" {
+		dir := filepath.Dir(path)
+		_, seen := m.dirs[dir]
+		m.dirs[dir] = append(m.dirs[dir], filepath.Base(path))
+
+		if seen {
+			return
+		}
+
+		path = dir
+	}
+}
+
+func (m *memFs) readFile(path string) ([]byte, error) {
+	data, ok := m.files[filepath.Clean(path)]
+
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	return data, nil
+}
+
+func (m *memFs) stat(path string) (os.FileInfo, error) {
+	path = filepath.Clean(path)
+
+	if data, ok := m.files[path]; ok {
+		return &memFileInfo{name: filepath.Base(path), size: int64(len(data))}, nil
+	}
+
+	if _, ok := m.dirs[path]; ok {
+		return &memFileInfo{name: filepath.Base(path), dir: true}, nil
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+func (m *memFs) readDir(path string) ([]os.FileInfo, error) {
+	path = filepath.Clean(path)
+	children, ok := m.dirs[path]
+
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	res := make([]os.FileInfo, len(children))
+
+	for i, child := range children {
+		info, err := m.stat(filepath.Join(path, child))
+
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = info
+	}
+
+	return res, nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
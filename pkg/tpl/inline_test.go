@@ -0,0 +1,85 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+func TestInlineFs(t *testing.T) {
+	enc := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	fs, err := NewInlineFs("inline-x", &def.InlineTpl{
+		Source: "function execute(tpl, params) {}",
+		Data: map[string]string{
+			"b/c.txt": enc("c"),
+			"a.txt":   enc("a"),
+		},
+	})
+	require.Nil(t, err)
+
+	src, err := fs.ReadFile(InlineTplDir + "/inline-x.tpl.js")
+	require.Nil(t, err)
+	require.Equal(t, "function execute(tpl, params) {}", string(src))
+
+	dataDir := InlineTplDir + "/inline-x.tpl.data"
+
+	st, err := fs.Stat(dataDir)
+	require.Nil(t, err)
+	require.True(t, st.IsDir())
+
+	entries, err := fs.ReadDir(dataDir)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "a.txt", entries[0].Name())
+	require.Equal(t, "b", entries[1].Name())
+	require.True(t, entries[1].IsDir())
+
+	var files []string
+
+	require.Nil(t, fs.walkFiles(dataDir, func(path string) error {
+		files = append(files, path)
+		return nil
+	}))
+	require.Equal(t, []string{dataDir + "/a.txt", dataDir + "/b/c.txt"}, files)
+
+	_, err = fs.Stat(dataDir + "/missing")
+	require.NotNil(t, err)
+
+	for _, data := range []map[string]string{
+		{"../escape": enc("x")},
+		{"/etc/passwd": enc("x")},
+		{"ok": "not base64!"},
+	} {
+		_, err = NewInlineFs("inline-x", &def.InlineTpl{Data: data})
+		require.NotNil(t, err)
+	}
+}
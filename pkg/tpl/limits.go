@@ -0,0 +1,244 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robertkrimen/otto"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+// Resource limits applied to a single template execution.
+// Zero values mean no limit.
+type Limits struct {
+	// Maximum time spent executing template javascript
+	Timeout time.Duration
+	// Maximum number of images a template may build or fetch
+	MaxImages int
+	// Maximum number of containers a template may create
+	MaxContainers int
+	// Maximum total number of bytes a template may write
+	// to workspace and mount directories
+	MaxWriteBytes int64
+}
+
+// Usage counters checked against limits
+type quota struct {
+	tplName    string
+	limits     Limits
+	images     int
+	containers int
+	bytes      int64
+	sync.Mutex
+}
+
+func newQuota(tplName string, limits Limits) *quota {
+	return &quota{
+		tplName: tplName,
+		limits:  limits,
+	}
+}
+
+func (q *quota) addImage() {
+	if q == nil {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.limits.MaxImages > 0 && q.images >= q.limits.MaxImages {
+		panic(errors.Errorf("Template %s exceeded the limit of %d images",
+			q.tplName, q.limits.MaxImages))
+	}
+
+	q.images++
+}
+
+func (q *quota) addContainer() {
+	if q == nil {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.limits.MaxContainers > 0 && q.containers >= q.limits.MaxContainers {
+		panic(errors.Errorf("Template %s exceeded the limit of %d containers",
+			q.tplName, q.limits.MaxContainers))
+	}
+
+	q.containers++
+}
+
+// Must be called before writing n bytes
+func (q *quota) addBytes(n int64) {
+	if q == nil || n <= 0 {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.limits.MaxWriteBytes > 0 && q.bytes+n > q.limits.MaxWriteBytes {
+		panic(errors.Errorf(
+			"Template %s exceeded the limit of %d bytes written to workspace/mount dirs",
+			q.tplName, q.limits.MaxWriteBytes))
+	}
+
+	q.bytes += n
+}
+
+// Account for a file or a directory tree about to be copied
+func (q *quota) addTree(path string, fs *Fs) {
+	if q == nil || q.limits.MaxWriteBytes <= 0 {
+		return
+	}
+
+	st, err := fs.Stat(path)
+
+	if err != nil {
+		panic(errors.WithStack(err))
+	}
+
+	if !st.IsDir() {
+		q.addBytes(st.Size())
+
+		return
+	}
+
+	err = fs.walkFiles(path, func(p string) error {
+		st, err := fs.Stat(p)
+
+		if err != nil {
+			return err
+		}
+
+		q.addBytes(st.Size())
+
+		return nil
+	})
+
+	if err != nil {
+		panic(errors.WithStack(err))
+	}
+}
+
+// Interrupt the VM when the execution timeout expires
+// or the context gets cancelled.
+// Returned function must be called once the execution is over.
+func watchExecution(vm *otto.Otto, tplName string, timeout time.Duration,
+	ctx context.Context) func() {
+
+	var expired <-chan time.Time
+	var cancelled <-chan struct{}
+	var timer *time.Timer
+
+	if timeout > 0 {
+		timer = time.NewTimer(timeout)
+		expired = timer.C
+	}
+
+	if ctx != nil {
+		cancelled = ctx.Done()
+	}
+
+	if expired == nil && cancelled == nil {
+		return func() {}
+	}
+
+	vm.Interrupt = make(chan func(), 1)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-expired:
+			vm.Interrupt <- func() {
+				panic(errors.Errorf(
+					"Template %s exceeded the execution timeout of %s",
+					tplName, timeout))
+			}
+		case <-cancelled:
+			vm.Interrupt <- func() {
+				panic(errors.WithStack(errCancelled))
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Run f interrupting the VM when the execution timeout expires.
+// Panics raised during the execution are returned as errors
+func runWatched(vm *otto.Otto, tplName string, timeout time.Duration,
+	f func() error) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	stop := watchExecution(vm, tplName, timeout, nil)
+	defer stop()
+
+	return f()
+}
+
+// Run template top-level code and read its info,
+// interrupting the VM when the execution timeout expires
+func runInfo(vm *otto.Otto, program interface{}, tplName string,
+	timeout time.Duration) (*def.TplInfo, error) {
+
+	var info *def.TplInfo
+
+	err := runWatched(vm, tplName, timeout, func() error {
+		if _, err := vm.Run(program); err != nil {
+			return errors.Wrap(err, "Error executing")
+		}
+
+		var err error
+		info, err = readInfo(vm)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,173 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+func TestLimits(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "xenvman-limits-")
+	require.Nil(t, err)
+
+	defer os.RemoveAll(tmpDir)
+
+	execute := func(src string, params def.TplParams, limits Limits,
+		ctx context.Context) error {
+
+		fs, err := NewInlineFs("limited", &def.InlineTpl{
+			Source: src,
+			Data: map[string]string{
+				"file": base64.StdEncoding.EncodeToString(make([]byte, 100)),
+			},
+		})
+		require.Nil(t, err)
+
+		_, _, err = Execute("env", "limited", 0, ExecuteParams{
+			TplDir:    InlineTplDir,
+			WsDir:     filepath.Join(tmpDir, "ws"),
+			MountDir:  filepath.Join(tmpDir, "mount"),
+			TplParams: params,
+			Redactor:  lib.NewRedactor(),
+			Fs:        fs,
+			Limits:    limits,
+			Ctx:       ctx,
+		})
+
+		return err
+	}
+
+	// Infinite loop is interrupted
+	start := time.Now()
+	err = execute("function execute(tpl, params) { while(true) {} }", nil,
+		Limits{Timeout: 100 * time.Millisecond}, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(),
+		"Template limited exceeded the execution timeout of 100ms")
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	// Infinite loop is interrupted on cancellation
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+
+	err = execute("while(true) {}", nil, Limits{}, ctx)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), errCancelled.Error())
+
+	// Images and containers
+	src := `function execute(tpl, params) {
+	  for(var i = 0; i < params.images; i++) {
+	    var img = tpl.FetchImage("img" + i);
+
+	    for(var j = 0; j < params.containers; j++) {
+	      img.NewContainer("cont" + j);
+	    }
+	  }
+	}`
+
+	counts := func(images, containers int) def.TplParams {
+		return def.TplParams{"images": images, "containers": containers}
+	}
+
+	limits := Limits{MaxImages: 2, MaxContainers: 3}
+
+	require.Nil(t, execute(src, counts(2, 1), limits, nil))
+	require.Nil(t, execute(src, counts(1, 3), limits, nil))
+
+	err = execute(src, counts(3, 0), limits, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(),
+		"Template limited exceeded the limit of 2 images")
+
+	err = execute(src, counts(2, 2), limits, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(),
+		"Template limited exceeded the limit of 3 containers")
+
+	// Written bytes: 100 bytes data file + 10 bytes file + 10 bytes mount
+	src = `function execute(tpl, params) {
+	  var img = tpl.BuildImage("img");
+	  img.CopyDataToWorkspace("file");
+	  img.AddFileToWorkspace("extra", "0123456789", 0644);
+	  img.NewContainer("cont").MountString("0123456789", "/f", 0644, {});
+	}`
+
+	require.Nil(t, execute(src, nil, Limits{MaxWriteBytes: 120}, nil))
+
+	err = execute(src, nil, Limits{MaxWriteBytes: 119}, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(),
+		"Template limited exceeded the limit of 119 bytes")
+
+	err = execute(src, nil, Limits{MaxWriteBytes: 50}, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(),
+		"Template limited exceeded the limit of 50 bytes")
+}
+
+func TestLimitsTopLevel(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "xenvman-limits-")
+	require.Nil(t, err)
+
+	defer os.RemoveAll(baseDir)
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(baseDir, "spin@1.0.0.tpl.js"),
+		[]byte("while(true) {}"), 0644))
+
+	srcs, err := NewSources(NewLocalSource("", baseDir))
+	require.Nil(t, err)
+
+	timeout := 100 * time.Millisecond
+
+	// Loading template info is interrupted
+	start := time.Now()
+	infos := NewRegistry(srcs, timeout).List()
+	require.Contains(t, infos["spin@1.0.0"].Error, "exceeded the execution timeout")
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	// So is reading versions of candidate files
+	start = time.Now()
+	_, _, err = Execute("env", "spin@1", 0, ExecuteParams{
+		TplDir:   baseDir,
+		WsDir:    filepath.Join(baseDir, ".tmp", "ws"),
+		MountDir: filepath.Join(baseDir, ".tmp", "mount"),
+		Redactor: lib.NewRedactor(),
+		Limits:   Limits{Timeout: timeout},
+	})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "No version of spin satisfies 1")
+	require.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,540 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"encoding/json"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/ast"
+	"github.com/robertkrimen/otto/file"
+	"github.com/robertkrimen/otto/parser"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+// Primitive parameter types, any of them can be turned into a list
+// by appending [], e.g. string[]
+var paramTypes = map[string]bool{
+	"any":     true,
+	"array":   true,
+	"base64":  true,
+	"bool":    true,
+	"boolean": true,
+	"float":   true,
+	"int":     true,
+	"integer": true,
+	"number":  true,
+	"object":  true,
+	"string":  true,
+}
+
+// Validate a parameter type declaration.
+// A type is either a primitive type name (optionally followed by one or
+// more []) or a JSON object/array describing the shape of a value
+func ParseParamType(t string) error {
+	t = strings.TrimSpace(t)
+
+	if t == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+		var shape interface{}
+
+		if err := json.Unmarshal([]byte(t), &shape); err != nil {
+			return errors.Wrapf(err, "Invalid type shape %s", t)
+		}
+
+		return nil
+	}
+
+	base := t
+
+	for strings.HasSuffix(base, "[]") {
+		base = strings.TrimSpace(strings.TrimSuffix(base, "[]"))
+	}
+
+	if !paramTypes[strings.ToLower(base)] {
+		return errors.Errorf("Unknown type: %s", t)
+	}
+
+	return nil
+}
+
+type lintTpl struct {
+	name    string
+	issues  []*def.TplLintIssue
+	imports []*lintImport
+}
+
+type lintImport struct {
+	name string
+	pos  *file.Position
+}
+
+func (lt *lintTpl) add(severity string, pos *file.Position,
+	format string, args ...interface{}) {
+
+	issue := &def.TplLintIssue{
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+
+	if pos != nil {
+		issue.Line = pos.Line
+		issue.Column = pos.Column
+	}
+
+	lt.issues = append(lt.issues, issue)
+}
+
+type lintDir struct {
+	ns  string
+	dir string
+}
+
+// Statically check all the templates in a directory.
+// Template code run to check info() is interrupted after timeout.
+// Returns template name -> list of found issues
+func Lint(baseDir string,
+	timeout time.Duration) (map[string][]*def.TplLintIssue, error) {
+
+	return lintDirs([]lintDir{{dir: baseDir}}, timeout)
+}
+
+func lintDirs(dirs []lintDir,
+	timeout time.Duration) (map[string][]*def.TplLintIssue, error) {
+
+	tpls := map[string]*lintTpl{}
+
+	for _, d := range dirs {
+		files, err := findTemplates(d.dir)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, f := range files {
+			lt := lintFile(d.dir, f, timeout)
+			lt.name = nsName(d.ns, lt.name)
+			tpls[lt.name] = lt
+		}
+	}
+
+	lintImports(tpls)
+
+	res := map[string][]*def.TplLintIssue{}
+
+	for name, lt := range tpls {
+		sort.SliceStable(lt.issues, func(i, j int) bool {
+			return lt.issues[i].Line < lt.issues[j].Line
+		})
+
+		res[name] = lt.issues
+
+		if res[name] == nil {
+			res[name] = []*def.TplLintIssue{}
+		}
+	}
+
+	return res, nil
+}
+
+func lintFile(baseDir, jsFile string, timeout time.Duration) *lintTpl {
+	lt := &lintTpl{
+		name: tplName(baseDir, jsFile),
+	}
+
+	src, err := ioutil.ReadFile(jsFile)
+
+	if err != nil {
+		lt.add(def.TplLintError, nil, "Error reading template: %s", err)
+
+		return lt
+	}
+
+	program, err := parser.ParseFile(nil, jsFile, src, 0)
+
+	if err != nil {
+		if list, ok := err.(parser.ErrorList); ok {
+			for _, e := range list {
+				pos := e.Position
+				lt.add(def.TplLintError, &pos, "Syntax error: %s", e.Message)
+			}
+		} else {
+			lt.add(def.TplLintError, nil, "Syntax error: %s", err)
+		}
+
+		return lt
+	}
+
+	declared := map[string]bool{}
+
+	for _, decl := range program.DeclarationList {
+		switch d := decl.(type) {
+		case *ast.FunctionDeclaration:
+			if d.Function.Name != nil {
+				declared[d.Function.Name.Name] = true
+			}
+		case *ast.VariableDeclaration:
+			for _, v := range d.List {
+				declared[v.Name] = true
+			}
+		}
+	}
+
+	if !declared[executeFunctionName] {
+		lt.add(def.TplLintError, nil, "Missing %s function", executeFunctionName)
+	}
+
+	var info *def.TplInfo
+
+	if !declared[infoFunctionName] {
+		lt.add(def.TplLintWarning, nil, "Missing %s function", infoFunctionName)
+	} else {
+		info = lintInfo(lt, src, timeout)
+	}
+
+	lintVersion(lt, info, jsFile)
+
+	dataDir := strings.TrimSuffix(jsFile, ".js") + ".data"
+
+	ast.Walk(&lintVisitor{
+		lt:      lt,
+		file:    program.File,
+		dataDir: dataDir,
+	}, program)
+
+	return lt
+}
+
+// Check that the template version is valid and matches the file name
+func lintVersion(lt *lintTpl, info *def.TplInfo, jsFile string) {
+	version := tplVersion(info, jsFile, defaultFs)
+
+	if version == "" {
+		return
+	}
+
+	if _, err := lib.ParseVersion(version); err != nil {
+		lt.add(def.TplLintError, nil, "Invalid version: %s", err)
+
+		return
+	}
+
+	_, fileVersion := SplitTplRef(filepath.Base(lt.name))
+
+	if fileVersion != "" && fileVersion != version {
+		lt.add(def.TplLintError, nil,
+			"Version %s does not match file name version %s",
+			version, fileVersion)
+	}
+}
+
+// Check info() structure and declared parameter types
+func lintInfo(lt *lintTpl, src []byte, timeout time.Duration) *def.TplInfo {
+	vm := otto.New()
+	setupLib(vm)
+
+	var rawInfo otto.Value
+
+	err := runWatched(vm, lt.name, timeout, func() error {
+		if _, err := vm.Run(src); err != nil {
+			return errors.Wrap(err, "Error executing template")
+		}
+
+		var err error
+
+		if rawInfo, err = vm.Call(infoFunctionName, nil); err != nil {
+			return errors.Wrapf(err, "Error calling %s function",
+				infoFunctionName)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		lt.add(def.TplLintError, nil, "%s", err)
+
+		return nil
+	}
+
+	exported, err := rawInfo.Export()
+
+	if err != nil {
+		lt.add(def.TplLintError, nil, "Error exporting info: %s", err)
+
+		return nil
+	}
+
+	infoMap, ok := exported.(map[string]interface{})
+
+	if !ok {
+		lt.add(def.TplLintError, nil,
+			"Expected %s to return an object but got: %T",
+			infoFunctionName, exported)
+
+		return nil
+	}
+
+	info := &def.TplInfo{}
+
+	dec, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused: true,
+		Result:      info,
+	})
+
+	if err := dec.Decode(infoMap); err != nil {
+		msg := err.Error()
+
+		if merr, ok := err.(*mapstructure.Error); ok {
+			msg = strings.Join(merr.Errors, "; ")
+		}
+
+		lt.add(def.TplLintError, nil, "Invalid %s structure: %s",
+			infoFunctionName, msg)
+
+		return nil
+	}
+
+	names := make([]string, 0, len(info.Parameters))
+
+	for name := range info.Parameters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		param := info.Parameters[name]
+
+		if param == nil {
+			lt.add(def.TplLintError, nil, "Parameter %s: empty declaration", name)
+
+			continue
+		}
+
+		if param.Type == "" {
+			lt.add(def.TplLintWarning, nil, "Parameter %s: missing type", name)
+		} else if err := ParseParamType(param.Type); err != nil {
+			lt.add(def.TplLintError, nil, "Parameter %s: %s", name, err)
+		}
+	}
+
+	return info
+}
+
+type lintVisitor struct {
+	lt      *lintTpl
+	file    *file.File
+	dataDir string
+}
+
+func (v *lintVisitor) Enter(n ast.Node) ast.Visitor {
+	call, ok := n.(*ast.CallExpression)
+
+	if !ok {
+		return v
+	}
+
+	var fname string
+
+	switch callee := call.Callee.(type) {
+	case *ast.Identifier:
+		fname = callee.Name
+	case *ast.DotExpression:
+		fname = callee.Identifier.Name
+	default:
+		return v
+	}
+
+	switch fname {
+	case "import_template":
+		if name, ok := stringArg(call, 0); ok {
+			v.lt.imports = append(v.lt.imports, &lintImport{
+				name: strings.TrimSpace(name),
+				pos:  v.file.Position(call.Idx0()),
+			})
+		}
+
+	case "MountData":
+		if hasOpt(call, 2, "skip-if-nonexistent") {
+			break
+		}
+
+		if name, ok := stringArg(call, 0); ok {
+			v.checkDataFile(name, call)
+		}
+
+	case "CopyDataToWorkspace":
+		for i := range call.ArgumentList {
+			if name, ok := stringArg(call, i); ok && name != "*" {
+				v.checkDataFile(name, call)
+			}
+		}
+	}
+
+	return v
+}
+
+func (v *lintVisitor) Exit(n ast.Node) {}
+
+func (v *lintVisitor) checkDataFile(name string, call *ast.CallExpression) {
+	path := filepath.Clean(filepath.Join(v.dataDir, name))
+
+	if _, err := os.Stat(path); err != nil {
+		v.lt.add(def.TplLintError, v.file.Position(call.Idx0()),
+			"Data file does not exist: %s", name)
+	}
+}
+
+func stringArg(call *ast.CallExpression, idx int) (string, bool) {
+	if len(call.ArgumentList) <= idx {
+		return "", false
+	}
+
+	lit, ok := call.ArgumentList[idx].(*ast.StringLiteral)
+
+	if !ok {
+		return "", false
+	}
+
+	return lit.Value, true
+}
+
+// Check if an object literal argument contains a key
+func hasOpt(call *ast.CallExpression, idx int, key string) bool {
+	if len(call.ArgumentList) <= idx {
+		return false
+	}
+
+	obj, ok := call.ArgumentList[idx].(*ast.ObjectLiteral)
+
+	if !ok {
+		return false
+	}
+
+	for _, prop := range obj.Value {
+		if prop.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check that statically known imports exist and do not form cycles
+func lintImports(tpls map[string]*lintTpl) {
+	for _, lt := range tpls {
+		for _, imp := range lt.imports {
+			if !hasLintTpl(tpls, imp.name) {
+				lt.add(def.TplLintError, imp.pos,
+					"Imported template not found: %s", imp.name)
+			}
+		}
+	}
+
+	for name, lt := range tpls {
+		if cycle := findImportCycle(tpls, name); cycle != nil {
+			var pos *file.Position
+
+			for _, imp := range lt.imports {
+				if imp.name == cycle[1] {
+					pos = imp.pos
+					break
+				}
+			}
+
+			lt.add(def.TplLintError, pos, "Import cycle: %s",
+				strings.Join(cycle, " -> "))
+		}
+	}
+}
+
+// Check if a template referenced as <name>[@<version>] exists
+func hasLintTpl(tpls map[string]*lintTpl, ref string) bool {
+	name, constraint := SplitTplRef(ref)
+
+	if _, ok := tpls[name]; ok && constraint == "" {
+		return true
+	}
+
+	for tplName := range tpls {
+		if n, _ := SplitTplRef(tplName); n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the shortest import path leading from a template back to itself
+func findImportCycle(tpls map[string]*lintTpl, start string) []string {
+	prev := map[string]string{}
+	queue := []string{start}
+	visited := map[string]bool{}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		lt, ok := tpls[cur]
+
+		if !ok {
+			continue
+		}
+
+		for _, imp := range lt.imports {
+			if imp.name == start {
+				path := []string{start}
+
+				for n := cur; n != start; n = prev[n] {
+					path = append([]string{n}, path...)
+				}
+
+				return append([]string{start}, path...)
+			}
+
+			if !visited[imp.name] {
+				visited[imp.name] = true
+				prev[imp.name] = cur
+				queue = append(queue, imp.name)
+			}
+		}
+	}
+
+	return nil
+}
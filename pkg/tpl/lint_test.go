@@ -0,0 +1,116 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+func TestParseParamType(t *testing.T) {
+	for _, ok := range []string{"", "string", "number[]", "Object",
+		"string[][]", `{"db": ["query"]}`, `["string"]`} {
+		require.Nil(t, ParseParamType(ok), ok)
+	}
+
+	for _, bad := range []string{"strng", "[string", "{invalid", "string[", "list of strings"} {
+		require.NotNil(t, ParseParamType(bad), bad)
+	}
+}
+
+func TestLint(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	res, err := Lint(filepath.Join(cwd, "testdata", "lint"),
+		500*time.Millisecond)
+	require.Nil(t, err)
+
+	messages := func(name, severity string) []string {
+		var msgs []string
+
+		for _, issue := range res[name] {
+			if issue.Severity == severity {
+				msgs = append(msgs, issue.Message)
+			}
+		}
+
+		return msgs
+	}
+
+	contains := func(msgs []string, substr string) bool {
+		for _, msg := range msgs {
+			if strings.Contains(msg, substr) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	require.Len(t, res, 9)
+	require.Empty(t, res["ok"])
+
+	require.NotEmpty(t, res["syntax"])
+	require.Equal(t, 2, res["syntax"][0].Line)
+	require.Contains(t, res["syntax"][0].Message, "Syntax error")
+
+	require.Equal(t, []string{"Missing execute function"},
+		messages("noexec", def.TplLintError))
+
+	require.True(t, contains(messages("badinfo", def.TplLintError), "params"))
+
+	errs := messages("badtype", def.TplLintError)
+	require.Len(t, errs, 2)
+	require.True(t, contains(errs, "p1: Unknown type"))
+	require.True(t, contains(errs, "p2: Invalid type shape"))
+	require.Equal(t, []string{"Parameter p3: missing type"},
+		messages("badtype", def.TplLintWarning))
+
+	errs = messages("nodata", def.TplLintError)
+	require.Len(t, errs, 2)
+	require.True(t, contains(errs, "missing-ws"))
+	require.True(t, contains(errs, "missing-mount"))
+	require.Equal(t, []string{"Missing info function"},
+		messages("nodata", def.TplLintWarning))
+
+	errs = messages("loop", def.TplLintError)
+	require.Len(t, errs, 1)
+	require.True(t, contains(errs, "exceeded the execution timeout"))
+
+	require.Equal(t, []string{"Import cycle: cycle-a -> cycle-b -> cycle-a"},
+		messages("cycle-a", def.TplLintError))
+
+	errs = messages("cycle-b", def.TplLintError)
+	require.Len(t, errs, 2)
+	require.True(t, contains(errs, "Imported template not found: missing"))
+	require.True(t, contains(errs, "Import cycle: cycle-b -> cycle-a -> cycle-b"))
+}
@@ -0,0 +1,99 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Network every container is attached to unless attached
+// to some other networks explicitly
+const DefaultNetwork = "default"
+
+var networkNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func ensureNetworkName(name string) {
+	if !networkNameRe.MatchString(name) {
+		panic(errors.Errorf("Invalid network name: %q", name))
+	}
+}
+
+// Declare a named env network.
+// Networks are shared by all the templates within an env,
+// so declaring the same network in several templates is fine
+func (tpl *Tpl) Network(name string) string {
+	checkCancelled(tpl.ctx)
+	ensureNetworkName(name)
+
+	tpl.Lock()
+	defer tpl.Unlock()
+
+	if name == DefaultNetwork {
+		return name
+	}
+
+	for _, n := range tpl.networks {
+		if n == name {
+			return name
+		}
+	}
+
+	tpl.networks = append(tpl.networks, name)
+
+	return name
+}
+
+// Networks declared by the template
+func (tpl *Tpl) GetNetworks() []string {
+	return tpl.networks
+}
+
+// Attach container to a network declared with Network().
+// Once attached to any network, the container is no longer attached
+// to the default one, unless it's attached explicitly as well.
+// The first attached network is the primary one
+func (cont *Container) AttachNetwork(name string) {
+	checkCancelled(cont.ctx)
+	ensureNetworkName(name)
+
+	for _, n := range cont.networks {
+		if n == name {
+			return
+		}
+	}
+
+	cont.networks = append(cont.networks, name)
+}
+
+// Networks the container is attached to, the primary one first
+func (cont *Container) Networks() []string {
+	if len(cont.networks) == 0 {
+		return []string{DefaultNetwork}
+	}
+
+	return cont.networks
+}
@@ -0,0 +1,92 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+func TestNetworks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "xenvman-networks-")
+	require.Nil(t, err)
+
+	defer os.RemoveAll(tmpDir)
+
+	execute := func(src string) (*Tpl, error) {
+		fs, err := NewInlineFs("net", &def.InlineTpl{Source: src})
+		require.Nil(t, err)
+
+		t, _, err := Execute("env", "net", 0, ExecuteParams{
+			TplDir:   InlineTplDir,
+			WsDir:    filepath.Join(tmpDir, "ws"),
+			MountDir: filepath.Join(tmpDir, "mount"),
+			Redactor: lib.NewRedactor(),
+			Fs:       fs,
+		})
+
+		return t, err
+	}
+
+	tpl, err := execute(`
+function execute(tpl, params) {
+  var back = tpl.Network("back");
+  tpl.Network("front");
+  tpl.Network("back");
+  tpl.Network("default");
+
+  var img = tpl.FetchImage("image");
+
+  var web = img.NewContainer("web");
+  web.AttachNetwork("front");
+  web.AttachNetwork(back);
+  web.AttachNetwork("front");
+
+  img.NewContainer("db");
+}`)
+	require.Nil(t, err)
+	require.Equal(t, []string{"back", "front"}, tpl.GetNetworks())
+
+	conts := tpl.GetFetchImages()[0].Containers()
+	require.Equal(t, []string{"front", "back"}, conts["web"].Networks())
+	require.Equal(t, []string{DefaultNetwork}, conts["db"].Networks())
+
+	_, err = execute(`function execute(tpl, params) { tpl.Network("no spaces"); }`)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Invalid network name")
+
+	_, err = execute(`
+function execute(tpl, params) {
+  tpl.FetchImage("image").NewContainer("c").AttachNetwork("");
+}`)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Invalid network name")
+}
@@ -68,3 +68,48 @@ func (o Opts) GetObject(key string, def map[string]interface{}) map[string]inter
 		return def
 	}
 }
+
+func (o Opts) GetString(key string, def string) string {
+	if v, ok := o[key]; ok {
+		if strv, ok := v.(string); !ok {
+			panic(errors.Errorf("Invalid type for opt %s, expected string got %T",
+				key, v))
+		} else {
+			return strv
+		}
+	} else {
+		return def
+	}
+}
+
+func (o Opts) GetListOfStrings(key string, def []string) []string {
+	v, ok := o[key]
+
+	if !ok {
+		return def
+	}
+
+	switch vv := v.(type) {
+	case []string:
+		return vv
+	case []interface{}:
+		res := make([]string, len(vv))
+
+		for i, item := range vv {
+			s, ok := item.(string)
+
+			if !ok {
+				panic(errors.Errorf(
+					"Invalid type for opt %s, expected list of strings got %T item",
+					key, item))
+			}
+
+			res[i] = s
+		}
+
+		return res
+	default:
+		panic(errors.Errorf("Invalid type for opt %s, expected list of strings got %T",
+			key, v))
+	}
+}
@@ -32,5 +32,7 @@ import (
 type ReadinessCheck interface {
 	InterpolateParameters(data interface{}) error
 	Wait(ctx context.Context, success bool) bool
+	// Parameters as supplied by a template
+	Parameters() map[string]interface{}
 	fmt.Stringer
 }
@@ -50,8 +50,9 @@ func readinessCheckHttpInit(params map[string]interface{}) ReadinessCheck {
 	}
 
 	return &readinessCheckHttp{
-		params:  *httpParams,
-		headers: make([]map[string]*regexp.Regexp, len(httpParams.Headers)),
+		params:    *httpParams,
+		rawParams: params,
+		headers:   make([]map[string]*regexp.Regexp, len(httpParams.Headers)),
 	}
 }
 
@@ -70,6 +71,7 @@ type readinessCheckHttpParams struct {
 
 type readinessCheckHttp struct {
 	params        readinessCheckHttpParams
+	rawParams     map[string]interface{}
 	body          *regexp.Regexp
 	headers       []map[string]*regexp.Regexp
 	retryInterval time.Duration
@@ -208,6 +210,10 @@ func (rh *readinessCheckHttp) Wait(ctx context.Context, success bool) bool {
 	return false
 }
 
+func (rh *readinessCheckHttp) Parameters() map[string]interface{} {
+	return rh.rawParams
+}
+
 func (rh *readinessCheckHttp) String() string {
 	return "http"
 }
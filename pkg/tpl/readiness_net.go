@@ -47,7 +47,8 @@ func readinessCheckNetInit(params map[string]interface{}) ReadinessCheck {
 	}
 
 	return &readinessCheckNet{
-		params: *tcpParams,
+		params:    *tcpParams,
+		rawParams: params,
 	}
 }
 
@@ -60,6 +61,7 @@ type readinessCheckNetParams struct {
 
 type readinessCheckNet struct {
 	params        readinessCheckNetParams
+	rawParams     map[string]interface{}
 	retryInterval time.Duration
 }
 
@@ -119,6 +121,10 @@ func (rnet *readinessCheckNet) Wait(ctx context.Context, success bool) bool {
 	return false
 }
 
+func (rnet *readinessCheckNet) Parameters() map[string]interface{} {
+	return rnet.rawParams
+}
+
 func (rnet *readinessCheckNet) String() string {
 	return "net"
 }
@@ -0,0 +1,415 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/robertkrimen/otto"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/logger"
+)
+
+var registryLog = logger.GetLogger("xenvman.pkg.tpl.registry")
+
+// Registry caches template info and compiled programs for all
+// the template sources. Files are only reloaded when their modification
+// time or size change. Watched directories are rescanned only after
+// a change notification, the others are rescanned on every access.
+type Registry struct {
+	sources *Sources
+	// Template top-level code execution timeout
+	timeout time.Duration
+	// Template source dir -> cached templates
+	dirs map[string]*registryDir
+	sync.Mutex
+}
+
+type registryDir struct {
+	dir     string
+	timeout time.Duration
+	entries map[string]*registryEntry // Template file -> entry
+	watched bool
+	dirty   bool
+	sync.Mutex
+}
+
+type registryEntry struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	program *otto.Script
+	// Info as returned by info(), nil if not defined
+	rawInfo *def.TplInfo
+	// Info with version and data dir filled in
+	info *def.TplInfo
+	err  error
+}
+
+func NewRegistry(sources *Sources, timeout time.Duration) *Registry {
+	return &Registry{
+		sources: sources,
+		timeout: timeout,
+		dirs:    map[string]*registryDir{},
+	}
+}
+
+// List info for all the templates, templates which cannot be loaded
+// are reported with the error field set
+func (r *Registry) List() map[string]*def.TplInfo {
+	res := map[string]*def.TplInfo{}
+
+	for _, src := range r.currentDirs() {
+		for name, info := range src.rd.scan() {
+			res[nsName(src.ns, name)] = info
+		}
+	}
+
+	return res
+}
+
+// Get info for a single template
+func (r *Registry) Get(name string) (*def.TplInfo, error) {
+	dir, rel, err := r.sources.Resolve(name)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	jsFile, _, err := getTplPaths(rel, dir)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	info, ok := r.dir(dir).scan()[tplName(dir, jsFile)]
+
+	if !ok {
+		return nil, ErrTplNotFound
+	}
+
+	return info, nil
+}
+
+// Return a compiled template program if it is cached and up to date
+func (r *Registry) Program(jsFile string) *otto.Script {
+	r.Lock()
+	var rd *registryDir
+
+	for dir, d := range r.dirs {
+		if strings.HasPrefix(jsFile, dir+string(filepath.Separator)) {
+			rd = d
+			break
+		}
+	}
+	r.Unlock()
+
+	if rd == nil {
+		return nil
+	}
+
+	st, err := os.Stat(jsFile)
+
+	if err != nil {
+		return nil
+	}
+
+	rd.Lock()
+	defer rd.Unlock()
+
+	e, ok := rd.entries[jsFile]
+
+	if !ok || e.err != nil || !e.modTime.Equal(st.ModTime()) ||
+		e.size != st.Size() {
+		return nil
+	}
+
+	return e.program
+}
+
+// Mark a directory as changed, so it is rescanned on the next access
+func (r *Registry) Invalidate(dir string) {
+	r.dir(dir).markDirty()
+}
+
+// Watch a template directory for changes until ctx is done
+func (r *Registry) Watch(ctx context.Context, dir string) error {
+	dir = filepath.Clean(dir)
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return errors.Wrap(err, "Error creating watcher")
+	}
+
+	if err := watchTree(watcher, dir); err != nil {
+		_ = watcher.Close()
+
+		return errors.WithStack(err)
+	}
+
+	rd := r.dir(dir)
+
+	rd.Lock()
+	rd.watched = true
+	rd.dirty = true
+	rd.Unlock()
+
+	go func() {
+		//noinspection GoUnhandledErrorResult
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-watcher.Events:
+				if ev.Op&fsnotify.Create != 0 {
+					if st, err := os.Stat(ev.Name); err == nil && st.IsDir() {
+						if err := watchTree(watcher, ev.Name); err != nil {
+							registryLog.Warningf("Error watching %s: %s",
+								ev.Name, err)
+						}
+					}
+				}
+
+				rd.markDirty()
+			case err := <-watcher.Errors:
+				registryLog.Warningf("Watcher error for %s: %s", dir, err)
+
+				// Events might have been lost
+				rd.markDirty()
+			}
+		}
+	}()
+
+	return nil
+}
+
+type nsDir struct {
+	ns string
+	rd *registryDir
+}
+
+// Registry dirs for currently available sources,
+// dirs of no longer used source revisions are dropped
+func (r *Registry) currentDirs() []nsDir {
+	var res []nsDir
+
+	used := map[string]bool{}
+
+	for _, src := range r.sources.list {
+		dir := src.Dir()
+
+		if dir == "" {
+			continue
+		}
+
+		res = append(res, nsDir{ns: src.Namespace(), rd: r.dir(dir)})
+		used[filepath.Clean(dir)] = true
+	}
+
+	r.Lock()
+	for dir := range r.dirs {
+		if !used[dir] {
+			delete(r.dirs, dir)
+		}
+	}
+	r.Unlock()
+
+	return res
+}
+
+func (r *Registry) dir(dir string) *registryDir {
+	dir = filepath.Clean(dir)
+
+	r.Lock()
+	defer r.Unlock()
+
+	rd, ok := r.dirs[dir]
+
+	if !ok {
+		rd = &registryDir{
+			dir:     dir,
+			timeout: r.timeout,
+			entries: map[string]*registryEntry{},
+			dirty:   true,
+		}
+
+		r.dirs[dir] = rd
+	}
+
+	return rd
+}
+
+func (rd *registryDir) markDirty() {
+	rd.Lock()
+	rd.dirty = true
+	rd.Unlock()
+}
+
+// Bring the cache up to date if needed and return template name -> info
+func (rd *registryDir) scan() map[string]*def.TplInfo {
+	rd.Lock()
+	defer rd.Unlock()
+
+	if rd.dirty || !rd.watched {
+		// Clear the flag first, so changes made during the scan
+		// cause another one
+		rd.dirty = false
+
+		if err := rd.update(); err != nil {
+			registryLog.Errorf("Error scanning %s: %s", rd.dir, err)
+
+			rd.dirty = true
+		}
+	}
+
+	res := map[string]*def.TplInfo{}
+
+	for file, e := range rd.entries {
+		if e.err != nil {
+			res[tplName(rd.dir, file)] = &def.TplInfo{
+				DataDir: []string{},
+				Error:   e.err.Error(),
+			}
+		} else if e.info != nil {
+			res[tplName(rd.dir, file)] = e.info
+		}
+	}
+
+	return res
+}
+
+func (rd *registryDir) update() error {
+	files, err := findTemplates(rd.dir)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	entries := make(map[string]*registryEntry, len(files))
+
+	for _, file := range files {
+		st, err := os.Stat(file)
+
+		if err != nil {
+			continue
+		}
+
+		e, ok := rd.entries[file]
+
+		if !ok || !e.modTime.Equal(st.ModTime()) || e.size != st.Size() {
+			e = loadEntry(file, st, e, rd.timeout)
+		}
+
+		// Version sidecar and data dir can change independently
+		if e.rawInfo != nil {
+			info := *e.rawInfo
+			info.Version = tplVersion(e.rawInfo, file, defaultFs)
+
+			dataDir := strings.TrimSuffix(file, ".js") + ".data/"
+
+			if _, err := os.Stat(dataDir); err == nil {
+				info.DataDir = loadDataDir(dataDir)
+			}
+
+			e.info = &info
+		}
+
+		entries[file] = e
+	}
+
+	rd.entries = entries
+
+	return nil
+}
+
+// Load a template file, reusing compiled program
+// of the previous entry if the contents did not change
+func loadEntry(file string, st os.FileInfo, prev *registryEntry,
+	timeout time.Duration) *registryEntry {
+	e := &registryEntry{
+		modTime: st.ModTime(),
+		size:    st.Size(),
+	}
+
+	src, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		e.err = errors.Wrapf(err, "Error reading tpl %s", file)
+
+		return e
+	}
+
+	e.hash = sha256.Sum256(src)
+
+	if prev != nil && prev.err == nil && prev.hash == e.hash {
+		e.program = prev.program
+		e.rawInfo = prev.rawInfo
+
+		return e
+	}
+
+	registryLog.Debugf("Loading tpl %s", file)
+
+	vm := otto.New()
+
+	if e.program, e.err = vm.Compile(file, src); e.err != nil {
+		return e
+	}
+
+	e.rawInfo, e.err = runInfo(vm, e.program, file, timeout)
+
+	return e
+}
+
+// Add a directory and all its non-hidden subdirectories to a watcher
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			return filepath.SkipDir
+		}
+
+		return errors.Wrapf(watcher.Add(path), "Error watching %s", path)
+	})
+}
@@ -0,0 +1,157 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+func registryTestTpl(desc string) []byte {
+	return []byte(fmt.Sprintf(`
+function info() {
+  return {description: "%s"};
+}
+
+function execute(tpl, params) {
+  tpl.FetchImage("%s");
+}
+`, desc, desc))
+}
+
+func TestRegistry(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "xenvman-registry-")
+	require.Nil(t, err)
+	defer os.RemoveAll(baseDir)
+
+	appFile := filepath.Join(baseDir, "app.tpl.js")
+
+	require.Nil(t, ioutil.WriteFile(appFile, registryTestTpl("v1"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(baseDir, "broken.tpl.js"),
+		[]byte("function info( {"), 0644))
+
+	srcs, err := NewSources(NewLocalSource("", baseDir))
+	require.Nil(t, err)
+
+	reg := NewRegistry(srcs, 0)
+
+	infos := reg.List()
+	require.Len(t, infos, 2)
+	require.Equal(t, "v1", infos["app"].Description)
+	require.Empty(t, infos["app"].Error)
+	require.NotEmpty(t, infos["broken"].Error)
+
+	info, err := reg.Get("app")
+	require.Nil(t, err)
+	require.Equal(t, "v1", info.Description)
+
+	_, err = reg.Get("missing")
+	require.Equal(t, ErrTplNotFound, err)
+
+	// Unchanged files are not reloaded
+	program := reg.Program(appFile)
+	require.NotNil(t, program)
+
+	reg.List()
+	require.True(t, program == reg.Program(appFile))
+
+	// Changed files are
+	require.Nil(t, ioutil.WriteFile(appFile, registryTestTpl("v2"), 0644))
+	future := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(appFile, future, future))
+
+	require.Nil(t, reg.Program(appFile))
+	require.Equal(t, "v2", reg.List()["app"].Description)
+	require.NotNil(t, reg.Program(appFile))
+
+	// Cached program is used for execution
+	tmpDir := filepath.Join(baseDir, ".tmp")
+
+	tpl, _, err := Execute("env", "app", 0, ExecuteParams{
+		Sources:  srcs,
+		Registry: reg,
+		WsDir:    filepath.Join(tmpDir, "ws"),
+		MountDir: filepath.Join(tmpDir, "mount"),
+		Redactor: lib.NewRedactor(),
+	})
+	require.Nil(t, err)
+	require.Equal(t, "v2", tpl.GetFetchImages()[0].name)
+}
+
+func TestRegistryWatch(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "xenvman-registry-")
+	require.Nil(t, err)
+	defer os.RemoveAll(baseDir)
+
+	srcs, err := NewSources(NewLocalSource("", baseDir))
+	require.Nil(t, err)
+
+	reg := NewRegistry(srcs, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.Nil(t, reg.Watch(ctx, baseDir))
+	require.Empty(t, reg.List())
+
+	waitFor := func(cond func() bool) {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return
+			}
+
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		t.Fatal("Timeout waiting for registry update")
+	}
+
+	// New directories are watched as well
+	subDir := filepath.Join(baseDir, "db")
+	require.Nil(t, os.Mkdir(subDir, 0755))
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(subDir, "pg.tpl.js"),
+		registryTestTpl("pg"), 0644))
+
+	waitFor(func() bool {
+		info, ok := reg.List()["db/pg"]
+
+		return ok && info.Description == "pg"
+	})
+
+	require.Nil(t, os.Remove(filepath.Join(subDir, "pg.tpl.js")))
+
+	waitFor(func() bool {
+		return len(reg.List()) == 0
+	})
+}
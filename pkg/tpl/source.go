@@ -0,0 +1,374 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/logger"
+)
+
+var sourceLog = logger.GetLogger("xenvman.pkg.tpl.source")
+
+const (
+	SourceLocal = "local"
+	SourceGit   = "git"
+	SourceHttp  = "http"
+)
+
+// Source of templates
+type Source interface {
+	// Templates are available as <namespace>/<name>,
+	// empty namespace means no prefix
+	Namespace() string
+	// Local directory with templates, empty if not fetched yet
+	Dir() string
+	// Currently used revision
+	Revision() string
+	// Fetch the latest content
+	Refresh(ctx context.Context) error
+	// Source type
+	String() string
+}
+
+type SourceConfig struct {
+	Type      string `mapstructure:"type"`
+	Namespace string `mapstructure:"namespace"`
+	// Local directory, local sources only
+	Path string `mapstructure:"path"`
+	// Repo or tarball url
+	Url string `mapstructure:"url"`
+	// Git branch, tag or commit
+	Ref string `mapstructure:"ref"`
+	// Expected tarball checksum
+	Sha256 string `mapstructure:"sha256"`
+	// Number of leading path components to strip from tarball entries
+	StripComponents int `mapstructure:"strip_components"`
+	// Directory within a repo or tarball containing templates
+	Subdir string `mapstructure:"subdir"`
+}
+
+// Create a new template source, remote sources are cached in cacheDir
+func NewSource(cfg SourceConfig, cacheDir string) (Source, error) {
+	ns := strings.Trim(cfg.Namespace, "/")
+
+	if strings.Contains(ns, "..") {
+		return nil, errors.Errorf("Namespace must not contain '..': %s", ns)
+	}
+
+	subdir := filepath.Clean("/" + cfg.Subdir)
+
+	cache := filepath.Join(cacheDir, strings.Replace(ns, "/", "_", -1))
+
+	switch cfg.Type {
+	case SourceLocal, "":
+		if cfg.Path == "" {
+			return nil, errors.Errorf("path is required for local source %s", ns)
+		}
+
+		return NewLocalSource(ns, cfg.Path), nil
+
+	case SourceGit:
+		if ns == "" || cfg.Url == "" {
+			return nil, errors.New("namespace and url are required for git source")
+		}
+
+		ref := cfg.Ref
+
+		if ref == "" {
+			ref = "HEAD"
+		}
+
+		return &gitSource{
+			remoteSource: remoteSource{ns: ns, subdir: subdir, cacheDir: cache},
+			url:          cfg.Url,
+			ref:          ref,
+		}, nil
+
+	case SourceHttp:
+		if ns == "" || cfg.Url == "" {
+			return nil, errors.New("namespace and url are required for http source")
+		}
+
+		return &httpSource{
+			remoteSource: remoteSource{ns: ns, subdir: subdir, cacheDir: cache},
+			url:          cfg.Url,
+			sha256:       strings.ToLower(cfg.Sha256),
+			strip:        cfg.StripComponents,
+		}, nil
+
+	default:
+		return nil, errors.Errorf("Unknown template source type: %s", cfg.Type)
+	}
+}
+
+type localSource struct {
+	ns  string
+	dir string
+}
+
+func NewLocalSource(ns, dir string) Source {
+	return &localSource{ns: ns, dir: dir}
+}
+
+func (ls *localSource) Namespace() string                 { return ls.ns }
+func (ls *localSource) Dir() string                       { return ls.dir }
+func (ls *localSource) Revision() string                  { return "" }
+func (ls *localSource) Refresh(ctx context.Context) error { return nil }
+func (ls *localSource) String() string                    { return SourceLocal }
+
+// Common part of sources fetched into a local cache.
+// Every revision is extracted into its own directory so that
+// a refresh never modifies templates which might be in use.
+type remoteSource struct {
+	ns       string
+	subdir   string
+	cacheDir string
+	dir      string
+	prevDir  string
+	rev      string
+	sync.RWMutex
+}
+
+func (rs *remoteSource) Namespace() string {
+	return rs.ns
+}
+
+func (rs *remoteSource) Dir() string {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	if rs.dir == "" {
+		return ""
+	}
+
+	return filepath.Join(rs.dir, rs.subdir)
+}
+
+func (rs *remoteSource) Revision() string {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	return rs.rev
+}
+
+func (rs *remoteSource) revDir(rev string) string {
+	return filepath.Join(rs.cacheDir, rev)
+}
+
+// Switch to a new revision, the one before the previous is removed
+func (rs *remoteSource) swap(rev, dir string) {
+	rs.Lock()
+	old := rs.prevDir
+
+	rs.prevDir = rs.dir
+	rs.dir = dir
+	rs.rev = rev
+	rs.Unlock()
+
+	if old != "" && old != dir {
+		_ = os.RemoveAll(old)
+	}
+
+	sourceLog.Infof("Template source %s switched to revision %s", rs.ns, rev)
+}
+
+// Set of template sources
+type Sources struct {
+	list   []Source
+	errors map[string]string
+	sync.RWMutex
+}
+
+func NewSources(srcs ...Source) (*Sources, error) {
+	seen := map[string]bool{}
+
+	for _, src := range srcs {
+		if seen[src.Namespace()] {
+			return nil, errors.Errorf("Duplicate template namespace: %q",
+				src.Namespace())
+		}
+
+		seen[src.Namespace()] = true
+	}
+
+	list := append([]Source{}, srcs...)
+
+	// Longest namespace wins
+	sort.SliceStable(list, func(i, j int) bool {
+		return len(list[i].Namespace()) > len(list[j].Namespace())
+	})
+
+	return &Sources{
+		list:   list,
+		errors: map[string]string{},
+	}, nil
+}
+
+// Find a source for a template and return its directory
+// along with template name relative to it
+func (s *Sources) Resolve(tpl string) (string, string, error) {
+	name := strings.TrimLeft(strings.TrimSpace(tpl), "/")
+
+	for _, src := range s.list {
+		ns := src.Namespace()
+		rel := name
+
+		if ns != "" {
+			if !strings.HasPrefix(name, ns+"/") {
+				continue
+			}
+
+			rel = strings.TrimPrefix(name, ns+"/")
+		}
+
+		dir := src.Dir()
+
+		if dir == "" {
+			return "", "", errors.Errorf(
+				"Template source %s is not available yet", ns)
+		}
+
+		return dir, rel, nil
+	}
+
+	return "", "", errors.Errorf("No template source found for %s", tpl)
+}
+
+// Refresh all the sources
+func (s *Sources) Refresh(ctx context.Context) []*def.TplSource {
+	for _, src := range s.list {
+		err := src.Refresh(ctx)
+
+		s.Lock()
+		if err != nil {
+			sourceLog.Errorf("Error refreshing template source %s: %+v",
+				src.Namespace(), err)
+
+			s.errors[src.Namespace()] = err.Error()
+		} else {
+			delete(s.errors, src.Namespace())
+		}
+		s.Unlock()
+	}
+
+	return s.Status()
+}
+
+func (s *Sources) Status() []*def.TplSource {
+	s.RLock()
+	defer s.RUnlock()
+
+	res := make([]*def.TplSource, 0, len(s.list))
+
+	for _, src := range s.list {
+		res = append(res, &def.TplSource{
+			Namespace: src.Namespace(),
+			Type:      src.String(),
+			Revision:  src.Revision(),
+			Error:     s.errors[src.Namespace()],
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Namespace < res[j].Namespace
+	})
+
+	return res
+}
+
+// Periodically refresh sources until ctx is done
+func (s *Sources) RunRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Refresh(ctx)
+		}
+	}
+}
+
+// Load info for templates from all the sources
+func (s *Sources) LoadTemplatesInfo(
+	timeout time.Duration) (map[string]*def.TplInfo, error) {
+
+	res := map[string]*def.TplInfo{}
+
+	for _, src := range s.list {
+		dir := src.Dir()
+
+		if dir == "" {
+			continue
+		}
+
+		infos, err := LoadTemplatesInfo(dir, timeout)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for name, info := range infos {
+			res[nsName(src.Namespace(), name)] = info
+		}
+	}
+
+	return res, nil
+}
+
+// Lint templates from all the sources
+func (s *Sources) Lint(
+	timeout time.Duration) (map[string][]*def.TplLintIssue, error) {
+
+	var dirs []lintDir
+
+	for _, src := range s.list {
+		if dir := src.Dir(); dir != "" {
+			dirs = append(dirs, lintDir{ns: src.Namespace(), dir: dir})
+		}
+	}
+
+	return lintDirs(dirs, timeout)
+}
+
+func nsName(ns, name string) string {
+	if ns == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s/%s", ns, name)
+}
@@ -0,0 +1,153 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+// Templates from a git repo at a given ref.
+// The repo is cloned once and fetched on every refresh,
+// the ref is then resolved to a commit which is exported
+// into a separate directory
+type gitSource struct {
+	remoteSource
+	url string
+	ref string
+}
+
+func (gs *gitSource) String() string {
+	return SourceGit
+}
+
+func (gs *gitSource) Refresh(ctx context.Context) error {
+	repo := filepath.Join(gs.cacheDir, "repo")
+
+	if _, err := os.Stat(repo); os.IsNotExist(err) {
+		if err := os.MkdirAll(gs.cacheDir, 0755); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if _, err := git(ctx, "", "clone", "--quiet", "--no-checkout",
+			gs.url, repo); err != nil {
+			return errors.Wrapf(err, "Error cloning %s", gs.url)
+		}
+	} else {
+		if _, err := git(ctx, repo, "fetch", "--quiet", "--tags", "--force",
+			"origin", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return errors.Wrapf(err, "Error fetching %s", gs.url)
+		}
+	}
+
+	rev, err := gs.resolve(ctx, repo)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if rev == gs.Revision() {
+		return nil
+	}
+
+	dir := gs.revDir(rev)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := gs.export(ctx, repo, rev, dir); err != nil {
+			_ = os.RemoveAll(dir)
+
+			return errors.WithStack(err)
+		}
+	}
+
+	gs.swap(rev, dir)
+
+	return nil
+}
+
+// Resolve ref to a commit, branches are looked up in origin first
+func (gs *gitSource) resolve(ctx context.Context, repo string) (string, error) {
+	for _, ref := range []string{"origin/" + gs.ref, gs.ref} {
+		out, err := git(ctx, repo, "rev-parse", "--verify", "--quiet",
+			ref+"^{commit}")
+
+		if err == nil {
+			return strings.TrimSpace(out), nil
+		}
+	}
+
+	return "", errors.Errorf("Unable to resolve ref %s in %s", gs.ref, gs.url)
+}
+
+func (gs *gitSource) export(ctx context.Context, repo, rev, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "archive", "--format=tar", rev)
+	cmd.Dir = repo
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "Error running git archive")
+	}
+
+	extractErr := lib.ExtractTar(out, dir, 0)
+
+	if err := cmd.Wait(); err != nil {
+		return errors.Wrapf(err, "Error running git archive: %s",
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return errors.WithStack(extractErr)
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Errorf("git %s: %s: %s", args[0], err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,107 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+// Templates from a (optionally gzipped) tarball served over HTTP(S)
+type httpSource struct {
+	remoteSource
+	url    string
+	sha256 string
+	strip  int
+}
+
+func (hs *httpSource) String() string {
+	return SourceHttp
+}
+
+func (hs *httpSource) Refresh(ctx context.Context) error {
+	// Pinned content never changes
+	if hs.sha256 != "" && hs.sha256 == hs.Revision() {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, hs.url, nil)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return errors.Wrapf(err, "Error fetching %s", hs.url)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Unexpected HTTP response from %s: %s",
+			hs.url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error reading %s", hs.url)
+	}
+
+	rev := fmt.Sprintf("%x", sha256.Sum256(body))
+
+	if hs.sha256 != "" && rev != hs.sha256 {
+		return errors.Errorf("Checksum mismatch for %s: expected %s, got %s",
+			hs.url, hs.sha256, rev)
+	}
+
+	if rev == hs.Revision() {
+		return nil
+	}
+
+	dir := hs.revDir(rev)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := lib.ExtractTar(bytes.NewReader(body), dir, hs.strip); err != nil {
+			_ = os.RemoveAll(dir)
+
+			return errors.Wrapf(err, "Error extracting %s", hs.url)
+		}
+	}
+
+	hs.swap(rev, dir)
+
+	return nil
+}
@@ -0,0 +1,256 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+const sourceTpl = `function info() { return {description: "%s"}; }
+function execute(tpl, params) {}
+`
+
+func TestSourcesResolve(t *testing.T) {
+	srcs, err := NewSources(
+		NewLocalSource("", "/base"),
+		NewLocalSource("payments", "/payments"),
+		NewLocalSource("payments/kafka", "/kafka"),
+	)
+	require.Nil(t, err)
+
+	cases := []struct{ tpl, dir, name string }{
+		{"db/mongo", "/base", "db/mongo"},
+		{"/db/mongo", "/base", "db/mongo"},
+		{"payments/api", "/payments", "api"},
+		{"payments/kafka/broker", "/kafka", "broker"},
+		{"paymentsx/api", "/base", "paymentsx/api"},
+	}
+
+	for _, c := range cases {
+		dir, name, err := srcs.Resolve(c.tpl)
+		require.Nil(t, err, c.tpl)
+		require.Equal(t, c.dir, dir, c.tpl)
+		require.Equal(t, c.name, name, c.tpl)
+	}
+
+	_, err = NewSources(NewLocalSource("a", "/a"), NewLocalSource("a", "/b"))
+	require.NotNil(t, err)
+
+	srcs, err = NewSources(NewLocalSource("payments", "/payments"))
+	require.Nil(t, err)
+
+	_, _, err = srcs.Resolve("db/mongo")
+	require.NotNil(t, err)
+}
+
+func TestGitSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	work := filepath.Join(tmpDir, "work")
+	bare := filepath.Join(tmpDir, "bare.git")
+
+	run := func(dir string, args ...string) {
+		args = append([]string{"-c", "user.name=test",
+			"-c", "user.email=test@example.com"}, args...)
+
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		require.Nil(t, err, string(out))
+	}
+
+	commit := func(desc string) {
+		require.Nil(t, ioutil.WriteFile(filepath.Join(work, "tpl", "kafka.tpl.js"),
+			[]byte(fmt.Sprintf(sourceTpl, desc)), 0644))
+
+		run(work, "add", "-A")
+		run(work, "commit", "-q", "-m", desc)
+	}
+
+	require.Nil(t, os.MkdirAll(filepath.Join(work, "tpl"), 0755))
+	run(work, "init", "-q")
+	run(work, "checkout", "-q", "-b", "main")
+	commit("v1")
+	run(work, "tag", "v1")
+	run(tmpDir, "clone", "-q", "--bare", work, bare)
+
+	newSrc := func(ns, ref string) Source {
+		src, err := NewSource(SourceConfig{
+			Type:      SourceGit,
+			Namespace: ns,
+			Url:       bare,
+			Ref:       ref,
+			Subdir:    "tpl",
+		}, filepath.Join(tmpDir, "cache", ref))
+		require.Nil(t, err)
+
+		return src
+	}
+
+	branch := newSrc("payments", "main")
+	tag := newSrc("payments-stable", "v1")
+
+	srcs, err := NewSources(branch, tag)
+	require.Nil(t, err)
+
+	require.Equal(t, "", branch.Dir())
+
+	for _, src := range []Source{branch, tag} {
+		require.Nil(t, src.Refresh(context.Background()))
+	}
+
+	infos, err := LoadTemplatesInfo(branch.Dir(), 0)
+	require.Nil(t, err)
+	require.Equal(t, "v1", infos["kafka"].Description)
+
+	status := srcs.Status()
+	require.Len(t, status, 2)
+	require.Equal(t, "payments", status[0].Namespace)
+	require.Equal(t, "git", status[0].Type)
+	require.NotEmpty(t, status[0].Revision)
+
+	// New commit is picked up by the branch source only
+	commit("v2")
+	run(work, "push", "-q", bare, "main")
+
+	rev1 := branch.Revision()
+	dir1 := branch.Dir()
+
+	require.Nil(t, branch.Refresh(context.Background()))
+	require.Nil(t, tag.Refresh(context.Background()))
+
+	require.NotEqual(t, rev1, branch.Revision())
+	require.NotEqual(t, dir1, branch.Dir())
+
+	infos, err = LoadTemplatesInfo(branch.Dir(), 0)
+	require.Nil(t, err)
+	require.Equal(t, "v2", infos["kafka"].Description)
+
+	infos, err = LoadTemplatesInfo(tag.Dir(), 0)
+	require.Nil(t, err)
+	require.Equal(t, "v1", infos["kafka"].Description)
+
+	// Templates are executed from the source
+	tpl, _, err := Execute("env", "payments/kafka", 0, ExecuteParams{
+		Sources:  srcs,
+		WsDir:    filepath.Join(tmpDir, "ws"),
+		MountDir: filepath.Join(tmpDir, "mount"),
+	})
+	require.Nil(t, err)
+	require.Equal(t, "payments/kafka", tpl.GetName())
+
+	// Unknown ref
+	bad := newSrc("bad", "nonexistent")
+	require.NotNil(t, bad.Refresh(context.Background()))
+}
+
+func TestHttpSource(t *testing.T) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	data := []byte(fmt.Sprintf(sourceTpl, "search"))
+
+	require.Nil(t, tw.WriteHeader(&tar.Header{
+		Name:     "templates-1.0/es.tpl.js",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+	}))
+	_, err := tw.Write(data)
+	require.Nil(t, err)
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+
+	tarball := buf.Bytes()
+	sum := fmt.Sprintf("%x", sha256.Sum256(tarball))
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(tarball)
+		}))
+	defer srv.Close()
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	src, err := NewSource(SourceConfig{
+		Type:            SourceHttp,
+		Namespace:       "search",
+		Url:             srv.URL,
+		Sha256:          sum,
+		StripComponents: 1,
+	}, tmpDir)
+	require.Nil(t, err)
+
+	require.Nil(t, src.Refresh(context.Background()))
+	require.Equal(t, sum, src.Revision())
+
+	srcs, err := NewSources(NewLocalSource("", filepath.Join(tmpDir, "none")), src)
+	require.Nil(t, err)
+
+	dir, name, err := srcs.Resolve("search/es")
+	require.Nil(t, err)
+	require.Equal(t, "es", name)
+
+	_, err = os.Stat(filepath.Join(dir, "es.tpl.js"))
+	require.Nil(t, err)
+
+	// Checksum mismatch
+	bad, err := NewSource(SourceConfig{
+		Type:      SourceHttp,
+		Namespace: "bad",
+		Url:       srv.URL,
+		Sha256:    "deadbeef",
+	}, tmpDir)
+	require.Nil(t, err)
+
+	err = bad.Refresh(context.Background())
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Checksum mismatch")
+	require.Equal(t, "", bad.Dir())
+}
@@ -0,0 +1,286 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+var ErrTplNotFound = errors.New("Template not found")
+
+// Returned when an uploaded template fails validation
+type TplRejectedError struct {
+	Issues []*def.TplLintIssue
+}
+
+func (e *TplRejectedError) Error() string {
+	msgs := make([]string, len(e.Issues))
+
+	for i, issue := range e.Issues {
+		if issue.Line > 0 {
+			msgs[i] = fmt.Sprintf("%d:%d: %s", issue.Line, issue.Column,
+				issue.Message)
+		} else {
+			msgs[i] = issue.Message
+		}
+	}
+
+	return fmt.Sprintf("Template rejected: %s", strings.Join(msgs, "; "))
+}
+
+// Uploads are staged in a hidden directory, so they are not
+// picked up by LoadTemplatesInfo until installed
+const stagingPrefix = ".xenvman-upload-"
+
+// Serializes template modifications
+var storeLock sync.RWMutex
+
+// Template file, data dir and version sidecar suffixes
+var storeSuffixes = []string{".js", ".data", ".version"}
+
+// Install a template from a (optionally gzipped) tar archive containing
+// <name>.tpl.js file and optionally <name>.tpl.data directory and
+// <name>.tpl.version file, where <name> is the last component of the
+// template name. An existing template with the same name is replaced.
+// Template code run during validation is interrupted after timeout.
+func InstallTemplate(baseDir, name string, r io.Reader,
+	timeout time.Duration) (*def.TplInfo, error) {
+
+	jsFile, err := storePath(baseDir, name)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	staging, err := ioutil.TempDir(baseDir, stagingPrefix)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating staging dir")
+	}
+
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(staging)
+
+	newDir := filepath.Join(staging, "new")
+
+	if err := lib.ExtractTar(r, newDir, 0); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(jsFile), ".js")
+	newJs := filepath.Join(newDir, base+".js")
+
+	if err := checkUpload(newDir, base, timeout); err != nil {
+		return nil, err
+	}
+
+	// Uploaded templates must describe themselves
+	if info, err := loadInfo(newJs, timeout); err != nil || info == nil {
+		msg := fmt.Sprintf("Missing %s function", infoFunctionName)
+
+		if err != nil {
+			msg = err.Error()
+		}
+
+		return nil, &TplRejectedError{Issues: []*def.TplLintIssue{{
+			Severity: def.TplLintError,
+			Message:  msg,
+		}}}
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	dir := filepath.Dir(jsFile)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Replace the data dir and version sidecar first and the template
+	// file last, each of them is swapped with a single rename
+	for _, suffix := range storeSuffixes[1:] {
+		cur := filepath.Join(dir, base+suffix)
+		err := os.Rename(cur, filepath.Join(staging, "old"+suffix))
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "Error replacing %s", cur)
+		}
+
+		err = os.Rename(filepath.Join(newDir, base+suffix), cur)
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "Error installing %s", cur)
+		}
+	}
+
+	if err := os.Rename(newJs, jsFile); err != nil {
+		return nil, errors.Wrapf(err, "Error installing %s", jsFile)
+	}
+
+	tplLog.Infof("Installed template %s", name)
+
+	return loadInfo(jsFile, timeout)
+}
+
+// Check uploaded template contents
+func checkUpload(dir, base string, timeout time.Duration) error {
+	var issues []*def.TplLintIssue
+
+	reject := func(format string, args ...interface{}) {
+		issues = append(issues, &def.TplLintIssue{
+			Severity: def.TplLintError,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	allowed := map[string]bool{}
+
+	for _, suffix := range storeSuffixes {
+		allowed[base+suffix] = true
+	}
+
+	for _, e := range entries {
+		if !allowed[e.Name()] {
+			reject("Unexpected archive entry: %s", e.Name())
+		} else if e.IsDir() != (e.Name() == base+".data") {
+			reject("Unexpected archive entry type: %s", e.Name())
+		}
+	}
+
+	jsFile := filepath.Join(dir, base+".js")
+
+	if _, err := os.Stat(jsFile); err != nil {
+		reject("Archive must contain %s", base+".js")
+	} else {
+		for _, issue := range lintFile(dir, jsFile, timeout).issues {
+			if issue.Severity == def.TplLintError {
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	if len(issues) > 0 {
+		return &TplRejectedError{Issues: issues}
+	}
+
+	return nil
+}
+
+// Write a gzipped tar archive with template file, its data dir and
+// version sidecar, in the same format InstallTemplate accepts
+func ArchiveTemplate(baseDir, name string, w io.Writer) error {
+	jsFile, err := storePath(baseDir, name)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if _, err := os.Stat(jsFile); os.IsNotExist(err) {
+		return ErrTplNotFound
+	}
+
+	base := strings.TrimSuffix(jsFile, ".js")
+	var paths []string
+
+	for _, suffix := range storeSuffixes {
+		paths = append(paths, base+suffix)
+	}
+
+	return lib.WriteTar(w, filepath.Dir(jsFile), paths)
+}
+
+// Remove a template file along with its data dir and version sidecar
+func RemoveTemplate(baseDir, name string) error {
+	jsFile, err := storePath(baseDir, name)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if err := os.Remove(jsFile); os.IsNotExist(err) {
+		return ErrTplNotFound
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	base := strings.TrimSuffix(jsFile, ".js")
+
+	for _, suffix := range storeSuffixes[1:] {
+		if err := os.RemoveAll(base + suffix); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	tplLog.Infof("Removed template %s", name)
+
+	return nil
+}
+
+// Path to a template file which can be stored in baseDir
+func storePath(baseDir, name string) (string, error) {
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return "", errors.Errorf("Invalid template name: %s", name)
+		}
+	}
+
+	if _, version := SplitTplRef(name); version != "" {
+		if _, err := lib.ParseVersion(version); err != nil {
+			return "", errors.Wrapf(err, "Invalid template name: %s", name)
+		}
+	}
+
+	jsFile, _, err := getTplPaths(name, filepath.Clean(baseDir))
+
+	return jsFile, errors.WithStack(err)
+}
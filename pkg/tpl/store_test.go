@@ -0,0 +1,189 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+const storeTestTpl = `
+function info() {
+  return {
+    description: "%s",
+    parameters: {}
+  };
+}
+
+function execute(tpl, params) {
+  var img = tpl.BuildImage("app");
+  img.CopyDataToWorkspace("Dockerfile");
+}
+`
+
+// Archive files given as relative path -> contents
+func storeTestArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	dir, err := ioutil.TempDir("", "xenvman-store-src-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var paths []string
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, ioutil.WriteFile(path, []byte(data), 0644))
+
+		paths = append(paths, path)
+	}
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, lib.WriteTar(buf, dir, paths))
+
+	return buf
+}
+
+func TestTemplateStore(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "xenvman-store-")
+	require.Nil(t, err)
+	defer os.RemoveAll(baseDir)
+
+	tplSrc := func(desc string) string {
+		return fmt.Sprintf(storeTestTpl, desc)
+	}
+
+	archive := storeTestArchive(t, map[string]string{
+		"app.tpl.js":              tplSrc("v1"),
+		"app.tpl.data/Dockerfile": "FROM scratch",
+	})
+
+	info, err := InstallTemplate(baseDir, "svc/app", archive, 0)
+	require.Nil(t, err)
+	require.Equal(t, "v1", info.Description)
+	require.Equal(t, []string{"Dockerfile"}, info.DataDir)
+
+	infos, err := LoadTemplatesInfo(baseDir, 0)
+	require.Nil(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "v1", infos["svc/app"].Description)
+
+	// Replace with a new version
+	archive = storeTestArchive(t, map[string]string{
+		"app.tpl.js":              tplSrc("v2"),
+		"app.tpl.data/Dockerfile": "FROM alpine",
+		"app.tpl.version":         "2.0.0",
+	})
+
+	info, err = InstallTemplate(baseDir, "svc/app", archive, 0)
+	require.Nil(t, err)
+	require.Equal(t, "v2", info.Description)
+	require.Equal(t, "2.0.0", info.Version)
+
+	// Download and compare
+	buf := &bytes.Buffer{}
+	require.Nil(t, ArchiveTemplate(baseDir, "svc/app", buf))
+
+	dest := filepath.Join(baseDir, ".dest")
+	require.Nil(t, lib.ExtractTar(buf, dest, 0))
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "app.tpl.data", "Dockerfile"))
+	require.Nil(t, err)
+	require.Equal(t, "FROM alpine", string(data))
+
+	// Invalid uploads are rejected and leave the template intact
+	rejected := []map[string]string{
+		{"app.tpl.js": "function execute( {"},
+		{"app.tpl.js": tplSrc("v3"), "other.tpl.js": tplSrc("other")},
+		{"app.tpl.js": "function execute(tpl, params) {}"},
+		{"app.tpl.js": strings.Replace(tplSrc("v3"), "Dockerfile", "Missing", 1)},
+		{"app.tpl.data/Dockerfile": "FROM scratch"},
+	}
+
+	for _, files := range rejected {
+		_, err = InstallTemplate(baseDir, "svc/app", storeTestArchive(t, files), 0)
+		require.NotNil(t, err)
+		require.IsType(t, &TplRejectedError{}, err)
+	}
+
+	infos, err = LoadTemplatesInfo(baseDir, 0)
+	require.Nil(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "v2", infos["svc/app"].Description)
+
+	for _, name := range []string{"../app", ".hidden/app", "svc//app", "app@^2"} {
+		_, err = InstallTemplate(baseDir, name, storeTestArchive(t,
+			map[string]string{"app.tpl.js": tplSrc("v1")}), 0)
+		require.NotNil(t, err, name)
+	}
+
+	// Remove
+	require.Nil(t, RemoveTemplate(baseDir, "svc/app"))
+	require.Equal(t, ErrTplNotFound, RemoveTemplate(baseDir, "svc/app"))
+	require.Equal(t, ErrTplNotFound, ArchiveTemplate(baseDir, "svc/app", buf))
+
+	_, err = os.Stat(filepath.Join(baseDir, "svc", "app.tpl.data"))
+	require.True(t, os.IsNotExist(err))
+
+	infos, err = LoadTemplatesInfo(baseDir, 0)
+	require.Nil(t, err)
+	require.Empty(t, infos)
+}
+
+func TestTemplateStoreTimeout(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "xenvman-store-")
+	require.Nil(t, err)
+	defer os.RemoveAll(baseDir)
+
+	tplSrc := fmt.Sprintf(storeTestTpl, "app")
+
+	looping := []string{
+		"while(true) {}\n" + tplSrc,
+		strings.Replace(tplSrc, "return {", "while(true) {}\n  return {", 1),
+	}
+
+	for _, src := range looping {
+		archive := storeTestArchive(t, map[string]string{
+			"app.tpl.js":              src,
+			"app.tpl.data/Dockerfile": "FROM scratch",
+		})
+
+		_, err := InstallTemplate(baseDir, "app", archive, 100*time.Millisecond)
+		require.NotNil(t, err)
+		require.IsType(t, &TplRejectedError{}, err)
+		require.Contains(t, err.Error(), "exceeded the execution timeout")
+	}
+
+	_, err = os.Stat(filepath.Join(baseDir, "app.tpl.js"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,11 @@
+function info() {
+  return {
+    description: "Bad info",
+    params: {},
+    parameters: {
+      p: {type: "strng"}
+    }
+  };
+}
+
+function execute(tpl, params) {}
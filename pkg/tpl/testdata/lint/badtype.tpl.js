@@ -0,0 +1,11 @@
+function info() {
+  return {
+    parameters: {
+      p1: {type: "strng[]"},
+      p2: {type: "{invalid"},
+      p3: {description: "No type"}
+    }
+  };
+}
+
+function execute(tpl, params) {}
@@ -0,0 +1,3 @@
+function execute(tpl, params) {
+  import_template("cycle-b", {});
+}
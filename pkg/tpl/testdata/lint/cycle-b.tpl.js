@@ -0,0 +1,4 @@
+function execute(tpl, params) {
+  import_template("cycle-a", {});
+  import_template("missing", {});
+}
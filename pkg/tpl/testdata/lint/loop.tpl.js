@@ -0,0 +1,6 @@
+function info() {
+  while (true) {}
+}
+
+function execute(tpl, params) {
+}
@@ -0,0 +1,7 @@
+function execute(tpl, params) {
+  var img = tpl.BuildImage("nodata");
+  img.CopyDataToWorkspace("missing-ws");
+
+  var cont = img.NewContainer("nodata");
+  cont.MountData("missing-mount", "/mnt", {});
+}
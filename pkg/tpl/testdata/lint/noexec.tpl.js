@@ -0,0 +1,3 @@
+function info() {
+  return {description: "No execute"};
+}
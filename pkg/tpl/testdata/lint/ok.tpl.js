@@ -0,0 +1,19 @@
+function info() {
+  return {
+    description: "Valid template",
+    parameters: {
+      name: {description: "Name", type: "string"},
+      hosts: {description: "Hosts", type: "string[]"},
+      init: {description: "Init", type: "{\"db\": [\"query\"]}"}
+    }
+  };
+}
+
+function execute(tpl, params) {
+  var img = tpl.BuildImage("ok");
+  img.CopyDataToWorkspace("file.txt", "*");
+
+  var cont = img.NewContainer("ok");
+  cont.MountData("file.txt", "/file.txt", {});
+  cont.MountData("optional.txt", "/optional.txt", {"skip-if-nonexistent": true});
+}
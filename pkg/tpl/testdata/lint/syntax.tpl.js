@@ -0,0 +1,3 @@
+function execute(tpl, params) {
+  var x = ;
+}
@@ -0,0 +1,9 @@
+function info() {
+  return {
+    description: "1.5"
+  };
+}
+
+function execute(tpl, params) {
+  tpl.FetchImage("postgres:1.5");
+}
@@ -0,0 +1,10 @@
+function info() {
+  return {
+    description: "3.0",
+    version: "3.0.0"
+  };
+}
+
+function execute(tpl, params) {
+  tpl.FetchImage("postgres:3.0");
+}
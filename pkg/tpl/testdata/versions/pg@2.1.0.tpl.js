@@ -0,0 +1,9 @@
+function info() {
+  return {
+    description: "2.1"
+  };
+}
+
+function execute(tpl, params) {
+  tpl.FetchImage("postgres:2.1");
+}
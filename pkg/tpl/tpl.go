@@ -33,6 +33,7 @@ import (
 	"path/filepath"
 
 	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/conteng"
 	"github.com/syhpoon/xenvman/pkg/lib"
 	"github.com/syhpoon/xenvman/pkg/logger"
 )
@@ -45,9 +46,12 @@ var readinessMap = map[string]func(map[string]interface{}) ReadinessCheck{
 }
 
 type Tpl struct {
-	envId string
-	name  string
-	idx   int
+	envId   string
+	name    string
+	version string
+	// Hash of the template file and data dir contents
+	hash string
+	idx  int
 
 	// Images to build
 	buildImages []*BuildImage
@@ -55,10 +59,15 @@ type Tpl struct {
 	// Images to fetch
 	fetchImages []*FetchImage
 
+	// Declared networks
+	networks []string
+
 	dataDir  string
 	wsDir    string
 	mountDir string
 	fs       *Fs
+	secrets  *lib.Redactor
+	quota    *quota
 
 	imported []*Tpl
 
@@ -75,6 +84,7 @@ func (tpl *Tpl) BuildImage(name string) *BuildImage {
 	verifyPath(wsDir, tpl.wsDir)
 
 	checkCancelled(tpl.ctx)
+	tpl.quota.addImage()
 
 	if err := os.MkdirAll(wsDir, 0755); err != nil {
 		panic(errors.WithStack(err))
@@ -91,8 +101,15 @@ func (tpl *Tpl) BuildImage(name string) *BuildImage {
 			dataDir:    tpl.dataDir,
 			containers: map[string]*Container{},
 			fs:         tpl.fs,
+			secrets:    tpl.secrets,
+			quota:      tpl.quota,
 			ctx:        tpl.ctx,
 		},
+		buildParams: conteng.BuildImageParams{
+			NoCache: true,
+			Pull:    true,
+		},
+		reuse: true,
 	}
 
 	tplLog.Debugf("[%s] Building image %s", tpl.envId, imgName)
@@ -112,6 +129,7 @@ func (tpl *Tpl) FetchImage(imgName string) *FetchImage {
 	verifyPath(wsDir, tpl.wsDir)
 
 	checkCancelled(tpl.ctx)
+	tpl.quota.addImage()
 
 	if err := os.MkdirAll(wsDir, 0755); err != nil {
 		panic(errors.WithStack(err))
@@ -128,6 +146,8 @@ func (tpl *Tpl) FetchImage(imgName string) *FetchImage {
 			dataDir:    tpl.dataDir,
 			containers: map[string]*Container{},
 			fs:         tpl.fs,
+			secrets:    tpl.secrets,
+			quota:      tpl.quota,
 			ctx:        tpl.ctx,
 		},
 	}
@@ -145,6 +165,14 @@ func (tpl *Tpl) GetName() string {
 	return tpl.name
 }
 
+func (tpl *Tpl) GetVersion() string {
+	return tpl.version
+}
+
+func (tpl *Tpl) GetHash() string {
+	return tpl.hash
+}
+
 func (tpl *Tpl) GetIdx() int {
 	return tpl.idx
 }
@@ -0,0 +1,222 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/robertkrimen/otto"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+const versionSep = "@"
+
+// Split template reference <name>[@<version constraint>]
+func SplitTplRef(ref string) (name, constraint string) {
+	ref = strings.TrimSpace(ref)
+
+	if idx := strings.Index(ref, versionSep); idx >= 0 {
+		return ref[:idx], strings.TrimSpace(ref[idx+1:])
+	}
+
+	return ref, ""
+}
+
+// Template version is taken from info(), <name>.tpl.version sidecar file
+// or <name>@<version>.tpl.js file name, in that order
+func tplVersion(info *def.TplInfo, jsFile string, fs *Fs) string {
+	if info != nil && info.Version != "" {
+		return info.Version
+	}
+
+	sidecar := strings.TrimSuffix(jsFile, ".js") + ".version"
+
+	if data, err := fs.ReadFile(sidecar); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+
+	_, version := SplitTplRef(strings.TrimSuffix(filepath.Base(jsFile), ".tpl.js"))
+
+	return version
+}
+
+// Read template version without executing it,
+// compiled program is taken from the registry if cached
+func fileVersion(jsFile string, params *ExecuteParams) (string, error) {
+	src, err := params.Fs.ReadFile(jsFile)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var program interface{} = src
+
+	if params.Registry != nil && params.Fs == defaultFs {
+		if p := params.Registry.Program(jsFile); p != nil {
+			program = p
+		}
+	}
+
+	vm := otto.New()
+	setupLib(vm)
+
+	info, err := runInfo(vm, program, jsFile, params.Limits.Timeout)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Error executing %s", jsFile)
+	}
+
+	return tplVersion(info, jsFile, params.Fs), nil
+}
+
+type tplCandidate struct {
+	relName string
+	version *lib.Version
+}
+
+// Find the highest template version satisfying a constraint.
+// Returns a name relative to tplDir of the chosen template file.
+// Versioned templates live alongside as <name>@<version>.tpl.js
+func resolveVersion(relName, constraint, tplDir string,
+	params *ExecuteParams) (string, *lib.Version, error) {
+
+	fs := params.Fs
+
+	cons, err := lib.ParseConstraint(constraint)
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	jsFile, _, err := getTplPaths(relName, tplDir)
+
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	dir := filepath.Dir(jsFile)
+	base := strings.TrimSuffix(filepath.Base(jsFile), ".tpl.js")
+
+	entries, err := fs.readDir(dir)
+
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, errors.Wrapf(err, "Error reading tpl dir %s", dir)
+	}
+
+	var best *tplCandidate
+	// Whether any candidate files exist at all
+	found := false
+
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".tpl.js")
+
+		if !e.Mode().IsRegular() || name == e.Name() ||
+			(name != base && !strings.HasPrefix(name, base+versionSep)) {
+			continue
+		}
+
+		found = true
+		file := filepath.Join(dir, e.Name())
+		raw, err := fileVersion(file, params)
+
+		if err != nil || raw == "" {
+			tplLog.Debugf("Skipping unversioned tpl candidate %s: %v", file, err)
+
+			continue
+		}
+
+		v, err := lib.ParseVersion(raw)
+
+		if err != nil {
+			tplLog.Warningf("Skipping tpl %s: %s", file, err)
+
+			continue
+		}
+
+		if cons.Check(v) && (best == nil || v.Compare(best.version) > 0) {
+			best = &tplCandidate{
+				relName: filepath.Join(filepath.Dir(relName), name),
+				version: v,
+			}
+		}
+	}
+
+	if !found {
+		if _, err := fs.Stat(jsFile); err != nil {
+			return "", nil, errors.Wrapf(err, "Template not found: %s", relName)
+		}
+	}
+
+	if best == nil {
+		return "", nil, errors.Errorf("No version of %s satisfies %s",
+			relName, constraint)
+	}
+
+	return best.relName, best.version, nil
+}
+
+// Hash of the template file and its data directory contents
+func contentHash(jsFile, dataDir string, fs *Fs) (string, error) {
+	h := sha256.New()
+
+	src, err := fs.ReadFile(jsFile)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	_, _ = h.Write(src)
+
+	if st, err := fs.Stat(dataDir); err != nil || !st.IsDir() {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	// Files are visited in lexical order, so the hash is stable
+	err = fs.walkFiles(dataDir, func(path string) error {
+		data, err := fs.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(h, "\x00%s\x00", strings.TrimPrefix(path, dataDir))
+		_, _ = h.Write(data)
+
+		return nil
+	})
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Error hashing data dir %s", dataDir)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,143 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+func TestExecuteVersion(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "xenvman-version-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	tplDir, err := filepath.Abs("testdata/versions")
+	require.Nil(t, err)
+
+	execute := func(ref string) (*Tpl, error) {
+		tpl, _, err := Execute("env", ref, 0, ExecuteParams{
+			TplDir:   tplDir,
+			WsDir:    filepath.Join(tmpDir, "ws"),
+			MountDir: filepath.Join(tmpDir, "mount"),
+			Redactor: lib.NewRedactor(),
+		})
+
+		return tpl, err
+	}
+
+	cases := []struct {
+		ref     string
+		version string
+	}{
+		{"pg", "3.0.0"},
+		{"pg@2", "2.2.0"},
+		{"pg@2.1", "2.1.0"},
+		{"pg@>=2.1 <3", "2.2.0"},
+		{"pg@^3", "3.0.0"},
+		{"only", "1.5.0"},
+		{"only@~1.0", "1.0.0"},
+	}
+
+	hashes := map[string]bool{}
+
+	for _, c := range cases {
+		tpl, err := execute(c.ref)
+		require.Nil(t, err, c.ref)
+
+		name, _ := SplitTplRef(c.ref)
+		require.Equal(t, name, tpl.GetName())
+		require.Equal(t, c.version, tpl.GetVersion(), c.ref)
+		require.Len(t, tpl.GetHash(), 64)
+
+		hashes[name+tpl.GetVersion()] = true
+		require.Equal(t, "postgres:"+c.version[:3],
+			tpl.GetFetchImages()[0].name)
+	}
+
+	require.Len(t, hashes, 5)
+
+	// Hash is stable and depends on data dir contents
+	t1, err := execute("pg@2.1")
+	require.Nil(t, err)
+
+	t2, err := execute("pg@2.1")
+	require.Nil(t, err)
+	require.Equal(t, t1.GetHash(), t2.GetHash())
+
+	dataFile := filepath.Join(tplDir, "pg@2.1.0.tpl.data", "postgresql.conf")
+	orig, err := ioutil.ReadFile(dataFile)
+	require.Nil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(dataFile, []byte("changed"), 0644))
+	defer ioutil.WriteFile(dataFile, orig, 0644)
+
+	t3, err := execute("pg@2.1")
+	require.Nil(t, err)
+	require.NotEqual(t, t1.GetHash(), t3.GetHash())
+
+	_, err = execute("pg@4")
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "No version of pg satisfies 4")
+
+	_, err = execute("pg@bad")
+	require.NotNil(t, err)
+}
+
+func TestLoadTemplatesInfoVersion(t *testing.T) {
+	infos, err := LoadTemplatesInfo("testdata/versions", 0)
+	require.Nil(t, err)
+
+	require.Equal(t, "3.0.0", infos["pg"].Version)
+	require.Equal(t, "2.1.0", infos["pg@2.1.0"].Version)
+	require.Equal(t, "2.2.0", infos["pg@2.2.0"].Version)
+	require.Equal(t, "1.5.0", infos["only@1.5.0"].Version)
+}
+
+func TestLintVersion(t *testing.T) {
+	res, err := Lint("testdata/versions", 0)
+	require.Nil(t, err)
+	require.Len(t, res, 5)
+
+	for name, issues := range res {
+		require.Empty(t, issues, name)
+	}
+
+	lt := &lintTpl{name: "pg@2.0.0"}
+	lintVersion(lt, &def.TplInfo{Version: "2.1.0"}, "pg@2.0.0.tpl.js")
+	require.Len(t, lt.issues, 1)
+	require.Contains(t, lt.issues[0].Message, "does not match")
+
+	lt = &lintTpl{name: "pg"}
+	lintVersion(lt, &def.TplInfo{Version: "two"}, "pg.tpl.js")
+	require.Len(t, lt.issues, 1)
+	require.Contains(t, lt.issues[0].Message, "Invalid version")
+}
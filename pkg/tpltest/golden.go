@@ -0,0 +1,245 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+)
+
+// Golden test cases for <name>.tpl.js are stored
+// as <name>.tpl.test/<case>.json files
+const testDirSuffix = ".tpl.test"
+
+// Golden test case
+type Case struct {
+	Parameters def.TplParams     `json:"parameters,omitempty"`
+	Secrets    map[string]string `json:"secrets,omitempty"`
+	// Expected error substring, if execution is supposed to fail
+	Error string `json:"error,omitempty"`
+	// Expected plan
+	Plan json.RawMessage `json:"plan,omitempty"`
+}
+
+type CaseResult struct {
+	Tpl  string
+	Name string
+	// Difference between expected and actual plans
+	Diff string
+	// Case file has been updated with the actual plan
+	Updated bool
+	Err     error
+}
+
+func (cr *CaseResult) Passed() bool {
+	return cr.Err == nil && cr.Diff == ""
+}
+
+// Find all the templates having golden test cases
+func FindTested(tplDir string) ([]string, error) {
+	var tpls []string
+
+	f := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && strings.HasSuffix(info.Name(), testDirSuffix) {
+			rel, err := filepath.Rel(tplDir, path)
+
+			if err != nil {
+				return err
+			}
+
+			tpls = append(tpls,
+				filepath.ToSlash(strings.TrimSuffix(rel, testDirSuffix)))
+
+			return filepath.SkipDir
+		}
+
+		return nil
+	}
+
+	if err := filepath.Walk(tplDir, f); err != nil {
+		return nil, errors.Wrapf(err, "Error scanning tpl directory")
+	}
+
+	sort.Strings(tpls)
+
+	return tpls, nil
+}
+
+// Run all the golden test cases for a template.
+// If update is true, case files are rewritten with actual plans.
+func RunCases(tplDir, tplName string, update bool) ([]*CaseResult, error) {
+	dir := filepath.Join(tplDir, tplName+testDirSuffix)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(files) == 0 {
+		return nil, errors.Errorf("No test cases found in %s", dir)
+	}
+
+	sort.Strings(files)
+
+	var results []*CaseResult
+
+	for _, file := range files {
+		res := &CaseResult{
+			Tpl:  tplName,
+			Name: strings.TrimSuffix(filepath.Base(file), ".json"),
+		}
+
+		res.Diff, res.Updated, res.Err = runCase(tplDir, tplName, file, update)
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func runCase(tplDir, tplName, file string, update bool) (string, bool, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return "", false, errors.Wrapf(err, "Error reading test case")
+	}
+
+	tc := &Case{}
+
+	if err := json.Unmarshal(data, tc); err != nil {
+		return "", false, errors.Wrapf(err, "Error parsing test case")
+	}
+
+	plan, err := Run(Params{
+		TplDir: tplDir,
+		Tpl: &def.Tpl{
+			Tpl:        tplName,
+			Parameters: tc.Parameters,
+			Secrets:    tc.Secrets,
+		},
+	})
+
+	if tc.Error != "" {
+		if err == nil {
+			return "", false, errors.Errorf(
+				"Expected error containing %q but template succeeded", tc.Error)
+		} else if !strings.Contains(err.Error(), tc.Error) {
+			return "", false, errors.Errorf(
+				"Expected error containing %q but got: %s", tc.Error, err)
+		}
+
+		return "", false, nil
+	} else if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+
+	actual, err := json.MarshalIndent(plan, "", "  ")
+
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+
+	var actualObj, expectedObj interface{}
+
+	_ = json.Unmarshal(actual, &actualObj)
+
+	if len(tc.Plan) > 0 {
+		if err := json.Unmarshal(tc.Plan, &expectedObj); err != nil {
+			return "", false, errors.Wrapf(err, "Error parsing expected plan")
+		}
+	}
+
+	if reflect.DeepEqual(actualObj, expectedObj) {
+		return "", false, nil
+	}
+
+	if update {
+		tc.Plan = actual
+
+		out, err := json.MarshalIndent(tc, "", "  ")
+
+		if err != nil {
+			return "", false, errors.WithStack(err)
+		}
+
+		if err := ioutil.WriteFile(file, append(out, '\n'), 0644); err != nil {
+			return "", false, errors.Wrapf(err, "Error updating test case")
+		}
+
+		return "", true, nil
+	}
+
+	expected, _ := json.MarshalIndent(expectedObj, "", "  ")
+
+	return diff(expected, actual), false, nil
+}
+
+// Show the first differing line between expected and actual plans
+func diff(expected, actual []byte) string {
+	// Normalize key order
+	var e, a interface{}
+
+	_ = json.Unmarshal(expected, &e)
+	_ = json.Unmarshal(actual, &a)
+
+	expected, _ = json.MarshalIndent(e, "", "  ")
+	actual, _ = json.MarshalIndent(a, "", "  ")
+
+	el := bytes.Split(expected, []byte("\n"))
+	al := bytes.Split(actual, []byte("\n"))
+
+	for i := 0; i < len(el) || i < len(al); i++ {
+		var eline, aline []byte
+
+		if i < len(el) {
+			eline = el[i]
+		}
+
+		if i < len(al) {
+			aline = al[i]
+		}
+
+		if !bytes.Equal(eline, aline) {
+			return fmt.Sprintf("line %d:\n- %s\n+ %s", i+1,
+				strings.TrimSpace(string(eline)), strings.TrimSpace(string(aline)))
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,411 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+// Package tpltest executes templates without a container engine
+// and records what they would create as a Plan
+package tpltest
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+	"github.com/syhpoon/xenvman/pkg/tpl"
+)
+
+// Env id used for all the test executions, so that generated
+// image names are stable
+const envId = "tpltest"
+
+const defaultRecursionLimit = 10
+
+// Everything a template would create if executed for real
+type Plan struct {
+	Tpl         string        `json:"tpl"`
+	Idx         int           `json:"idx"`
+	Parameters  def.TplParams `json:"parameters,omitempty"`
+	BuildImages []*Image      `json:"build_images,omitempty"`
+	FetchImages []*Image      `json:"fetch_images,omitempty"`
+	Networks    []string      `json:"networks,omitempty"`
+	Imports     []*Plan       `json:"imports,omitempty"`
+}
+
+type Image struct {
+	Name string `json:"name"`
+	// Workspace files: relative path -> content, build images only
+	Workspace map[string]string `json:"workspace,omitempty"`
+	// Build parameters set by the template, build images only
+	Build *BuildParams `json:"build,omitempty"`
+	// Pull policy set by the template, fetch images only
+	PullPolicy string       `json:"pull_policy,omitempty"`
+	Containers []*Container `json:"containers,omitempty"`
+}
+
+type BuildParams struct {
+	BuildArgs  map[string]string `json:"build_args,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Platform   string            `json:"platform,omitempty"`
+}
+
+type Container struct {
+	Name            string            `json:"name"`
+	Hostname        string            `json:"hostname"`
+	Cmd             []string          `json:"cmd,omitempty"`
+	Entrypoint      []string          `json:"entrypoint,omitempty"`
+	Ports           []string          `json:"ports,omitempty"`
+	Environ         map[string]string `json:"environ,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Mounts          []*Mount          `json:"mounts,omitempty"`
+	ReadinessChecks []*ReadinessCheck `json:"readiness_checks,omitempty"`
+	Certs           []*Cert           `json:"certs,omitempty"`
+	// Attached networks, omitted for the default one
+	Networks []string `json:"networks,omitempty"`
+}
+
+type Mount struct {
+	ContainerFile string `json:"container_file"`
+	Readonly      bool   `json:"readonly"`
+	Interpolate   bool   `json:"interpolate,omitempty"`
+	// File content for mounted files
+	Content string `json:"content,omitempty"`
+	// Relative path -> content for mounted directories
+	Files map[string]string `json:"files,omitempty"`
+}
+
+type ReadinessCheck struct {
+	Type       string                 `json:"type"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type Cert struct {
+	Sans []string `json:"sans,omitempty"`
+}
+
+type Params struct {
+	TplDir string
+	Tpl    *def.Tpl
+	// Defaults to 10
+	RecursionLimit int
+	Ctx            context.Context
+}
+
+// Execute a template along with all its imports and build a plan
+func Run(params Params) (*Plan, error) {
+	if params.RecursionLimit == 0 {
+		params.RecursionLimit = defaultRecursionLimit
+	}
+
+	if params.Ctx == nil {
+		params.Ctx = context.Background()
+	}
+
+	tmpDir, err := ioutil.TempDir("", "xenvman-tpltest-")
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating temporary dir")
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	r := &runner{
+		params:  params,
+		wsDir:   filepath.Join(tmpDir, "ws"),
+		mntDir:  filepath.Join(tmpDir, "mount"),
+		tplIdx:  map[string]int{},
+		secrets: lib.NewRedactor(),
+	}
+
+	return r.run(params.Tpl, 0)
+}
+
+type runner struct {
+	params  Params
+	wsDir   string
+	mntDir  string
+	tplIdx  map[string]int
+	secrets *lib.Redactor
+}
+
+func (r *runner) run(tplDef *def.Tpl, rec int) (*Plan, error) {
+	if rec >= r.params.RecursionLimit {
+		return nil, errors.Errorf("Recursion limit reached")
+	}
+
+	idx := r.tplIdx[tplDef.Tpl]
+	r.tplIdx[tplDef.Tpl]++
+
+	t, imprt, err := tpl.Execute(envId, tplDef.Tpl, idx, tpl.ExecuteParams{
+		TplDir:    r.params.TplDir,
+		WsDir:     r.wsDir,
+		MountDir:  r.mntDir,
+		TplParams: tplDef.Parameters,
+		Secrets:   tplDef.Secrets,
+		Redactor:  r.secrets,
+		Ctx:       r.params.Ctx,
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	plan := &Plan{
+		Tpl:        t.GetName(),
+		Idx:        t.GetIdx(),
+		Parameters: tplDef.Parameters,
+	}
+
+	for _, img := range t.GetBuildImages() {
+		pimg, err := r.image(img.Image, true)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		bp := img.BuildParams()
+
+		if len(bp.BuildArgs) > 0 || bp.Target != "" || bp.Dockerfile != "" ||
+			len(bp.Labels) > 0 || bp.Platform != "" {
+			pimg.Build = &BuildParams{
+				BuildArgs:  bp.BuildArgs,
+				Target:     bp.Target,
+				Dockerfile: bp.Dockerfile,
+				Labels:     bp.Labels,
+				Platform:   bp.Platform,
+			}
+		}
+
+		plan.BuildImages = append(plan.BuildImages, pimg)
+	}
+
+	for _, img := range t.GetFetchImages() {
+		pimg, err := r.image(img.Image, false)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		pimg.PullPolicy = string(img.PullPolicy())
+		plan.FetchImages = append(plan.FetchImages, pimg)
+	}
+
+	sortImages(plan.BuildImages)
+	sortImages(plan.FetchImages)
+
+	plan.Networks = t.GetNetworks()
+
+	for _, it := range imprt {
+		iplan, err := r.run(it, rec+1)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error executing imported template %s",
+				it.Tpl)
+		}
+
+		plan.Imports = append(plan.Imports, iplan)
+	}
+
+	return plan, nil
+}
+
+func (r *runner) image(img *tpl.Image, build bool) (*Image, error) {
+	pimg := &Image{
+		Name: img.Name(),
+	}
+
+	if build {
+		files, err := r.readDir(img.WsDir())
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading workspace for %s",
+				img.Name())
+		}
+
+		pimg.Workspace = files
+	}
+
+	for _, cont := range img.Containers() {
+		pcont, err := r.container(cont)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		pimg.Containers = append(pimg.Containers, pcont)
+	}
+
+	sort.Slice(pimg.Containers, func(i, j int) bool {
+		return pimg.Containers[i].Name < pimg.Containers[j].Name
+	})
+
+	return pimg, nil
+}
+
+func (r *runner) container(cont *tpl.Container) (*Container, error) {
+	pcont := &Container{
+		Name:       cont.Name(),
+		Hostname:   cont.Hostname(),
+		Cmd:        cont.Cmd(),
+		Entrypoint: cont.Entrypoint(),
+	}
+
+	for _, port := range cont.Ports() {
+		pcont.Ports = append(pcont.Ports, port.String())
+	}
+
+	if len(cont.Environ()) > 0 {
+		pcont.Environ = map[string]string{}
+
+		for k, v := range cont.Environ() {
+			if cont.IsSecretEnv(k) {
+				pcont.Environ[k] = lib.Redacted
+			} else {
+				pcont.Environ[k] = r.secrets.Redact(v)
+			}
+		}
+	}
+
+	if len(cont.Labels()) > 0 {
+		pcont.Labels = cont.Labels()
+	}
+
+	if nets := cont.Networks(); len(nets) > 1 || nets[0] != tpl.DefaultNetwork {
+		pcont.Networks = nets
+	}
+
+	toInterpolate, _ := cont.ToInterpolate()
+	interpolate := map[string]bool{}
+
+	for _, f := range toInterpolate {
+		interpolate[f] = true
+	}
+
+	for _, m := range cont.Mounts() {
+		pm := &Mount{
+			ContainerFile: m.ContainerFile,
+			Readonly:      m.Readonly,
+			Interpolate:   interpolate[m.HostFile],
+		}
+
+		info, err := os.Stat(m.HostFile)
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if info.IsDir() {
+			if pm.Files, err = r.readDir(m.HostFile); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		} else {
+			data, err := ioutil.ReadFile(m.HostFile)
+
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			pm.Content = r.content(data)
+		}
+
+		pcont.Mounts = append(pcont.Mounts, pm)
+	}
+
+	for _, rc := range cont.GetReadinessChecks() {
+		pcont.ReadinessChecks = append(pcont.ReadinessChecks, &ReadinessCheck{
+			Type:       rc.String(),
+			Parameters: rc.Parameters(),
+		})
+	}
+
+	for _, req := range cont.CertRequests() {
+		pcont.Certs = append(pcont.Certs, &Cert{Sans: req.Sans})
+	}
+
+	return pcont, nil
+}
+
+// Read all the regular files in a directory
+func (r *runner) readDir(dir string) (map[string]string, error) {
+	files := map[string]string{}
+
+	f := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(rel)] = r.content(data)
+
+		return nil
+	}
+
+	if err := filepath.Walk(dir, f); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	return files, nil
+}
+
+// Text content is recorded as is, binary one as a checksum
+func (r *runner) content(data []byte) string {
+	if utf8.Valid(data) {
+		return r.secrets.Redact(string(data))
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func sortImages(imgs []*Image) {
+	sort.Slice(imgs, func(i, j int) bool {
+		return imgs[i].Name < imgs[j].Name
+	})
+}
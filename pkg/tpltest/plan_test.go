@@ -0,0 +1,140 @@
+/*
+ MIT License
+
+ Copyright (c) 2019 Max Kuznetsov <syhpoon@syhpoon.ca>
+
+ Permission is hereby granted, free of charge, to any person obtaining a copy
+ of this software and associated documentation files (the "Software"), to deal
+ in the Software without restriction, including without limitation the rights
+ to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ copies of the Software, and to permit persons to whom the Software is
+ furnished to do so, subject to the following conditions:
+
+ The above copyright notice and this permission notice shall be included in all
+ copies or substantial portions of the Software.
+
+ THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ SOFTWARE.
+*/
+
+package tpltest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syhpoon/xenvman/pkg/def"
+	"github.com/syhpoon/xenvman/pkg/lib"
+)
+
+func TestRun(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	plan, err := Run(Params{
+		TplDir: filepath.Join(cwd, "testdata"),
+		Tpl: &def.Tpl{
+			Tpl:        "app",
+			Parameters: def.TplParams{"mode": "prod"},
+			Secrets:    map[string]string{"password": "hunter2"},
+		},
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, "app", plan.Tpl)
+	require.Len(t, plan.BuildImages, 1)
+	require.Len(t, plan.FetchImages, 0)
+
+	img := plan.BuildImages[0]
+	require.Equal(t, "xenv-app-app:tpltest-0", img.Name)
+	require.Contains(t, img.Workspace, "Dockerfile")
+	require.Equal(t, &BuildParams{
+		BuildArgs: map[string]string{"MODE": "prod"},
+		Target:    "test",
+	}, img.Build)
+	require.Len(t, img.Containers, 1)
+
+	cont := img.Containers[0]
+	require.Equal(t, "app.0.app.xenv", cont.Hostname)
+	require.Equal(t, []string{"app", "--mode", "prod"}, cont.Cmd)
+	require.Equal(t, []string{"8080/tcp", "127.0.0.1::8125/udp"}, cont.Ports)
+	require.Equal(t, map[string]string{
+		"MODE":     "prod",
+		"PASSWORD": lib.Redacted,
+	}, cont.Environ)
+	require.Len(t, cont.Mounts, 4)
+	require.Equal(t, "/etc/app.conf", cont.Mounts[0].ContainerFile)
+	require.True(t, cont.Mounts[0].Interpolate)
+	require.Len(t, cont.ReadinessChecks, 1)
+	require.Equal(t, "net", cont.ReadinessChecks[0].Type)
+	require.Equal(t, "tcp", cont.ReadinessChecks[0].Parameters["protocol"])
+	require.Len(t, cont.Certs, 1)
+
+	require.Len(t, plan.Imports, 1)
+	require.Equal(t, "db", plan.Imports[0].Tpl)
+	require.Equal(t, "appdb",
+		plan.Imports[0].FetchImages[0].Containers[0].Environ["POSTGRES_DB"])
+	require.Equal(t, "if-not-present",
+		plan.Imports[0].FetchImages[0].PullPolicy)
+}
+
+func TestRunCases(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tplDir := filepath.Join(cwd, "testdata")
+
+	tpls, err := FindTested(tplDir)
+	require.Nil(t, err)
+	require.Equal(t, []string{"app"}, tpls)
+
+	results, err := RunCases(tplDir, "app", false)
+	require.Nil(t, err)
+	require.Len(t, results, 2)
+
+	for _, res := range results {
+		require.True(t, res.Passed(), "%s: %s %v", res.Name, res.Diff, res.Err)
+	}
+}
+
+func TestRunCasesUpdate(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+
+	tmpDir := filepath.Join(os.TempDir(), "xenvman-test-"+lib.NewId())
+	defer os.RemoveAll(tmpDir)
+
+	data, err := ioutil.ReadFile(filepath.Join(cwd, "testdata", "db.tpl.js"))
+	require.Nil(t, err)
+
+	require.Nil(t, os.MkdirAll(filepath.Join(tmpDir, "db.tpl.test"), 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(tmpDir, "db.tpl.js"), data, 0644))
+
+	caseFile := filepath.Join(tmpDir, "db.tpl.test", "case.json")
+	require.Nil(t, ioutil.WriteFile(caseFile,
+		[]byte(`{"parameters": {"name": "one"}}`), 0644))
+
+	results, err := RunCases(tmpDir, "db", false)
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.False(t, results[0].Passed())
+	require.NotEmpty(t, results[0].Diff)
+
+	results, err = RunCases(tmpDir, "db", true)
+	require.Nil(t, err)
+	require.True(t, results[0].Passed())
+	require.True(t, results[0].Updated)
+
+	results, err = RunCases(tmpDir, "db", false)
+	require.Nil(t, err)
+	require.True(t, results[0].Passed())
+	require.False(t, results[0].Updated)
+}
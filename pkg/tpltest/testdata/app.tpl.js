@@ -0,0 +1,48 @@
+function info() {
+  return {
+    description: "Application template",
+    parameters: {
+      mode: {
+        description: "Application mode",
+        type: "string",
+        mandatory: true
+      },
+      password: {
+        description: "Database password",
+        type: "string",
+        secret: true
+      }
+    }
+  };
+}
+
+function execute(tpl, params) {
+  if(!type.IsDefined(params.mode)) {
+    throw new Error("mode is required");
+  }
+
+  var img = tpl.BuildImage("app");
+  img.CopyDataToWorkspace("Dockerfile");
+  img.SetTarget("test");
+  img.SetBuildArg("MODE", params.mode);
+
+  var cont = img.NewContainer("app");
+
+  cont.SetPorts(8080, "127.0.0.1::8125/udp");
+  cont.SetCmd("app", "--mode", params.mode);
+  cont.SetEnv("MODE", params.mode);
+  cont.SetSecretEnv("PASSWORD", params.password);
+  cont.SetLabel("role", "app");
+
+  cont.MountString("port={{.Self.ExposedPort 8080}}", "/etc/app.conf", 0644,
+                   {"interpolate": true});
+
+  cont.AddReadinessCheck("net", {
+    "protocol": "tcp",
+    "address": "{{.ExternalAddress}}:{{.Self.ExposedPort 8080}}"
+  });
+
+  tpl.IssueCert(cont, {"sans": ["app.local"]});
+
+  import_template("db", {"name": "appdb"});
+}
@@ -0,0 +1,7 @@
+function execute(tpl, params) {
+  var img = tpl.FetchImage("postgres:11");
+  img.SetPullPolicy("if-not-present");
+  var cont = img.NewContainer("db");
+
+  cont.SetEnv("POSTGRES_DB", params.name);
+}